@@ -78,5 +78,6 @@ func Routes() *mux.Router {
 // New will start running the api on the given port
 func New(r repo.Repo, mw middleware.Chain) http.Handler {
 	v1.Repo = r
+	repo.GlobalRepo = r
 	return mw.Load(Routes())
 }
@@ -0,0 +1,124 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+)
+
+// Lifetimes of the tokens emailed to users to reset their password or verify
+// their email
+var (
+	PasswordResetLifetime = time.Hour
+	VerificationLifetime  = time.Hour * 48
+)
+
+// Token types for the tokens emailed to users
+const (
+	passwordResetToken = "password_reset"
+	verificationToken  = "verify_email"
+)
+
+// ErrInvalidAccountToken is returned for expired, used or forged password
+// reset and verification tokens
+var ErrInvalidAccountToken = errors.New("invalid or expired token")
+
+// fingerprint returns a hash of the value so it can be put in a token without
+// revealing it
+func fingerprint(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:16])
+}
+
+func accountToken(u models.User, typ string, lifetime time.Duration, fp string) (string, error) {
+	now := time.Now()
+
+	return signToken(jwt.MapClaims{
+		"username": u.Username,
+		"typ":      typ,
+		"fp":       fp,
+		"iat":      now.Unix(),
+		"exp":      now.Add(lifetime).Unix(),
+	})
+}
+
+// accountTokenUser will return the stored user the token was issued to if it
+// is of the given type and fp returns the same fingerprint it was issued with
+func accountTokenUser(signed, typ string, fp func(models.User) string) (models.User, error) {
+	if repo.GlobalRepo == nil {
+		return models.User{}, ErrInvalidAccountToken
+	}
+
+	token, err := jwt.Parse(signed, verificationKey)
+	if err != nil {
+		return models.User{}, ErrInvalidAccountToken
+	}
+
+	claims := getClaims(token)
+	if claims == nil || claims["typ"] != typ {
+		return models.User{}, ErrInvalidAccountToken
+	}
+
+	username, _ := claims["username"].(string)
+	issued, _ := claims["fp"].(string)
+
+	user, err := repo.GlobalRepo.Users().Get(&models.User{}, username)
+	if err == repo.ErrNotFound {
+		return models.User{}, ErrInvalidAccountToken
+	}
+
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(fp(user)), []byte(issued)) != 1 {
+		return models.User{}, ErrInvalidAccountToken
+	}
+
+	return user, nil
+}
+
+func passwordFingerprint(u models.User) string {
+	return fingerprint(u.Password)
+}
+
+func emailFingerprint(u models.User) string {
+	return fingerprint(u.Email)
+}
+
+// NewPasswordResetToken will return a token which lets the user u set a new
+// password. It can only be used once since it stops being valid as soon as
+// their password changes.
+func NewPasswordResetToken(u models.User) (string, error) {
+	return accountToken(u, passwordResetToken, PasswordResetLifetime,
+		passwordFingerprint(u))
+}
+
+// PasswordResetUser will return the user a token from NewPasswordResetToken
+// was issued to
+func PasswordResetUser(token string) (models.User, error) {
+	return accountTokenUser(token, passwordResetToken, passwordFingerprint)
+}
+
+// NewVerificationToken will return a token which proves the user u received
+// an email at their email address
+func NewVerificationToken(u models.User) (string, error) {
+	return accountToken(u, verificationToken, VerificationLifetime,
+		emailFingerprint(u))
+}
+
+// VerificationUser will return the user a token from NewVerificationToken was
+// issued to, the token stops being valid if they change their email
+func VerificationUser(token string) (models.User, error) {
+	return accountTokenUser(token, verificationToken, emailFingerprint)
+}
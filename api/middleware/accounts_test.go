@@ -0,0 +1,61 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package middleware
+
+import (
+	"testing"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+)
+
+func TestAccountTokens(t *testing.T) {
+	repo.GlobalRepo = repo.NewMockRepo()
+	defer func() { repo.GlobalRepo = nil }()
+
+	// The mock repo always returns testadmin
+	u, err := repo.GlobalRepo.Users().Get(&models.User{}, "testadmin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reset, err := NewPasswordResetToken(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user, err := PasswordResetUser(reset); err != nil || user.Username != "testadmin" {
+		t.Errorf("Expected testadmin Got %v %v", user.Username, err)
+	}
+
+	if _, err = VerificationUser(reset); err != ErrInvalidAccountToken {
+		t.Errorf("Expected a reset token not to verify emails Got %v", err)
+	}
+
+	changed := u
+	changed.Password = "changed"
+
+	stale, _ := NewPasswordResetToken(changed)
+	if _, err = PasswordResetUser(stale); err != ErrInvalidAccountToken {
+		t.Errorf("Expected a token for an old password to be rejected Got %v", err)
+	}
+
+	verify, err := NewVerificationToken(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user, err := VerificationUser(verify); err != nil || user.Username != "testadmin" {
+		t.Errorf("Expected testadmin Got %v %v", user.Username, err)
+	}
+
+	changed = u
+	changed.Email = "other@example.com"
+
+	stale, _ = NewVerificationToken(changed)
+	if _, err = VerificationUser(stale); err != ErrInvalidAccountToken {
+		t.Errorf("Expected a token for an old email to be rejected Got %v", err)
+	}
+}
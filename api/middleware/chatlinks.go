@@ -0,0 +1,81 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+)
+
+// ChatLinkCodeLifetime is how long a user has to run the link slash command
+// with a chat link code
+var ChatLinkCodeLifetime = 10 * time.Minute
+
+// ErrInvalidChatLinkCode is returned for expired, used or unknown chat link
+// codes
+var ErrInvalidChatLinkCode = errors.New("invalid or expired link code")
+
+func chatLinkKey(code string) string {
+	return "chat_link:" + code
+}
+
+// NewChatLinkCode will return a code which links the chat account of whoever
+// sends it in a slash command to the user u. It is short enough to be typed.
+func NewChatLinkCode(u models.User) (string, error) {
+	if repo.GlobalCache == nil {
+		return "", ErrNoSessionStore
+	}
+
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	code := hex.EncodeToString(b)
+	expires := time.Now().Add(ChatLinkCodeLifetime).Unix()
+
+	return code, repo.GlobalCache.Set(chatLinkKey(code),
+		strconv.FormatInt(expires, 10)+":"+u.Username)
+}
+
+// ChatLinkUser will return the username a code from NewChatLinkCode was
+// issued to. Each code can only be used once.
+func ChatLinkUser(code string) (string, error) {
+	if repo.GlobalCache == nil {
+		return "", ErrNoSessionStore
+	}
+
+	key := chatLinkKey(strings.ToLower(code))
+
+	cached, err := repo.GlobalCache.Get(key)
+	if err != nil {
+		return "", ErrInvalidChatLinkCode
+	}
+
+	if err = repo.GlobalCache.Remove(key); err != nil {
+		return "", err
+	}
+
+	value, _ := cached.(string)
+
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return "", ErrInvalidChatLinkCode
+	}
+
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", ErrInvalidChatLinkCode
+	}
+
+	return parts[1], nil
+}
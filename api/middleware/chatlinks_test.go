@@ -0,0 +1,46 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package middleware
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"github.com/praelatus/praelatus/repo/cache"
+)
+
+func TestChatLinkCode(t *testing.T) {
+	repo.GlobalCache = cache.NewMemory(10, 0)
+	defer func() { repo.GlobalCache = nil }()
+
+	code, err := NewChatLinkCode(models.User{Username: "testuser"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	username, err := ChatLinkUser(strings.ToUpper(code))
+	if err != nil || username != "testuser" {
+		t.Errorf("Expected testuser Got %s %v", username, err)
+	}
+
+	if _, err = ChatLinkUser(code); err != ErrInvalidChatLinkCode {
+		t.Errorf("Expected %s Got %v", ErrInvalidChatLinkCode, err)
+	}
+
+	defer func(l time.Duration) { ChatLinkCodeLifetime = l }(ChatLinkCodeLifetime)
+	ChatLinkCodeLifetime = -time.Minute
+
+	code, err = NewChatLinkCode(models.User{Username: "testuser"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ChatLinkUser(code); err != ErrInvalidChatLinkCode {
+		t.Errorf("Expected %s for an expired code Got %v", ErrInvalidChatLinkCode, err)
+	}
+}
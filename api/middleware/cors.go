@@ -10,7 +10,7 @@ func CORS(next http.Handler) http.Handler {
 		func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Add("Access-Control-Allow-Origin", r.Header.Get("Origin"))
 			w.Header().Add("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
-			w.Header().Add("Access-Control-Expose-Headers", "X-Praelatus-Token, Content-Type")
+			w.Header().Add("Access-Control-Expose-Headers", "X-Praelatus-Token, X-Praelatus-Refresh-Token, Content-Type")
 			w.Header().Add("Access-Control-Allow-Credentials", "true")
 			w.Header().Add("Access-Control-Allow-Methods", "GET, PUT, POST, DELETE, OPTIONS")
 			w.Header().Add("Accepts", "application/json")
@@ -0,0 +1,258 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"sort"
+	"sync"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/praelatus/praelatus/config"
+)
+
+// signingKey is a key that session tokens are signed and verified with
+type signingKey struct {
+	id     string
+	method jwt.SigningMethod
+	sign   interface{}
+	verify interface{}
+}
+
+// keySet holds every configured key by ID and the one new tokens are signed
+// with
+type keySet struct {
+	current signingKey
+	byID    map[string]signingKey
+}
+
+var (
+	keysMu sync.Mutex
+	keys   *keySet
+)
+
+func loadKey(k config.JWTKey) (signingKey, error) {
+	key := signingKey{
+		id:     k.ID,
+		method: jwt.GetSigningMethod(k.Algorithm),
+	}
+
+	switch key.method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if k.Secret == "" {
+			return key, fmt.Errorf("key %s has no secret", k.ID)
+		}
+
+		key.sign = []byte(k.Secret)
+		key.verify = key.sign
+		return key, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+	default:
+		return key, fmt.Errorf("key %s has unsupported algorithm %s", k.ID, k.Algorithm)
+	}
+
+	pem, err := ioutil.ReadFile(k.PrivateKeyFile)
+	if err != nil {
+		return key, err
+	}
+
+	if _, ok := key.method.(*jwt.SigningMethodRSA); ok {
+		priv, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+		if err != nil {
+			return key, err
+		}
+
+		key.sign, key.verify = priv, &priv.PublicKey
+		return key, nil
+	}
+
+	priv, err := jwt.ParseECPrivateKeyFromPEM(pem)
+	if err != nil {
+		return key, err
+	}
+
+	key.sign, key.verify = priv, &priv.PublicKey
+	return key, nil
+}
+
+// newKeySet will load the keys in the given configuration. If no keys are
+// configured a random key is generated so tokens will not survive a restart.
+func newKeySet(c config.JWTConfig) (*keySet, error) {
+	ks := &keySet{byID: make(map[string]signingKey)}
+
+	for _, k := range c.Keys {
+		key, err := loadKey(k)
+		if err != nil {
+			return nil, err
+		}
+
+		ks.byID[key.id] = key
+	}
+
+	if len(c.Keys) == 0 {
+		log.Println("WARNING: no JWT keys configured, generating a temporary one")
+
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+
+		ks.byID["generated"] = signingKey{
+			id:     "generated",
+			method: jwt.SigningMethodHS256,
+			sign:   secret,
+			verify: secret,
+		}
+
+		c.SigningKey = "generated"
+	}
+
+	current, ok := ks.byID[c.SigningKey]
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not configured", c.SigningKey)
+	}
+
+	ks.current = current
+	return ks, nil
+}
+
+// LoadKeys will replace the keys used for session tokens with the ones in the
+// given configuration
+func LoadKeys(c config.JWTConfig) error {
+	ks, err := newKeySet(c)
+	if err != nil {
+		return err
+	}
+
+	keysMu.Lock()
+	keys = ks
+	keysMu.Unlock()
+
+	return nil
+}
+
+// currentKeys returns the loaded keys loading them from the configuration
+// first if LoadKeys has not been called
+func currentKeys() *keySet {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+
+	if keys == nil {
+		ks, err := newKeySet(config.JWT())
+		if err != nil {
+			log.Println("ERROR: [JWT_KEYS]", err)
+			ks, _ = newKeySet(config.JWTConfig{})
+		}
+
+		keys = ks
+	}
+
+	return keys
+}
+
+// signToken will sign a token for the claims with the current signing key
+func signToken(claims jwt.Claims) (string, error) {
+	key := currentKeys().current
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.sign)
+}
+
+// verificationKey is a jwt.Keyfunc which returns the key named by the token's
+// kid header, making sure the token uses that key's algorithm
+func verificationKey(t *jwt.Token) (interface{}, error) {
+	kid, ok := t.Header["kid"].(string)
+	if !ok {
+		return nil, errors.New("token has no kid")
+	}
+
+	key, ok := currentKeys().byID[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %s", kid)
+	}
+
+	if t.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("key %s can not be used with %s", kid, t.Method.Alg())
+	}
+
+	return key.verify, nil
+}
+
+// JWK is a public key in the JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+func encodeInt(i *big.Int, size int) string {
+	b := i.Bytes()
+	if len(b) < size {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// JWKS returns the public keys which session tokens can be verified with.
+// Shared secrets are never published so HS256 keys are not included.
+func JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+
+	for _, key := range currentKeys().byID {
+		jwk := JWK{
+			Kid: key.id,
+			Use: "sig",
+			Alg: key.method.Alg(),
+		}
+
+		switch pub := key.verify.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = encodeInt(pub.N, 0)
+			jwk.E = encodeInt(big.NewInt(int64(pub.E)), 0)
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = pub.Curve.Params().Name
+			jwk.X = encodeInt(pub.X, size)
+			jwk.Y = encodeInt(pub.Y, size)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+
+	return set
+}
@@ -0,0 +1,204 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/praelatus/praelatus/config"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"github.com/praelatus/praelatus/repo/cache"
+)
+
+func writeKeys(t *testing.T) (string, string, func()) {
+	dir, err := ioutil.TempDir("", "praelatus-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaFile := filepath.Join(dir, "rsa.pem")
+	err = ioutil.WriteFile(rsaFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(rsaKey),
+	}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecFile := filepath.Join(dir, "ec.pem")
+	err = ioutil.WriteFile(ecFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: der,
+	}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rsaFile, ecFile, func() { os.RemoveAll(dir) }
+}
+
+func sessionFor(t *testing.T, u models.User) string {
+	w := httptest.NewRecorder()
+
+	err := SetUserSession(u, w, httptest.NewRequest("POST", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w.Header().Get("X-Praelatus-Token")
+}
+
+func userFor(token string) *models.User {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return GetUserSession(r)
+}
+
+func TestKeyRotation(t *testing.T) {
+	repo.GlobalCache = cache.NewMemory(10, 0)
+	defer func() { repo.GlobalCache = nil }()
+
+	defer LoadKeys(config.JWTConfig{})
+
+	old := config.JWTKey{ID: "old", Algorithm: "HS256", Secret: "old secret"}
+	next := config.JWTKey{ID: "new", Algorithm: "HS256", Secret: "new secret"}
+
+	err := LoadKeys(config.JWTConfig{SigningKey: "old", Keys: []config.JWTKey{old}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldToken := sessionFor(t, models.User{Username: "test"})
+
+	err = LoadKeys(config.JWTConfig{SigningKey: "new", Keys: []config.JWTKey{old, next}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if userFor(oldToken) == nil {
+		t.Error("Expected token signed with the old key to still be valid")
+	}
+
+	newToken := sessionFor(t, models.User{Username: "test"})
+
+	token, _ := jwt.Parse(newToken, verificationKey)
+	if token == nil || token.Header["kid"] != "new" {
+		t.Errorf("Expected kid new Got %v", token)
+	}
+
+	err = LoadKeys(config.JWTConfig{SigningKey: "new", Keys: []config.JWTKey{next}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if userFor(oldToken) != nil {
+		t.Error("Expected token signed with a removed key to be invalid")
+	}
+}
+
+func TestAsymmetricKeys(t *testing.T) {
+	repo.GlobalCache = cache.NewMemory(10, 0)
+	defer func() { repo.GlobalCache = nil }()
+
+	defer LoadKeys(config.JWTConfig{})
+
+	rsaFile, ecFile, cleanup := writeKeys(t)
+	defer cleanup()
+
+	for _, key := range []config.JWTKey{
+		{ID: "rsa", Algorithm: "RS256", PrivateKeyFile: rsaFile},
+		{ID: "ec", Algorithm: "ES256", PrivateKeyFile: ecFile},
+	} {
+		err := LoadKeys(config.JWTConfig{SigningKey: key.ID, Keys: []config.JWTKey{key}})
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		u := userFor(sessionFor(t, models.User{Username: "test"}))
+		if u == nil || u.Username != "test" {
+			t.Errorf("%s: Expected user test Got %v", key.ID, u)
+		}
+
+		jwks := JWKS()
+		if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != key.ID || jwks.Keys[0].Alg != key.Algorithm {
+			t.Errorf("%s: Expected the public key in the JWKS Got %v", key.ID, jwks)
+		}
+	}
+}
+
+func TestKeyAlgorithmMismatch(t *testing.T) {
+	defer LoadKeys(config.JWTConfig{})
+
+	rsaFile, _, cleanup := writeKeys(t)
+	defer cleanup()
+
+	err := LoadKeys(config.JWTConfig{
+		SigningKey: "rsa",
+		Keys: []config.JWTKey{
+			{ID: "rsa", Algorithm: "RS256", PrivateKeyFile: rsaFile},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sign an HS256 token using the public key as the secret
+	pub, err := x509.MarshalPKIXPublicKey(currentKeys().current.verify)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := makeClaims(models.User{
+		Username: "admin",
+		IsAdmin:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = "rsa"
+
+	signed, err := forged.SignedString(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if userFor(signed) != nil {
+		t.Error("Expected token with the wrong algorithm to be rejected")
+	}
+
+	if len(JWKS().Keys) != 1 {
+		t.Error("Expected the RSA key to be published")
+	}
+}
@@ -5,7 +5,10 @@
 package middleware
 
 import (
+	"bufio"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"time"
 
@@ -42,6 +45,26 @@ func (w *LoggedResponseWriter) Write(b []byte) (int, error) {
 	return w.ResponseWriter.Write(b)
 }
 
+// Hijack implements http.Hijacker so websocket connections can be upgraded
+// through the logger
+func (w *LoggedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	w.status = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
+
+// Flush implements http.Flusher so streamed responses, such as server-sent
+// events, aren't held in a buffer by the logger
+func (w *LoggedResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 // Logger will log a request and any information about the request, it should
 // be the first middleware in any chain.
 func Logger(next http.Handler) http.Handler {
@@ -0,0 +1,92 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+)
+
+// LoginCodeLifetime is how long the UI has to exchange a login code after the
+// user is sent back to it by an external login
+var LoginCodeLifetime = time.Minute
+
+// loginCodeToken is the token type of login codes
+const loginCodeToken = "login_code"
+
+// ErrInvalidLoginCode is returned for expired, used or forged login codes
+var ErrInvalidLoginCode = errors.New("invalid or expired login code")
+
+func loginCodeKey(jti interface{}) string {
+	return fmt.Sprintf("login_code:%v", jti)
+}
+
+// NewLoginCode will return a code proving that the user u logged in through
+// an external provider. It is given to the UI in the URL it is redirected to
+// and exchanged for a session with LoginCodeUser, so the session tokens are
+// never put in a URL.
+func NewLoginCode(u models.User) (string, error) {
+	if repo.GlobalCache == nil {
+		return "", ErrNoSessionStore
+	}
+
+	claims, err := makeClaims(u)
+	if err != nil {
+		return "", err
+	}
+
+	claims["exp"] = time.Now().Add(LoginCodeLifetime).Unix()
+	claims["typ"] = loginCodeToken
+
+	signed, err := signToken(claims)
+	if err != nil {
+		return "", err
+	}
+
+	// Codes are remembered so that each can only be exchanged once
+	return signed, repo.GlobalCache.Set(loginCodeKey(claims["jti"]), u.Username)
+}
+
+// LoginCodeUser will return the stored user a code from NewLoginCode was
+// issued to. Each code can only be used once.
+func LoginCodeUser(code string) (models.User, error) {
+	if repo.GlobalCache == nil || repo.GlobalRepo == nil {
+		return models.User{}, ErrNoSessionStore
+	}
+
+	token, err := jwt.Parse(code, verificationKey)
+	if err != nil {
+		return models.User{}, ErrInvalidLoginCode
+	}
+
+	claims := getClaims(token)
+	if claims == nil || claims["typ"] != loginCodeToken {
+		return models.User{}, ErrInvalidLoginCode
+	}
+
+	key := loginCodeKey(claims["jti"])
+
+	if _, err = repo.GlobalCache.Get(key); err != nil {
+		return models.User{}, ErrInvalidLoginCode
+	}
+
+	if err = repo.GlobalCache.Remove(key); err != nil {
+		return models.User{}, err
+	}
+
+	username, _ := claims["username"].(string)
+
+	user, err := repo.GlobalRepo.Users().Get(&models.User{}, username)
+	if err == repo.ErrNotFound {
+		return models.User{}, ErrInvalidLoginCode
+	}
+
+	return user, err
+}
@@ -0,0 +1,52 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package middleware
+
+import (
+	"testing"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"github.com/praelatus/praelatus/repo/cache"
+)
+
+func TestLoginCode(t *testing.T) {
+	repo.GlobalRepo = repo.NewMockRepo()
+	repo.GlobalCache = cache.NewMemory(10, 0)
+	defer func() { repo.GlobalRepo, repo.GlobalCache = nil, nil }()
+
+	u, err := repo.GlobalRepo.Users().Get(&models.User{}, "testadmin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := NewLoginCode(u)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if GetUserSession(bearer(code)) != nil {
+		t.Error("Expected a login code not to be a session")
+	}
+
+	user, err := LoginCodeUser(code)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if user.Username != u.Username {
+		t.Errorf("Expected %s Got %s", u.Username, user.Username)
+	}
+
+	if _, err = LoginCodeUser(code); err != ErrInvalidLoginCode {
+		t.Errorf("Expected %s Got %v", ErrInvalidLoginCode, err)
+	}
+
+	if _, err = LoginCodeUser("forged"); err != ErrInvalidLoginCode {
+		t.Errorf("Expected %s Got %v", ErrInvalidLoginCode, err)
+	}
+}
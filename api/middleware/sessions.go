@@ -5,29 +5,62 @@
 package middleware
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
 )
 
-// TODO: create a way to invalidate a session
-// TODO: prevent session hijacking
-// TODO: Make this actually secure
-var signingKey = []byte("CHANGE ME")
+// Lifetimes of the tokens issued by SetUserSession, session tokens are used to
+// authenticate requests and refresh tokens can only be exchanged for a new
+// session token.
+var (
+	SessionLifetime = time.Hour * 24
+	RefreshLifetime = time.Hour * 24 * 30
+)
+
+// Token types stored in the typ claim
+const (
+	sessionToken = "session"
+	refreshToken = "refresh"
+)
 
-func makeClaims(user models.User) jwt.MapClaims {
+func makeClaims(user models.User) (jwt.MapClaims, error) {
 	now := time.Now()
+
+	// jti makes tokens issued within the same second unique
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return nil, err
+	}
+
 	return jwt.MapClaims{
 		"username": user.Username,
 		"email":    user.Email,
 		"is_admin": user.IsAdmin,
-		"iat":      now,
-		"exp":      now.Add(time.Hour * 24),
+		"typ":      sessionToken,
+		"jti":      hex.EncodeToString(jti),
+		"iat":      now.Unix(),
+		"exp":      now.Add(SessionLifetime).Unix(),
+	}, nil
+}
+
+func makeRefreshClaims(user models.User) (jwt.MapClaims, error) {
+	claims, err := makeClaims(user)
+	if err != nil {
+		return nil, err
 	}
+
+	claims["typ"] = refreshToken
+	claims["exp"] = time.Now().Add(RefreshLifetime).Unix()
+	return claims, nil
 }
 
 func userFromClaims(claims jwt.MapClaims) models.User {
@@ -69,20 +102,12 @@ func userFromClaims(claims jwt.MapClaims) models.User {
 }
 
 func getToken(r *http.Request) *jwt.Token {
-	auth := r.Header.Get("Authorization")
-	if len(auth) == 0 {
-		return nil
-	}
-
-	tokenString := auth[len("Bearer "):]
-	if tokenString == "" {
+	tokenString := bearerToken(r)
+	if tokenString == "" || IsAPITokenRequest(r) {
 		return nil
 	}
 
-	token, err := jwt.Parse(tokenString,
-		func(t *jwt.Token) (interface{}, error) {
-			return signingKey, nil
-		})
+	token, err := jwt.Parse(tokenString, verificationKey)
 	if err != nil {
 		fmt.Println("ERROR: [TOKEN_VALIDATION]", err.Error())
 		return nil
@@ -101,15 +126,44 @@ func getClaims(token *jwt.Token) jwt.MapClaims {
 	return claims
 }
 
-// GetUserSession will check the given http.Request for a session token and if
-// found it will return the corresponding user.
-func GetUserSession(r *http.Request) *models.User {
+// validToken returns the claims of the token on the request r if it is of the
+// given type and is still in the session store
+func validToken(r *http.Request, typ string) (*jwt.Token, jwt.MapClaims) {
 	token := getToken(r)
 	if token == nil {
-		return nil
+		return nil, nil
 	}
 
 	claims := getClaims(token)
+	if claims == nil || claims["typ"] != typ {
+		return nil, nil
+	}
+
+	// Only tokens which are still in the session store are valid, without
+	// a store tokens couldn't be revoked so none are accepted
+	if repo.GlobalCache == nil {
+		noStoreWarning.Do(func() {
+			fmt.Println("ERROR: [TOKEN_VALIDATION] no session store configured, rejecting all sessions")
+		})
+
+		return nil, nil
+	}
+
+	if _, err := repo.GlobalCache.GetSession(token.Raw); err != nil {
+		return nil, nil
+	}
+
+	return token, claims
+}
+
+// GetUserSession will check the given http.Request for a session token or a
+// personal API token and if found it will return the corresponding user.
+func GetUserSession(r *http.Request) *models.User {
+	if IsAPITokenRequest(r) {
+		return apiTokenUser(r)
+	}
+
+	_, claims := validToken(r, sessionToken)
 	if claims == nil {
 		return nil
 	}
@@ -118,22 +172,147 @@ func GetUserSession(r *http.Request) *models.User {
 	return &user
 }
 
-// SetUserSession will generate a secure cookie for user u, will set the cookie
-// on the response w and will add the user session to the session store
-func SetUserSession(u models.User, w http.ResponseWriter) error {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, makeClaims(u))
-	signed, err := token.SignedString(signingKey)
+// issueToken will sign the claims and add the token to the session store
+func issueToken(u models.User, claims jwt.MapClaims) (string, error) {
+	if repo.GlobalCache == nil {
+		return "", ErrNoSessionStore
+	}
+
+	signed, err := signToken(claims)
+	if err != nil {
+		return "", err
+	}
+
+	return signed, storeSession(u.Username, models.Session{Token: signed})
+}
+
+// SetUserSession will generate a session token and a refresh token for user u,
+// will set them on the response w and will add them to the session store.
+// Sessions can't be created without a session store.
+func SetUserSession(u models.User, w http.ResponseWriter, r *http.Request) error {
+	claims, err := makeClaims(u)
+	if err != nil {
+		return err
+	}
+
+	refreshClaims, err := makeRefreshClaims(u)
+	if err != nil {
+		return err
+	}
+
+	signed, err := issueToken(u, claims)
+	if err != nil {
+		return err
+	}
+
+	refresh, err := issueToken(u, refreshClaims)
+	if err != nil {
+		return err
+	}
+
+	// Remember the refresh token issued alongside the session so logging out
+	// revokes both
+	err = repo.GlobalCache.Set(refreshKey(claims["jti"]), refresh)
 	if err != nil {
 		return err
 	}
 
-	// TODO: Store the session with the client specific ID for session hijacking
 	w.Header().Set("X-Praelatus-Token", signed)
+	w.Header().Set("X-Praelatus-Refresh-Token", refresh)
 	return nil
 }
 
-// RefreshSession will reset the expiry on the session for the given request
-func RefreshSession(r *http.Request) error {
+// RefreshSession will validate the session or refresh token on the request r
+// and return the user it belongs to so that new tokens can be issued for them
+// with SetUserSession. Refresh tokens can only be exchanged once.
+func RefreshSession(r *http.Request) (*models.User, error) {
+	_, claims := validToken(r, sessionToken)
+	if claims == nil {
+		var token *jwt.Token
+
+		token, claims = validToken(r, refreshToken)
+		if claims == nil {
+			return nil, errors.New("no valid session on this request")
+		}
+
+		// Taking the refresh token out of the store only succeeds once so
+		// concurrent requests can't both exchange it
+		_, err := repo.GlobalCache.TakeSession(token.Raw)
+		if err == repo.ErrNotFound {
+			return nil, errors.New("no valid session on this request")
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	user := userFromClaims(claims)
+	return &user, nil
+}
+
+// ErrNoSessionStore is returned when creating or revoking sessions without a
+// configured session store
+var ErrNoSessionStore = errors.New("no session store configured")
+
+// noStoreWarning makes sure the missing session store is only logged once
+var noStoreWarning sync.Once
+
+// sessionsMu guards the lists of session tokens kept for each user
+var sessionsMu sync.Mutex
+
+func sessionsKey(username string) string {
+	return "sessions:" + username
+}
+
+// refreshKey is where the refresh token issued with the session token with
+// the given jti is kept
+func refreshKey(jti interface{}) string {
+	return fmt.Sprintf("refresh:%v", jti)
+}
+
+// activeSessions returns the tokens of the user's sessions which are still in
+// the session store, sessionsMu must be held
+func activeSessions(username string) []string {
+	cached, err := repo.GlobalCache.Get(sessionsKey(username))
+	if err != nil {
+		return []string{}
+	}
+
+	tokens, _ := cached.([]string)
+	active := make([]string, 0, len(tokens))
+
+	for _, token := range tokens {
+		if _, err := repo.GlobalCache.GetSession(token); err == nil {
+			active = append(active, token)
+		}
+	}
+
+	return active
+}
+
+// storeSession will add the session to the session store and to the list of
+// the user's sessions
+func storeSession(username string, session models.Session) error {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	err := repo.GlobalCache.SetSession(session.Token, session)
+	if err != nil {
+		return err
+	}
+
+	return repo.GlobalCache.Set(sessionsKey(username),
+		append(activeSessions(username), session.Token))
+}
+
+// RemoveUserSession will revoke the session used to make the request r so the
+// token can no longer be used
+func RemoveUserSession(r *http.Request) error {
+	if repo.GlobalCache == nil {
+		return ErrNoSessionStore
+	}
+
 	token := getToken(r)
 	if token == nil {
 		return errors.New("no session on this request")
@@ -144,6 +323,48 @@ func RefreshSession(r *http.Request) error {
 		return errors.New("no claims on token")
 	}
 
-	claims["exp"] = time.Now().Add(time.Hour * 24)
-	return nil
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	err := repo.GlobalCache.RemoveSession(token.Raw)
+	if err != nil {
+		return err
+	}
+
+	if refresh, err := repo.GlobalCache.Get(refreshKey(claims["jti"])); err == nil {
+		if refresh, ok := refresh.(string); ok {
+			err = repo.GlobalCache.RemoveSession(refresh)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = repo.GlobalCache.Remove(refreshKey(claims["jti"]))
+		if err != nil {
+			return err
+		}
+	}
+
+	username := userFromClaims(claims).Username
+	return repo.GlobalCache.Set(sessionsKey(username), activeSessions(username))
+}
+
+// RevokeUserSessions will revoke every session of the user with the given
+// username
+func RevokeUserSessions(username string) error {
+	if repo.GlobalCache == nil {
+		return ErrNoSessionStore
+	}
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	for _, token := range activeSessions(username) {
+		err := repo.GlobalCache.RemoveSession(token)
+		if err != nil {
+			return err
+		}
+	}
+
+	return repo.GlobalCache.Remove(sessionsKey(username))
 }
@@ -5,16 +5,21 @@
 package middleware
 
 import (
+	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"github.com/praelatus/praelatus/repo/cache"
 )
 
 func TestGetToken(t *testing.T) {
-	testToken := jwt.New(jwt.SigningMethodHS256)
-	signed, err := testToken.SignedString(signingKey)
+	signed, err := signToken(jwt.MapClaims{})
 	if err != nil {
 		t.Error(err)
 		return
@@ -42,7 +47,10 @@ func TestMakeClaims(t *testing.T) {
 		IsAdmin:  false,
 	}
 
-	claims := makeClaims(u)
+	claims, err := makeClaims(u)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if claims["username"] != u.Username {
 		t.Errorf("Expected %s Got %s\n", u.Username, claims["username"])
@@ -73,7 +81,10 @@ func TestMakeAdminClaims(t *testing.T) {
 		IsAdmin:  true,
 	}
 
-	claims := makeClaims(u)
+	claims, err := makeClaims(u)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if claims["username"] != u.Username {
 		t.Errorf("Expected %s Got %s\n", u.Username, claims["username"])
@@ -104,7 +115,10 @@ func TestUserFromClaims(t *testing.T) {
 		IsAdmin:  true,
 	}
 
-	claims := makeClaims(u)
+	claims, err := makeClaims(u)
+	if err != nil {
+		t.Fatal(err)
+	}
 	newUser := userFromClaims(claims)
 
 	if u.Username != newUser.Username {
@@ -121,6 +135,9 @@ func TestUserFromClaims(t *testing.T) {
 }
 
 func TestTokenIntegration(t *testing.T) {
+	repo.GlobalCache = cache.NewMemory(10, 0)
+	defer func() { repo.GlobalCache = nil }()
+
 	u := models.User{
 		Username: "test",
 		FullName: "Test Testerson",
@@ -130,7 +147,7 @@ func TestTokenIntegration(t *testing.T) {
 
 	w := httptest.NewRecorder()
 
-	err := SetUserSession(u, w)
+	err := SetUserSession(u, w, httptest.NewRequest("POST", "/", nil))
 	if err != nil {
 		t.Error(err)
 		return
@@ -153,3 +170,221 @@ func TestTokenIntegration(t *testing.T) {
 		t.Errorf("Got: %v Expected: %v\n", returnedUser.IsAdmin, u.IsAdmin)
 	}
 }
+
+func TestSessionStore(t *testing.T) {
+	repo.GlobalCache = cache.NewMemory(10, 0)
+	defer func() { repo.GlobalCache = nil }()
+
+	u := models.User{
+		Username: "test",
+		Email:    "test@example.com",
+	}
+
+	w := httptest.NewRecorder()
+
+	err := SetUserSession(u, w, httptest.NewRequest("POST", "/", nil))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	token := w.Header().Get("X-Praelatus-Token")
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	if GetUserSession(r) == nil {
+		t.Error("Expected a user for a stored session Got nil")
+	}
+
+	err = RemoveUserSession(r)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if u := GetUserSession(r); u != nil {
+		t.Errorf("Expected nil for a removed session Got %v", u)
+	}
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	repo.GlobalCache = cache.NewMemory(10, 0)
+	defer func() { repo.GlobalCache = nil }()
+
+	u := models.User{Username: "test"}
+	requests := make([]*httptest.ResponseRecorder, 2)
+
+	for i := range requests {
+		requests[i] = httptest.NewRecorder()
+
+		err := SetUserSession(u, requests[i], httptest.NewRequest("POST", "/", nil))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	err := RevokeUserSessions(u.Username)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, w := range requests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+w.Header().Get("X-Praelatus-Token"))
+
+		if GetUserSession(r) != nil {
+			t.Error("Expected session to be revoked")
+		}
+	}
+}
+
+func TestNoSessionStore(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	err := SetUserSession(models.User{Username: "test"}, w, httptest.NewRequest("POST", "/", nil))
+	if err != ErrNoSessionStore {
+		t.Errorf("Expected %v Got %v", ErrNoSessionStore, err)
+	}
+
+	claims, err := makeClaims(models.User{Username: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := signToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if GetUserSession(bearer(signed)) != nil {
+		t.Error("Expected tokens to be rejected without a session store")
+	}
+}
+
+func bearer(token string) *http.Request {
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestRefreshSession(t *testing.T) {
+	repo.GlobalCache = cache.NewMemory(10, 0)
+	defer func() { repo.GlobalCache = nil }()
+
+	w := httptest.NewRecorder()
+
+	err := SetUserSession(models.User{Username: "test"}, w, httptest.NewRequest("POST", "/", nil))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	refresh := w.Header().Get("X-Praelatus-Refresh-Token")
+	if refresh == "" {
+		t.Error("Expected a refresh token to be set")
+		return
+	}
+
+	if GetUserSession(bearer(refresh)) != nil {
+		t.Error("Expected refresh token to not be accepted as a session token")
+	}
+
+	u, err := RefreshSession(bearer(refresh))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if u.Username != "test" {
+		t.Errorf("Expected test Got %s", u.Username)
+	}
+
+	if _, err = RefreshSession(bearer(refresh)); err == nil {
+		t.Error("Expected refresh token to only be usable once")
+	}
+
+	if _, err = RefreshSession(bearer(w.Header().Get("X-Praelatus-Token"))); err != nil {
+		t.Errorf("Expected session token to be refreshable Got %s", err)
+	}
+}
+
+func TestRefreshSessionConcurrent(t *testing.T) {
+	repo.GlobalCache = cache.NewMemory(0, 0)
+	defer func() { repo.GlobalCache = nil }()
+
+	w := httptest.NewRecorder()
+
+	err := SetUserSession(models.User{Username: "test"}, w, httptest.NewRequest("POST", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refresh := w.Header().Get("X-Praelatus-Refresh-Token")
+
+	var wg sync.WaitGroup
+	var exchanged int32
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if _, err := RefreshSession(bearer(refresh)); err == nil {
+				atomic.AddInt32(&exchanged, 1)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if exchanged != 1 {
+		t.Errorf("Expected the refresh token to be exchanged once Got %d", exchanged)
+	}
+}
+
+func TestLogoutRevokesRefreshToken(t *testing.T) {
+	repo.GlobalCache = cache.NewMemory(10, 0)
+	defer func() { repo.GlobalCache = nil }()
+
+	w := httptest.NewRecorder()
+
+	err := SetUserSession(models.User{Username: "test"}, w, httptest.NewRequest("POST", "/", nil))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = RemoveUserSession(bearer(w.Header().Get("X-Praelatus-Token")))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err = RefreshSession(bearer(w.Header().Get("X-Praelatus-Refresh-Token"))); err == nil {
+		t.Error("Expected refresh token to be revoked on logout")
+	}
+}
+
+func TestExpiredSession(t *testing.T) {
+	repo.GlobalCache = cache.NewMemory(10, 0)
+	defer func() { repo.GlobalCache = nil }()
+
+	defer func(l time.Duration) { SessionLifetime = l }(SessionLifetime)
+	SessionLifetime = -time.Minute
+
+	w := httptest.NewRecorder()
+
+	err := SetUserSession(models.User{Username: "test"}, w, httptest.NewRequest("POST", "/", nil))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if GetUserSession(bearer(w.Header().Get("X-Praelatus-Token"))) != nil {
+		t.Error("Expected expired token to be rejected")
+	}
+}
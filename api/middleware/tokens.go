@@ -0,0 +1,97 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// apiTokenPrefix marks a bearer token as a personal API token instead of a JWT
+const apiTokenPrefix = "prae_"
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIToken will generate an ID and secret for the token storing the hash
+// of the secret on it. The returned string is what the user authenticates
+// with, it is not stored anywhere so it can only be shown to them once.
+func NewAPIToken(t *models.APIToken) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	t.ID = bson.NewObjectId()
+	encoded := hex.EncodeToString(secret)
+	t.Hash = hashSecret(encoded)
+
+	return apiTokenPrefix + t.ID.Hex() + "_" + encoded, nil
+}
+
+// bearerToken returns the token from the Authorization header of r
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+
+	for _, scheme := range []string{"Bearer ", "Token "} {
+		if strings.HasPrefix(auth, scheme) {
+			return strings.TrimPrefix(auth, scheme)
+		}
+	}
+
+	return ""
+}
+
+// IsAPITokenRequest reports whether the request r is authenticated with a
+// personal API token instead of a session
+func IsAPITokenRequest(r *http.Request) bool {
+	return strings.HasPrefix(bearerToken(r), apiTokenPrefix)
+}
+
+// apiTokenUser returns the user which the API token on the request r belongs
+// to. Users of scoped tokens are limited to the token's scopes and are never
+// administrators.
+func apiTokenUser(r *http.Request) *models.User {
+	if repo.GlobalRepo == nil {
+		return nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(bearerToken(r), apiTokenPrefix), "_", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+
+	token, err := repo.GlobalRepo.Tokens().Get(&models.User{}, parts[0])
+	if err != nil {
+		return nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashSecret(parts[1])), []byte(token.Hash)) != 1 ||
+		token.Expired() {
+		return nil
+	}
+
+	user, err := repo.GlobalRepo.Users().Get(&models.User{}, token.Username)
+	if err != nil {
+		return nil
+	}
+
+	return &models.User{
+		Username: user.Username,
+		Email:    user.Email,
+		IsAdmin:  user.IsAdmin && len(token.Scopes) == 0,
+		Scopes:   token.Scopes,
+	}
+}
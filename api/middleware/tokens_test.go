@@ -0,0 +1,106 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/models/permission"
+	"github.com/praelatus/praelatus/repo"
+)
+
+// createAPIToken stores a token for the mock repo's user returning the string
+// to authenticate with
+func createAPIToken(t *testing.T, token models.APIToken) string {
+	token.Username = "testadmin"
+
+	secret, err := NewAPIToken(&token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repo.GlobalRepo.Tokens().Create(&models.User{IsAdmin: true}, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return secret
+}
+
+func TestAPITokenSession(t *testing.T) {
+	repo.GlobalRepo = repo.NewMockRepo()
+	defer func() { repo.GlobalRepo = nil }()
+
+	secret := createAPIToken(t, models.APIToken{Name: "ci"})
+
+	u := GetUserSession(bearer(secret))
+	if u == nil {
+		t.Error("Expected API token to be accepted")
+		return
+	}
+
+	if u.Username != "testadmin" || !u.IsAdmin {
+		t.Errorf("Expected unscoped token to have the user's rights Got %v", u)
+	}
+
+	if GetUserSession(bearer(secret+"0")) != nil {
+		t.Error("Expected token with the wrong secret to be rejected")
+	}
+
+	if GetUserSession(bearer(apiTokenPrefix+"nonsense")) != nil {
+		t.Error("Expected malformed token to be rejected")
+	}
+}
+
+func TestScopedAPIToken(t *testing.T) {
+	repo.GlobalRepo = repo.NewMockRepo()
+	defer func() { repo.GlobalRepo = nil }()
+
+	secret := createAPIToken(t, models.APIToken{
+		Name:   "ci",
+		Scopes: permission.Permissions{}.Add(permission.CreateTicket),
+	})
+
+	u := GetUserSession(bearer(secret))
+	if u == nil {
+		t.Error("Expected API token to be accepted")
+		return
+	}
+
+	if u.IsAdmin {
+		t.Error("Expected scoped token to not have administrator rights")
+	}
+
+	if !u.Scopes.Contains(permission.CreateTicket) {
+		t.Errorf("Expected scopes to be set Got %v", u.Scopes)
+	}
+}
+
+func TestExpiredAPIToken(t *testing.T) {
+	repo.GlobalRepo = repo.NewMockRepo()
+	defer func() { repo.GlobalRepo = nil }()
+
+	expired := time.Now().Add(-time.Minute)
+	secret := createAPIToken(t, models.APIToken{Name: "ci", ExpiresDate: &expired})
+
+	if GetUserSession(bearer(secret)) != nil {
+		t.Error("Expected expired token to be rejected")
+	}
+}
+
+func TestIsAPITokenRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	if IsAPITokenRequest(r) {
+		t.Error("Expected request without a token to not be an API token request")
+	}
+
+	r.Header.Set("Authorization", "Token "+apiTokenPrefix+"abc_def")
+	if !IsAPITokenRequest(r) {
+		t.Error("Expected Token scheme to be accepted")
+	}
+}
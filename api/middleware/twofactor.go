@@ -0,0 +1,135 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+)
+
+// ChallengeLifetime is how long a user has to finish logging in after
+// entering their password when two-factor authentication is required
+var ChallengeLifetime = time.Minute * 5
+
+// Token types for users who have entered their password but still need to
+// enter a two-factor code or set up two-factor authentication
+const (
+	challengeToken = "2fa_challenge"
+	enrollToken    = "2fa_enroll"
+)
+
+// ErrInvalidChallenge is returned for expired, used or forged challenges
+var ErrInvalidChallenge = errors.New("invalid or expired two-factor challenge")
+
+func challengeKey(jti interface{}) string {
+	return fmt.Sprintf("2fa_challenge:%v", jti)
+}
+
+// NewTwoFactorChallenge will return a token proving that the user u has
+// entered their password. When enroll is false it can be exchanged for a
+// session along with a two-factor code using TwoFactorChallengeUser, when it
+// is true it can only be used to set up two-factor authentication.
+func NewTwoFactorChallenge(u models.User, enroll bool) (string, error) {
+	claims, err := makeClaims(u)
+	if err != nil {
+		return "", err
+	}
+
+	claims["exp"] = time.Now().Add(ChallengeLifetime).Unix()
+	claims["typ"] = challengeToken
+
+	if enroll {
+		claims["typ"] = enrollToken
+	}
+
+	signed, err := signToken(claims)
+	if err != nil {
+		return "", err
+	}
+
+	// Challenges are remembered so that each can only be answered once
+	if !enroll {
+		if repo.GlobalCache == nil {
+			return "", ErrNoSessionStore
+		}
+
+		err = repo.GlobalCache.Set(challengeKey(claims["jti"]), u.Username)
+	}
+
+	return signed, err
+}
+
+// parseChallenge returns the claims of the signed token if it is of the given
+// type
+func parseChallenge(signed, typ string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(signed, verificationKey)
+	if err != nil {
+		return nil, ErrInvalidChallenge
+	}
+
+	claims := getClaims(token)
+	if claims == nil || claims["typ"] != typ {
+		return nil, ErrInvalidChallenge
+	}
+
+	return claims, nil
+}
+
+// TwoFactorChallengeUser will return the user a challenge from
+// NewTwoFactorChallenge was issued to. Each challenge can only be used once,
+// whether or not the code given with it was correct, so that codes can't be
+// guessed.
+func TwoFactorChallengeUser(challenge string) (*models.User, error) {
+	claims, err := parseChallenge(challenge, challengeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if repo.GlobalCache == nil {
+		return nil, ErrNoSessionStore
+	}
+
+	key := challengeKey(claims["jti"])
+
+	if _, err = repo.GlobalCache.Get(key); err != nil {
+		return nil, ErrInvalidChallenge
+	}
+
+	if err = repo.GlobalCache.Remove(key); err != nil {
+		return nil, err
+	}
+
+	user := userFromClaims(claims)
+	return &user, nil
+}
+
+// GetEnrollmentSession will return the user for the session token or
+// enrollment challenge on the request r. It is used by the endpoints which
+// set up two-factor authentication so that users who are required to use it
+// can do so before they are given a session. Personal API tokens are never
+// accepted.
+func GetEnrollmentSession(r *http.Request) *models.User {
+	if IsAPITokenRequest(r) {
+		return nil
+	}
+
+	if u := GetUserSession(r); u != nil {
+		return u
+	}
+
+	claims, err := parseChallenge(bearerToken(r), enrollToken)
+	if err != nil {
+		return nil
+	}
+
+	user := userFromClaims(claims)
+	return &user
+}
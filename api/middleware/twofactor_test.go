@@ -0,0 +1,67 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package middleware
+
+import (
+	"testing"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"github.com/praelatus/praelatus/repo/cache"
+)
+
+func TestTwoFactorChallenge(t *testing.T) {
+	repo.GlobalCache = cache.NewMemory(10, 0)
+	defer func() { repo.GlobalCache = nil }()
+
+	u := models.User{Username: "foouser", Email: "foo@foo.com"}
+
+	challenge, err := NewTwoFactorChallenge(u, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if GetUserSession(bearer(challenge)) != nil || GetEnrollmentSession(bearer(challenge)) != nil {
+		t.Error("Expected a challenge not to be a session")
+	}
+
+	user, err := TwoFactorChallengeUser(challenge)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if user.Username != "foouser" {
+		t.Errorf("Expected foouser Got %s", user.Username)
+	}
+
+	if _, err = TwoFactorChallengeUser(challenge); err != ErrInvalidChallenge {
+		t.Errorf("Expected %s Got %v", ErrInvalidChallenge, err)
+	}
+}
+
+func TestEnrollmentSession(t *testing.T) {
+	u := models.User{Username: "foouser", Email: "foo@foo.com"}
+
+	enroll, err := NewTwoFactorChallenge(u, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if GetUserSession(bearer(enroll)) != nil {
+		t.Error("Expected an enrollment challenge not to be a session")
+	}
+
+	if _, err = TwoFactorChallengeUser(enroll); err != ErrInvalidChallenge {
+		t.Errorf("Expected %s Got %v", ErrInvalidChallenge, err)
+	}
+
+	user := GetEnrollmentSession(bearer(enroll))
+	if user == nil || user.Username != "foouser" {
+		t.Errorf("Expected foouser Got %v", user)
+	}
+}
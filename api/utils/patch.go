@@ -0,0 +1,62 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// ErrPatchNotObject is returned when a merge patch isn't a JSON object
+var ErrPatchNotObject = errors.New("patch must be a JSON object")
+
+// mergePatch applies patch to target as described in RFC 7386, objects are
+// merged, null removes a member and anything else replaces the target
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+
+		t[k] = mergePatch(t[k], v)
+	}
+
+	return t
+}
+
+// MergePatch applies the JSON Merge Patch to the JSON document doc and returns
+// the patched document, the patch must be an object
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var p interface{}
+
+	err := json.Unmarshal(patch, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := p.(map[string]interface{}); !ok {
+		return nil, ErrPatchNotObject
+	}
+
+	var target interface{}
+
+	err = json.Unmarshal(doc, &target)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(mergePatch(target, p))
+}
@@ -77,6 +77,11 @@ func GetErrorCode(e error) int {
 		return http.StatusUnauthorized
 	case repo.ErrNotFound:
 		return http.StatusNotFound
+	case repo.ErrInvalidTransition, repo.ErrInvalidTicketType,
+		repo.ErrInvalidFieldsForTicket:
+		return http.StatusBadRequest
+	case repo.ErrConflict:
+		return http.StatusConflict
 	default:
 		return http.StatusInternalServerError
 	}
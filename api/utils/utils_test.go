@@ -19,8 +19,11 @@ type TestType struct {
 
 func TestError(t *testing.T) {
 	tests := map[error]int{
-		repo.ErrUnauthorized:    http.StatusUnauthorized,
-		errors.New("undefined"): http.StatusInternalServerError,
+		repo.ErrUnauthorized:           http.StatusUnauthorized,
+		repo.ErrInvalidTransition:      http.StatusBadRequest,
+		repo.ErrInvalidFieldsForTicket: http.StatusBadRequest,
+		repo.ErrConflict:               http.StatusConflict,
+		errors.New("undefined"):        http.StatusInternalServerError,
 	}
 
 	for err, expectedStatus := range tests {
@@ -86,3 +89,30 @@ func TestValidateStringModel(t *testing.T) {
 		return
 	}
 }
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		doc, patch, expected string
+	}{
+		{`{"a": "b", "c": "d"}`, `{"a": "z"}`, `{"a":"z","c":"d"}`},
+		{`{"a": "b", "c": "d"}`, `{"a": null}`, `{"c":"d"}`},
+		{`{"a": ["b"]}`, `{"a": ["c", "d"]}`, `{"a":["c","d"]}`},
+		{`{"a": {"b": "c", "d": "e"}}`, `{"a": {"b": null, "f": "g"}}`, `{"a":{"d":"e","f":"g"}}`},
+		{`{"a": "b"}`, `{"a": {"c": "d"}}`, `{"a":{"c":"d"}}`},
+	}
+
+	for _, test := range tests {
+		patched, err := MergePatch([]byte(test.doc), []byte(test.patch))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(patched) != test.expected {
+			t.Errorf("Expected %s Got %s", test.expected, patched)
+		}
+	}
+
+	if _, err := MergePatch([]byte(`{}`), []byte(`["a"]`)); err != ErrPatchNotObject {
+		t.Errorf("Expected %s Got %v", ErrPatchNotObject, err)
+	}
+}
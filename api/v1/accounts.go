@@ -0,0 +1,210 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/praelatus/praelatus/api/middleware"
+	"github.com/praelatus/praelatus/api/utils"
+	"github.com/praelatus/praelatus/mail"
+	"github.com/praelatus/praelatus/models"
+)
+
+// Mail sends emails to users. When it is nil users who register are active
+// straight away and passwords can't be reset.
+var Mail mail.Sender
+
+// URL is the address of Praelatus used in links in emails
+var URL = "http://localhost:8080"
+
+const verificationEmail = `Hi %s,
+
+Please verify your email address for Praelatus by following this link:
+
+%s
+
+The link expires in 48 hours.
+`
+
+const passwordResetEmail = `Hi %s,
+
+Someone asked to reset your Praelatus password. To choose a new password
+follow this link:
+
+%s
+
+The link expires in an hour. If you didn't ask to reset your password you can
+ignore this email.
+`
+
+// link returns the URL of the given page with the token
+func link(page, token string) string {
+	return strings.TrimSuffix(URL, "/") + page + "?token=" + url.QueryEscape(token)
+}
+
+// sendVerification will email the user a link to verify their email address
+func sendVerification(user models.User) error {
+	token, err := middleware.NewVerificationToken(user)
+	if err != nil {
+		return err
+	}
+
+	return Mail.Send(mail.Message{
+		To:      []string{user.Email},
+		Subject: "Verify your email address",
+		Body:    fmt.Sprintf(verificationEmail, user.FullName, link("/verify-email", token)),
+	})
+}
+
+// sendPasswordReset will email the user a link to reset their password
+func sendPasswordReset(user models.User) error {
+	token, err := middleware.NewPasswordResetToken(user)
+	if err != nil {
+		return err
+	}
+
+	return Mail.Send(mail.Message{
+		To:      []string{user.Email},
+		Subject: "Reset your password",
+		Body:    fmt.Sprintf(passwordResetEmail, user.FullName, link("/reset-password", token)),
+	})
+}
+
+// requestPasswordReset will email a password reset link to the user with the
+// given email. It always succeeds so it can't be used to find out who has an
+// account.
+func requestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	if Mail == nil {
+		utils.APIErr(w, http.StatusNotImplemented, "email is not configured")
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Email == "" {
+		utils.APIErr(w, http.StatusBadRequest, "email is required")
+		return
+	}
+
+	// Users created by LDAP or an OIDC provider change their password
+	// there, they don't have one here to reset
+	user, err := Repo.Users().GetByEmail(&models.User{}, req.Email)
+	if err == nil && user.Provider == "" {
+		// Sent in the background so the response time doesn't reveal
+		// whether the user exists
+		go func() {
+			if err := sendPasswordReset(user); err != nil {
+				log.Println("Unable to send password reset to", user.Username, err)
+			}
+		}()
+	}
+
+	w.Write(utils.Success())
+}
+
+// confirmPasswordReset will set a new password for the user the reset token
+// was sent to and log them out everywhere. Since following the link proves
+// they own their email address it also verifies it.
+func confirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.APIErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Password == "" {
+		utils.APIErr(w, http.StatusBadRequest, "password is required")
+		return
+	}
+
+	user, err := middleware.PasswordResetUser(req.Token)
+	if err == middleware.ErrInvalidAccountToken {
+		utils.APIErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	if user.Provider != "" {
+		utils.APIErr(w, http.StatusForbidden,
+			"passwords for accounts from "+user.Provider+" can't be reset here")
+		return
+	}
+
+	hash, err := models.HashPassword(req.Password)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	err = Repo.Users().SetPassword(&user, user.Username, hash)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	err = Repo.Users().Activate(&user, user.Username)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	err = middleware.RevokeUserSessions(user.Username)
+	if err != nil && err != middleware.ErrNoSessionStore {
+		utils.Error(w, err)
+		return
+	}
+
+	w.Write(utils.Success())
+}
+
+// verifyEmail will activate the user the verification token was sent to
+func verifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.APIErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := middleware.VerificationUser(req.Token)
+	if err == middleware.ErrInvalidAccountToken {
+		utils.APIErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	err = Repo.Users().Activate(&user, user.Username)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	w.Write(utils.Success())
+}
@@ -0,0 +1,256 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package v1_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/praelatus/praelatus/api/middleware"
+	"github.com/praelatus/praelatus/api/v1"
+	pmail "github.com/praelatus/praelatus/mail"
+	"github.com/praelatus/praelatus/models"
+)
+
+var linkToken = regexp.MustCompile(`token=(\S+)`)
+
+// useMail will send emails to files in a temporary directory
+func useMail(t *testing.T) (*pmail.File, func()) {
+	dir, err := ioutil.TempDir("", "praelatus-mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := pmail.NewFile("praelatus@example.com", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v1.Mail = f
+
+	return f, func() {
+		v1.Mail = nil
+		os.RemoveAll(dir)
+	}
+}
+
+// waitForToken will wait for the nth email to be sent returning who it was
+// sent to and the token from the link in it
+func waitForToken(t *testing.T, f *pmail.File, n int) (string, string) {
+	var files []string
+
+	for i := 0; i < 100 && len(files) < n; i++ {
+		files, _ = f.Files()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(files) < n {
+		t.Fatalf("Expected %d emails Got %d", n, len(files))
+	}
+
+	raw, err := ioutil.ReadFile(files[n-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := ioutil.ReadAll(quotedprintable.NewReader(msg.Body))
+
+	match := linkToken.FindSubmatch(body)
+	if match == nil {
+		t.Fatalf("No token found in:\n%s", body)
+	}
+
+	token, err := url.QueryUnescape(string(match[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return msg.Header.Get("To"), token
+}
+
+func TestRegistrationVerification(t *testing.T) {
+	defer useBoltRepo(t)()
+
+	f, cleanup := useMail(t)
+	defer cleanup()
+
+	w := do("POST", "/api/v1/users", "", models.User{
+		Username: "newuser",
+		Password: "newpass",
+		Email:    "new@example.com",
+		FullName: "New User",
+	})
+	if w.Code != 200 || w.Header().Get("X-Praelatus-Token") != "" {
+		t.Fatalf("Expected the user to be created without a session Got: %d %s",
+			w.Code, w.Body.String())
+	}
+
+	login := map[string]string{"username": "newuser", "password": "newpass"}
+
+	if w = do("POST", "/api/v1/tokens", "", login); w.Code != 403 {
+		t.Errorf("Expected unverified users not to log in Got: %d", w.Code)
+	}
+
+	to, token := waitForToken(t, f, 1)
+	if to != "new@example.com" {
+		t.Errorf("Expected the email to go to new@example.com Got: %s", to)
+	}
+
+	if w = do("POST", "/api/v1/users/verify", "", map[string]string{"token": "forged"}); w.Code != 400 {
+		t.Errorf("Expected Status Code: 400 Got: %d", w.Code)
+	}
+
+	if w = do("POST", "/api/v1/users/verify", "", map[string]string{"token": token}); w.Code != 200 {
+		t.Fatalf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	if w = do("POST", "/api/v1/tokens", "", login); w.Code != 200 {
+		t.Errorf("Expected verified users to log in Got: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestEmailChangeVerification(t *testing.T) {
+	u, _ := models.NewUser("changer", "changerpass", "Changer", "old@example.com", false)
+
+	defer useBoltRepo(t, *u)()
+
+	login := map[string]string{"username": "changer", "password": "changerpass"}
+
+	w := do("POST", "/api/v1/tokens", "", login)
+	if w.Code != 200 {
+		t.Fatalf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	token := w.Header().Get("X-Praelatus-Token")
+	u.Email = "new@example.com"
+
+	// Without email the change can't be verified
+	if w = do("PUT", "/api/v1/users/changer", token, u); w.Code != 403 {
+		t.Errorf("Expected Status Code: 403 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	f, cleanup := useMail(t)
+	defer cleanup()
+
+	if w = do("PUT", "/api/v1/users/changer", token, u); w.Code != 200 {
+		t.Fatalf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	if w = do("GET", "/api/v1/users/me", token, nil); w.Code == 200 {
+		t.Errorf("Expected the session to be revoked Got: %s", w.Body.String())
+	}
+
+	if w = do("POST", "/api/v1/tokens", "", login); w.Code != 403 {
+		t.Errorf("Expected unverified users not to log in Got: %d", w.Code)
+	}
+
+	to, verify := waitForToken(t, f, 1)
+	if to != "new@example.com" {
+		t.Errorf("Expected the email to go to new@example.com Got: %s", to)
+	}
+
+	if w = do("POST", "/api/v1/users/verify", "", map[string]string{"token": verify}); w.Code != 200 {
+		t.Fatalf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	if w = do("POST", "/api/v1/tokens", "", login); w.Code != 200 {
+		t.Errorf("Expected verified users to log in Got: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	u, _ := models.NewUser("resetuser", "oldpass", "Reset User", "reset@example.com", false)
+	u.IsActive = true
+
+	defer useBoltRepo(t, *u)()
+
+	if w := do("POST", "/api/v1/users/password-reset", "",
+		map[string]string{"email": "reset@example.com"}); w.Code != 501 {
+		t.Errorf("Expected Status Code: 501 without email Got: %d", w.Code)
+	}
+
+	f, cleanup := useMail(t)
+	defer cleanup()
+
+	// Unknown emails look the same as known ones
+	for _, email := range []string{"nobody@example.com", "RESET@example.com"} {
+		w := do("POST", "/api/v1/users/password-reset", "", map[string]string{"email": email})
+		if w.Code != 200 {
+			t.Errorf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	to, token := waitForToken(t, f, 1)
+	if to != "reset@example.com" {
+		t.Errorf("Expected the email to go to reset@example.com Got: %s", to)
+	}
+
+	confirm := map[string]string{"token": token, "password": "newpass"}
+
+	if w := do("POST", "/api/v1/users/password-reset/confirm", "", confirm); w.Code != 200 {
+		t.Fatalf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	// The token stops working once the password has changed
+	confirm["password"] = "otherpass"
+	if w := do("POST", "/api/v1/users/password-reset/confirm", "", confirm); w.Code != 400 {
+		t.Errorf("Expected a used token to be rejected Got: %d", w.Code)
+	}
+
+	if w := do("POST", "/api/v1/tokens", "",
+		map[string]string{"username": "resetuser", "password": "newpass"}); w.Code != 200 {
+		t.Errorf("Expected to log in with the new password Got: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPasswordResetProviderAccount(t *testing.T) {
+	u, _ := models.NewUser("ldapuser", "ldappass", "LDAP User", "ldap@example.com", false)
+	u.IsActive = true
+	u.Provider = "ldap"
+
+	defer useBoltRepo(t, *u)()
+
+	f, cleanup := useMail(t)
+	defer cleanup()
+
+	if w := do("POST", "/api/v1/users/password-reset", "",
+		map[string]string{"email": "ldap@example.com"}); w.Code != 200 {
+		t.Errorf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if files, _ := f.Files(); len(files) != 0 {
+		t.Errorf("Expected no reset email for an LDAP account Got %d", len(files))
+	}
+
+	token, err := middleware.NewPasswordResetToken(*u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := do("POST", "/api/v1/users/password-reset/confirm", "",
+		map[string]string{"token": token, "password": "localpass"}); w.Code != 403 {
+		t.Errorf("Expected Status Code: 403 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	// The local password of an LDAP account is never accepted
+	if w := do("POST", "/api/v1/tokens", "",
+		map[string]string{"username": "ldapuser", "password": "ldappass"}); w.Code != 403 {
+		t.Errorf("Expected Status Code: 403 Got: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,367 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package v1
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/praelatus/praelatus/api/middleware"
+	"github.com/praelatus/praelatus/api/utils"
+	"github.com/praelatus/praelatus/events"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+)
+
+// ChatSigningSecret is the secret chat servers sign slash command requests
+// with, slash commands are disabled when it is empty
+var ChatSigningSecret string
+
+// Slash command requests older than this are rejected so they can't be
+// replayed
+const maxChatRequestAge = 5 * time.Minute
+
+// maxChatRequest is the largest slash command request which is read
+const maxChatRequest = 64 * 1024
+
+// chatSystem is used to look up the users who run slash commands
+var chatSystem = &models.User{Username: "system", IsAdmin: true}
+
+const chatUsage = "Usage:\n" +
+	"`/praelatus link CODE` links your chat account to Praelatus\n" +
+	"`/praelatus create PROJECT \"summary\" [\"description\"]` creates a ticket\n" +
+	"`/praelatus show TICKET` shows a ticket\n" +
+	"`/praelatus transition TICKET \"transition\"` transitions a ticket"
+
+func chatRouter(router *mux.Router) {
+	router.HandleFunc("/chat/commands", chatCommand).Methods("POST")
+	router.HandleFunc("/chat/link", chatLinkCode).Methods("POST")
+	router.HandleFunc("/chat/link", chatUnlink).Methods("DELETE")
+}
+
+// chatField, chatAttachment and chatResponse are the Slack slash command
+// response format, which Mattermost also accepts
+type chatField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+type chatAttachment struct {
+	Fallback  string      `json:"fallback"`
+	Color     string      `json:"color,omitempty"`
+	Title     string      `json:"title"`
+	TitleLink string      `json:"title_link"`
+	Text      string      `json:"text,omitempty"`
+	Fields    []chatField `json:"fields,omitempty"`
+}
+
+type chatResponse struct {
+	ResponseType string           `json:"response_type"`
+	Text         string           `json:"text"`
+	Attachments  []chatAttachment `json:"attachments,omitempty"`
+}
+
+// ephemeral returns a response only the user who ran the command sees
+func ephemeral(format string, args ...interface{}) chatResponse {
+	return chatResponse{
+		ResponseType: "ephemeral",
+		Text:         fmt.Sprintf(format, args...),
+	}
+}
+
+// ticketCard returns a response showing the ticket, it is posted in the
+// channel when inChannel is true
+func ticketCard(text string, t models.Ticket, inChannel bool) chatResponse {
+	assignee := t.Assignee
+	if assignee == "" {
+		assignee = "Unassigned"
+	}
+
+	description := []rune(t.Description)
+	if len(description) > 300 {
+		description = append(description[:300], '…')
+	}
+
+	res := ephemeral("%s", text)
+	if inChannel {
+		res.ResponseType = "in_channel"
+	}
+
+	res.Attachments = []chatAttachment{
+		{
+			Fallback:  t.Key + ": " + t.Summary,
+			Color:     "#3aa3e3",
+			Title:     t.Key + ": " + t.Summary,
+			TitleLink: strings.TrimSuffix(URL, "/") + "/tickets/" + t.Key,
+			Text:      string(description),
+			Fields: []chatField{
+				{Title: "Status", Value: t.Status.Name, Short: true},
+				{Title: "Type", Value: t.Type, Short: true},
+				{Title: "Assignee", Value: assignee, Short: true},
+				{Title: "Reporter", Value: t.Reporter, Short: true},
+			},
+		},
+	}
+
+	return res
+}
+
+// verifyChatSignature checks the request was signed with the secret the way
+// Slack signs requests, v0= followed by the hex encoded HMAC-SHA256 of
+// v0:timestamp:body, and that it isn't too old
+func verifyChatSignature(secret string, h http.Header, body []byte, now time.Time) bool {
+	ts := h.Get("X-Slack-Request-Timestamp")
+
+	sent, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	age := now.Sub(time.Unix(sent, 0))
+	if age > maxChatRequestAge || age < -maxChatRequestAge {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(h.Get("X-Slack-Signature")))
+}
+
+// splitArgs splits the text of a command on spaces, except inside double
+// quotes. Chat clients often turn quotes into curly quotes so those work too.
+func splitArgs(text string) []string {
+	var args []string
+	var arg bytes.Buffer
+
+	quoted, started := false, false
+
+	for _, r := range text {
+		switch {
+		case r == '"' || r == '“' || r == '”':
+			quoted, started = !quoted, true
+		case r == ' ' && !quoted:
+			if started {
+				args = append(args, arg.String())
+				arg.Reset()
+				started = false
+			}
+		default:
+			arg.WriteRune(r)
+			started = true
+		}
+	}
+
+	if started {
+		args = append(args, arg.String())
+	}
+
+	return args
+}
+
+// chatUser returns the user who linked the chat account
+func chatUser(account models.ChatAccount) (*models.User, error) {
+	u, err := Repo.Users().GetByChatAccount(chatSystem, account)
+	if err == repo.ErrNotFound || u.PendingVerification {
+		return nil, errors.New("your chat account isn't linked to a Praelatus account, " +
+			"get a link code from Praelatus and run `/praelatus link CODE`")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+// chatLink links the chat account to the user a link code was issued to
+func chatLink(account models.ChatAccount, code string) chatResponse {
+	if !account.Linked() {
+		return ephemeral("Unable to link: the chat server didn't say who you are")
+	}
+
+	username, err := middleware.ChatLinkUser(code)
+	if err != nil {
+		return ephemeral("Unable to link: %s", err.Error())
+	}
+
+	err = Repo.Users().SetChatAccount(chatSystem, username, account)
+	if err != nil {
+		return ephemeral("Unable to link: %s", err.Error())
+	}
+
+	return ephemeral("Your chat account is now linked to the Praelatus user %s", username)
+}
+
+// chatCommand runs a slash command sent by a chat server as the user who
+// linked the chat account which ran it
+func chatCommand(w http.ResponseWriter, r *http.Request) {
+	if ChatSigningSecret == "" {
+		utils.APIErr(w, http.StatusNotFound, "slash commands are not enabled")
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxChatRequest))
+	if err != nil {
+		utils.APIErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !verifyChatSignature(ChatSigningSecret, r.Header, body, time.Now()) {
+		utils.APIErr(w, http.StatusUnauthorized, "invalid signature")
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		utils.APIErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Users are found by their ids since chat users can change their
+	// usernames to anything
+	account := models.ChatAccount{
+		TeamID: form.Get("team_id"),
+		UserID: form.Get("user_id"),
+	}
+
+	args := splitArgs(form.Get("text"))
+	if len(args) == 2 && args[0] == "link" {
+		utils.SendJSON(w, chatLink(account, args[1]))
+		return
+	}
+
+	u, err := chatUser(account)
+	if err != nil {
+		utils.SendJSON(w, ephemeral("%s", err.Error()))
+		return
+	}
+
+	utils.SendJSON(w, runChatCommand(u, args))
+}
+
+// chatLinkCode returns a code which the logged in user can send with the link
+// slash command to link their chat account
+func chatLinkCode(w http.ResponseWriter, r *http.Request) {
+	u := middleware.GetUserSession(r)
+	if u == nil {
+		utils.APIErr(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+		return
+	}
+
+	if accountOwner(w, r) == nil {
+		return
+	}
+
+	code, err := middleware.NewChatLinkCode(*u)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	utils.SendJSON(w, map[string]string{
+		"code":    code,
+		"command": "/praelatus link " + code,
+	})
+}
+
+// chatUnlink unlinks the logged in user's chat account
+func chatUnlink(w http.ResponseWriter, r *http.Request) {
+	u := middleware.GetUserSession(r)
+	if u == nil {
+		utils.APIErr(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+		return
+	}
+
+	if accountOwner(w, r) == nil {
+		return
+	}
+
+	err := Repo.Users().SetChatAccount(u, u.Username, models.ChatAccount{})
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	w.Write(utils.Success())
+}
+
+func runChatCommand(u *models.User, args []string) chatResponse {
+	if len(args) == 0 {
+		return ephemeral(chatUsage)
+	}
+
+	switch {
+	case args[0] == "show" && len(args) == 2:
+		t, err := Repo.Tickets().Get(u, strings.ToUpper(args[1]))
+		if err != nil {
+			return ephemeral("Unable to show %s: %s", args[1], err.Error())
+		}
+
+		return ticketCard("", t, false)
+	case args[0] == "create" && (len(args) == 3 || len(args) == 4):
+		return chatCreate(u, strings.ToUpper(args[1]), args[2:]...)
+	case args[0] == "transition" && len(args) == 3:
+		t, _, err := Repo.Tickets().Transition(u, strings.ToUpper(args[1]), args[2])
+		if err != nil {
+			return ephemeral("Unable to transition %s: %s", args[1], err.Error())
+		}
+
+		events.Wake()
+
+		return ticketCard(fmt.Sprintf("%s transitioned %s to %s", u.Username,
+			t.Key, t.Status.Name), t, true)
+	}
+
+	return ephemeral(chatUsage)
+}
+
+// chatCreate creates a ticket with the project's first ticket type
+func chatCreate(u *models.User, projectKey string, text ...string) chatResponse {
+	p, err := Repo.Projects().Get(u, projectKey)
+	if err != nil {
+		return ephemeral("Unable to create a ticket in %s: %s", projectKey, err.Error())
+	}
+
+	if len(p.TicketTypes) == 0 {
+		return ephemeral("Unable to create a ticket in %s: project has no ticket types", projectKey)
+	}
+
+	t := models.Ticket{
+		Summary:  text[0],
+		Reporter: u.Username,
+		Type:     p.TicketTypes[0],
+		Project:  p.Key,
+		Watchers: []string{u.Username},
+	}
+
+	if len(text) == 2 {
+		t.Description = text[1]
+	}
+
+	t, err = Repo.Tickets().Create(u, t)
+	if err != nil {
+		return ephemeral("Unable to create a ticket in %s: %s", projectKey, err.Error())
+	}
+
+	events.Wake()
+
+	return ticketCard(fmt.Sprintf("%s created %s", u.Username, t.Key), t, true)
+}
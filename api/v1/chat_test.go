@@ -0,0 +1,191 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package v1_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/praelatus/praelatus/api/middleware"
+	"github.com/praelatus/praelatus/api/v1"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+)
+
+type chatResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+	Attachments  []struct {
+		Title     string `json:"title"`
+		TitleLink string `json:"title_link"`
+		Fields    []struct {
+			Title string `json:"title"`
+			Value string `json:"value"`
+		} `json:"fields"`
+	} `json:"attachments"`
+}
+
+// slashCommand sends the command text as the chat user with the id userID,
+// signed with secret at the given time. The username is always testadmin so
+// commands can't rely on it.
+func slashCommand(t *testing.T, secret, userID, text string, at time.Time) (int, chatResponse) {
+	body := url.Values{
+		"command":   {"/praelatus"},
+		"team_id":   {"T1"},
+		"user_id":   {userID},
+		"user_name": {"testadmin"},
+		"text":      {text},
+	}.Encode()
+
+	ts := strconv.FormatInt(at.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":" + body))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/api/v1/chat/commands", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Slack-Request-Timestamp", ts)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+
+	router.ServeHTTP(w, r)
+
+	var res chatResponse
+	if w.Code == 200 {
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err, w.Body.String())
+		}
+	}
+
+	return w.Code, res
+}
+
+// linkChat links the chat user with the id userID to the Praelatus user,
+// returning the user's session token
+func linkChat(t *testing.T, username, userID string) string {
+	u, err := v1.Repo.Users().Get(&models.User{Username: "system", IsAdmin: true}, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	if err = middleware.SetUserSession(u, w, httptest.NewRequest("POST", "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	token := w.Header().Get("X-Praelatus-Token")
+
+	if w = do("POST", "/api/v1/chat/link", "", nil); w.Code != 401 {
+		t.Errorf("Expected Status Code: 401 without a session Got: %d", w.Code)
+	}
+
+	w = do("POST", "/api/v1/chat/link", token, nil)
+	if w.Code != 200 {
+		t.Fatalf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	var link map[string]string
+	if err = json.Unmarshal(w.Body.Bytes(), &link); err != nil {
+		t.Fatal(err)
+	}
+
+	_, res := slashCommand(t, "chat-secret", userID, "link "+link["code"], time.Now())
+	if res.Text != "Your chat account is now linked to the Praelatus user "+username {
+		t.Fatalf("Expected %s to be linked Got %v", username, res)
+	}
+
+	return token
+}
+
+func TestChatCommands(t *testing.T) {
+	defer useBoltRepo(t)()
+
+	if err := repo.Seed(v1.Repo); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(secret string) { v1.ChatSigningSecret = secret }(v1.ChatSigningSecret)
+	v1.ChatSigningSecret = "chat-secret"
+
+	now := time.Now()
+
+	if code, _ := slashCommand(t, "wrong", "U1", "show TEST-1", now); code != 401 {
+		t.Errorf("Expected Status Code: 401 for a bad signature Got: %d", code)
+	}
+
+	if code, _ := slashCommand(t, "chat-secret", "U1", "show TEST-1", now.Add(-time.Hour)); code != 401 {
+		t.Errorf("Expected Status Code: 401 for an old request Got: %d", code)
+	}
+
+	_, res := slashCommand(t, "chat-secret", "U1", "show TEST-1", now)
+	if res.ResponseType != "ephemeral" || !strings.Contains(res.Text, "isn't linked") {
+		t.Errorf("Expected unlinked users to be told Got %v", res)
+	}
+
+	_, res = slashCommand(t, "chat-secret", "U1", "link 0123456789", now)
+	if !strings.Contains(res.Text, "invalid or expired link code") {
+		t.Errorf("Expected an unknown link code to be refused Got %v", res)
+	}
+
+	linkChat(t, "testadmin", "U1")
+	token := linkChat(t, "testuser", "U2")
+
+	_, res = slashCommand(t, "chat-secret", "U1", "show test-1", now)
+	if len(res.Attachments) != 1 || res.ResponseType != "ephemeral" ||
+		!strings.HasPrefix(res.Attachments[0].Title, "TEST-1: ") ||
+		res.Attachments[0].TitleLink != "http://localhost:8080/tickets/TEST-1" {
+		t.Errorf("Expected a card for TEST-1 Got %v", res)
+	}
+
+	_, res = slashCommand(t, "chat-secret", "U1",
+		`create TEST "Broken from chat" “It doesn't work”`, now)
+	if len(res.Attachments) != 1 || res.ResponseType != "in_channel" {
+		t.Fatalf("Expected a card for the new ticket Got %v", res)
+	}
+
+	key := strings.Fields(res.Text)[2]
+
+	created, err := v1.Repo.Tickets().Get(&models.User{Username: "system", IsAdmin: true}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if created.Summary != "Broken from chat" || created.Description != "It doesn't work" ||
+		created.Reporter != "testadmin" {
+		t.Errorf("Expected the ticket to be created as testadmin Got %v", created)
+	}
+
+	_, res = slashCommand(t, "chat-secret", "U1", `transition `+key+` "In Progress"`, now)
+	if res.ResponseType != "in_channel" || res.Text != "testadmin transitioned "+key+" to In Progress" {
+		t.Errorf("Expected the ticket to be transitioned Got %v", res)
+	}
+
+	_, res = slashCommand(t, "chat-secret", "U2", `transition `+key+` "Done"`, now)
+	if res.ResponseType != "ephemeral" || len(res.Attachments) != 0 {
+		t.Errorf("Expected testuser not to be able to transition Got %v", res)
+	}
+
+	_, res = slashCommand(t, "chat-secret", "U1", "", now)
+	if !strings.HasPrefix(res.Text, "Usage:") {
+		t.Errorf("Expected the usage Got %v", res)
+	}
+
+	if w := do("DELETE", "/api/v1/chat/link", token, nil); w.Code != 200 {
+		t.Errorf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	_, res = slashCommand(t, "chat-secret", "U2", "show TEST-1", now)
+	if !strings.Contains(res.Text, "isn't linked") {
+		t.Errorf("Expected testuser to be unlinked Got %v", res)
+	}
+}
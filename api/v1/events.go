@@ -0,0 +1,61 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package v1
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/praelatus/praelatus/api/middleware"
+	"github.com/praelatus/praelatus/api/utils"
+	"github.com/praelatus/praelatus/events"
+	"github.com/praelatus/praelatus/models"
+)
+
+func eventsRouter(router *mux.Router) {
+	router.HandleFunc("/events", eventSource).Methods("GET")
+	router.HandleFunc("/events/ws", eventStream).Methods("GET")
+}
+
+// streamUser returns the user for a request to one of the event streams.
+// Browsers can't set headers on websocket or EventSource requests so the
+// token can be given as a query parameter instead.
+func streamUser(r *http.Request) *models.User {
+	if token := r.FormValue("token"); token != "" && r.Header.Get("Authorization") == "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return middleware.GetUserSession(r)
+}
+
+// eventStream will upgrade the request to a websocket which is sent the
+// events the user subscribes to
+func eventStream(w http.ResponseWriter, r *http.Request) {
+	u := streamUser(r)
+	if u == nil {
+		utils.APIErr(w, http.StatusUnauthorized,
+			"you must be logged in to receive events")
+		return
+	}
+
+	// AddWs has already replied to the client if it fails
+	_ = events.AddWs(w, r, *u)
+}
+
+// eventSource will send the events for tickets the user can view as
+// server-sent events
+func eventSource(w http.ResponseWriter, r *http.Request) {
+	u := streamUser(r)
+	if u == nil {
+		utils.APIErr(w, http.StatusUnauthorized,
+			"you must be logged in to receive events")
+		return
+	}
+
+	err := events.ServeSSE(w, r, *u)
+	if err == events.ErrStreamingUnsupported {
+		utils.APIErr(w, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package v1_test
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/praelatus/praelatus/api/middleware"
+	"github.com/praelatus/praelatus/models"
+)
+
+func TestEventStream(t *testing.T) {
+	u, _ := models.NewUser("streamer", "streampass", "Streamer", "streamer@example.com", false)
+	u.IsActive = true
+
+	defer useBoltRepo(t, *u)()
+
+	w := do("GET", "/api/v1/events/ws", "", nil)
+	if w.Code != 401 {
+		t.Errorf("Expected Status Code: 401 Got: %d", w.Code)
+	}
+
+	w = do("POST", "/api/v1/tokens", "",
+		map[string]string{"username": "streamer", "password": "streampass"})
+	token := w.Header().Get("X-Praelatus-Token")
+
+	srv := httptest.NewServer(middleware.Default.Load(router))
+	defer srv.Close()
+
+	// The dialer's user agent has to match the one the token was issued to
+	endpoint := "ws" + strings.TrimPrefix(srv.URL, "http") +
+		"/api/v1/events/ws?token=" + url.QueryEscape(token)
+
+	conn, _, err := websocket.DefaultDialer.Dial(endpoint, map[string][]string{
+		"User-Agent": {""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	err = conn.WriteJSON(map[string]interface{}{
+		"action":   "subscribe",
+		"projects": []string{"TEST"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reply struct {
+		Subscribed struct {
+			Projects []string `json:"projects"`
+		} `json:"subscribed"`
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	err = conn.ReadJSON(&reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reply.Subscribed.Projects) != 1 || reply.Subscribed.Projects[0] != "TEST" {
+		t.Errorf("Expected subscription to TEST Got: %v", reply.Subscribed.Projects)
+	}
+}
+
+func TestEventSource(t *testing.T) {
+	u, _ := models.NewUser("streamer", "streampass", "Streamer", "streamer@example.com", false)
+	u.IsActive = true
+
+	defer useBoltRepo(t, *u)()
+
+	w := do("GET", "/api/v1/events", "", nil)
+	if w.Code != 401 {
+		t.Errorf("Expected Status Code: 401 Got: %d", w.Code)
+	}
+
+	w = do("POST", "/api/v1/tokens", "",
+		map[string]string{"username": "streamer", "password": "streampass"})
+	token := w.Header().Get("X-Praelatus-Token")
+
+	srv := httptest.NewServer(middleware.Default.Load(router))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/api/v1/events?token="+url.QueryEscape(token), nil)
+	req.Header.Set("User-Agent", "")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream Got: %d %s",
+			res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	// The stream is flushed through the middleware as soon as it opens
+	line, err := bufio.NewReader(res.Body).ReadString('\n')
+	if err != nil || line != ": connected\n" {
+		t.Errorf("Expected the stream to open Got: %q %v", line, err)
+	}
+}
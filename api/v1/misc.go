@@ -17,6 +17,7 @@ import (
 
 func miscRouter(router *mux.Router) {
 	router.HandleFunc("/permissions", getAllPermissions)
+	router.HandleFunc("/jwks", getJWKS).Methods("GET")
 	// router.HandleFunc("/labels", GetAllLabels).Methods("GET")
 	// router.HandleFunc("/types", GetAllTypes).Methods("GET")
 }
@@ -32,3 +33,9 @@ func getAllPermissions(w http.ResponseWriter, r *http.Request) {
 
 	utils.SendJSON(w, permission.ListOfPermissions)
 }
+
+// getJWKS will return the public keys session tokens can be verified with so
+// other services can authenticate Praelatus users
+func getJWKS(w http.ResponseWriter, r *http.Request) {
+	utils.SendJSON(w, middleware.JWKS())
+}
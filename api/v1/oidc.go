@@ -0,0 +1,203 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package v1
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/praelatus/praelatus/api/middleware"
+	"github.com/praelatus/praelatus/api/utils"
+	"github.com/praelatus/praelatus/auth"
+)
+
+// OIDC are the OpenID Connect providers users can log in with
+var OIDC []*auth.OIDC
+
+// oidcCookie holds the state, nonce and provider of an in progress login so
+// the callback can check it was started by the same browser
+const oidcCookie = "praelatus_oidc"
+
+func oidcRouter(router *mux.Router) {
+	router.HandleFunc("/auth/oidc/providers", getOIDCProviders).Methods("GET")
+	router.HandleFunc("/auth/oidc/login", oidcLogin).Methods("GET")
+	router.HandleFunc("/auth/oidc/callback", oidcCallback).Methods("GET")
+	router.HandleFunc("/auth/oidc/exchange", oidcExchange).Methods("POST")
+}
+
+// oidcProvider will return the provider with the given name, if name is empty
+// and only one provider is configured that provider is returned
+func oidcProvider(name string) *auth.OIDC {
+	if name == "" && len(OIDC) == 1 {
+		return OIDC[0]
+	}
+
+	for _, p := range OIDC {
+		if p.Name() == name {
+			return p
+		}
+	}
+
+	return nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// getOIDCProviders will return the names of the providers users can log in
+// with
+func getOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, len(OIDC))
+	for i, p := range OIDC {
+		names[i] = p.Name()
+	}
+
+	utils.SendJSON(w, names)
+}
+
+// oidcLogin will redirect the user to the provider named by the provider query
+// parameter to log in
+func oidcLogin(w http.ResponseWriter, r *http.Request) {
+	p := oidcProvider(r.URL.Query().Get("provider"))
+	if p == nil {
+		utils.APIErr(w, http.StatusNotFound, "no oidc provider with that name")
+		return
+	}
+
+	state, err := randomString()
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	nonce, err := randomString()
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	authURL, err := p.AuthCodeURL(state, nonce)
+	if err != nil {
+		log.Println("Unable to reach oidc provider", p.Name(), err)
+		utils.APIErr(w, http.StatusBadGateway, "unable to reach oidc provider")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    strings.Join([]string{state, nonce, p.Name()}, "."),
+		Path:     "/",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcCallback is where the provider sends users back to, the code it gives
+// is exchanged for the user's ID token. The browser is then sent on to the UI
+// with a login code in the URL fragment which the UI exchanges for a session
+// with oidcExchange, since the UI can't read tokens from this response.
+func oidcCallback(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(oidcCookie)
+	if err != nil {
+		utils.APIErr(w, http.StatusBadRequest, "no oidc login in progress")
+		return
+	}
+
+	// The login can only be completed once
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+	})
+
+	// Provider names may contain dots so they're kept last
+	parts := strings.SplitN(cookie.Value, ".", 3)
+	if len(parts) != 3 {
+		utils.APIErr(w, http.StatusBadRequest, "no oidc login in progress")
+		return
+	}
+
+	q := r.URL.Query()
+
+	if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(parts[0])) != 1 {
+		utils.APIErr(w, http.StatusBadRequest, "invalid state")
+		return
+	}
+
+	if e := q.Get("error"); e != "" {
+		utils.APIErr(w, http.StatusUnauthorized, "login failed: "+e)
+		return
+	}
+
+	p := oidcProvider(parts[2])
+	if p == nil {
+		utils.APIErr(w, http.StatusNotFound, "no oidc provider with that name")
+		return
+	}
+
+	user, err := p.Exchange(q.Get("code"), parts[1])
+	if err != nil {
+		log.Println("OIDC login with", p.Name(), "failed:", err)
+		utils.APIErr(w, http.StatusUnauthorized, "login failed")
+		return
+	}
+
+	code, err := middleware.NewLoginCode(user)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	// The UI routes on the fragment, which is never sent to servers, so the
+	// code can't leak through logs or the Referer header
+	http.Redirect(w, r, strings.TrimSuffix(URL, "/")+"/#/login/oidc?code="+
+		url.QueryEscape(code), http.StatusFound)
+}
+
+// oidcExchange will give the user a session for a login code from
+// oidcCallback, with the same verification and two-factor checks as a login
+// with a password
+func oidcExchange(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.APIErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := middleware.LoginCodeUser(req.Code)
+	if err == middleware.ErrInvalidLoginCode {
+		utils.APIErr(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	startSession(w, r, user)
+}
@@ -0,0 +1,216 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package v1_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/praelatus/praelatus/api/v1"
+	"github.com/praelatus/praelatus/auth"
+	"github.com/praelatus/praelatus/config"
+	"github.com/praelatus/praelatus/models"
+)
+
+func testOIDC(t *testing.T) *auth.MockIssuer {
+	issuer := auth.NewMockIssuer("praelatus")
+	issuer.Claims = jwt.MapClaims{"email": "test@example.com", "email_verified": true}
+
+	p, err := auth.NewOIDC(config.OIDCProvider{
+		Name:        "mock",
+		Issuer:      issuer.URL,
+		ClientID:    "praelatus",
+		RedirectURL: "http://localhost/api/v1/auth/oidc/callback",
+	}, v1.Repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v1.OIDC = []*auth.OIDC{p}
+	return issuer
+}
+
+// startOIDCLogin will start a login returning the state cookie and the query
+// the issuer sent the user back with
+func startOIDCLogin(t *testing.T, issuer *auth.MockIssuer) (*http.Cookie, url.Values) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/api/v1/auth/oidc/login?provider=mock", nil)
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected Status Code: 302 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("Expected an HttpOnly state cookie Got: %v", cookies)
+	}
+
+	code, state, err := issuer.Login(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cookies[0], url.Values{"code": {code}, "state": {state}}
+}
+
+func oidcCallback(cookie *http.Cookie, q url.Values) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/api/v1/auth/oidc/callback?"+q.Encode(), nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+
+	router.ServeHTTP(w, r)
+	return w
+}
+
+// oidcExchange will exchange the login code in the URL the callback
+// redirected to for a session
+func oidcExchange(t *testing.T, callback *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+	if callback.Code != http.StatusFound {
+		t.Fatalf("Expected Status Code: 302 Got: %d %s", callback.Code, callback.Body.String())
+	}
+
+	if callback.Header().Get("X-Praelatus-Token") != "" {
+		t.Error("Expected no session before the code is exchanged")
+	}
+
+	location, err := url.Parse(callback.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The code is only in the fragment where the UI's router reads it
+	route, err := url.Parse(location.Fragment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if location.RawQuery != "" || route.Path != "/login/oidc" {
+		t.Errorf("Expected a redirect to the UI Got: %s", location)
+	}
+
+	return do("POST", "/api/v1/auth/oidc/exchange", "",
+		map[string]string{"code": route.Query().Get("code")})
+}
+
+func TestOIDCLogin(t *testing.T) {
+	sso, _ := models.NewUser("ssouser", "ssopass", "SSO User", "test@example.com", false)
+	sso.EmailVerified = true
+
+	defer useBoltRepo(t, *sso)()
+
+	issuer := testOIDC(t)
+	defer issuer.Close()
+	defer func() { v1.OIDC = nil }()
+
+	cookie, q := startOIDCLogin(t, issuer)
+	callback := oidcCallback(cookie, q)
+
+	w := oidcExchange(t, callback)
+	if w.Code != 200 {
+		t.Errorf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+		return
+	}
+
+	var u models.User
+	if err := json.Unmarshal(w.Body.Bytes(), &u); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if u.Username != "ssouser" {
+		t.Errorf("Expected ssouser Got: %s", u.Username)
+	}
+
+	token := w.Header().Get("X-Praelatus-Token")
+	if token == "" {
+		t.Error("Expected a session token")
+		return
+	}
+
+	if w = do("GET", "/api/v1/users/me", token, nil); w.Code != 200 {
+		t.Errorf("Expected session to be valid Got: %d %s", w.Code, w.Body.String())
+	}
+
+	// Login codes can only be exchanged once
+	if w = oidcExchange(t, callback); w.Code != 401 {
+		t.Errorf("Expected Status Code: 401 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	w = do("POST", "/api/v1/auth/oidc/exchange", "", map[string]string{"code": "forged"})
+	if w.Code != 401 {
+		t.Errorf("Expected Status Code: 401 Got: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestOIDCCallbackErrors(t *testing.T) {
+	issuer := testOIDC(t)
+	defer issuer.Close()
+	defer func() { v1.OIDC = nil }()
+
+	cookie, q := startOIDCLogin(t, issuer)
+
+	if w := oidcCallback(nil, q); w.Code != 400 {
+		t.Errorf("(No Cookie) Expected Status Code: 400 Got: %d", w.Code)
+	}
+
+	bad := url.Values{"code": {q.Get("code")}, "state": {"wrong"}}
+	if w := oidcCallback(cookie, bad); w.Code != 400 {
+		t.Errorf("(Wrong State) Expected Status Code: 400 Got: %d", w.Code)
+	}
+
+	denied := url.Values{"error": {"access_denied"}, "state": {q.Get("state")}}
+	if w := oidcCallback(cookie, denied); w.Code != 401 {
+		t.Errorf("(Denied) Expected Status Code: 401 Got: %d", w.Code)
+	}
+
+	// The code is still unused so this is the first real exchange
+	issuer.Claims = jwt.MapClaims{"email": "test@example.com", "email_verified": false}
+	if w := oidcCallback(cookie, q); w.Code != 401 {
+		t.Errorf("(Unverified Email) Expected Status Code: 401 Got: %d", w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/api/v1/auth/oidc/login?provider=nope", nil)
+	router.ServeHTTP(w, r)
+
+	if w.Code != 404 {
+		t.Errorf("(Unknown Provider) Expected Status Code: 404 Got: %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/api/v1/auth/oidc/providers", nil)
+	router.ServeHTTP(w, r)
+
+	if w.Body.String() != `["mock"]` {
+		t.Errorf("Expected [\"mock\"] Got: %s", w.Body.String())
+	}
+}
+
+func TestOIDCLoginTwoFactor(t *testing.T) {
+	u, _ := models.NewUser("twofa", "twofapass", "Two Factor", "twofa@example.com", false)
+	u.EmailVerified = true
+	u.TwoFactor.Enabled = true
+
+	defer useBoltRepo(t, *u)()
+
+	issuer := testOIDC(t)
+	defer issuer.Close()
+	defer func() { v1.OIDC = nil }()
+
+	issuer.Claims = jwt.MapClaims{"email": "twofa@example.com", "email_verified": true}
+
+	cookie, q := startOIDCLogin(t, issuer)
+
+	// Logging in with a provider gets the same challenge as a password
+	challengeFrom(t, oidcExchange(t, oidcCallback(cookie, q)), false)
+}
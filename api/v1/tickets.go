@@ -6,14 +6,18 @@ package v1
 
 import (
 	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
 	"net/http"
 
 	"github.com/gorilla/mux"
 	"github.com/praelatus/praelatus/api/middleware"
 	"github.com/praelatus/praelatus/api/utils"
 	"github.com/praelatus/praelatus/events"
-	"github.com/praelatus/praelatus/events/event"
 	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/models/permission"
 	"github.com/praelatus/praelatus/ql/lexer"
 	"github.com/praelatus/praelatus/ql/parser"
 	"gopkg.in/mgo.v2/bson"
@@ -22,12 +26,14 @@ import (
 func ticketRouter(router *mux.Router) {
 	router.HandleFunc("/tickets", getAllTickets).Methods("GET")
 	router.HandleFunc("/tickets", createTicket).Methods("POST")
+	router.HandleFunc("/tickets/{key}", patchTicket).Methods("PATCH")
 	router.HandleFunc("/tickets/{key}", singleTicket)
-	// TODO: add update route
 
 	router.HandleFunc("/tickets/{key}/addComment", addComment).Methods("POST")
-	// TODO: add transition route
-	// TODO: add a "get available actions" route
+	router.HandleFunc("/tickets/{key}/transition", transitionTicket).Methods("POST")
+	router.HandleFunc("/tickets/{key}/actions", getAvailableActions).Methods("GET")
+	router.HandleFunc("/tickets/{key}/history", getTicketHistory).Methods("GET")
+	router.HandleFunc("/tickets/{key}/attachments/{id}", getAttachment).Methods("GET")
 }
 
 func createTicket(w http.ResponseWriter, r *http.Request) {
@@ -52,12 +58,7 @@ func createTicket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	go events.FireEvent(event.Generic{
-		User:           *u,
-		InProject:      models.Project{Key: t.Project},
-		EventType:      "CREATED",
-		ActionedTicket: t,
-	})
+	events.Wake()
 
 	utils.SendJSON(w, t)
 }
@@ -84,6 +85,9 @@ func singleTicket(w http.ResponseWriter, r *http.Request) {
 		}
 
 		err = Repo.Tickets().Update(u, id, ticket)
+		if err == nil {
+			events.Wake()
+		}
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 		return
@@ -97,6 +101,95 @@ func singleTicket(w http.ResponseWriter, r *http.Request) {
 	utils.SendJSON(w, t)
 }
 
+// readOnlyTicketFields are the fields of a ticket which can't be changed by
+// patching it
+var readOnlyTicketFields = []string{"key", "project", "workflow", "status",
+	"comments", "createdDate", "updatedDate"}
+
+// patchTicket will apply the JSON Merge Patch (RFC 7386) in the body to the
+// ticket, only the fields in the patch are changed
+func patchTicket(w http.ResponseWriter, r *http.Request) {
+	u := middleware.GetUserSession(r)
+	key := mux.Vars(r)["key"]
+
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil || (mt != "application/merge-patch+json" && mt != "application/json") {
+			utils.APIErr(w, http.StatusUnsupportedMediaType,
+				"patches must be application/merge-patch+json")
+			return
+		}
+	}
+
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		utils.APIErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var fields map[string]json.RawMessage
+
+	err = json.Unmarshal(patch, &fields)
+	if err != nil {
+		utils.APIErr(w, http.StatusBadRequest, utils.ErrPatchNotObject.Error())
+		return
+	}
+
+	for _, f := range readOnlyTicketFields {
+		if _, ok := fields[f]; ok {
+			utils.APIErr(w, http.StatusBadRequest, f+" can not be changed by an update")
+			return
+		}
+	}
+
+	t, err := Repo.Tickets().Get(u, key)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	doc, err := json.Marshal(t)
+	if err != nil {
+		utils.APIErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	patched, err := utils.MergePatch(doc, patch)
+	if err != nil {
+		utils.APIErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var updated models.Ticket
+
+	err = json.Unmarshal(patched, &updated)
+	if err != nil {
+		utils.APIErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := utils.ValidateModel(updated); err != nil {
+		utils.APIErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = Repo.Tickets().Update(u, key, updated)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	events.Wake()
+
+	t, err = Repo.Tickets().Get(u, key)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	utils.SendJSON(w, t)
+}
+
 // getAllTickets will return all tickets which the user has permissions to.
 func getAllTickets(w http.ResponseWriter, r *http.Request) {
 	u := middleware.GetUserSession(r)
@@ -109,6 +202,11 @@ func getAllTickets(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := a.Validate(); err != nil {
+		utils.APIErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	tickets, err := Repo.Tickets().Search(u, a)
 	if err != nil {
 		utils.APIErr(w, http.StatusInternalServerError, err.Error())
@@ -142,18 +240,153 @@ func addComment(w http.ResponseWriter, r *http.Request) {
 
 	key := mux.Vars(r)["key"]
 
-	ticket, err := Repo.Tickets().AddComment(u, key, c)
+	_, err = Repo.Tickets().AddComment(u, key, c)
 	if err != nil {
-		utils.APIErr(w, http.StatusInternalServerError, err.Error())
+		utils.Error(w, err)
 		return
 	}
 
-	go events.FireEvent(event.Comment{
-		User:           *u,
-		InProject:      models.Project{Key: ticket.Project},
-		ActionedTicket: ticket,
-		Comment:        c,
-	})
+	events.Wake()
 
 	utils.SendJSON(w, bson.M{})
 }
+
+// transitionTicket will run the transition given by the name query parameter
+// on the ticket, assuming it is valid for the ticket's current status in its
+// workflow.
+func transitionTicket(w http.ResponseWriter, r *http.Request) {
+	u := middleware.GetUserSession(r)
+	if u == nil {
+		utils.APIErr(w, http.StatusForbidden, "you must be logged in to transition a ticket")
+		return
+	}
+
+	name := r.FormValue("name")
+	if name == "" {
+		utils.APIErr(w, http.StatusBadRequest, "name is a required parameter")
+		return
+	}
+
+	key := mux.Vars(r)["key"]
+
+	ticket, _, err := Repo.Tickets().Transition(u, key, name)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	events.Wake()
+
+	utils.SendJSON(w, ticket)
+}
+
+// getAvailableActions will return the transitions which the logged in user can
+// perform on the ticket from its current status.
+func getAvailableActions(w http.ResponseWriter, r *http.Request) {
+	u := middleware.GetUserSession(r)
+	if u == nil {
+		utils.APIErr(w, http.StatusForbidden, "you must be logged in to see a ticket's actions")
+		return
+	}
+
+	key := mux.Vars(r)["key"]
+
+	ticket, err := Repo.Tickets().Get(u, key)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	p, err := Repo.Projects().Get(u, ticket.Project)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	// The session doesn't carry the user's roles so grab them from the
+	// database, everything else comes from the session so that API token
+	// scopes still apply.
+	dbUser, err := Repo.Users().Get(u, u.Username)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	user := *u
+	user.Roles = dbUser.Roles
+
+	if len(models.HasPermission(permission.TransitionTicket, user, p)) == 0 {
+		utils.SendJSON(w, []models.Transition{})
+		return
+	}
+
+	workflow, err := Repo.Workflows().Get(u, ticket.Workflow.Hex())
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	// Transitions are run by name so only offer the ones running that name
+	// would perform
+	actions := models.Transitions{}
+
+	for _, t := range ticket.AvailableTransitions(workflow) {
+		run, ok := ticket.Transition(workflow, t.Name)
+		if ok && run.FromStatus == t.FromStatus && run.ToStatus == t.ToStatus {
+			actions = append(actions, t)
+		}
+	}
+
+	utils.SendJSON(w, actions)
+}
+
+// getTicketHistory will send the changes made to the ticket's fields, oldest
+// first
+func getTicketHistory(w http.ResponseWriter, r *http.Request) {
+	u := middleware.GetUserSession(r)
+
+	history, err := Repo.Tickets().History(u, mux.Vars(r)["key"])
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	utils.SendJSON(w, history)
+}
+
+// getAttachment will send a file attached to one of the ticket's comments
+func getAttachment(w http.ResponseWriter, r *http.Request) {
+	u := middleware.GetUserSession(r)
+	vars := mux.Vars(r)
+
+	ticket, err := Repo.Tickets().Get(u, vars["key"])
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	a, ok := ticket.Attachment(vars["id"])
+	if !ok || Files == nil {
+		utils.APIErr(w, http.StatusNotFound, "attachment not found")
+		return
+	}
+
+	f, err := Files.Get(a.Path)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	defer f.Close()
+
+	// Always download attachments so HTML ones can't run as Praelatus
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": a.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	_, err = io.Copy(w, f)
+	if err != nil {
+		log.Println("Unable to send attachment", a.Path, err)
+	}
+}
@@ -6,9 +6,17 @@ package v1_test
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/praelatus/praelatus/api/v1"
+	"github.com/praelatus/praelatus/files/filesystem"
 	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"gopkg.in/mgo.v2/bson"
 )
 
 func ticketFromJSON(jsn []byte) (interface{}, error) {
@@ -23,6 +31,12 @@ func ticketsFromJSON(jsn []byte) (interface{}, error) {
 	return tk, err
 }
 
+func transitionsFromJSON(jsn []byte) (interface{}, error) {
+	var tr []models.Transition
+	err := json.Unmarshal(jsn, &tr)
+	return tr, err
+}
+
 func toTickets(v interface{}) []models.Ticket {
 	return v.([]models.Ticket)
 }
@@ -113,22 +127,276 @@ var ticketRouteTests = []routeTest{
 	// 	},
 	// },
 
-	// {
-	// 	Name:      "Transition Ticket",
-	// 	Login:     true,
-	// 	Method:    "POST",
-	// 	Endpoint:  "/api/v1/tickets/TEST-1/transition?name=In%20Progress",
-	// 	Converter: ticketFromJSON,
-	// 	Validator: func(v interface{}, t *testing.T) {
-	// 		tk := toTicket(v)
+	{
+		Name:      "Transition Ticket",
+		Login:     true,
+		Method:    "POST",
+		Endpoint:  "/api/v1/tickets/TEST-1/transition?name=In%20Progress",
+		Converter: ticketFromJSON,
+		Validator: func(v interface{}, t *testing.T) {
+			tk := toTicket(v)
 
-	// 		if tk.Status != "In Progress" {
-	// 			t.Errorf("Expected In Progress Got: %s", tk.Status)
-	// 		}
-	// 	},
-	// },
+			if tk.Status.Name != "In Progress" {
+				t.Errorf("Expected In Progress Got: %s", tk.Status.Name)
+			}
+		},
+	},
+
+	{
+		Name:         "Invalid Transition",
+		Login:        true,
+		Method:       "POST",
+		Endpoint:     "/api/v1/tickets/TEST-1/transition?name=Backlog",
+		ExpectedCode: 400,
+	},
+
+	{
+		Name:      "Available Actions",
+		Admin:     true,
+		Endpoint:  "/api/v1/tickets/TEST-1/actions",
+		Converter: transitionsFromJSON,
+		Validator: func(v interface{}, t *testing.T) {
+			tr := v.([]models.Transition)
+
+			if len(tr) != 2 {
+				t.Errorf("Expected 2 Transitions Got: %d", len(tr))
+			}
+
+			for _, transition := range tr {
+				if transition.IsCreate() {
+					t.Errorf("Expected no create transition Got: %s", transition.Name)
+				}
+			}
+		},
+	},
+
+	{
+		// The mock repo's users are admins but the session user isn't
+		Name:      "Available Actions Without Permission",
+		Login:     true,
+		Endpoint:  "/api/v1/tickets/TEST-1/actions",
+		Converter: transitionsFromJSON,
+		Validator: func(v interface{}, t *testing.T) {
+			tr := v.([]models.Transition)
+
+			if len(tr) != 0 {
+				t.Errorf("Expected 0 Transitions Got: %d", len(tr))
+			}
+		},
+	},
+
+	{
+		Name:         "Available Actions Not Logged In",
+		Endpoint:     "/api/v1/tickets/TEST-1/actions",
+		ExpectedCode: 403,
+	},
+
+	{
+		Name:         "Transition Ticket Not Logged In",
+		Method:       "POST",
+		Endpoint:     "/api/v1/tickets/TEST-1/transition?name=In%20Progress",
+		ExpectedCode: 403,
+	},
 }
 
 func TestTicketRoutes(t *testing.T) {
 	testRoutes(ticketRouteTests, t)
 }
+
+func TestTicketAttachment(t *testing.T) {
+	u, _ := models.NewUser("attacher", "attachpass", "Attacher", "attacher@example.com", true)
+	u.IsActive = true
+
+	defer useBoltRepo(t, *u)()
+
+	err := repo.Seed(v1.Repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "praelatus-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	v1.Files = filesystem.NewAt(dir)
+	defer func() { v1.Files = nil }()
+
+	path, err := v1.Files.Put("page.html", strings.NewReader("<script>alert(1)</script>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := models.Attachment{
+		ID:          bson.NewObjectId(),
+		Name:        "page.html",
+		ContentType: "text/html",
+		Size:        25,
+		Path:        path,
+	}
+
+	_, err = v1.Repo.Tickets().AddComment(u, "TEST-1", models.Comment{
+		Author:      "attacher",
+		Body:        "see attached",
+		Attachments: []models.Attachment{a},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := do("POST", "/api/v1/tokens", "",
+		map[string]string{"username": "attacher", "password": "attachpass"})
+	token := w.Header().Get("X-Praelatus-Token")
+
+	w = do("GET", "/api/v1/tickets/TEST-1/attachments/"+a.ID.Hex(), token, nil)
+	if w.Code != 200 || w.Body.String() != "<script>alert(1)</script>" {
+		t.Fatalf("Expected the attachment Got: %d %s", w.Code, w.Body.String())
+	}
+
+	if w.Header().Get("Content-Disposition") != `attachment; filename=page.html` {
+		t.Errorf("Expected the attachment to be downloaded Got: %s",
+			w.Header().Get("Content-Disposition"))
+	}
+
+	w = do("GET", "/api/v1/tickets/TEST-1/attachments/"+bson.NewObjectId().Hex(), token, nil)
+	if w.Code != 404 {
+		t.Errorf("Expected Status Code: 404 Got: %d", w.Code)
+	}
+}
+
+func TestPatchTicket(t *testing.T) {
+	u, _ := models.NewUser("patcher", "patchpass", "Patcher", "patcher@example.com", true)
+	u.IsActive = true
+
+	defer useBoltRepo(t, *u)()
+
+	err := repo.Seed(v1.Repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := v1.Repo.Tickets().Get(u, "TEST-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := do("POST", "/api/v1/tokens", "",
+		map[string]string{"username": "patcher", "password": "patchpass"})
+	token := w.Header().Get("X-Praelatus-Token")
+
+	w = do("PATCH", "/api/v1/tickets/TEST-1", token,
+		json.RawMessage(`{"summary": "Patched", "assignee": null, "labels": ["triaged"]}`))
+	if w.Code != 200 {
+		t.Fatalf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	var after models.Ticket
+	if err = json.Unmarshal(w.Body.Bytes(), &after); err != nil {
+		t.Fatal(err)
+	}
+
+	if after.Summary != "Patched" || after.Assignee != "" || len(after.Labels) != 1 ||
+		after.Description != before.Description || after.Status != before.Status ||
+		len(after.Comments) != len(before.Comments) {
+		t.Errorf("Expected only the patched fields to change Got %v", after)
+	}
+
+	due, err := v1.Repo.Outbox().Due(u, time.Now().Add(time.Minute), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var changed []string
+
+	for _, o := range due {
+		if o.Type == models.UpdatedEvent && o.Ticket.Key == "TEST-1" {
+			for _, c := range o.Changes {
+				changed = append(changed, c.Field)
+			}
+		}
+	}
+
+	if strings.Join(changed, ",") != "summary,assignee,labels" {
+		t.Errorf("Expected the changed fields in the UPDATED event Got %v", changed)
+	}
+
+	for _, patch := range []string{`{"status": {"name": "Done"}}`, `["summary"]`, `{"type": "Nope"}`} {
+		if w = do("PATCH", "/api/v1/tickets/TEST-1", token, json.RawMessage(patch)); w.Code != 400 {
+			t.Errorf("Expected Status Code: 400 for %s Got: %d %s", patch, w.Code, w.Body.String())
+		}
+	}
+
+	w = do("POST", "/api/v1/tokens", "",
+		map[string]string{"username": "testuser", "password": "test"})
+
+	w = do("PATCH", "/api/v1/tickets/TEST-1", w.Header().Get("X-Praelatus-Token"),
+		json.RawMessage(`{"summary": "Not allowed"}`))
+	if w.Code == 200 {
+		t.Errorf("Expected users without EDIT_TICKET not to be able to patch Got: %s", w.Body.String())
+	}
+}
+
+func TestTicketHistory(t *testing.T) {
+	defer useBoltRepo(t)()
+
+	err := repo.Seed(v1.Repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := v1.Repo.Tickets().Get(&models.User{Username: "system", IsAdmin: true}, "TEST-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assignee := "testuser"
+	if before.Assignee == assignee {
+		assignee = "testadmin"
+	}
+
+	w := do("POST", "/api/v1/tokens", "",
+		map[string]string{"username": "testadmin", "password": "test"})
+	token := w.Header().Get("X-Praelatus-Token")
+
+	w = do("PATCH", "/api/v1/tickets/TEST-1", token,
+		map[string]string{"assignee": assignee})
+	if w.Code != 200 {
+		t.Fatalf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	w = do("POST", "/api/v1/tickets/TEST-1/transition?name=Done", token, nil)
+	if w.Code != 200 {
+		t.Fatalf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	w = do("GET", "/api/v1/tickets/TEST-1/history", token, nil)
+	if w.Code != 200 {
+		t.Fatalf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	var history []models.HistoryEntry
+	if err = json.Unmarshal(w.Body.Bytes(), &history); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) < 3 || history[0].Field != models.HistoryCreated {
+		t.Fatalf("Expected the history from the ticket being created Got %v", history)
+	}
+
+	history = history[len(history)-2:]
+
+	if history[0].Field != "assignee" || history[0].From != before.Assignee ||
+		history[0].To != assignee || history[0].Actor != "testadmin" || history[0].Date.IsZero() {
+		t.Errorf("Expected the assignee change Got %v", history[0])
+	}
+
+	if history[1].Field != "status" || history[1].To != "Done" {
+		t.Errorf("Expected the status change Got %v", history[1])
+	}
+
+	if w = do("GET", "/api/v1/tickets/TEST-404/history", token, nil); w.Code != 404 {
+		t.Errorf("Expected Status Code: 404 Got: %d", w.Code)
+	}
+}
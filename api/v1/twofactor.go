@@ -0,0 +1,342 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/praelatus/praelatus/api/middleware"
+	"github.com/praelatus/praelatus/api/utils"
+	"github.com/praelatus/praelatus/auth"
+	"github.com/praelatus/praelatus/models"
+)
+
+// RequireTwoFactor enforces two-factor authentication for system
+// administrators and users who can administer a project, they must set it up
+// before they are given a session
+var RequireTwoFactor bool
+
+func twoFactorRouter(router *mux.Router) {
+	router.HandleFunc("/tokens/2fa", loginTwoFactor).Methods("POST")
+
+	router.HandleFunc("/users/me/2fa", getTwoFactor).Methods("GET")
+	router.HandleFunc("/users/me/2fa", enrollTwoFactor).Methods("POST")
+	router.HandleFunc("/users/me/2fa", disableTwoFactor).Methods("DELETE")
+	router.HandleFunc("/users/me/2fa/verify", verifyTwoFactor).Methods("POST")
+	router.HandleFunc("/users/me/2fa/recovery", regenerateRecoveryCodes).Methods("POST")
+	router.HandleFunc("/users/{username}/2fa", resetTwoFactor).Methods("DELETE")
+}
+
+// twoFactorChallenge is sent instead of a session when a user logging in
+// still needs to enter a two-factor code, or set up two-factor authentication
+// when Enroll is true
+type twoFactorChallenge struct {
+	Message   string `json:"message"`
+	Challenge string `json:"challenge"`
+	Enroll    bool   `json:"enroll"`
+}
+
+// sendChallenge will respond to a login with a challenge for the user
+func sendChallenge(w http.ResponseWriter, user models.User, enroll bool) {
+	challenge, err := middleware.NewTwoFactorChallenge(user, enroll)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	msg := "two-factor code required"
+	if enroll {
+		msg = "two-factor authentication must be set up before logging in"
+	}
+
+	w.WriteHeader(http.StatusUnauthorized)
+	utils.SendJSON(w, twoFactorChallenge{
+		Message:   msg,
+		Challenge: challenge,
+		Enroll:    enroll,
+	})
+}
+
+// twoFactorRequired reports whether the user must use two-factor
+// authentication
+func twoFactorRequired(user models.User) (bool, error) {
+	if !RequireTwoFactor {
+		return false, nil
+	}
+
+	return auth.Privileged(Repo, user)
+}
+
+// twoFactorUser returns the stored user for the two-factor endpoints, they
+// can be used with a session or the challenge given to users who must set up
+// two-factor authentication before logging in
+func twoFactorUser(w http.ResponseWriter, r *http.Request) *models.User {
+	u := middleware.GetEnrollmentSession(r)
+	if u == nil {
+		utils.APIErr(w, http.StatusForbidden, "you must be logged in")
+		return nil
+	}
+
+	user, err := Repo.Users().Get(u, u.Username)
+	if err != nil {
+		utils.Error(w, err)
+		return nil
+	}
+
+	return &user
+}
+
+type twoFactorCode struct {
+	Challenge string `json:"challenge,omitempty"`
+	Code      string `json:"code"`
+}
+
+func decodeTwoFactorCode(w http.ResponseWriter, r *http.Request) (twoFactorCode, bool) {
+	var req twoFactorCode
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.APIErr(w, http.StatusBadRequest, err.Error())
+		return req, false
+	}
+
+	if req.Code == "" {
+		utils.APIErr(w, http.StatusBadRequest, "code is required")
+		return req, false
+	}
+
+	return req, true
+}
+
+// sendRecoveryCodes will store new recovery codes for the user and send them
+// back, they are never shown again
+func sendRecoveryCodes(w http.ResponseWriter, user *models.User, tf models.TwoFactor) {
+	codes, hashes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	tf.RecoveryCodes = hashes
+
+	err = Repo.Users().SetTwoFactor(user, user.Username, tf)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	utils.SendJSON(w, map[string][]string{"recoveryCodes": codes})
+}
+
+// loginTwoFactor will exchange the challenge from login and a two-factor code
+// or recovery code for a session
+func loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeTwoFactorCode(w, r)
+	if !ok {
+		return
+	}
+
+	u, err := middleware.TwoFactorChallengeUser(req.Challenge)
+	if err != nil {
+		utils.APIErr(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	user, err := Repo.Users().Get(u, u.Username)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	tf := user.TwoFactor
+	if !auth.VerifyTwoFactor(&tf, req.Code) {
+		utils.APIErr(w, http.StatusForbidden, "invalid two-factor code")
+		return
+	}
+
+	err = Repo.Users().SetTwoFactor(&user, user.Username, tf)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	err = middleware.SetUserSession(user, w, r)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	utils.SendJSON(w, user)
+}
+
+func getTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := twoFactorUser(w, r)
+	if user == nil {
+		return
+	}
+
+	required, err := twoFactorRequired(*user)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	utils.SendJSON(w, struct {
+		Enabled       bool `json:"enabled"`
+		Required      bool `json:"required"`
+		RecoveryCodes int  `json:"recoveryCodes"`
+	}{
+		Enabled:       user.TwoFactor.Enabled,
+		Required:      required,
+		RecoveryCodes: len(user.TwoFactor.RecoveryCodes),
+	})
+}
+
+// enrollTwoFactor will generate a new secret for the user to add to their
+// authenticator, two-factor authentication is enabled once they confirm it
+// with verifyTwoFactor
+func enrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := twoFactorUser(w, r)
+	if user == nil {
+		return
+	}
+
+	if user.TwoFactor.Enabled {
+		utils.APIErr(w, http.StatusBadRequest, "two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	err = Repo.Users().SetTwoFactor(user, user.Username, models.TwoFactor{Secret: secret})
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	utils.SendJSON(w, map[string]string{
+		"secret": secret,
+		"uri":    auth.TOTPURI(user.Username, secret),
+	})
+}
+
+// verifyTwoFactor will enable two-factor authentication once the user has
+// entered a code for the secret from enrollTwoFactor
+func verifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := twoFactorUser(w, r)
+	if user == nil {
+		return
+	}
+
+	req, ok := decodeTwoFactorCode(w, r)
+	if !ok {
+		return
+	}
+
+	tf := user.TwoFactor
+	if tf.Enabled {
+		utils.APIErr(w, http.StatusBadRequest, "two-factor authentication is already enabled")
+		return
+	}
+
+	if tf.Secret == "" {
+		utils.APIErr(w, http.StatusBadRequest, "two-factor enrollment has not been started")
+		return
+	}
+
+	if !auth.VerifyTOTP(&tf, req.Code) {
+		utils.APIErr(w, http.StatusForbidden, "invalid two-factor code")
+		return
+	}
+
+	tf.Enabled = true
+	sendRecoveryCodes(w, user, tf)
+}
+
+// regenerateRecoveryCodes will replace the user's recovery codes
+func regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user := twoFactorUser(w, r)
+	if user == nil {
+		return
+	}
+
+	req, ok := decodeTwoFactorCode(w, r)
+	if !ok {
+		return
+	}
+
+	tf := user.TwoFactor
+	if !auth.VerifyTwoFactor(&tf, req.Code) {
+		utils.APIErr(w, http.StatusForbidden, "invalid two-factor code")
+		return
+	}
+
+	sendRecoveryCodes(w, user, tf)
+}
+
+func disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := twoFactorUser(w, r)
+	if user == nil {
+		return
+	}
+
+	req, ok := decodeTwoFactorCode(w, r)
+	if !ok {
+		return
+	}
+
+	required, err := twoFactorRequired(*user)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	if required {
+		utils.APIErr(w, http.StatusForbidden, "two-factor authentication is required for administrators")
+		return
+	}
+
+	tf := user.TwoFactor
+	if !auth.VerifyTwoFactor(&tf, req.Code) {
+		utils.APIErr(w, http.StatusForbidden, "invalid two-factor code")
+		return
+	}
+
+	err = Repo.Users().SetTwoFactor(user, user.Username, models.TwoFactor{})
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	w.Write(utils.Success())
+}
+
+// resetTwoFactor lets administrators turn off two-factor authentication for
+// users who have lost their authenticator and recovery codes
+func resetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	u := accountOwner(w, r)
+	if u == nil {
+		return
+	}
+
+	if !u.IsAdmin {
+		utils.APIErr(w, http.StatusForbidden, "you must be an administrator")
+		return
+	}
+
+	err := Repo.Users().SetTwoFactor(u, mux.Vars(r)["username"], models.TwoFactor{})
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	w.Write(utils.Success())
+}
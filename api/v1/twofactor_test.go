@@ -0,0 +1,204 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package v1_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/praelatus/praelatus/api/v1"
+	"github.com/praelatus/praelatus/auth"
+	"github.com/praelatus/praelatus/models"
+)
+
+// twoFactorRepo swaps the mock repo for a bolt repo with the user twofa,
+// whose password is twofapass, since enrolling needs somewhere to store the
+// secret
+func twoFactorRepo(t *testing.T, admin bool) func() {
+	u, err := models.NewUser("twofa", "twofapass", "Two Factor", "twofa@example.com", admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u.IsActive = true
+	cleanup := useBoltRepo(t, *u)
+
+	return func() {
+		v1.RequireTwoFactor = false
+		cleanup()
+	}
+}
+
+func do(method, endpoint, token string, body interface{}) *httptest.ResponseRecorder {
+	jsn, _ := json.Marshal(body)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, endpoint, bytes.NewBuffer(jsn))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func passwordLogin(t *testing.T) *httptest.ResponseRecorder {
+	return do("POST", "/api/v1/tokens", "",
+		map[string]string{"username": "twofa", "password": "twofapass"})
+}
+
+// enroll will set up two-factor authentication with the given token
+// returning the secret and recovery codes
+func enroll(t *testing.T, token string) (string, []string) {
+	w := do("POST", "/api/v1/users/me/2fa", token, nil)
+	if w.Code != 200 {
+		t.Fatalf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	var enrollment struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+
+	json.Unmarshal(w.Body.Bytes(), &enrollment)
+
+	if enrollment.Secret == "" || enrollment.URI != auth.TOTPURI("twofa", enrollment.Secret) {
+		t.Fatalf("Expected a secret and provisioning uri Got: %s", w.Body.String())
+	}
+
+	w = do("POST", "/api/v1/users/me/2fa/verify", token, map[string]string{"code": "000000"})
+	if w.Code != 403 {
+		t.Errorf("(Wrong Code) Expected Status Code: 403 Got: %d", w.Code)
+	}
+
+	code, _ := auth.TOTPCode(enrollment.Secret, time.Now())
+
+	w = do("POST", "/api/v1/users/me/2fa/verify", token, map[string]string{"code": code})
+	if w.Code != 200 {
+		t.Fatalf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	var recovery struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}
+
+	json.Unmarshal(w.Body.Bytes(), &recovery)
+
+	if len(recovery.RecoveryCodes) == 0 {
+		t.Fatalf("Expected recovery codes Got: %s", w.Body.String())
+	}
+
+	return enrollment.Secret, recovery.RecoveryCodes
+}
+
+func challengeFrom(t *testing.T, w *httptest.ResponseRecorder, enroll bool) string {
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected Status Code: 401 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	var challenge struct {
+		Challenge string `json:"challenge"`
+		Enroll    bool   `json:"enroll"`
+	}
+
+	json.Unmarshal(w.Body.Bytes(), &challenge)
+
+	if challenge.Challenge == "" || challenge.Enroll != enroll {
+		t.Fatalf("Expected a challenge with enroll %v Got: %s", enroll, w.Body.String())
+	}
+
+	return challenge.Challenge
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	defer twoFactorRepo(t, false)()
+
+	w := passwordLogin(t)
+	if w.Code != 200 {
+		t.Fatalf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	secret, recoveryCodes := enroll(t, w.Header().Get("X-Praelatus-Token"))
+
+	challenge := challengeFrom(t, passwordLogin(t), false)
+
+	w = do("POST", "/api/v1/tokens/2fa", "",
+		map[string]string{"challenge": challenge, "code": "000000"})
+	if w.Code != 403 {
+		t.Errorf("(Wrong Code) Expected Status Code: 403 Got: %d", w.Code)
+	}
+
+	// A challenge can only be answered once
+	code, _ := auth.TOTPCode(secret, time.Now())
+
+	w = do("POST", "/api/v1/tokens/2fa", "",
+		map[string]string{"challenge": challenge, "code": code})
+	if w.Code != 401 {
+		t.Errorf("(Used Challenge) Expected Status Code: 401 Got: %d", w.Code)
+	}
+
+	challenge = challengeFrom(t, passwordLogin(t), false)
+
+	w = do("POST", "/api/v1/tokens/2fa", "",
+		map[string]string{"challenge": challenge, "code": recoveryCodes[0]})
+	if w.Code != 200 || w.Header().Get("X-Praelatus-Token") == "" {
+		t.Fatalf("Expected a session Got: %d %s", w.Code, w.Body.String())
+	}
+
+	token := w.Header().Get("X-Praelatus-Token")
+
+	w = do("GET", "/api/v1/users/me/2fa", token, nil)
+	if w.Body.String() != `{"enabled":true,"required":false,"recoveryCodes":9}` {
+		t.Errorf("Unexpected status %s", w.Body.String())
+	}
+
+	w = do("DELETE", "/api/v1/users/me/2fa", token, map[string]string{"code": recoveryCodes[1]})
+	if w.Code != 200 {
+		t.Errorf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	if w = passwordLogin(t); w.Code != 200 {
+		t.Errorf("Expected 2FA to be disabled Got: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestTwoFactorRequired(t *testing.T) {
+	defer twoFactorRepo(t, true)()
+
+	v1.RequireTwoFactor = true
+
+	challenge := challengeFrom(t, passwordLogin(t), true)
+
+	// The enrollment challenge isn't a session
+	if w := do("GET", "/api/v1/users/me", challenge, nil); w.Code != 404 {
+		t.Errorf("Expected Status Code: 404 Got: %d", w.Code)
+	}
+
+	secret, _ := enroll(t, challenge)
+
+	challenge = challengeFrom(t, passwordLogin(t), false)
+	code, _ := auth.TOTPCode(secret, time.Now().Add(30*time.Second))
+
+	w := do("POST", "/api/v1/tokens/2fa", "",
+		map[string]string{"challenge": challenge, "code": code})
+	if w.Code != 200 {
+		t.Fatalf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	token := w.Header().Get("X-Praelatus-Token")
+
+	w = do("DELETE", "/api/v1/users/me/2fa", token, map[string]string{"code": code})
+	if w.Code != 403 {
+		t.Errorf("Expected required 2FA not to be disabled Got: %d", w.Code)
+	}
+
+	if w = do("DELETE", "/api/v1/users/twofa/2fa", token, nil); w.Code != 200 {
+		t.Errorf("Expected admins to reset 2FA Got: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -9,10 +9,13 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/praelatus/praelatus/api/middleware"
 	"github.com/praelatus/praelatus/api/utils"
+	"github.com/praelatus/praelatus/auth"
 	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/models/permission"
 
 	"github.com/gorilla/mux"
 )
@@ -21,14 +24,24 @@ func userRouter(router *mux.Router) {
 	router.HandleFunc("/users", getAllUsers).Methods("GET")
 	router.HandleFunc("/users", createUser).Methods("POST")
 	router.HandleFunc("/tokens", login).Methods("POST")
+	router.HandleFunc("/tokens", logout).Methods("DELETE")
+	router.HandleFunc("/tokens/refresh", refreshSession).Methods("POST")
 
 	router.HandleFunc("/users/notifications", getCurrentUserNotifications)
+	router.HandleFunc("/users/password-reset", requestPasswordReset).Methods("POST")
+	router.HandleFunc("/users/password-reset/confirm", confirmPasswordReset).Methods("POST")
+	router.HandleFunc("/users/verify", verifyEmail).Methods("POST")
 	router.HandleFunc("/users/{username}/activity", getUserActivity)
 
 	router.HandleFunc("/users/me", loggedInUser)
+	router.HandleFunc("/users/me/tokens", getAPITokens).Methods("GET")
+	router.HandleFunc("/users/me/tokens", createAPIToken).Methods("POST")
+	router.HandleFunc("/users/me/tokens/{id}", revokeAPIToken).Methods("DELETE")
+	router.HandleFunc("/users/{username}", updateUser).Methods("PUT")
 	router.HandleFunc("/users/{username}", singleUser)
 	router.HandleFunc("/users/{username}/avatar", avatar)
 	router.HandleFunc("/users/{username}/leadof", leadOf)
+	router.HandleFunc("/users/{username}/sessions", revokeSessions).Methods("DELETE")
 }
 
 func loggedInUser(w http.ResponseWriter, r *http.Request) {
@@ -54,24 +67,234 @@ func login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := Repo.Users().Get(&models.User{}, loginReq.Username)
+	provider := Auth
+	if provider == nil {
+		provider = auth.Local{Repo: Repo}
+	}
+
+	user, err := provider.Authenticate(loginReq.Username, loginReq.Password)
+	if err == auth.ErrInvalidCredentials {
+		utils.APIErr(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	startSession(w, r, user)
+}
+
+// startSession will give the user who has just authenticated a session, or a
+// two-factor challenge if they still need to use or set up two-factor
+// authentication. Every way of logging in goes through here.
+func startSession(w http.ResponseWriter, r *http.Request, user models.User) {
+	if user.Inactive() {
+		utils.APIErr(w, http.StatusForbidden,
+			"you must verify your email address before logging in")
+		return
+	}
+
+	if user.TwoFactor.Enabled {
+		sendChallenge(w, user, false)
+		return
+	}
+
+	required, err := twoFactorRequired(user)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	if required {
+		sendChallenge(w, user, true)
+		return
+	}
+
+	err = middleware.SetUserSession(user, w, r)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	utils.SendJSON(w, user)
+}
+
+func logout(w http.ResponseWriter, r *http.Request) {
+	u := middleware.GetUserSession(r)
+	if u == nil {
+		utils.APIErr(w, http.StatusForbidden, "you must be logged in")
+		return
+	}
+
+	err := middleware.RemoveUserSession(r)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	w.Write(utils.Success())
+}
+
+// refreshSession will exchange a session or refresh token for new tokens
+func refreshSession(w http.ResponseWriter, r *http.Request) {
+	u, err := middleware.RefreshSession(r)
+	if err != nil {
+		utils.APIErr(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	// Reload the user so changes since the last token was issued, such as
+	// losing admin, are reflected in the new one
+	user, err := Repo.Users().Get(u, u.Username)
 	if err != nil {
 		utils.Error(w, err)
 		return
 	}
 
-	if user.CheckPw([]byte(loginReq.Password)) {
-		err := middleware.SetUserSession(user, w)
+	// Inactive users keep their tokens until they expire so they are
+	// revoked here instead of being refreshed, the same check as logging in
+	if user.Inactive() {
+		err = middleware.RevokeUserSessions(user.Username)
 		if err != nil {
 			utils.Error(w, err)
 			return
 		}
 
-		utils.SendJSON(w, user)
+		utils.APIErr(w, http.StatusUnauthorized, "this account is not active")
+		return
+	}
+
+	err = middleware.SetUserSession(user, w, r)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	utils.SendJSON(w, user)
+}
+
+// revokeSessions will log the user out of every client they are logged in on
+func revokeSessions(w http.ResponseWriter, r *http.Request) {
+	u := accountOwner(w, r)
+	if u == nil {
+		return
+	}
+
+	if !u.IsAdmin {
+		utils.APIErr(w, http.StatusForbidden, "you must be an administrator")
+		return
+	}
+
+	err := middleware.RevokeUserSessions(mux.Vars(r)["username"])
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	w.Write(utils.Success())
+}
+
+// accountOwner returns the logged in user for the account management
+// endpoints, accounts and their tokens can only be managed with a session so
+// a leaked API token can't create more tokens or take over the account
+func accountOwner(w http.ResponseWriter, r *http.Request) *models.User {
+	u := middleware.GetUserSession(r)
+	if u == nil || middleware.IsAPITokenRequest(r) {
+		utils.APIErr(w, http.StatusForbidden, "you must be logged in")
+		return nil
+	}
+
+	return u
+}
+
+func getAPITokens(w http.ResponseWriter, r *http.Request) {
+	u := accountOwner(w, r)
+	if u == nil {
+		return
+	}
+
+	tokens, err := Repo.Tokens().ForUser(u, *u)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	utils.SendJSON(w, tokens)
+}
+
+func createAPIToken(w http.ResponseWriter, r *http.Request) {
+	u := accountOwner(w, r)
+	if u == nil {
+		return
+	}
+
+	var token models.APIToken
+
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&token)
+	if err != nil {
+		utils.APIErr(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	utils.APIErr(w, http.StatusForbidden, "invalid password")
+	if err := utils.ValidateModel(token); err != nil {
+		utils.APIErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	for _, scope := range token.Scopes {
+		if !permission.ValidPermission(permission.Permission(scope)) {
+			utils.APIErr(w, http.StatusBadRequest, "invalid scope: "+scope)
+			return
+		}
+	}
+
+	if token.Expired() {
+		utils.APIErr(w, http.StatusBadRequest, "expiresDate must be in the future")
+		return
+	}
+
+	if token.Scopes == nil {
+		token.Scopes = permission.Permissions{}
+	}
+
+	token.Username = u.Username
+	token.CreatedDate = time.Now()
+
+	secret, err := middleware.NewAPIToken(&token)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	token, err = Repo.Tokens().Create(u, token)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	// The token is only ever sent in this response
+	utils.SendJSON(w, struct {
+		models.APIToken
+		Token string `json:"token"`
+	}{token, secret})
+}
+
+func revokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	u := accountOwner(w, r)
+	if u == nil {
+		return
+	}
+
+	err := Repo.Tokens().Delete(u, mux.Vars(r)["id"])
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	w.Write(utils.Success())
 }
 
 func createUser(w http.ResponseWriter, r *http.Request) {
@@ -104,13 +327,38 @@ func createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = middleware.SetUserSession(*u, w)
+	// Users created by an administrator are trusted, anyone else has to
+	// verify their email first if we can send them one
+	u.IsActive = loggedInUser.IsAdmin || Mail == nil
+	u.PendingVerification = !u.IsActive
+
+	user, err := Repo.Users().Create(loggedInUser, *u)
 	if err != nil {
-		utils.APIErr(w, http.StatusInternalServerError, err.Error())
+		utils.Error(w, err)
 		return
 	}
 
-	utils.SendJSON(w, u)
+	if user.PendingVerification {
+		err = sendVerification(user)
+		if err != nil {
+			utils.Error(w, err)
+			return
+		}
+
+		utils.SendJSON(w, user)
+		return
+	}
+
+	// Users who registered themselves are logged in straight away
+	if !loggedInUser.IsAdmin {
+		err = middleware.SetUserSession(user, w, r)
+		if err != nil {
+			utils.APIErr(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	utils.SendJSON(w, user)
 }
 
 func getAllUsers(w http.ResponseWriter, r *http.Request) {
@@ -144,15 +392,11 @@ func singleUser(w http.ResponseWriter, r *http.Request) {
 	case "GET":
 		user, err = Repo.Users().Get(u, username)
 	case "DELETE":
-		err = Repo.Users().Delete(u, username)
-	case "PUT":
-		decoder := json.NewDecoder(r.Body)
-		err = decoder.Decode(&user)
-		if err != nil {
-			break
+		if accountOwner(w, r) == nil {
+			return
 		}
 
-		err = Repo.Users().Update(u, username, user)
+		err = Repo.Users().Delete(u, username)
 	}
 
 	if err != nil {
@@ -164,6 +408,68 @@ func singleUser(w http.ResponseWriter, r *http.Request) {
 	utils.SendJSON(w, user)
 }
 
+// updateUser will update the user with the given username. When anyone but an
+// administrator changes an email they are logged out and sent a verification
+// email, their account can't be used until they verify the new address.
+func updateUser(w http.ResponseWriter, r *http.Request) {
+	u := accountOwner(w, r)
+	if u == nil {
+		return
+	}
+
+	username := mux.Vars(r)["username"]
+
+	var updated models.User
+
+	err := json.NewDecoder(r.Body).Decode(&updated)
+	if err != nil {
+		utils.APIErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	current, err := Repo.Users().Get(u, username)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	reverify := updated.Email != current.Email && !u.IsAdmin
+	if reverify && Mail == nil {
+		utils.APIErr(w, http.StatusForbidden,
+			"email can only be changed by an administrator")
+		return
+	}
+
+	err = Repo.Users().Update(u, username, updated)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	user, err := Repo.Users().Get(u, username)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	if reverify {
+		err = middleware.RevokeUserSessions(user.Username)
+		if err != nil && err != middleware.ErrNoSessionStore {
+			utils.Error(w, err)
+			return
+		}
+
+		err = sendVerification(user)
+		if err != nil {
+			utils.Error(w, err)
+			return
+		}
+	}
+
+	user.Password = ""
+	utils.SendJSON(w, user)
+}
+
 func avatar(w http.ResponseWriter, r *http.Request) {
 	u := middleware.GetUserSession(r)
 	var user models.User
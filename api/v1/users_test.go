@@ -5,9 +5,12 @@
 package v1_test
 
 import (
+	"bytes"
 	"encoding/json"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/praelatus/praelatus/api/middleware"
 	"github.com/praelatus/praelatus/models"
 )
 
@@ -39,8 +42,8 @@ var userRouteTests = []routeTest{
 		Validator: func(v interface{}, t *testing.T) {
 			user := toUser(v)
 
-			if user.Username != "testuser" {
-				t.Errorf("Expected testuser Got: %s", user.Username)
+			if user.Username != "testadmin" {
+				t.Errorf("Expected testadmin Got: %s", user.Username)
 			}
 
 			if user.Password != "" {
@@ -84,8 +87,8 @@ var userRouteTests = []routeTest{
 		Validator: func(v interface{}, t *testing.T) {
 			user := toUser(v)
 
-			if user.Username != "testuser" {
-				t.Errorf("Expected testuser Got: %s", user.Username)
+			if user.Username != "fakeuser" || !user.IsActive {
+				t.Errorf("Expected active user fakeuser Got: %v", user)
 			}
 
 			if user.Password != "" {
@@ -100,4 +103,334 @@ var userRouteTests = []routeTest{
 		Admin:    true,
 		Method:   "DELETE",
 	},
+
+	{
+		Name:     "Login",
+		Endpoint: "/api/v1/tokens",
+		Method:   "POST",
+		Body:     map[string]string{"username": "testadmin", "password": "test"},
+	},
+
+	{
+		Name:         "Login Wrong Password",
+		Endpoint:     "/api/v1/tokens",
+		Method:       "POST",
+		Body:         map[string]string{"username": "testadmin", "password": "wrong"},
+		ExpectedCode: 403,
+	},
+
+	{
+		Name:         "Logout Not Logged In",
+		Endpoint:     "/api/v1/tokens",
+		Method:       "DELETE",
+		ExpectedCode: 403,
+	},
+
+	{
+		Name:         "Refresh Not Logged In",
+		Endpoint:     "/api/v1/tokens/refresh",
+		Method:       "POST",
+		ExpectedCode: 401,
+	},
+
+	{
+		Name:         "Revoke Sessions Not Admin",
+		Endpoint:     "/api/v1/users/foouser/sessions",
+		Login:        true,
+		Method:       "DELETE",
+		ExpectedCode: 403,
+	},
+
+	{
+		Name:         "List API Tokens Not Logged In",
+		Endpoint:     "/api/v1/users/me/tokens",
+		ExpectedCode: 403,
+	},
+
+	{
+		Name:         "Create API Token Invalid Scope",
+		Endpoint:     "/api/v1/users/me/tokens",
+		Login:        true,
+		Method:       "POST",
+		Body:         map[string]interface{}{"name": "ci", "scopes": []string{"NOT_A_PERMISSION"}},
+		ExpectedCode: 400,
+	},
+
+	{
+		Name:         "Create API Token Already Expired",
+		Endpoint:     "/api/v1/users/me/tokens",
+		Login:        true,
+		Method:       "POST",
+		Body:         map[string]interface{}{"name": "ci", "expiresDate": "2017-01-01T00:00:00Z"},
+		ExpectedCode: 400,
+	},
+
+	{
+		Name:         "Create API Token No Name",
+		Endpoint:     "/api/v1/users/me/tokens",
+		Login:        true,
+		Method:       "POST",
+		Body:         map[string]interface{}{},
+		ExpectedCode: 400,
+	},
+}
+
+func TestUserRoutes(t *testing.T) {
+	testRoutes(userRouteTests, t)
+}
+
+func TestLogout(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("DELETE", "/api/v1/tokens", nil)
+	testLogin(w, r)
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != 200 {
+		t.Errorf("Expected Status Code: 200 Got: %d", w.Code)
+		return
+	}
+
+	w = httptest.NewRecorder()
+	me := httptest.NewRequest("GET", "/api/v1/users/me", nil)
+	me.Header.Set("Authorization", r.Header.Get("Authorization"))
+
+	router.ServeHTTP(w, me)
+
+	if w.Code != 404 {
+		t.Errorf("Expected token to be revoked Got: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/api/v1/tokens/refresh", nil)
+	testLogin(w, r)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	if w.Code != 200 {
+		t.Errorf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+		return
+	}
+
+	token := w.Header().Get("X-Praelatus-Token")
+	if token == "" || "Bearer "+token == r.Header.Get("Authorization") {
+		t.Errorf("Expected a new token Got: %s", token)
+		return
+	}
+
+	w = httptest.NewRecorder()
+	me := httptest.NewRequest("GET", "/api/v1/users/me", nil)
+	me.Header.Set("Authorization", "Bearer "+token)
+
+	router.ServeHTTP(w, me)
+
+	if w.Code != 200 {
+		t.Errorf("Expected new token to be valid Got: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRefreshInactiveUser(t *testing.T) {
+	u, _ := models.NewUser("inactive", "inactivepass", "Inactive", "inactive@example.com", false)
+	u.IsActive = false
+	u.PendingVerification = true
+
+	defer useBoltRepo(t, *u)()
+
+	w := httptest.NewRecorder()
+	err := middleware.SetUserSession(*u, w, httptest.NewRequest("POST", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := w.Header().Get("X-Praelatus-Token")
+
+	w = do("POST", "/api/v1/tokens/refresh", token, nil)
+	if w.Code != 401 {
+		t.Errorf("Expected Status Code: 401 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	if w = do("GET", "/api/v1/users/me", token, nil); w.Code == 200 {
+		t.Errorf("Expected the session to be revoked Got: %s", w.Body.String())
+	}
+}
+
+// Accounts created before IsActive was set on new users have it false, they
+// must still be able to log in and refresh their sessions
+func TestRefreshExistingUser(t *testing.T) {
+	u, _ := models.NewUser("existing", "existingpass", "Existing", "existing@example.com", false)
+	u.IsActive = false
+
+	defer useBoltRepo(t, *u)()
+
+	w := do("POST", "/api/v1/tokens", "",
+		map[string]string{"username": "existing", "password": "existingpass"})
+	if w.Code != 200 {
+		t.Fatalf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	w = do("POST", "/api/v1/tokens/refresh", w.Header().Get("X-Praelatus-Token"), nil)
+	if w.Code != 200 {
+		t.Errorf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	w := httptest.NewRecorder()
+	me := httptest.NewRequest("GET", "/api/v1/users/me", nil)
+	testLogin(w, me)
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("DELETE", "/api/v1/users/foouser/sessions", nil)
+	testAdminLogin(w, r)
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != 200 {
+		t.Errorf("Expected Status Code: 200 Got: %d", w.Code)
+		return
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, me)
+
+	if w.Code != 404 {
+		t.Errorf("Expected token to be revoked Got: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/api/v1/users/me/tokens",
+		bytes.NewBufferString(`{"name": "ci", "scopes": ["CREATE_TICKET"]}`))
+	testLogin(w, r)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	if w.Code != 200 {
+		t.Errorf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+		return
+	}
+
+	var created struct {
+		models.APIToken
+		Token string `json:"token"`
+	}
+
+	err := json.Unmarshal(w.Body.Bytes(), &created)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if created.Token == "" || created.Username != "foouser" {
+		t.Errorf("Expected a token for foouser Got: %s", w.Body.String())
+		return
+	}
+
+	w = httptest.NewRecorder()
+	me := httptest.NewRequest("GET", "/api/v1/users/me", nil)
+	me.Header.Set("Authorization", "Bearer "+created.Token)
+
+	router.ServeHTTP(w, me)
+
+	if w.Code != 200 {
+		t.Errorf("Expected API token to be accepted Got: %d %s", w.Code, w.Body.String())
+		return
+	}
+
+	// API tokens can't be used to manage tokens
+	w = httptest.NewRecorder()
+	list := httptest.NewRequest("GET", "/api/v1/users/me/tokens", nil)
+	list.Header.Set("Authorization", "Bearer "+created.Token)
+
+	router.ServeHTTP(w, list)
+
+	if w.Code != 403 {
+		t.Errorf("Expected Status Code: 403 Got: %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	list = httptest.NewRequest("GET", "/api/v1/users/me/tokens", nil)
+	testLogin(w, list)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, list)
+
+	var tokens []models.APIToken
+
+	err = json.Unmarshal(w.Body.Bytes(), &tokens)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(tokens) == 0 {
+		t.Errorf("Expected the created token to be listed Got: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("DELETE", "/api/v1/users/me/tokens/"+created.ID.Hex(), nil)
+	testLogin(w, r)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	if w.Code != 200 {
+		t.Errorf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+		return
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, me)
+
+	if w.Code != 404 {
+		t.Errorf("Expected revoked token to be rejected Got: %d", w.Code)
+	}
+}
+
+func TestAPITokenAccountManagement(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/api/v1/users/me/tokens",
+		bytes.NewBufferString(`{"name": "viewer", "scopes": ["VIEW_PROJECT"]}`))
+	testLogin(w, r)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	var created struct {
+		Token string `json:"token"`
+	}
+
+	err := json.Unmarshal(w.Body.Bytes(), &created)
+	if err != nil || created.Token == "" {
+		t.Fatalf("Expected a token Got: %d %s", w.Code, w.Body.String())
+	}
+
+	endpoints := []struct {
+		method   string
+		endpoint string
+		body     interface{}
+	}{
+		{"PUT", "/api/v1/users/foouser", map[string]string{"email": "evil@example.com"}},
+		{"DELETE", "/api/v1/users/foouser", nil},
+		{"DELETE", "/api/v1/users/foouser/sessions", nil},
+		{"GET", "/api/v1/users/me/2fa", nil},
+		{"POST", "/api/v1/users/me/2fa", nil},
+		{"DELETE", "/api/v1/users/me/2fa", map[string]string{"code": "000000"}},
+		{"DELETE", "/api/v1/users/foouser/2fa", nil},
+		{"POST", "/api/v1/chat/link", nil},
+		{"DELETE", "/api/v1/chat/link", nil},
+	}
+
+	for _, e := range endpoints {
+		w = do(e.method, e.endpoint, created.Token, e.body)
+		if w.Code != 403 {
+			t.Errorf("%s %s Expected Status Code: 403 Got: %d %s",
+				e.method, e.endpoint, w.Code, w.Body.String())
+		}
+	}
 }
@@ -8,18 +8,32 @@ package v1
 
 import (
 	"github.com/gorilla/mux"
+	"github.com/praelatus/praelatus/auth"
+	"github.com/praelatus/praelatus/files"
 	"github.com/praelatus/praelatus/repo"
 )
 
 // Repo is the global database connection
 var Repo repo.Repo
 
+// Files stores the files attached to comments
+var Files files.FS
+
+// Auth is the provider users log in with, when nil users are checked against
+// the password hashes in Repo
+var Auth auth.Provider
+
 // Routes will set up the appropriate routes on the given mux.Router
 func Routes(router *mux.Router) {
 	fieldRouter(router)
 	projectRouter(router)
+	webhookRouter(router)
 	ticketRouter(router)
 	userRouter(router)
 	workflowRouter(router)
 	miscRouter(router)
+	oidcRouter(router)
+	twoFactorRouter(router)
+	eventsRouter(router)
+	chatRouter(router)
 }
@@ -8,8 +8,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/gorilla/mux"
@@ -18,15 +21,47 @@ import (
 	"github.com/praelatus/praelatus/api/v1"
 	"github.com/praelatus/praelatus/models"
 	"github.com/praelatus/praelatus/repo"
+	"github.com/praelatus/praelatus/repo/bolt"
+	"github.com/praelatus/praelatus/repo/cache"
 )
 
 var router *mux.Router
 
 func init() {
 	v1.Repo = repo.NewMockRepo()
+	repo.GlobalRepo = v1.Repo
+	repo.GlobalCache = cache.NewMemory(0, 0)
 	router = api.Routes()
 }
 
+// useBoltRepo swaps the mock repo for a bolt repo in a temporary directory
+// containing users, for tests which need changes to be stored. The returned
+// function restores the mock repo.
+func useBoltRepo(t *testing.T, users ...models.User) func() {
+	dir, err := ioutil.TempDir("", "praelatus-v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := bolt.New(filepath.Join(dir, "praelatus.db"))
+
+	for _, u := range users {
+		_, err = r.Users().Create(&models.User{IsAdmin: true}, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mock := v1.Repo
+	v1.Repo, repo.GlobalRepo = r, r
+
+	return func() {
+		v1.Repo, repo.GlobalRepo = mock, mock
+		r.(bolt.Repo).DB.Close()
+		os.RemoveAll(dir)
+	}
+}
+
 func testLogin(w http.ResponseWriter, r *http.Request) {
 	u := models.User{
 		Username: "foouser",
@@ -38,7 +73,7 @@ func testLogin(w http.ResponseWriter, r *http.Request) {
 		Settings: models.Settings{},
 	}
 
-	err := middleware.SetUserSession(u, w)
+	err := middleware.SetUserSession(u, w, r)
 	if err != nil {
 		panic(err)
 	}
@@ -57,7 +92,7 @@ func testAdminLogin(w http.ResponseWriter, r *http.Request) {
 		Settings: models.Settings{},
 	}
 
-	err := middleware.SetUserSession(u, w)
+	err := middleware.SetUserSession(u, w, r)
 	if err != nil {
 		panic(err)
 	}
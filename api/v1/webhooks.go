@@ -0,0 +1,271 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/praelatus/praelatus/api/middleware"
+	"github.com/praelatus/praelatus/api/utils"
+	"github.com/praelatus/praelatus/events"
+	"github.com/praelatus/praelatus/events/event"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/ql/lexer"
+	"github.com/praelatus/praelatus/ql/parser"
+	"github.com/praelatus/praelatus/repo"
+)
+
+func webhookRouter(router *mux.Router) {
+	router.HandleFunc("/projects/{key}/webhooks", getWebhooks).Methods("GET")
+	router.HandleFunc("/projects/{key}/webhooks", createWebhook).Methods("POST")
+	router.HandleFunc("/projects/{key}/webhooks/{id}", singleWebhook)
+	router.HandleFunc("/projects/{key}/webhooks/{id}/deliveries", getWebhookDeliveries).Methods("GET")
+	router.HandleFunc("/projects/{key}/webhooks/{id}/deliveries/{delivery}", singleWebhookDelivery).Methods("GET")
+	router.HandleFunc("/projects/{key}/webhooks/{id}/deliveries/{delivery}/redeliver", redeliverWebhook).Methods("POST")
+}
+
+// validateWebhook checks that the webhook has an http(s) URL, only known event
+// types, a known format and a query that parses and compares fields to values
+func validateWebhook(wh models.Webhook) error {
+	if err := utils.ValidateModel(wh); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(wh.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an http or https URL")
+	}
+
+	// Names are checked again when they are resolved for each delivery
+	ip := net.ParseIP(u.Hostname())
+	if strings.EqualFold(u.Hostname(), "localhost") {
+		ip = net.IPv4(127, 0, 0, 1)
+	}
+
+	if ip != nil && events.PrivateAddress(ip) {
+		return events.ErrPrivateAddress
+	}
+
+	for _, e := range wh.Events {
+		known := false
+
+		for _, t := range event.Types {
+			known = known || e == string(t)
+		}
+
+		if !known {
+			return fmt.Errorf("%s is not an event type", e)
+		}
+	}
+
+	if wh.Format != "" {
+		known := false
+
+		for _, f := range models.WebhookFormats {
+			known = known || wh.Format == f
+		}
+
+		if !known {
+			return fmt.Errorf("%s is not a webhook format", wh.Format)
+		}
+	}
+
+	if wh.Query != "" {
+		p := parser.New(lexer.New(wh.Query))
+		q := p.Parse()
+
+		if p.Errors() != nil {
+			return p.Errors()
+		}
+
+		// The query is combined with the key of each event's ticket so
+		// it has to be something the repos can search with
+		if err := q.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeWebhook reads and validates the webhook in the request body
+func decodeWebhook(w http.ResponseWriter, r *http.Request) (models.Webhook, bool) {
+	var wh models.Webhook
+
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&wh)
+	if err != nil {
+		utils.APIErr(w, http.StatusBadRequest, err.Error())
+		return wh, false
+	}
+
+	if err := validateWebhook(wh); err != nil {
+		utils.APIErr(w, http.StatusBadRequest, err.Error())
+		return wh, false
+	}
+
+	return wh, true
+}
+
+func getWebhooks(w http.ResponseWriter, r *http.Request) {
+	u := middleware.GetUserSession(r)
+
+	webhooks, err := Repo.Webhooks().ForProject(u, mux.Vars(r)["key"])
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	utils.SendJSON(w, models.Webhooks(webhooks))
+}
+
+// createWebhook will add a webhook to the project, this requires the admin
+// project permission
+func createWebhook(w http.ResponseWriter, r *http.Request) {
+	u := middleware.GetUserSession(r)
+
+	wh, ok := decodeWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	wh.Project = mux.Vars(r)["key"]
+
+	wh, err := Repo.Webhooks().Create(u, wh)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	utils.SendJSON(w, wh)
+}
+
+// webhook returns the webhook in the URL if it belongs to the project in the
+// URL
+func webhook(r *http.Request) (models.Webhook, error) {
+	u := middleware.GetUserSession(r)
+	vars := mux.Vars(r)
+
+	wh, err := Repo.Webhooks().Get(u, vars["id"])
+	if err != nil {
+		return wh, err
+	}
+
+	if wh.Project != vars["key"] {
+		return wh, repo.ErrNotFound
+	}
+
+	return wh, nil
+}
+
+func singleWebhook(w http.ResponseWriter, r *http.Request) {
+	u := middleware.GetUserSession(r)
+
+	wh, err := webhook(r)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		utils.SendJSON(w, wh)
+		return
+	case "DELETE":
+		err = Repo.Webhooks().Delete(u, wh.ID.Hex())
+	case "PUT":
+		var ok bool
+
+		wh, ok = decodeWebhook(w, r)
+		if !ok {
+			return
+		}
+
+		err = Repo.Webhooks().Update(u, mux.Vars(r)["id"], wh)
+	}
+
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	w.Write(utils.Success())
+}
+
+func getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	u := middleware.GetUserSession(r)
+
+	wh, err := webhook(r)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	last, _ := strconv.Atoi(r.FormValue("last"))
+	// Last cannot be passed to us as 0 if it is 0 that means either nothing
+	// or a non-number was passed to set to the default value of 50
+	if last == 0 {
+		last = 50
+	}
+
+	deliveries, err := Repo.Deliveries().ForWebhook(u, wh.ID.Hex(), last)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	utils.SendJSON(w, deliveries)
+}
+
+// webhookDelivery returns the delivery in the URL if it was for the webhook
+// in the URL
+func webhookDelivery(r *http.Request) (models.HookDelivery, error) {
+	wh, err := webhook(r)
+	if err != nil {
+		return models.HookDelivery{}, err
+	}
+
+	d, err := Repo.Deliveries().Get(middleware.GetUserSession(r), mux.Vars(r)["delivery"])
+	if err != nil {
+		return d, err
+	}
+
+	if d.Webhook != wh.ID {
+		return d, repo.ErrNotFound
+	}
+
+	return d, nil
+}
+
+func singleWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	d, err := webhookDelivery(r)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	utils.SendJSON(w, d)
+}
+
+// redeliverWebhook will send the request of a delivery again, the new
+// delivery is returned even if it failed
+func redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	d, err := webhookDelivery(r)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	d, _ = events.Redeliver(d)
+	utils.SendJSON(w, d)
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package v1_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/praelatus/praelatus/api/v1"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestWebhooks(t *testing.T) {
+	admin, _ := models.NewUser("webhookadmin", "webhookpass", "Webhook Admin", "webhooks@example.com", true)
+	admin.IsActive = true
+
+	u, _ := models.NewUser("webhookuser", "webhookpass", "Webhook User", "user@example.com", false)
+	u.IsActive = true
+
+	defer useBoltRepo(t, *admin, *u)()
+
+	err := repo.Seed(v1.Repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(username string) string {
+		w := do("POST", "/api/v1/tokens", "",
+			map[string]string{"username": username, "password": "webhookpass"})
+		return w.Header().Get("X-Praelatus-Token")
+	}
+
+	token := login("webhookadmin")
+	endpoint := "/api/v1/projects/TEST/webhooks"
+
+	invalid := []models.Webhook{
+		{URL: "ftp://example.com", Events: []string{"COMMENT"}},
+		{URL: "https://example.com/hook", Events: []string{"DELETED"}},
+		{URL: "https://example.com/hook"},
+		{URL: "http://localhost:8080/hook", Events: []string{"COMMENT"}},
+		{URL: "http://169.254.169.254/latest/meta-data", Events: []string{"COMMENT"}},
+		{URL: "https://example.com/hook", Events: []string{"COMMENT"}, Query: "summary = "},
+		{URL: "https://example.com/hook", Events: []string{"COMMENT"}, Query: "summary"},
+		{URL: "https://example.com/hook", Events: []string{"COMMENT"}, Format: "irc"},
+	}
+
+	for _, wh := range invalid {
+		if w := do("POST", endpoint, token, wh); w.Code != 400 {
+			t.Errorf("Expected Status Code: 400 for %v Got: %d", wh, w.Code)
+		}
+	}
+
+	w := do("POST", endpoint, token, models.Webhook{
+		URL:    "https://example.com/hook",
+		Events: []string{"CREATED", "COMMENT"},
+		Query:  `summary = "test"`,
+		Secret: "s3cret",
+	})
+
+	var created models.Webhook
+	if err = json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+
+	if created.Project != "TEST" || created.Secret != "" {
+		t.Errorf("Expected a sanitized webhook for TEST Got %v", created)
+	}
+
+	if w = do("GET", endpoint, login("webhookuser"), nil); w.Code == 200 {
+		t.Errorf("Expected only project administrators to see webhooks Got: %s", w.Body.String())
+	}
+
+	single := endpoint + "/" + created.ID.Hex()
+
+	bare := created
+	bare.Query = "summary"
+	if w = do("PUT", single, token, bare); w.Code != 400 {
+		t.Errorf("Expected Status Code: 400 for a bare field query Got: %d", w.Code)
+	}
+
+	created.Events = []string{"UPDATED"}
+	if w = do("PUT", single, token, created); w.Code != 200 {
+		t.Fatalf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	stored, err := v1.Repo.Webhooks().Get(admin, created.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.Secret != "s3cret" || !stored.Wants("UPDATED") || stored.Wants("COMMENT") {
+		t.Errorf("Expected the events to change and the secret to be kept Got %v", stored)
+	}
+
+	w = do("GET", endpoint, token, nil)
+
+	var ws []models.Webhook
+	if err = json.Unmarshal(w.Body.Bytes(), &ws); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+
+	if len(ws) != 1 || ws[0].ID != created.ID || ws[0].Secret != "" {
+		t.Errorf("Expected the sanitized webhook Got %v", ws)
+	}
+
+	if w = do("GET", "/api/v1/projects/TEST2/webhooks/"+created.ID.Hex(), token, nil); w.Code != 404 {
+		t.Errorf("Expected Status Code: 404 Got: %d", w.Code)
+	}
+
+	if w = do("GET", single+"/deliveries/"+bson.NewObjectId().Hex(), token, nil); w.Code != 404 {
+		t.Errorf("Expected Status Code: 404 Got: %d", w.Code)
+	}
+
+	if w = do("DELETE", single, token, nil); w.Code != 200 {
+		t.Errorf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	if w = do("GET", single, token, nil); w.Code != 404 {
+		t.Errorf("Expected Status Code: 404 Got: %d", w.Code)
+	}
+}
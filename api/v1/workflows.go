@@ -7,18 +7,24 @@ package v1
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/praelatus/praelatus/api/middleware"
 	"github.com/praelatus/praelatus/api/utils"
+	"github.com/praelatus/praelatus/events"
 	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
 )
 
 func workflowRouter(router *mux.Router) {
 	router.HandleFunc("/workflows", getAllWorkflows).Methods("GET")
 	router.HandleFunc("/workflows", createWorkflow).Methods("POST")
 	router.HandleFunc("/workflows/{id}", singleWorkflow)
+	router.HandleFunc("/workflows/{id}/hooks/deliveries", getDeliveries).Methods("GET")
+	router.HandleFunc("/workflows/{id}/hooks/deliveries/{delivery}", singleDelivery).Methods("GET")
+	router.HandleFunc("/workflows/{id}/hooks/deliveries/{delivery}/redeliver", redeliver).Methods("POST")
 }
 
 func createWorkflow(w http.ResponseWriter, r *http.Request) {
@@ -60,7 +66,7 @@ func getAllWorkflows(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.SendJSON(w, ws)
+	utils.SendJSON(w, models.Workflows(ws))
 }
 
 func singleWorkflow(w http.ResponseWriter, r *http.Request) {
@@ -82,6 +88,14 @@ func singleWorkflow(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 
+		var stored models.Workflow
+
+		stored, err = Repo.Workflows().Get(u, id)
+		if err != nil {
+			break
+		}
+
+		workflow.KeepSecrets(stored)
 		err = Repo.Workflows().Update(u, id, workflow)
 	}
 
@@ -97,3 +111,64 @@ func singleWorkflow(w http.ResponseWriter, r *http.Request) {
 
 	w.Write(utils.Success())
 }
+
+func getDeliveries(w http.ResponseWriter, r *http.Request) {
+	u := middleware.GetUserSession(r)
+	id := mux.Vars(r)["id"]
+
+	last, _ := strconv.Atoi(r.FormValue("last"))
+	// Last cannot be passed to us as 0 if it is 0 that means either nothing
+	// or a non-number was passed to set to the default value of 50
+	if last == 0 {
+		last = 50
+	}
+
+	deliveries, err := Repo.Deliveries().ForWorkflow(u, id, last)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	utils.SendJSON(w, deliveries)
+}
+
+// delivery returns the delivery in the URL if it was for the workflow in the
+// URL
+func delivery(r *http.Request) (models.HookDelivery, error) {
+	u := middleware.GetUserSession(r)
+	vars := mux.Vars(r)
+
+	d, err := Repo.Deliveries().Get(u, vars["delivery"])
+	if err != nil {
+		return d, err
+	}
+
+	if d.Workflow.Hex() != vars["id"] {
+		return d, repo.ErrNotFound
+	}
+
+	return d, nil
+}
+
+func singleDelivery(w http.ResponseWriter, r *http.Request) {
+	d, err := delivery(r)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	utils.SendJSON(w, d)
+}
+
+// redeliver will send the request of a delivery again, the new delivery is
+// returned even if it failed
+func redeliver(w http.ResponseWriter, r *http.Request) {
+	d, err := delivery(r)
+	if err != nil {
+		utils.Error(w, err)
+		return
+	}
+
+	d, _ = events.Redeliver(d)
+	utils.SendJSON(w, d)
+}
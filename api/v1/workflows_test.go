@@ -6,9 +6,15 @@ package v1_test
 
 import (
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/praelatus/praelatus/api/v1"
+	"github.com/praelatus/praelatus/events"
 	"github.com/praelatus/praelatus/models"
+	"gopkg.in/mgo.v2/bson"
 )
 
 func workflowFromJSON(jsn []byte) (interface{}, error) {
@@ -85,3 +91,145 @@ var workflowRouteTests = []routeTest{
 		Method:   "DELETE",
 	},
 }
+
+func TestWorkflowHookSecrets(t *testing.T) {
+	u, _ := models.NewUser("hookadmin", "hookpass", "Hook Admin", "hooks@example.com", true)
+	u.IsActive = true
+
+	defer useBoltRepo(t, *u)()
+
+	wf, err := v1.Repo.Workflows().Create(u, models.Workflow{
+		Name: "Hooked",
+		Transitions: []models.Transition{
+			{
+				Name:  "Done",
+				Hooks: []models.Hook{{Endpoint: "http://example.com", Method: "POST", Secret: "s3cret"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := do("POST", "/api/v1/tokens", "",
+		map[string]string{"username": "hookadmin", "password": "hookpass"})
+	token := w.Header().Get("X-Praelatus-Token")
+
+	w = do("GET", "/api/v1/workflows/"+wf.ID.Hex(), token, nil)
+
+	var got models.Workflow
+	if err = json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Transitions[0].Hooks[0].Secret != "" {
+		t.Errorf("Expected the secret to be hidden Got %s", got.Transitions[0].Hooks[0].Secret)
+	}
+
+	got.Name = "Renamed"
+
+	if w = do("PUT", "/api/v1/workflows/"+wf.ID.Hex(), token, got); w.Code != 200 {
+		t.Fatalf("Expected Status Code: 200 Got: %d %s", w.Code, w.Body.String())
+	}
+
+	stored, err := v1.Repo.Workflows().Get(u, wf.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.Transitions[0].Hooks[0].Secret != "s3cret" {
+		t.Errorf("Expected the secret to be kept Got %q", stored.Transitions[0].Hooks[0].Secret)
+	}
+}
+
+func TestHookDeliveries(t *testing.T) {
+	admin, _ := models.NewUser("hookadmin", "hookpass", "Hook Admin", "hooks@example.com", true)
+	admin.IsActive = true
+
+	u, _ := models.NewUser("hookuser", "hookpass", "Hook User", "user@example.com", false)
+	u.IsActive = true
+
+	defer useBoltRepo(t, *admin, *u)()
+
+	var received string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(events.DeliveryHeader)
+	}))
+	defer srv.Close()
+
+	events.AllowPrivateHooks = true
+	defer func() { events.AllowPrivateHooks = false }()
+
+	wID := bson.NewObjectId()
+
+	d, err := v1.Repo.Deliveries().Create(admin, models.HookDelivery{
+		Workflow:   wID,
+		Transition: "Done",
+		Ticket:     "TEST-1",
+		Request: models.DeliveryRequest{
+			Method:  "POST",
+			URL:     srv.URL,
+			Headers: map[string]string{},
+		},
+		Error:       "responded with 500 Internal Server Error",
+		CreatedDate: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(username string) string {
+		w := do("POST", "/api/v1/tokens", "",
+			map[string]string{"username": username, "password": "hookpass"})
+		return w.Header().Get("X-Praelatus-Token")
+	}
+
+	endpoint := "/api/v1/workflows/" + wID.Hex() + "/hooks/deliveries"
+
+	if w := do("GET", endpoint, login("hookuser"), nil); w.Code == 200 {
+		t.Errorf("Expected only administrators to see deliveries Got: %s", w.Body.String())
+	}
+
+	token := login("hookadmin")
+
+	w := do("GET", endpoint, token, nil)
+
+	var ds []models.HookDelivery
+	if err = json.Unmarshal(w.Body.Bytes(), &ds); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+
+	if len(ds) != 1 || ds[0].ID != d.ID {
+		t.Errorf("Expected the delivery Got %v", ds)
+	}
+
+	other := "/api/v1/workflows/" + bson.NewObjectId().Hex() + "/hooks/deliveries/" + d.ID.Hex()
+	if w = do("GET", other, token, nil); w.Code != 404 {
+		t.Errorf("Expected Status Code: 404 Got: %d", w.Code)
+	}
+
+	w = do("POST", endpoint+"/"+d.ID.Hex()+"/redeliver", token, nil)
+
+	var redelivery models.HookDelivery
+	if err = json.Unmarshal(w.Body.Bytes(), &redelivery); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+
+	if redelivery.RedeliveryOf != d.ID || !redelivery.Success {
+		t.Errorf("Expected a successful redelivery of %s Got %v", d.ID.Hex(), redelivery)
+	}
+
+	if received != redelivery.ID.Hex() {
+		t.Errorf("Expected the delivery header %s Got %s", redelivery.ID.Hex(), received)
+	}
+
+	w = do("GET", endpoint, token, nil)
+	if err = json.Unmarshal(w.Body.Bytes(), &ds); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ds) != 2 || ds[0].ID != redelivery.ID {
+		t.Errorf("Expected the redelivery first Got %v", ds)
+	}
+}
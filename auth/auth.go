@@ -0,0 +1,116 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+// Package auth provides the authentication providers users can log in with,
+// checking the password hashes stored in the repo or binding to an LDAP
+// directory, and the OpenID Connect providers they can log in with instead.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/praelatus/praelatus/config"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+)
+
+var authLog = log.New(config.LogWriter(), "[AUTH] ", log.LstdFlags)
+
+// ErrInvalidCredentials is returned when the username or password is wrong
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// system is used for repo calls made on behalf of the provider itself
+var system = &models.User{Username: "system", IsAdmin: true}
+
+// Provider checks a username and password returning the user they belong to
+type Provider interface {
+	Authenticate(username, password string) (models.User, error)
+}
+
+// Local authenticates users against the password hashes stored in Repo. Users
+// created by another provider can only log in through that provider.
+type Local struct {
+	Repo repo.Repo
+}
+
+// Authenticate implements Provider
+func (l Local) Authenticate(username, password string) (models.User, error) {
+	user, err := l.Repo.Users().Get(system, username)
+	if err == repo.ErrNotFound {
+		return user, ErrInvalidCredentials
+	}
+
+	if err != nil {
+		return user, err
+	}
+
+	if user.Provider != "" || !user.CheckPw([]byte(password)) {
+		return models.User{}, ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+// Chain tries each provider in order until one of them authenticates the
+// user. If none do the first error other than ErrInvalidCredentials is
+// returned so that an unreachable directory isn't reported as a bad password.
+type Chain []Provider
+
+// Authenticate implements Provider
+func (c Chain) Authenticate(username, password string) (models.User, error) {
+	var failure error = ErrInvalidCredentials
+
+	for _, p := range c {
+		user, err := p.Authenticate(username, password)
+		if err == nil {
+			return user, nil
+		}
+
+		if err != ErrInvalidCredentials {
+			authLog.Println("ERROR:", err)
+
+			if failure == ErrInvalidCredentials {
+				failure = err
+			}
+		}
+	}
+
+	return models.User{}, failure
+}
+
+// New will return the Provider for the given configuration, local is used if
+// no providers are configured
+func New(c config.AuthConfig, r repo.Repo) (Provider, error) {
+	names := c.Providers
+	if len(names) == 0 {
+		names = []string{"local"}
+	}
+
+	chain := Chain{}
+
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case "local":
+			chain = append(chain, Local{Repo: r})
+		case "ldap":
+			l, err := NewLDAP(c.LDAP, r)
+			if err != nil {
+				return nil, err
+			}
+
+			chain = append(chain, l)
+		default:
+			return nil, fmt.Errorf("unknown authentication provider: %s", name)
+		}
+	}
+
+	if len(chain) == 1 {
+		return chain[0], nil
+	}
+
+	return chain, nil
+}
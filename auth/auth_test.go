@@ -0,0 +1,165 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package auth
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/praelatus/praelatus/config"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"github.com/praelatus/praelatus/repo/bolt"
+)
+
+// testRepo returns an empty repo in a temporary directory with the user
+// localuser whose password is localpass and whose email is verified
+func testRepo(t *testing.T) (repo.Repo, func()) {
+	dir, err := ioutil.TempDir("", "praelatus-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := bolt.New(filepath.Join(dir, "praelatus.db"))
+
+	u, err := models.NewUser("localuser", "localpass", "Local User", "local@example.com", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u.EmailVerified = true
+
+	_, err = r.Users().Create(system, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r, func() {
+		r.(bolt.Repo).DB.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	r, cleanup := testRepo(t)
+	defer cleanup()
+
+	p := Local{Repo: r}
+
+	u, err := p.Authenticate("localuser", "localpass")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if u.Username != "localuser" {
+		t.Errorf("Expected localuser Got %s", u.Username)
+	}
+
+	if _, err = p.Authenticate("localuser", "wrong"); err != ErrInvalidCredentials {
+		t.Errorf("Expected %s Got %v", ErrInvalidCredentials, err)
+	}
+
+	if _, err = p.Authenticate("nobody", "localpass"); err != ErrInvalidCredentials {
+		t.Errorf("Expected %s Got %v", ErrInvalidCredentials, err)
+	}
+}
+
+func TestLocalProviderAccount(t *testing.T) {
+	r, cleanup := testRepo(t)
+	defer cleanup()
+
+	u, err := models.NewUser("ssouser", "ssopass", "SSO User", "sso@example.com", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u.Provider = oidcProvider + "example"
+
+	_, err = r.Users().Create(system, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := Local{Repo: r}
+
+	if _, err = p.Authenticate("ssouser", "ssopass"); err != ErrInvalidCredentials {
+		t.Errorf("Expected %s Got %v", ErrInvalidCredentials, err)
+	}
+}
+
+type providerFunc func(username, password string) (models.User, error)
+
+func (f providerFunc) Authenticate(username, password string) (models.User, error) {
+	return f(username, password)
+}
+
+func TestChain(t *testing.T) {
+	down := errors.New("directory unreachable")
+
+	invalid := providerFunc(func(username, password string) (models.User, error) {
+		return models.User{}, ErrInvalidCredentials
+	})
+
+	unreachable := providerFunc(func(username, password string) (models.User, error) {
+		return models.User{}, down
+	})
+
+	valid := providerFunc(func(username, password string) (models.User, error) {
+		return models.User{Username: username}, nil
+	})
+
+	u, err := Chain{invalid, unreachable, valid}.Authenticate("user", "pass")
+	if err != nil || u.Username != "user" {
+		t.Errorf("Expected the last provider to log the user in Got %v %v", u, err)
+	}
+
+	if _, err = (Chain{invalid, unreachable}).Authenticate("user", "pass"); err != down {
+		t.Errorf("Expected %s Got %v", down, err)
+	}
+
+	if _, err = (Chain{invalid}).Authenticate("user", "pass"); err != ErrInvalidCredentials {
+		t.Errorf("Expected %s Got %v", ErrInvalidCredentials, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	p, err := New(config.AuthConfig{}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, ok := p.(Local); !ok {
+		t.Errorf("Expected local provider by default Got %T", p)
+	}
+
+	p, err = New(config.AuthConfig{
+		Providers: []string{"ldap", "local"},
+		LDAP: config.LDAPConfig{
+			URL:    "ldap://localhost",
+			BaseDN: "dc=example,dc=com",
+		},
+	}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if c, ok := p.(Chain); !ok || len(c) != 2 {
+		t.Errorf("Expected a chain of two providers Got %v", p)
+	}
+
+	if _, err = New(config.AuthConfig{Providers: []string{"ldap"}}, nil); err == nil {
+		t.Error("Expected ldap without a URL to be rejected")
+	}
+
+	if _, err = New(config.AuthConfig{Providers: []string{"kerberos"}}, nil); err == nil {
+		t.Error("Expected unknown providers to be rejected")
+	}
+}
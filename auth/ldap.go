@@ -0,0 +1,254 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/praelatus/praelatus/config"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	ldap "gopkg.in/ldap.v2"
+)
+
+// ldapProvider is the Provider of users created by the LDAP provider
+const ldapProvider = "ldap"
+
+// LDAP authenticates users by binding to an LDAP directory. Users are created
+// the first time they log in and their roles are updated from their groups
+// every time they log in.
+type LDAP struct {
+	config config.LDAPConfig
+	repo   repo.Repo
+	dial   func() (ldap.Client, error)
+}
+
+// NewLDAP will return an LDAP provider for the given configuration, filling
+// in defaults which work with OpenLDAP
+func NewLDAP(c config.LDAPConfig, r repo.Repo) (*LDAP, error) {
+	if c.URL == "" || c.BaseDN == "" {
+		return nil, errors.New("ldap requires a URL and BaseDN")
+	}
+
+	if c.UserFilter == "" {
+		c.UserFilter = "(uid=%s)"
+	}
+
+	if c.EmailAttribute == "" {
+		c.EmailAttribute = "mail"
+	}
+
+	if c.NameAttribute == "" {
+		c.NameAttribute = "cn"
+	}
+
+	if c.GroupAttribute == "" {
+		c.GroupAttribute = "memberOf"
+	}
+
+	l := &LDAP{config: c, repo: r}
+	l.dial = l.dialDirectory
+	return l, nil
+}
+
+// dialDirectory will connect to the directory at the configured URL, using
+// TLS for ldaps URLs or when StartTLS is set
+func (l *LDAP) dialDirectory() (ldap.Client, error) {
+	u, err := url.Parse(l.config.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: l.config.InsecureSkipVerify,
+	}
+
+	switch u.Scheme {
+	case "ldaps":
+		port := u.Port()
+		if port == "" {
+			port = "636"
+		}
+
+		return ldap.DialTLS("tcp", net.JoinHostPort(u.Hostname(), port), tlsConfig)
+	case "ldap":
+		port := u.Port()
+		if port == "" {
+			port = "389"
+		}
+
+		conn, err := ldap.Dial("tcp", net.JoinHostPort(u.Hostname(), port))
+		if err != nil || !l.config.StartTLS {
+			return conn, err
+		}
+
+		err = conn.StartTLS(tlsConfig)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return conn, nil
+	default:
+		return nil, fmt.Errorf("unsupported ldap url scheme: %s", u.Scheme)
+	}
+}
+
+// Authenticate implements Provider
+func (l *LDAP) Authenticate(username, password string) (models.User, error) {
+	// Binding with an empty password is an unauthenticated bind which most
+	// directories allow so it must never be treated as a login
+	if username == "" || password == "" {
+		return models.User{}, ErrInvalidCredentials
+	}
+
+	conn, err := l.dial()
+	if err != nil {
+		return models.User{}, err
+	}
+
+	defer conn.Close()
+
+	if l.config.BindDN != "" {
+		err = conn.Bind(l.config.BindDN, l.config.BindPassword)
+		if err != nil {
+			return models.User{}, err
+		}
+	}
+
+	res, err := conn.Search(ldap.NewSearchRequest(
+		l.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, 0, false,
+		fmt.Sprintf(l.config.UserFilter, ldap.EscapeFilter(username)),
+		[]string{l.config.EmailAttribute, l.config.NameAttribute, l.config.GroupAttribute},
+		nil,
+	))
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if len(res.Entries) != 1 {
+		return models.User{}, ErrInvalidCredentials
+	}
+
+	entry := res.Entries[0]
+
+	err = conn.Bind(entry.DN, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return models.User{}, ErrInvalidCredentials
+	}
+
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return l.provision(username, entry)
+}
+
+// provision will create the user for the directory entry if they don't exist
+// yet and update their roles from their groups. A local account with the same
+// username is never logged into, only accounts the directory created are.
+func (l *LDAP) provision(username string, entry *ldap.Entry) (models.User, error) {
+	user, err := l.repo.Users().Get(system, username)
+	if err == repo.ErrNotFound {
+		user, err = l.createUser(username, entry)
+	}
+
+	if err == nil && user.Provider != ldapProvider {
+		authLog.Println("Refusing directory login for local account", username)
+		return models.User{}, ErrInvalidCredentials
+	}
+
+	if err != nil || len(l.config.GroupRoles) == 0 {
+		return user, err
+	}
+
+	user.Roles = l.roles(user.Roles, entry.GetAttributeValues(l.config.GroupAttribute))
+	return user, l.repo.Users().SetRoles(system, user.Username, user.Roles)
+}
+
+// createUser will store a new user for the directory entry. They are given a
+// random password so that they can only log in through the directory.
+func (l *LDAP) createUser(username string, entry *ldap.Entry) (models.User, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return models.User{}, err
+	}
+
+	fullName := entry.GetAttributeValue(l.config.NameAttribute)
+	if fullName == "" {
+		fullName = username
+	}
+
+	u, err := models.NewUser(username, hex.EncodeToString(secret), fullName,
+		entry.GetAttributeValue(l.config.EmailAttribute), false)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	u.IsActive = true
+	u.Roles = []models.UserRole{}
+	u.Provider = ldapProvider
+
+	authLog.Println("Creating user for directory entry", entry.DN)
+	return l.repo.Users().Create(system, *u)
+}
+
+// roles will return current with the roles in every project which has a group
+// mapping replaced by the roles given to the groups, other roles are kept so
+// they can still be managed in praelatus
+func (l *LDAP) roles(current []models.UserRole, groups []string) []models.UserRole {
+	mapped := make(map[string]bool)
+	for _, gr := range l.config.GroupRoles {
+		mapped[gr.Project] = true
+	}
+
+	roles := []models.UserRole{}
+
+	for _, role := range current {
+		if !mapped[role.Project] {
+			roles = append(roles, role)
+		}
+	}
+
+	for _, gr := range l.config.GroupRoles {
+		role := models.UserRole{Role: models.Role(gr.Role), Project: gr.Project}
+
+		if isMember(groups, gr.Group) && !hasRole(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles
+}
+
+// isMember reports whether group is one of groups, DNs are case insensitive
+func isMember(groups []string, group string) bool {
+	for _, g := range groups {
+		if strings.EqualFold(g, group) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasRole(roles []models.UserRole, role models.UserRole) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,233 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/praelatus/praelatus/config"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	ldap "gopkg.in/ldap.v2"
+)
+
+const (
+	serviceDN   = "cn=praelatus,ou=services,dc=example,dc=com"
+	developers  = "cn=developers,ou=groups,dc=example,dc=com"
+	maintainers = "cn=maintainers,ou=groups,dc=example,dc=com"
+)
+
+type directoryEntry struct {
+	password string
+	attrs    map[string][]string
+}
+
+// directory is an in-process stand-in for an LDAP server, it only supports
+// the binds and equality searches which the provider makes
+type directory struct {
+	ldap.Client
+
+	entries map[string]directoryEntry
+	bound   string
+}
+
+func (d *directory) Bind(dn, password string) error {
+	e, ok := d.entries[dn]
+	if !ok || e.password != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+
+	d.bound = dn
+	return nil
+}
+
+func (d *directory) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if d.bound != serviceDN {
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("bind required"))
+	}
+
+	filter := strings.SplitN(strings.Trim(req.Filter, "()"), "=", 2)
+	res := &ldap.SearchResult{}
+
+	for dn, e := range d.entries {
+		if !strings.HasSuffix(dn, req.BaseDN) {
+			continue
+		}
+
+		for _, v := range e.attrs[filter[0]] {
+			if v == filter[1] {
+				res.Entries = append(res.Entries, ldap.NewEntry(dn, e.attrs))
+			}
+		}
+	}
+
+	return res, nil
+}
+
+func (d *directory) Close() {}
+
+func testLDAP(t *testing.T, r repo.Repo, groups ...string) *LDAP {
+	l, err := NewLDAP(config.LDAPConfig{
+		URL:          "ldap://localhost",
+		BindDN:       serviceDN,
+		BindPassword: "servicepass",
+		BaseDN:       "ou=people,dc=example,dc=com",
+		GroupRoles: []config.LDAPGroupRole{
+			{Group: developers, Project: "TEST", Role: "Contributor"},
+			{Group: maintainers, Project: "TEST", Role: "Administrator"},
+		},
+	}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := &directory{
+		entries: map[string]directoryEntry{
+			serviceDN: {password: "servicepass"},
+			"uid=jdoe,ou=people,dc=example,dc=com": {
+				password: "jdoepass",
+				attrs: map[string][]string{
+					"uid":      {"jdoe"},
+					"cn":       {"Jane Doe"},
+					"mail":     {"jdoe@example.com"},
+					"memberOf": groups,
+				},
+			},
+		},
+	}
+
+	l.dial = func() (ldap.Client, error) { return dir, nil }
+	return l
+}
+
+func TestLDAPProvisionsUsers(t *testing.T) {
+	r, cleanup := testRepo(t)
+	defer cleanup()
+
+	u, err := testLDAP(t, r, strings.ToUpper(developers)).Authenticate("jdoe", "jdoepass")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	stored, err := r.Users().Get(system, "jdoe")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stored.Email != "jdoe@example.com" || stored.FullName != "Jane Doe" {
+		t.Errorf("Expected user from the directory entry Got %v", stored)
+	}
+
+	expected := models.UserRole{Project: "TEST", Role: "Contributor"}
+	if len(u.Roles) != 1 || u.Roles[0] != expected || len(stored.Roles) != 1 {
+		t.Errorf("Expected roles %v Got %v stored %v", expected, u.Roles, stored.Roles)
+	}
+
+	// Directory users can't log in with a local password
+	if _, err = (Local{Repo: r}).Authenticate("jdoe", "jdoepass"); err != ErrInvalidCredentials {
+		t.Errorf("Expected %s Got %v", ErrInvalidCredentials, err)
+	}
+}
+
+func TestLDAPUpdatesRoles(t *testing.T) {
+	r, cleanup := testRepo(t)
+	defer cleanup()
+
+	_, err := testLDAP(t, r, developers).Authenticate("jdoe", "jdoepass")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Roles in projects without group mappings are managed in praelatus
+	other := models.UserRole{Project: "OTHER", Role: "Administrator"}
+	err = r.Users().SetRoles(system, "jdoe", []models.UserRole{
+		other,
+		{Project: "TEST", Role: "Contributor"},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	u, err := testLDAP(t, r, maintainers).Authenticate("jdoe", "jdoepass")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := []models.UserRole{other, {Project: "TEST", Role: "Administrator"}}
+	if len(u.Roles) != len(expected) || u.Roles[0] != expected[0] || u.Roles[1] != expected[1] {
+		t.Errorf("Expected roles %v Got %v", expected, u.Roles)
+	}
+}
+
+func TestLDAPInvalidCredentials(t *testing.T) {
+	r, cleanup := testRepo(t)
+	defer cleanup()
+
+	l := testLDAP(t, r)
+
+	for _, login := range [][2]string{
+		{"jdoe", "wrong"},
+		{"jdoe", ""},
+		{"nobody", "jdoepass"},
+		{"*", "jdoepass"},
+	} {
+		if _, err := l.Authenticate(login[0], login[1]); err != ErrInvalidCredentials {
+			t.Errorf("[%s] Expected %s Got %v", login[0], ErrInvalidCredentials, err)
+		}
+	}
+
+	if _, err := r.Users().Get(system, "jdoe"); err != repo.ErrNotFound {
+		t.Errorf("Expected no user to be created Got %v", err)
+	}
+}
+
+func TestLDAPLocalAccount(t *testing.T) {
+	r, cleanup := testRepo(t)
+	defer cleanup()
+
+	u, err := models.NewUser("jdoe", "localpass", "Local Jane", "local@example.com", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = r.Users().Create(system, *u); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = testLDAP(t, r, developers).Authenticate("jdoe", "jdoepass")
+	if err != ErrInvalidCredentials {
+		t.Errorf("Expected %s Got %v", ErrInvalidCredentials, err)
+	}
+
+	stored, err := r.Users().Get(system, "jdoe")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stored.FullName != "Local Jane" || len(stored.Roles) != 0 {
+		t.Errorf("Expected the local account to be untouched Got %v", stored)
+	}
+}
+
+func TestLDAPServiceBindFailure(t *testing.T) {
+	r, cleanup := testRepo(t)
+	defer cleanup()
+
+	l := testLDAP(t, r)
+	l.config.BindPassword = "wrong"
+
+	_, err := l.Authenticate("jdoe", "jdoepass")
+	if err == nil || err == ErrInvalidCredentials {
+		t.Errorf("Expected the service account error Got %v", err)
+	}
+}
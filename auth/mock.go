@@ -0,0 +1,188 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+// +build !release
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// MockIssuer is an OpenID Connect provider for testing logins. Every request
+// to its authorization endpoint is logged in immediately as Subject and the ID
+// token it issues contains Claims along with the standard claims.
+type MockIssuer struct {
+	*httptest.Server
+
+	ClientID string
+	Subject  string
+	Claims   jwt.MapClaims
+
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]string
+}
+
+// NewMockIssuer will start a MockIssuer which issues tokens for clientID, it
+// should be closed when no longer needed
+func NewMockIssuer(clientID string) *MockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	m := &MockIssuer{
+		ClientID: clientID,
+		Subject:  "mock-subject",
+		Claims:   jwt.MapClaims{},
+		key:      key,
+		codes:    make(map[string]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/authorize", m.authorize)
+	mux.HandleFunc("/token", m.token)
+	mux.HandleFunc("/jwks", m.jwks)
+
+	m.Server = httptest.NewServer(mux)
+	return m
+}
+
+func (m *MockIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(discovery{
+		Issuer:                m.URL,
+		AuthorizationEndpoint: m.URL + "/authorize",
+		TokenEndpoint:         m.URL + "/token",
+		JWKSURI:               m.URL + "/jwks",
+	})
+}
+
+// authorize will redirect back to the client with a code for the nonce
+func (m *MockIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	if q.Get("client_id") != m.ClientID {
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	code := hex.EncodeToString(b)
+
+	m.mu.Lock()
+	m.codes[code] = q.Get("nonce")
+	m.mu.Unlock()
+
+	rq := redirect.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirect.RawQuery = rq.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token will exchange a code from authorize for a signed ID token, codes can
+// only be used once
+func (m *MockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	m.mu.Lock()
+	nonce, ok := m.codes[r.FormValue("code")]
+	delete(m.codes, r.FormValue("code"))
+	m.mu.Unlock()
+
+	if !ok || r.FormValue("grant_type") != "authorization_code" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   m.URL,
+		"aud":   m.ClientID,
+		"sub":   m.Subject,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+		"nonce": nonce,
+	}
+
+	for k, v := range m.Claims {
+		claims[k] = v
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "mock",
+		"token_type":   "Bearer",
+		"id_token":     m.Sign(claims),
+	})
+}
+
+func (m *MockIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string][]jwk{
+		"keys": {{
+			Kty: "RSA",
+			Kid: "mock",
+			N:   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}},
+	})
+}
+
+// Sign will return the claims signed with the issuer's key
+func (m *MockIssuer) Sign(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "mock"
+
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		panic(err)
+	}
+
+	return signed
+}
+
+// Login will follow the authorization URL as a browser would, returning the
+// code and state from the redirect back to the client
+func (m *MockIssuer) Login(authURL string) (code, state string, err error) {
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	res, err := client.Get(authURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	res.Body.Close()
+
+	loc, err := res.Location()
+	if err != nil {
+		return "", "", err
+	}
+
+	return loc.Query().Get("code"), loc.Query().Get("state"), nil
+}
@@ -0,0 +1,507 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/praelatus/praelatus/config"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+)
+
+// oidcProvider prefixes the provider name in the Provider of users created by
+// an OIDC provider
+const oidcProvider = "oidc:"
+
+// signingMethods are the ID token algorithms which are accepted, HMAC is
+// never accepted since the client secret isn't meant to be a signing key
+var signingMethods = []string{
+	"RS256", "RS384", "RS512",
+	"ES256", "ES384", "ES512",
+}
+
+// discovery is the part of an OpenID Connect discovery document we use
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// jwk is a public key from the provider's JSON Web Key Set
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// OIDC logs users in with the OpenID Connect authorization code flow. Users
+// are linked to the praelatus user with the same email and are created the
+// first time they log in if there isn't one.
+type OIDC struct {
+	config config.OIDCProvider
+	repo   repo.Repo
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]interface{}
+}
+
+// NewOIDC will return an OIDC provider for the given configuration, the
+// provider's discovery document is fetched the first time it's used
+func NewOIDC(c config.OIDCProvider, r repo.Repo) (*OIDC, error) {
+	if c.Name == "" || c.Issuer == "" || c.ClientID == "" || c.RedirectURL == "" {
+		return nil, errors.New("oidc providers require a Name, Issuer, ClientID and RedirectURL")
+	}
+
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	return &OIDC{
+		config: c,
+		repo:   r,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// NewOIDCProviders will return an OIDC provider for each configuration
+func NewOIDCProviders(c []config.OIDCProvider, r repo.Repo) ([]*OIDC, error) {
+	providers := make([]*OIDC, 0, len(c))
+	names := make(map[string]bool)
+
+	for _, pc := range c {
+		o, err := NewOIDC(pc, r)
+		if err != nil {
+			return nil, err
+		}
+
+		if names[o.Name()] {
+			return nil, fmt.Errorf("duplicate oidc provider: %s", o.Name())
+		}
+
+		names[o.Name()] = true
+		providers = append(providers, o)
+	}
+
+	return providers, nil
+}
+
+// Name returns the name the provider was configured with
+func (o *OIDC) Name() string {
+	return o.config.Name
+}
+
+// getJSON will decode the JSON document at the given url into v
+func (o *OIDC) getJSON(u string, v interface{}) error {
+	res, err := o.client.Get(u)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", u, res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// discover will return the provider's discovery document, fetching it if it
+// hasn't been yet
+func (o *OIDC) discover() (*discovery, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.discovery != nil {
+		return o.discovery, nil
+	}
+
+	issuer := strings.TrimSuffix(o.config.Issuer, "/")
+
+	var d discovery
+
+	err := o.getJSON(issuer+"/.well-known/openid-configuration", &d)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(d.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %s not %s", d.Issuer, o.config.Issuer)
+	}
+
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing required endpoints")
+	}
+
+	o.discovery = &d
+	return o.discovery, nil
+}
+
+// AuthCodeURL returns the URL to send the user to so they can log in with
+// the provider, state and nonce are echoed back in the callback and ID token
+func (o *OIDC) AuthCodeURL(state, nonce string) (string, error) {
+	d, err := o.discover()
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", o.config.ClientID)
+	q.Set("redirect_uri", o.config.RedirectURL)
+	q.Set("scope", strings.Join(o.config.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// Exchange will trade the authorization code for an ID token and return the
+// user it belongs to. The token must contain the nonce the login was started
+// with.
+func (o *OIDC) Exchange(code, nonce string) (models.User, error) {
+	d, err := o.discover()
+	if err != nil {
+		return models.User{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", o.config.RedirectURL)
+	form.Set("client_id", o.config.ClientID)
+
+	req, err := http.NewRequest("POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return models.User{}, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(o.config.ClientID), url.QueryEscape(o.config.ClientSecret))
+
+	res, err := o.client.Do(req)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	defer res.Body.Close()
+
+	var tr struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&tr)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if tr.Error != "" {
+		return models.User{}, fmt.Errorf("token exchange failed: %s %s", tr.Error, tr.ErrorDescription)
+	}
+
+	if res.StatusCode != http.StatusOK || tr.IDToken == "" {
+		return models.User{}, fmt.Errorf("token exchange failed: %s", res.Status)
+	}
+
+	claims, err := o.verify(tr.IDToken, nonce)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return o.provision(claims)
+}
+
+// verify will check the ID token's signature and claims returning them if it
+// is valid
+func (o *OIDC) verify(idToken, nonce string) (jwt.MapClaims, error) {
+	parser := jwt.Parser{ValidMethods: signingMethods}
+
+	token, err := parser.Parse(idToken, o.verificationKey)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid id token")
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != strings.TrimSuffix(o.config.Issuer, "/") {
+		return nil, fmt.Errorf("id token issued by %s", iss)
+	}
+
+	if !o.hasAudience(claims) {
+		return nil, errors.New("id token was not issued for this client")
+	}
+
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("id token has no expiry")
+	}
+
+	if n, _ := claims["nonce"].(string); nonce == "" || n != nonce {
+		return nil, errors.New("id token nonce does not match")
+	}
+
+	return claims, nil
+}
+
+// hasAudience reports whether the aud claim, which may be a string or a list,
+// contains our client id
+func (o *OIDC) hasAudience(claims jwt.MapClaims) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == o.config.ClientID
+	case []interface{}:
+		for _, a := range aud {
+			if s, _ := a.(string); s == o.config.ClientID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// verificationKey is a jwt.Keyfunc which returns the provider's key named by
+// the token's kid header, the key set is fetched again if the key is unknown
+// so that rotated keys are picked up
+func (o *OIDC) verificationKey(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	key, err := o.key(kid, false)
+	if err == nil {
+		return key, nil
+	}
+
+	return o.key(kid, true)
+}
+
+// key will return the key with the given id, refreshing the key set first
+// if refresh is true or it hasn't been loaded. If kid is empty and the
+// provider only has one key that key is returned.
+func (o *OIDC) key(kid string, refresh bool) (interface{}, error) {
+	d, err := o.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.keys == nil || refresh {
+		var set struct {
+			Keys []jwk `json:"keys"`
+		}
+
+		err = o.getJSON(d.JWKSURI, &set)
+		if err != nil {
+			return nil, err
+		}
+
+		o.keys = make(map[string]interface{})
+
+		for _, k := range set.Keys {
+			pub, err := k.publicKey()
+			if err != nil {
+				authLog.Printf("Skipping key %s from %s: %s\n", k.Kid, o.Name(), err)
+				continue
+			}
+
+			o.keys[k.Kid] = pub
+		}
+	}
+
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+
+	if kid == "" && len(o.keys) == 1 {
+		for _, key := range o.keys {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown key %s", kid)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// publicKey will return the rsa or ecdsa public key for the jwk
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+// provision will return the user with the ID token's email, creating them
+// if they don't exist yet
+func (o *OIDC) provision(claims jwt.MapClaims) (models.User, error) {
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return models.User{}, errors.New("id token has no email")
+	}
+
+	// Only an email the provider says it has verified can be trusted to
+	// link accounts, a missing claim is treated as unverified
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		return models.User{}, fmt.Errorf("email %s has not been verified", email)
+	}
+
+	user, err := o.repo.Users().GetByEmail(system, email)
+	if err == repo.ErrNotFound {
+		return o.createUser(email, claims)
+	}
+
+	if err != nil || user.Provider == oidcProvider+o.Name() {
+		return user, err
+	}
+
+	// Anyone who controls an account at the provider with the same email
+	// would get this account, so administrators are never linked and
+	// others only once they have proven they own the email here too
+	if user.IsAdmin {
+		return models.User{}, fmt.Errorf("administrator %s can't be linked to %s", user.Username, o.Name())
+	}
+
+	if !user.EmailVerified {
+		return models.User{}, fmt.Errorf("email %s has not been verified by %s", email, user.Username)
+	}
+
+	return user, nil
+}
+
+// createUser will store a new user for the ID token. They are given a random
+// password so that they can only log in through the provider.
+func (o *OIDC) createUser(email string, claims jwt.MapClaims) (models.User, error) {
+	username, err := o.username(email, claims)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err = rand.Read(secret); err != nil {
+		return models.User{}, err
+	}
+
+	fullName, _ := claims["name"].(string)
+	if fullName == "" {
+		fullName = username
+	}
+
+	u, err := models.NewUser(username, hex.EncodeToString(secret), fullName, email, false)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	u.IsActive = true
+	u.EmailVerified = true
+	u.Provider = oidcProvider + o.Name()
+	u.Roles = []models.UserRole{}
+
+	authLog.Printf("Creating user %s for %s login\n", username, o.Name())
+	return o.repo.Users().Create(system, *u)
+}
+
+// username will return an unused username for the ID token, preferring the
+// provider's preferred_username and falling back to the email's local part
+func (o *OIDC) username(email string, claims jwt.MapClaims) (string, error) {
+	base, _ := claims["preferred_username"].(string)
+	if base == "" || strings.ContainsAny(base, " /") {
+		base = strings.SplitN(email, "@", 2)[0]
+	}
+
+	username := base
+
+	for i := 1; ; i++ {
+		_, err := o.repo.Users().Get(system, username)
+		if err == repo.ErrNotFound {
+			return username, nil
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		username = base + strconv.Itoa(i)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package auth
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/praelatus/praelatus/config"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+)
+
+func testOIDC(t *testing.T, r repo.Repo) (*OIDC, *MockIssuer) {
+	issuer := NewMockIssuer("praelatus")
+
+	o, err := NewOIDC(config.OIDCProvider{
+		Name:         "mock",
+		Issuer:       issuer.URL,
+		ClientID:     "praelatus",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/api/v1/auth/oidc/callback",
+	}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return o, issuer
+}
+
+// login will run the authorization code flow against the issuer returning the
+// code for the nonce
+func login(t *testing.T, o *OIDC, issuer *MockIssuer, nonce string) string {
+	authURL, err := o.AuthCodeURL("state", nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(authURL)
+	if u.Query().Get("scope") != "openid email profile" {
+		t.Errorf("Expected default scopes Got %s", u.Query().Get("scope"))
+	}
+
+	code, state, err := issuer.Login(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state != "state" {
+		t.Errorf("Expected state Got %s", state)
+	}
+
+	return code
+}
+
+func TestOIDCCreatesUser(t *testing.T) {
+	r, cleanup := testRepo(t)
+	defer cleanup()
+
+	o, issuer := testOIDC(t, r)
+	defer issuer.Close()
+
+	issuer.Claims = jwt.MapClaims{
+		"email":              "new@example.com",
+		"email_verified":     true,
+		"name":               "New User",
+		"preferred_username": "localuser",
+	}
+
+	u, err := o.Exchange(login(t, o, issuer, "nonce"), "nonce")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// localuser is taken so a suffix should be added
+	if u.Username != "localuser1" || u.FullName != "New User" || !u.IsActive {
+		t.Errorf("Expected new active user localuser1 Got %v", u)
+	}
+
+	stored, err := r.Users().Get(system, "localuser1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stored.Provider != oidcProvider+o.Name() {
+		t.Errorf("Expected provider %s Got %s", oidcProvider+o.Name(), stored.Provider)
+	}
+}
+
+func TestOIDCLinksByEmail(t *testing.T) {
+	r, cleanup := testRepo(t)
+	defer cleanup()
+
+	o, issuer := testOIDC(t, r)
+	defer issuer.Close()
+
+	issuer.Claims = jwt.MapClaims{"email": "LOCAL@example.com", "email_verified": true}
+
+	u, err := o.Exchange(login(t, o, issuer, "nonce"), "nonce")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if u.Username != "localuser" {
+		t.Errorf("Expected localuser Got %s", u.Username)
+	}
+}
+
+func TestOIDCRefusesLinking(t *testing.T) {
+	r, cleanup := testRepo(t)
+	defer cleanup()
+
+	o, issuer := testOIDC(t, r)
+	defer issuer.Close()
+
+	admin, _ := models.NewUser("admin", "adminpass", "Admin", "admin@example.com", true)
+	admin.EmailVerified = true
+
+	unverified, _ := models.NewUser("unverified", "pass", "Unverified", "unverified@example.com", false)
+
+	for _, u := range []*models.User{admin, unverified} {
+		if _, err := r.Users().Create(system, *u); err != nil {
+			t.Fatal(err)
+		}
+
+		issuer.Claims = jwt.MapClaims{"email": u.Email, "email_verified": true}
+
+		if _, err := o.Exchange(login(t, o, issuer, "nonce"), "nonce"); err == nil {
+			t.Errorf("Expected %s not to be linked", u.Username)
+		}
+	}
+
+	// Changing email clears the verification
+	err := r.Users().Update(system, "localuser", models.User{Email: "changed@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer.Claims = jwt.MapClaims{"email": "changed@example.com", "email_verified": true}
+
+	if _, err = o.Exchange(login(t, o, issuer, "nonce"), "nonce"); err == nil {
+		t.Error("Expected a changed email not to be linked")
+	}
+}
+
+func TestOIDCRejectsInvalidTokens(t *testing.T) {
+	r, cleanup := testRepo(t)
+	defer cleanup()
+
+	o, issuer := testOIDC(t, r)
+	defer issuer.Close()
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		nonce  string
+	}{
+		{"Wrong Nonce", jwt.MapClaims{"email": "local@example.com", "email_verified": true}, "other"},
+		{"Unverified Email", jwt.MapClaims{"email": "local@example.com", "email_verified": false}, "nonce"},
+		{"Missing Verification", jwt.MapClaims{"email": "local@example.com"}, "nonce"},
+		{"No Email", jwt.MapClaims{}, "nonce"},
+		{"Wrong Audience", jwt.MapClaims{"email": "local@example.com", "email_verified": true, "aud": "someone-else"}, "nonce"},
+		{"Wrong Issuer", jwt.MapClaims{"email": "local@example.com", "email_verified": true, "iss": "http://evil.example.com"}, "nonce"},
+		{"Expired", jwt.MapClaims{"email": "local@example.com", "email_verified": true, "exp": time.Now().Add(-time.Minute).Unix()}, "nonce"},
+	}
+
+	for _, test := range tests {
+		issuer.Claims = test.claims
+
+		_, err := o.Exchange(login(t, o, issuer, "nonce"), test.nonce)
+		if err == nil {
+			t.Errorf("(%s) Expected an error", test.name)
+		}
+	}
+
+	if _, err := o.Exchange("not-a-code", "nonce"); err == nil {
+		t.Error("Expected an error exchanging an unknown code")
+	}
+}
+
+func TestOIDCVerifyAudienceList(t *testing.T) {
+	o, issuer := testOIDC(t, nil)
+	defer issuer.Close()
+
+	token := issuer.Sign(jwt.MapClaims{
+		"iss":   issuer.URL,
+		"aud":   []string{"other", "praelatus"},
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": "nonce",
+	})
+
+	if _, err := o.verify(token, "nonce"); err != nil {
+		t.Error(err)
+	}
+
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss":   issuer.URL,
+		"aud":   "praelatus",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": "nonce",
+	})
+
+	signed, _ := hmac.SignedString([]byte("secret"))
+	if _, err := o.verify(signed, "nonce"); err == nil {
+		t.Error("Expected HS256 tokens to be rejected")
+	}
+}
+
+func TestNewOIDCProviders(t *testing.T) {
+	valid := config.OIDCProvider{
+		Name:        "mock",
+		Issuer:      "http://localhost",
+		ClientID:    "praelatus",
+		RedirectURL: "http://localhost/callback",
+	}
+
+	p, err := NewOIDCProviders([]config.OIDCProvider{valid}, nil)
+	if err != nil || len(p) != 1 || p[0].Name() != "mock" {
+		t.Errorf("Expected one provider Got %v %v", p, err)
+	}
+
+	if _, err = NewOIDCProviders([]config.OIDCProvider{valid, valid}, nil); err == nil {
+		t.Error("Expected an error for duplicate providers")
+	}
+
+	if _, err = NewOIDCProviders([]config.OIDCProvider{{Name: "mock"}}, nil); err == nil {
+		t.Error("Expected an error for an incomplete provider")
+	}
+}
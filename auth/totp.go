@@ -0,0 +1,205 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/models/permission"
+	"github.com/praelatus/praelatus/repo"
+)
+
+// TOTP parameters, these are the defaults every authenticator app supports
+const (
+	totpIssuer = "Praelatus"
+	totpPeriod = 30
+	totpDigits = 6
+
+	// totpSkew is how many periods either side of now a code is accepted
+	// for to allow for clock drift
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+)
+
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret will return a random base32 encoded secret for a user to add
+// to their authenticator
+func NewTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return secretEncoding.EncodeToString(secret), nil
+}
+
+// TOTPURI returns the otpauth URI for the secret which authenticator apps
+// read from a QR code
+func TOTPURI(username, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", totpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + totpIssuer + ":" + username,
+		RawQuery: q.Encode(),
+	}
+
+	return u.String()
+}
+
+// totpCode returns the code for the secret at the given time step as
+// described in RFC 6238
+func totpCode(secret string, counter int64) (string, error) {
+	key, err := secretEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, code%1000000), nil
+}
+
+// TOTPCode returns the code for the secret at time t
+func TOTPCode(secret string, t time.Time) (string, error) {
+	return totpCode(secret, t.Unix()/totpPeriod)
+}
+
+// validateTOTP will return the time step the code is valid for, codes from
+// steps at or before last are rejected so they can't be replayed
+func validateTOTP(secret, code string, last int64, t time.Time) (int64, bool) {
+	now := t.Unix() / totpPeriod
+
+	for counter := now - totpSkew; counter <= now+totpSkew; counter++ {
+		if counter <= last {
+			continue
+		}
+
+		expected, err := totpCode(secret, counter)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+
+	return 0, false
+}
+
+// normalizeCode strips the spaces and dashes people type in codes
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewRecoveryCodes will return a set of single use recovery codes for the
+// user to keep and their hashes to store
+func NewRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err = rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		code := hex.EncodeToString(b)
+		code = code[:5] + "-" + code[5:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// VerifyTOTP reports whether code is a valid TOTP code for the secret being
+// enrolled or enabled in tf, recording its time step so it can't be used
+// again
+func VerifyTOTP(tf *models.TwoFactor, code string) bool {
+	if tf.Secret == "" {
+		return false
+	}
+
+	counter, ok := validateTOTP(tf.Secret, normalizeCode(code), tf.LastCounter, time.Now())
+	if ok {
+		tf.LastCounter = counter
+	}
+
+	return ok
+}
+
+// VerifyTwoFactor reports whether code is a valid TOTP code or an unused
+// recovery code for tf. The code is recorded in tf so it can't be used again
+// and tf must be saved afterwards.
+func VerifyTwoFactor(tf *models.TwoFactor, code string) bool {
+	if !tf.Enabled {
+		return false
+	}
+
+	if VerifyTOTP(tf, code) {
+		return true
+	}
+
+	hash := hashRecoveryCode(code)
+
+	for i, h := range tf.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			tf.RecoveryCodes = append(tf.RecoveryCodes[:i:i], tf.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Privileged reports whether the user is a system administrator or can
+// administer any project, these are the users two-factor authentication is
+// enforced for
+func Privileged(r repo.Repo, u models.User) (bool, error) {
+	if u.IsAdmin {
+		return true, nil
+	}
+
+	projects, err := r.Projects().HasPermissionTo(&u,
+		permission.Permissions{permission.AdminProject})
+	if err == repo.ErrNotFound || err == repo.ErrUnauthorized {
+		return false, nil
+	}
+
+	return len(projects) != 0, err
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package auth
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+)
+
+// rfcSecret is the SHA1 secret from the RFC 6238 test vectors
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	tests := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, expected := range tests {
+		code, err := TOTPCode(rfcSecret, time.Unix(unix, 0))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		if code != expected {
+			t.Errorf("(%d) Expected %s Got %s", unix, expected, code)
+		}
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	u, err := url.Parse(TOTPURI("testuser", rfcSecret))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Praelatus:testuser" {
+		t.Errorf("Unexpected URI %s", u)
+	}
+
+	if u.Query().Get("secret") != rfcSecret || u.Query().Get("issuer") != "Praelatus" {
+		t.Errorf("Unexpected query %s", u.RawQuery)
+	}
+}
+
+func TestVerifyTwoFactor(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tf := models.TwoFactor{Enabled: true, Secret: secret, RecoveryCodes: hashes}
+
+	code, _ := TOTPCode(secret, time.Now())
+	if !VerifyTwoFactor(&tf, code) {
+		t.Error("Expected the current code to be valid")
+	}
+
+	if VerifyTwoFactor(&tf, code) {
+		t.Error("Expected a used code to be rejected")
+	}
+
+	if VerifyTwoFactor(&tf, "000000x") {
+		t.Error("Expected an invalid code to be rejected")
+	}
+
+	if !VerifyTwoFactor(&tf, " "+codes[3]+" ") || len(tf.RecoveryCodes) != len(codes)-1 {
+		t.Error("Expected the recovery code to be valid and used up")
+	}
+
+	if VerifyTwoFactor(&tf, codes[3]) {
+		t.Error("Expected a used recovery code to be rejected")
+	}
+
+	tf.Enabled = false
+	if VerifyTwoFactor(&tf, codes[4]) {
+		t.Error("Expected codes to be rejected when two-factor is disabled")
+	}
+}
+
+func TestPrivileged(t *testing.T) {
+	r, cleanup := testRepo(t)
+	defer cleanup()
+
+	if p, err := Privileged(r, models.User{Username: "localuser"}); p || err != nil {
+		t.Errorf("Expected localuser not to be privileged Got %v %v", p, err)
+	}
+
+	if p, err := Privileged(r, models.User{Username: "admin", IsAdmin: true}); !p || err != nil {
+		t.Errorf("Expected admins to be privileged Got %v %v", p, err)
+	}
+}
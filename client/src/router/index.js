@@ -50,6 +50,12 @@ export default new Router({
       name: 'Users/Login',
       component: Login
     },
+    {
+      path: '/login/oidc',
+      name: 'Users/OIDCLogin',
+      component: Login,
+      props: { oidc: true }
+    },
     {
       path: '/register',
       name: 'Users/Register',
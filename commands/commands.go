@@ -5,11 +5,38 @@
 package commands
 
 import (
+	"log"
+	"os"
+	"strings"
+
 	"github.com/praelatus/praelatus/config"
 	"github.com/praelatus/praelatus/repo"
+	"github.com/praelatus/praelatus/repo/bolt"
+	"github.com/praelatus/praelatus/repo/cache"
 	"github.com/praelatus/praelatus/repo/mongo"
+	"github.com/praelatus/praelatus/repo/sql"
 )
 
+// loadRepo will connect to the database backend indicated by the database url
 func loadRepo() repo.Repo {
-	return mongo.New(config.DBURL())
+	if strings.HasPrefix(config.DBURL(), "mongodb://") {
+		return mongo.New(config.DBURL())
+	}
+
+	if strings.HasPrefix(config.DBURL(), "bolt://") {
+		return bolt.New(strings.TrimPrefix(config.DBURL(), "bolt://"))
+	}
+
+	return sql.New(config.DBURL())
+}
+
+// loadCache will open the session store indicated by the configuration
+func loadCache() repo.Cache {
+	c, err := cache.New(config.SessionStore(), config.SessionURL())
+	if err != nil {
+		log.Println("Unable to open session store:", err)
+		os.Exit(1)
+	}
+
+	return c
 }
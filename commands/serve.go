@@ -6,8 +6,10 @@
 package commands
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	// Allows us to run profiling when flag is given
@@ -15,8 +17,15 @@ import (
 
 	"github.com/praelatus/praelatus/api"
 	"github.com/praelatus/praelatus/api/middleware"
+	"github.com/praelatus/praelatus/api/v1"
+	"github.com/praelatus/praelatus/auth"
 	"github.com/praelatus/praelatus/config"
 	"github.com/praelatus/praelatus/events"
+	"github.com/praelatus/praelatus/files"
+	"github.com/praelatus/praelatus/files/filesystem"
+	"github.com/praelatus/praelatus/inbound"
+	"github.com/praelatus/praelatus/mail"
+	"github.com/praelatus/praelatus/repo"
 	"github.com/spf13/cobra"
 	"github.com/tylerb/graceful"
 )
@@ -43,6 +52,68 @@ var server = &cobra.Command{
 		log.Println("Starting Praelatus...")
 		log.Println("Connecting to database...")
 		rpo := loadRepo()
+
+		log.Println("Loading JWT keys...")
+		err := middleware.LoadKeys(config.JWT())
+		if err != nil {
+			log.Println("Unable to load JWT keys:", err)
+			os.Exit(1)
+		}
+
+		log.Println("Loading authentication providers...")
+		v1.Auth, err = auth.New(config.Auth(), rpo)
+		if err != nil {
+			log.Println("Unable to load authentication providers:", err)
+			os.Exit(1)
+		}
+
+		v1.OIDC, err = auth.NewOIDCProviders(config.Auth().OIDC, rpo)
+		if err != nil {
+			log.Println("Unable to load OIDC providers:", err)
+			os.Exit(1)
+		}
+
+		v1.RequireTwoFactor = config.Auth().RequireTwoFactor
+		v1.ChatSigningSecret = config.Chat().SigningSecret
+
+		log.Println("Configuring mail...")
+		v1.Mail, err = mail.New(config.Mail())
+		if err != nil {
+			log.Println("Unable to configure mail:", err)
+			os.Exit(1)
+		}
+
+		v1.URL = config.Mail().URL
+		events.Mail, events.URL = v1.Mail, v1.URL
+
+		events.DigestInterval, err = time.ParseDuration(config.Mail().DigestInterval)
+		if err != nil {
+			log.Println("Invalid mail digest interval:", err)
+			os.Exit(1)
+		}
+
+		events.HookTimeout, err = time.ParseDuration(config.Hooks().Timeout)
+		if err != nil {
+			log.Println("Invalid hook timeout:", err)
+			os.Exit(1)
+		}
+
+		events.AllowPrivateHooks = config.Hooks().AllowPrivate
+		events.CaptureHookResponses = config.Hooks().CaptureResponses
+
+		log.Println("Opening file store...")
+		fs := filesystem.New()
+		err = fs.Init()
+		if err != nil {
+			log.Println("Unable to open file store:", err)
+			os.Exit(1)
+		}
+
+		v1.Files = fs
+
+		log.Println("Opening session store...")
+		repo.GlobalCache = loadCache()
+
 		mw := middleware.Default
 
 		if disableCORS {
@@ -61,12 +132,52 @@ var server = &cobra.Command{
 		}
 
 		log.Println("Staring event manager...")
-		go events.Run()
+		ctx, stopEvents := context.WithCancel(context.Background())
+		eventsStopped := make(chan struct{})
+
+		go func() {
+			defer close(eventsStopped)
+			events.Run(ctx)
+		}()
+
+		startInbound(config.Mail().Inbound, rpo, fs)
 
 		log.Println("Listening on", config.Port())
-		err := graceful.RunWithErr(config.Port(), time.Minute, r)
+		err = graceful.RunWithErr(config.Port(), time.Minute, r)
 		if err != nil {
 			log.Println("Exited with error:", err)
 		}
+
+		// Wait for waiting digests and queued emails to be sent
+		stopEvents()
+		<-eventsStopped
 	},
 }
+
+// startInbound will start turning email sent to the inbound address into
+// comments and tickets if it is configured
+func startInbound(c config.InboundConfig, rpo repo.Repo, fs files.FS) {
+	if c.Address == "" {
+		return
+	}
+
+	if c.Secret == "" && c.AuthServID == "" {
+		log.Println("Neither an inbound secret nor authserv-id is set, all inbound email will be refused")
+	}
+
+	events.ReplyAddress = c.Address
+	events.ReplySecret = c.Secret
+	p := inbound.New(c, rpo, fs)
+
+	if c.Maildir != "" {
+		log.Println("Reading email from", c.Maildir)
+		go inbound.NewMaildir(c.Maildir, p).Watch(time.Minute, nil)
+	}
+
+	if c.Listen != "" {
+		log.Println("Receiving email on", c.Listen)
+		go func() {
+			log.Println("Inbound SMTP server exited:", inbound.NewServer(p).ListenAndServe(c.Listen))
+		}()
+	}
+}
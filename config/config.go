@@ -22,6 +22,135 @@ type AWSConfig struct {
 	BaseURL *string
 }
 
+// JWTKey is a key which session tokens can be signed with. Secret is used for
+// HS256 keys and PrivateKeyFile is the path to a PEM encoded private key for
+// RS256 and ES256 keys.
+type JWTKey struct {
+	ID             string
+	Algorithm      string
+	Secret         string `json:",omitempty"`
+	PrivateKeyFile string `json:",omitempty"`
+}
+
+// JWTConfig holds the keys for session tokens. New tokens are signed with the
+// key whose ID is SigningKey and tokens are verified with the key named by
+// their kid header, so old keys can be kept while rotating to a new one.
+type JWTConfig struct {
+	SigningKey string
+	Keys       []JWTKey
+}
+
+// LDAPGroupRole gives members of the LDAP group with the DN Group the Role in
+// Project
+type LDAPGroupRole struct {
+	Group   string
+	Project string
+	Role    string
+}
+
+// LDAPConfig configures logging in against an LDAP directory or Active
+// Directory. Users are found by searching BaseDN with UserFilter, where %s is
+// replaced with the username, after binding as BindDN, then their password is
+// checked by binding as the user. GroupAttribute lists the groups a user is in
+// which are given roles by GroupRoles.
+type LDAPConfig struct {
+	URL                string
+	StartTLS           bool
+	InsecureSkipVerify bool `json:",omitempty"`
+
+	BindDN       string
+	BindPassword string `json:",omitempty"`
+
+	BaseDN         string
+	UserFilter     string
+	EmailAttribute string
+	NameAttribute  string
+	GroupAttribute string
+
+	GroupRoles []LDAPGroupRole
+}
+
+// OIDCProvider is an OpenID Connect provider users can log in with. Issuer is
+// the URL its discovery document is under and RedirectURL is where it sends
+// users back to with the authorization code.
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string `json:",omitempty"`
+	RedirectURL  string
+	Scopes       []string
+}
+
+// AuthConfig holds the names of the providers users can log in with in the
+// order they are tried, either local or ldap, and the OpenID Connect providers
+// users can log in with instead
+type AuthConfig struct {
+	Providers []string
+	LDAP      LDAPConfig
+	OIDC      []OIDCProvider
+
+	// RequireTwoFactor makes administrators set up two-factor
+	// authentication before they can log in with a password
+	RequireTwoFactor bool
+}
+
+// SMTPConfig is the mail server emails are sent through
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string `json:",omitempty"`
+}
+
+// InboundConfig configures receiving email. Address is where replies and new
+// tickets are sent, tickets and projects get plus addresses under it such as
+// praelatus+TEST-1@example.com. Email is read from Maildir and accepted by an
+// SMTP server on Listen, either can be empty. Secret signs the reply addresses
+// in notification emails and AuthServID is the authserv-id of the mail server
+// whose Authentication-Results headers are trusted, email which passes
+// neither check is refused.
+type InboundConfig struct {
+	Address    string
+	Maildir    string
+	Listen     string
+	Secret     string `json:",omitempty"`
+	AuthServID string
+}
+
+// MailConfig configures how emails are sent. Sender is either smtp or file,
+// which writes each email to a file in Dir instead of sending it, and no
+// emails are sent if it is empty. URL is the address of Praelatus used in
+// links in emails. DigestInterval is how often notification digests are sent
+// to users who want them, such as 1h or 30m.
+type MailConfig struct {
+	Sender         string
+	From           string
+	URL            string
+	Dir            string
+	DigestInterval string
+	SMTP           SMTPConfig
+	Inbound        InboundConfig
+}
+
+// HooksConfig configures how webhooks are sent. Timeout is how long an
+// endpoint has to respond, such as 10s, before the request is retried.
+// AllowPrivate lets hooks be sent to loopback, private and link-local
+// addresses and CaptureResponses keeps the headers and body of responses in
+// the delivery log as well as the status.
+type HooksConfig struct {
+	Timeout          string
+	AllowPrivate     bool
+	CaptureResponses bool
+}
+
+// ChatConfig configures chat slash commands. SigningSecret is the secret the
+// chat server signs slash command requests with, commands are disabled when
+// it is empty.
+type ChatConfig struct {
+	SigningSecret string
+}
+
 // Config holds much of the configuration for praelatus, if reading from the
 // configuration you should use the helper methods in this package as they do
 // some prequisite processing and return appropriate types.
@@ -32,6 +161,11 @@ type Config struct {
 	Port         string
 	LogLocations []string
 	SessionStore string
+	JWT          JWTConfig
+	Auth         AuthConfig
+	Mail         MailConfig
+	Hooks        HooksConfig
+	Chat         ChatConfig
 	AWS          AWSConfig
 }
 
@@ -68,6 +202,107 @@ func init() {
 		Cfg.SessionURL = "sessions.db"
 	}
 
+	if secret, keyFile := os.Getenv("PRAELATUS_JWT_SECRET"),
+		os.Getenv("PRAELATUS_JWT_KEY_FILE"); secret != "" || keyFile != "" {
+		alg := os.Getenv("PRAELATUS_JWT_ALGORITHM")
+		if alg == "" {
+			alg = "HS256"
+		}
+
+		Cfg.JWT = JWTConfig{
+			SigningKey: "default",
+			Keys: []JWTKey{
+				{
+					ID:             "default",
+					Algorithm:      alg,
+					Secret:         secret,
+					PrivateKeyFile: keyFile,
+				},
+			},
+		}
+	}
+
+	Cfg.Auth.Providers = strings.Split(os.Getenv("PRAELATUS_AUTH_PROVIDERS"), ";")
+	if os.Getenv("PRAELATUS_AUTH_PROVIDERS") == "" {
+		Cfg.Auth.Providers = []string{"local"}
+	}
+
+	Cfg.Auth.LDAP = LDAPConfig{
+		URL:          os.Getenv("PRAELATUS_LDAP_URL"),
+		StartTLS:     os.Getenv("PRAELATUS_LDAP_STARTTLS") == "true",
+		BindDN:       os.Getenv("PRAELATUS_LDAP_BIND_DN"),
+		BindPassword: os.Getenv("PRAELATUS_LDAP_BIND_PASSWORD"),
+		BaseDN:       os.Getenv("PRAELATUS_LDAP_BASE_DN"),
+		UserFilter:   os.Getenv("PRAELATUS_LDAP_USER_FILTER"),
+	}
+
+	Cfg.Auth.RequireTwoFactor = os.Getenv("PRAELATUS_REQUIRE_2FA") == "true"
+
+	// Multiple providers can only be configured in config.json
+	if issuer := os.Getenv("PRAELATUS_OIDC_ISSUER"); issuer != "" {
+		name := os.Getenv("PRAELATUS_OIDC_NAME")
+		if name == "" {
+			name = "oidc"
+		}
+
+		Cfg.Auth.OIDC = []OIDCProvider{
+			{
+				Name:         name,
+				Issuer:       issuer,
+				ClientID:     os.Getenv("PRAELATUS_OIDC_CLIENT_ID"),
+				ClientSecret: os.Getenv("PRAELATUS_OIDC_CLIENT_SECRET"),
+				RedirectURL:  os.Getenv("PRAELATUS_OIDC_REDIRECT_URL"),
+			},
+		}
+	}
+
+	Cfg.Mail = MailConfig{
+		Sender:         os.Getenv("PRAELATUS_MAIL_SENDER"),
+		From:           os.Getenv("PRAELATUS_MAIL_FROM"),
+		URL:            os.Getenv("PRAELATUS_URL"),
+		Dir:            os.Getenv("PRAELATUS_MAIL_DIR"),
+		DigestInterval: os.Getenv("PRAELATUS_MAIL_DIGEST_INTERVAL"),
+		SMTP: SMTPConfig{
+			Host:     os.Getenv("PRAELATUS_SMTP_HOST"),
+			Port:     os.Getenv("PRAELATUS_SMTP_PORT"),
+			Username: os.Getenv("PRAELATUS_SMTP_USERNAME"),
+			Password: os.Getenv("PRAELATUS_SMTP_PASSWORD"),
+		},
+		Inbound: InboundConfig{
+			Address:    os.Getenv("PRAELATUS_MAIL_INBOUND_ADDRESS"),
+			Maildir:    os.Getenv("PRAELATUS_MAIL_MAILDIR"),
+			Listen:     os.Getenv("PRAELATUS_MAIL_INBOUND_LISTEN"),
+			Secret:     os.Getenv("PRAELATUS_MAIL_INBOUND_SECRET"),
+			AuthServID: os.Getenv("PRAELATUS_MAIL_INBOUND_AUTHSERV_ID"),
+		},
+	}
+
+	if Cfg.Mail.From == "" {
+		Cfg.Mail.From = "praelatus@localhost"
+	}
+
+	if Cfg.Mail.URL == "" {
+		Cfg.Mail.URL = "http://localhost:8080"
+	}
+
+	if Cfg.Mail.Dir == "" {
+		Cfg.Mail.Dir = "mail"
+	}
+
+	if Cfg.Mail.DigestInterval == "" {
+		Cfg.Mail.DigestInterval = "1h"
+	}
+
+	Cfg.Hooks.Timeout = os.Getenv("PRAELATUS_HOOK_TIMEOUT")
+	if Cfg.Hooks.Timeout == "" {
+		Cfg.Hooks.Timeout = "10s"
+	}
+
+	Cfg.Hooks.AllowPrivate = os.Getenv("PRAELATUS_HOOK_ALLOW_PRIVATE") == "true"
+	Cfg.Hooks.CaptureResponses = os.Getenv("PRAELATUS_HOOK_CAPTURE_RESPONSES") == "true"
+
+	Cfg.Chat.SigningSecret = os.Getenv("PRAELATUS_CHAT_SIGNING_SECRET")
+
 	Cfg.Port = os.Getenv("PRAELATUS_PORT")
 	if Cfg.Port == "" {
 		Cfg.Port = ":" + os.Getenv("PORT")
@@ -130,11 +365,42 @@ func Port() string {
 	return Cfg.Port
 }
 
+// SessionStore will return which store to use for sessions and other cached
+// data, either bolt or memory
+func SessionStore() string {
+	return Cfg.SessionStore
+}
+
 // SessionURL will get the url to use for redis or file location for boltdb
 func SessionURL() string {
 	return Cfg.SessionURL
 }
 
+// JWT will return the configuration for signing session tokens
+func JWT() JWTConfig {
+	return Cfg.JWT
+}
+
+// Auth will return the configuration for the authentication providers
+func Auth() AuthConfig {
+	return Cfg.Auth
+}
+
+// Mail will return the configuration for sending emails
+func Mail() MailConfig {
+	return Cfg.Mail
+}
+
+// Hooks will return the configuration for sending webhooks
+func Hooks() HooksConfig {
+	return Cfg.Hooks
+}
+
+// Chat will return the configuration for chat slash commands
+func Chat() ChatConfig {
+	return Cfg.Chat
+}
+
 // WebWorkers returns the number of web workers to run for sending http
 // requests from hooks
 func WebWorkers() int {
@@ -0,0 +1,214 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/praelatus/praelatus/events/event"
+	"github.com/praelatus/praelatus/models"
+)
+
+// maxChatText is how much of a comment or description is put in a chat
+// message, the rest is behind the link to the ticket
+const maxChatText = 500
+
+// chatColors are the colors of the attachments of Slack and Mattermost
+// messages for each event type
+var chatColors = map[event.Type]string{
+	event.CreatedEvent:    "#2eb886",
+	event.UpdatedEvent:    "#daa038",
+	event.CommentEvent:    "#3aa3e3",
+	event.TransitionEvent: "#764fa5",
+}
+
+// chatMessage is an event formatted for a chat room. Summary says what
+// happened and mentions the ticket key, Title and Link are the ticket and
+// Text is the comment, transition, changes or description of a new ticket.
+type chatMessage struct {
+	Summary string
+	Key     string
+	Title   string
+	Link    string
+	Text    string
+	Project string
+	Color   string
+}
+
+func truncate(s string, n int) string {
+	r := []rune(strings.TrimSpace(s))
+	if len(r) <= n {
+		return string(r)
+	}
+
+	return string(r[:n]) + "…"
+}
+
+// changeLine describes the change, descriptions are usually too long to show
+// so only the fact they changed is
+func changeLine(c models.FieldChange) string {
+	if c.Field == "description" {
+		return "description changed"
+	}
+
+	value := func(v interface{}) string {
+		s := strings.Trim(fmt.Sprint(v), "[]")
+		if v == nil || s == "" {
+			return "none"
+		}
+
+		return s
+	}
+
+	return c.Field + ": " + value(c.From) + " → " + value(c.To)
+}
+
+func newChatMessage(e event.Event) chatMessage {
+	t := e.Ticket()
+
+	m := chatMessage{
+		Summary: e.String(),
+		Key:     t.Key,
+		Title:   t.Key + ": " + t.Summary,
+		Link:    ticketLink(t.Key),
+		Project: e.Project().Key,
+		Color:   chatColors[e.Type()],
+	}
+
+	switch data := e.Data().(type) {
+	case models.Comment:
+		m.Text = truncate(data.Body, maxChatText)
+	case models.Transition:
+		m.Text = data.ToStatus.Name
+		if data.FromStatus.Type != models.StatusNull {
+			m.Text = data.FromStatus.Name + " → " + data.ToStatus.Name
+		}
+	case []models.FieldChange:
+		lines := make([]string, len(data))
+		for i, c := range data {
+			lines[i] = changeLine(c)
+		}
+
+		m.Text = truncate(strings.Join(lines, "\n"), maxChatText)
+	default:
+		if e.Type() == event.CreatedEvent {
+			m.Text = truncate(t.Description, maxChatText)
+		}
+	}
+
+	return m
+}
+
+// slackAttachment is the attachment format shared by Slack and Mattermost
+// incoming webhooks
+type slackAttachment struct {
+	Fallback  string `json:"fallback"`
+	Color     string `json:"color,omitempty"`
+	Title     string `json:"title"`
+	TitleLink string `json:"title_link"`
+	Text      string `json:"text,omitempty"`
+	Footer    string `json:"footer,omitempty"`
+}
+
+type slackMessage struct {
+	Username    string            `json:"username,omitempty"`
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// linkKey replaces the ticket key in the summary with a link made by link,
+// the rest of the summary is escaped with escape
+func linkKey(m chatMessage, escape func(string) string, link func(text, url string) string) string {
+	parts := strings.SplitN(m.Summary, m.Key, 2)
+	if len(parts) != 2 || m.Key == "" {
+		return escape(m.Summary)
+	}
+
+	return escape(parts[0]) + link(m.Key, m.Link) + escape(parts[1])
+}
+
+func (m chatMessage) attachment(escape func(string) string) slackAttachment {
+	return slackAttachment{
+		Fallback:  m.Summary,
+		Color:     m.Color,
+		Title:     escape(m.Title),
+		TitleLink: m.Link,
+		Text:      escape(m.Text),
+		Footer:    m.Project,
+	}
+}
+
+// slack formats the message for a Slack incoming webhook, Slack only needs
+// &, < and > escaped and links are written <url|text>
+func (m chatMessage) slack() slackMessage {
+	return slackMessage{
+		Text: linkKey(m, slackEscaper.Replace, func(text, url string) string {
+			return "<" + url + "|" + text + ">"
+		}),
+		Attachments: []slackAttachment{m.attachment(slackEscaper.Replace)},
+	}
+}
+
+// mattermost formats the message for a Mattermost incoming webhook, which
+// takes the same attachments as Slack but renders markdown
+func (m chatMessage) mattermost() slackMessage {
+	noEscape := func(s string) string { return s }
+
+	return slackMessage{
+		Username: "Praelatus",
+		Text: linkKey(m, noEscape, func(text, url string) string {
+			return "[" + text + "](" + url + ")"
+		}),
+		Attachments: []slackAttachment{m.attachment(noEscape)},
+	}
+}
+
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format"`
+	FormattedBody string `json:"formatted_body"`
+}
+
+// matrix formats the message as an m.notice with a plain text body and an
+// HTML body for clients which can show it
+func (m chatMessage) matrix() matrixMessage {
+	body := m.Summary + "\n" + m.Title + " " + m.Link
+
+	formatted := linkKey(m, html.EscapeString, func(text, url string) string {
+		return `<a href="` + html.EscapeString(url) + `">` + html.EscapeString(text) + "</a>"
+	}) + "<br><strong>" + html.EscapeString(m.Title) + "</strong>"
+
+	if m.Text != "" {
+		body += "\n\n" + m.Text
+		formatted += "<br>" + strings.Replace(html.EscapeString(m.Text), "\n", "<br>", -1)
+	}
+
+	return matrixMessage{
+		MsgType:       "m.notice",
+		Body:          body,
+		Format:        "org.matrix.custom.html",
+		FormattedBody: formatted,
+	}
+}
+
+// formatEvent returns the body the event is sent to a webhook with
+func formatEvent(format string, e event.Event) ([]byte, error) {
+	switch format {
+	case models.WebhookSlack:
+		return json.Marshal(newChatMessage(e).slack())
+	case models.WebhookMattermost:
+		return json.Marshal(newChatMessage(e).mattermost())
+	case models.WebhookMatrix:
+		return json.Marshal(newChatMessage(e).matrix())
+	default:
+		return event.MarshalJSON(e)
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package events
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/praelatus/praelatus/events/event"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// chatRequest is a request received by the chat stand-in
+type chatRequest struct {
+	method, path, auth string
+	body               []byte
+}
+
+// chatServer stands in for a chat server, recording every request it gets
+func chatServer() (*httptest.Server, *[]chatRequest) {
+	var requests []chatRequest
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, chatRequest{r.Method, r.URL.Path,
+			r.Header.Get("Authorization"), b})
+		w.Write([]byte(`{"ok": true}`))
+	}))
+
+	return srv, &requests
+}
+
+var chatTicket = models.Ticket{
+	Key:         "TEST-1",
+	Project:     "TEST",
+	Summary:     "Fix <script> & styles",
+	Description: "The page is broken",
+}
+
+func chatEvents() []event.Event {
+	u := models.User{Username: "testuser"}
+	p := models.Project{Key: "TEST"}
+
+	return []event.Event{
+		event.Generic{User: u, InProject: p, ActionedTicket: chatTicket,
+			EventType: event.CreatedEvent},
+		event.Comment{User: u, InProject: p, ActionedTicket: chatTicket,
+			Comment: models.Comment{Body: "Looks good\nto me"}},
+		event.Transition{User: u, InProject: p, ActionedTicket: chatTicket,
+			Transition: models.Transition{
+				FromStatus: models.Status{Name: "Backlog"},
+				ToStatus:   models.Status{Name: "In Progress"},
+			}},
+	}
+}
+
+func TestChatMessage(t *testing.T) {
+	texts := []string{"The page is broken", "Looks good\nto me", "Backlog → In Progress"}
+
+	for i, e := range chatEvents() {
+		m := newChatMessage(e)
+
+		if m.Text != texts[i] {
+			t.Errorf("Expected %q Got %q", texts[i], m.Text)
+		}
+
+		if m.Link != "http://localhost:8080/tickets/TEST-1" || m.Title != "TEST-1: Fix <script> & styles" {
+			t.Errorf("Expected a link to the ticket Got %v", m)
+		}
+	}
+
+	updated := newChatMessage(event.Generic{
+		User:           models.User{Username: "testuser"},
+		ActionedTicket: chatTicket,
+		EventType:      event.UpdatedEvent,
+		Changes: []models.FieldChange{
+			{Field: "assignee", From: "", To: "testuser"},
+			{Field: "description", From: "Old", To: "New"},
+			{Field: "labels", From: nil, To: []string{"ui", "css"}},
+		},
+	})
+
+	if updated.Text != "assignee: none → testuser\ndescription changed\nlabels: none → ui css" {
+		t.Errorf("Expected the changes Got %q", updated.Text)
+	}
+
+	if s := truncate(strings.Repeat("a", maxChatText+10), maxChatText); len([]rune(s)) != maxChatText+1 {
+		t.Errorf("Expected the text to be truncated Got %d characters", len([]rune(s)))
+	}
+}
+
+func TestChatWebhooks(t *testing.T) {
+	defer useOutbox(t)()
+
+	srv, requests := chatServer()
+	defer srv.Close()
+
+	e := chatEvents()[1]
+
+	for _, format := range []string{models.WebhookSlack, models.WebhookMattermost} {
+		err := sendWebhook(models.Webhook{ID: bson.NewObjectId(), URL: srv.URL + "/hooks/abc",
+			Format: format, Events: []string{"COMMENT"}}, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(*requests) != 2 {
+		t.Fatalf("Expected 2 requests Got %d", len(*requests))
+	}
+
+	var slack, mattermost slackMessage
+
+	if err := json.Unmarshal((*requests)[0].body, &slack); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal((*requests)[1].body, &mattermost); err != nil {
+		t.Fatal(err)
+	}
+
+	link := "http://localhost:8080/tickets/TEST-1"
+
+	if slack.Text != "testuser commented on <"+link+"|TEST-1>" {
+		t.Errorf("Expected a Slack link Got %s", slack.Text)
+	}
+
+	if len(slack.Attachments) != 1 || slack.Attachments[0].TitleLink != link ||
+		slack.Attachments[0].Title != "TEST-1: Fix &lt;script&gt; &amp; styles" {
+		t.Errorf("Expected an escaped attachment for the ticket Got %v", slack.Attachments)
+	}
+
+	if mattermost.Text != "testuser commented on [TEST-1]("+link+")" || mattermost.Username != "Praelatus" {
+		t.Errorf("Expected a markdown link Got %v", mattermost)
+	}
+
+	if len(mattermost.Attachments) != 1 || mattermost.Attachments[0].Text != "Looks good\nto me" {
+		t.Errorf("Expected the comment in the attachment Got %v", mattermost.Attachments)
+	}
+}
+
+func TestMatrixWebhook(t *testing.T) {
+	defer useOutbox(t)()
+
+	if err := repo.Seed(repo.GlobalRepo); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, requests := chatServer()
+	defer srv.Close()
+
+	room := "/_matrix/client/r0/rooms/!room:example.com/send/m.room.message"
+
+	w, err := repo.Webhooks().Create(outboxUser, models.Webhook{
+		Project: "TEST",
+		URL:     srv.URL + room,
+		Events:  []string{"TRANSITION"},
+		Format:  models.WebhookMatrix,
+		Secret:  "access-token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = sendWebhook(w, chatEvents()[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	ds, err := repo.Deliveries().ForWebhook(outboxUser, w.ID.Hex(), 10)
+	if err != nil || len(ds) != 1 {
+		t.Fatalf("Expected 1 delivery Got %v %v", ds, err)
+	}
+
+	r := (*requests)[0]
+	if r.method != "PUT" || r.path != room+"/"+ds[0].ID.Hex() {
+		t.Errorf("Expected a PUT with the delivery as the transaction Got %s %s", r.method, r.path)
+	}
+
+	if r.auth != "Bearer access-token" {
+		t.Errorf("Expected the access token Got %q", r.auth)
+	}
+
+	var m matrixMessage
+	if err = json.Unmarshal(r.body, &m); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.MsgType != "m.notice" || !strings.Contains(m.Body, "Backlog → In Progress") ||
+		!strings.Contains(m.FormattedBody, `<a href="http://localhost:8080/tickets/TEST-1">TEST-1</a>`) ||
+		!strings.Contains(m.FormattedBody, "Fix &lt;script&gt; &amp; styles") {
+		t.Errorf("Expected a formatted notice Got %v", m)
+	}
+
+	for _, d := range ds {
+		if _, ok := d.Request.Headers["Authorization"]; ok {
+			t.Errorf("Expected the access token not to be recorded Got %v", d.Request.Headers)
+		}
+
+		if _, signed := d.Request.Headers[SignatureHeader]; signed {
+			t.Errorf("Expected Matrix requests not to be signed Got %v", d.Request.Headers)
+		}
+	}
+
+	if _, err = Redeliver(ds[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*requests) != 2 || (*requests)[1].auth != "Bearer access-token" {
+		t.Errorf("Expected the redelivery to use the access token Got %v", *requests)
+	}
+}
@@ -0,0 +1,375 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package events
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/praelatus/praelatus/events/event"
+	"github.com/praelatus/praelatus/mail"
+	"github.com/praelatus/praelatus/models"
+)
+
+// Mail sends notification emails to ticket watchers, none are sent when it is
+// nil
+var Mail mail.Sender
+
+// URL is the address of Praelatus used in links in notification emails
+var URL = "http://localhost:8080"
+
+// ReplyAddress is the inbound address replies to notification emails are sent
+// to, each ticket gets its own plus address under it. Replies go to the sender
+// when it is empty.
+var ReplyAddress string
+
+// ReplySecret signs the reply addresses so that replies can be trusted to come
+// from the user the email was sent to
+var ReplySecret string
+
+// DigestInterval is how often digests are sent to users who have asked for
+// their notifications to be batched
+var DigestInterval = time.Hour
+
+var (
+	sendEmailChan     = make(chan mail.Message, 100)
+	emailWorkers      = 5
+	emailRetries      = 3
+	emailRetryDelay   = time.Second
+	emailQueueTimeout = time.Second
+	emailDrainTimeout = 30 * time.Second
+)
+
+// pendingDigest holds the notifications waiting to be sent to a user in their
+// next digest
+type pendingDigest struct {
+	user          models.User
+	notifications []models.Notification
+}
+
+// digests are kept in memory, any waiting to be sent are flushed when the
+// event manager shuts down
+var digests = struct {
+	sync.Mutex
+	pending map[string]*pendingDigest
+}{pending: make(map[string]*pendingDigest)}
+
+// emailData is what the notification email templates are rendered with
+type emailData struct {
+	User         models.User
+	Ticket       models.Ticket
+	Notification models.Notification
+	Detail       string
+	Link         string
+}
+
+// digestData is what the digest email templates are rendered with
+type digestData struct {
+	User    models.User
+	Entries []emailData
+}
+
+var notificationText = template.Must(template.New("notification-text").Parse(
+	`{{.Notification.Body}}
+{{if .Detail}}
+{{.Detail}}
+{{end}}
+{{.Ticket.Key}}: {{.Ticket.Summary}}
+{{.Link}}
+
+You're receiving this because you're watching {{.Ticket.Key}}. You can change
+which emails you get in your settings.
+`))
+
+var notificationHTML = htmltemplate.Must(htmltemplate.New("notification-html").Parse(
+	`<p>{{.Notification.Body}}</p>
+{{if .Detail}}<blockquote style="white-space: pre-wrap">{{.Detail}}</blockquote>
+{{end}}<p><a href="{{.Link}}">{{.Ticket.Key}}: {{.Ticket.Summary}}</a></p>
+<p style="color: #777">You're receiving this because you're watching
+{{.Ticket.Key}}. You can change which emails you get in your settings.</p>
+`))
+
+var digestText = template.Must(template.New("digest-text").Parse(
+	`Here's what happened on the tickets you're watching:
+{{range .Entries}}
+* {{.Notification.Body}}
+  {{.Link}}
+{{end}}
+You can change which emails you get in your settings.
+`))
+
+var digestHTML = htmltemplate.Must(htmltemplate.New("digest-html").Parse(
+	`<p>Here's what happened on the tickets you're watching:</p>
+<ul>
+{{range .Entries}}<li><a href="{{.Link}}">{{.Notification.ActionedTicket}}</a>: {{.Notification.Body}}</li>
+{{end}}</ul>
+<p style="color: #777">You can change which emails you get in your settings.</p>
+`))
+
+// executer is implemented by both text and html templates
+type executer interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+func render(tmpl executer, data interface{}) (string, error) {
+	var b bytes.Buffer
+
+	err := tmpl.Execute(&b, data)
+	if err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+func ticketLink(key string) string {
+	return strings.TrimSuffix(URL, "/") + "/tickets/" + key
+}
+
+// renderNotification will return the email for a single notification
+func renderNotification(user models.User, n models.Notification, e event.Event) (mail.Message, error) {
+	data := emailData{
+		User:         user,
+		Ticket:       e.Ticket(),
+		Notification: n,
+		Link:         ticketLink(n.ActionedTicket),
+	}
+
+	if c, ok := e.Data().(models.Comment); ok {
+		data.Detail = c.Body
+	}
+
+	text, err := render(notificationText, data)
+	if err != nil {
+		return mail.Message{}, err
+	}
+
+	html, err := render(notificationHTML, data)
+	if err != nil {
+		return mail.Message{}, err
+	}
+
+	var replyTo string
+	if ReplyAddress != "" {
+		tag := data.Ticket.Key
+		if ReplySecret != "" {
+			tag += "." + mail.ReplyToken(ReplySecret, user.Username, tag)
+		}
+
+		replyTo = mail.PlusAddress(ReplyAddress, tag)
+	}
+
+	return mail.Message{
+		To:      []string{user.Email},
+		ReplyTo: replyTo,
+		Subject: fmt.Sprintf("[%s] %s", data.Ticket.Key, data.Ticket.Summary),
+		Body:    text,
+		HTML:    html,
+	}, nil
+}
+
+// renderDigest will return the email for a batch of notifications
+func renderDigest(d pendingDigest) (mail.Message, error) {
+	data := digestData{User: d.user}
+
+	for _, n := range d.notifications {
+		data.Entries = append(data.Entries, emailData{
+			User:         d.user,
+			Notification: n,
+			Link:         ticketLink(n.ActionedTicket),
+		})
+	}
+
+	text, err := render(digestText, data)
+	if err != nil {
+		return mail.Message{}, err
+	}
+
+	html, err := render(digestHTML, data)
+	if err != nil {
+		return mail.Message{}, err
+	}
+
+	subject := fmt.Sprintf("%d updates to tickets you're watching", len(d.notifications))
+	if len(d.notifications) == 1 {
+		subject = "1 update to a ticket you're watching"
+	}
+
+	return mail.Message{
+		To:      []string{d.user.Email},
+		Subject: subject,
+		Body:    text,
+		HTML:    html,
+	}, nil
+}
+
+// emailWatcher will email the notification to the watcher, or add it to their
+// next digest, if their settings say they want it
+func emailWatcher(user models.User, n models.Notification, e event.Event) error {
+	settings := user.Settings.Email
+	if user.Email == "" || !settings.Wants(string(e.Type())) {
+		return nil
+	}
+
+	if settings.Digest {
+		digests.Lock()
+		defer digests.Unlock()
+
+		d, ok := digests.pending[user.Username]
+		if !ok {
+			d = &pendingDigest{}
+			digests.pending[user.Username] = d
+		}
+
+		d.user = user
+		d.notifications = append(d.notifications, n)
+		return nil
+	}
+
+	m, err := renderNotification(user, n, e)
+	if err != nil {
+		return err
+	}
+
+	return queueEmail(m)
+}
+
+// queueEmail will queue the message for the email workers. It gives up if the
+// queue stays full so that a slow mail server can't hold up the dispatcher.
+func queueEmail(m mail.Message) error {
+	timeout := time.NewTimer(emailQueueTimeout)
+	defer timeout.Stop()
+
+	select {
+	case sendEmailChan <- m:
+		return nil
+	case <-timeout.C:
+		return fmt.Errorf("email queue is full, dropping %q to %v", m.Subject, m.To)
+	}
+}
+
+// flushDigests will queue a digest email for every user with notifications
+// waiting
+func flushDigests() {
+	digests.Lock()
+	pending := digests.pending
+	digests.pending = make(map[string]*pendingDigest)
+	digests.Unlock()
+
+	usernames := make([]string, 0, len(pending))
+	for username := range pending {
+		usernames = append(usernames, username)
+	}
+
+	sort.Strings(usernames)
+
+	for _, username := range usernames {
+		m, err := renderDigest(*pending[username])
+		if err != nil {
+			eventLog.Println("|Digest|", username, err)
+			continue
+		}
+
+		sendEmailChan <- m
+	}
+}
+
+// sendDigests will send the digests every DigestInterval until the context
+// is cancelled, then send any which are still waiting
+func sendDigests(ctx context.Context) {
+	ticker := time.NewTicker(DigestInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			flushDigests()
+		case <-ctx.Done():
+			flushDigests()
+			return
+		}
+	}
+}
+
+// sendEmail will try to send the message retrying with an increasing delay
+// if it fails. It stops retrying when the next attempt would be after the
+// deadline, a zero deadline has no limit.
+func sendEmail(m mail.Message, deadline time.Time) error {
+	var err error
+
+	delay := emailRetryDelay
+	attempts := 0
+
+	for attempts <= emailRetries {
+		if attempts > 0 {
+			if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+				break
+			}
+
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		attempts++
+
+		err = Mail.Send(m)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("giving up sending %q to %v after %d attempts: %s",
+		m.Subject, m.To, attempts, err.Error())
+}
+
+// sendEmailWorker will send the messages from in until the context is
+// cancelled, then send any which are still queued for up to
+// emailDrainTimeout
+func sendEmailWorker(ctx context.Context, in chan mail.Message) {
+	for {
+		var m mail.Message
+
+		select {
+		case m = <-in:
+		case <-ctx.Done():
+			drainEmails(in, time.Now().Add(emailDrainTimeout))
+			return
+		}
+
+		err := sendEmail(m, time.Time{})
+		if err != nil {
+			eventLog.Println("|Email Worker|", err)
+		}
+	}
+}
+
+// drainEmails will send the messages still queued on in, any left when the
+// deadline passes are dropped so a down mail server can't hold up shutdown
+func drainEmails(in chan mail.Message, deadline time.Time) {
+	for {
+		if time.Now().After(deadline) {
+			eventLog.Println("|Email Worker| shutdown deadline passed, dropping",
+				len(in), "queued emails")
+			return
+		}
+
+		select {
+		case m := <-in:
+			if err := sendEmail(m, deadline); err != nil {
+				eventLog.Println("|Email Worker|", err)
+			}
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package events
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/praelatus/praelatus/events/event"
+	"github.com/praelatus/praelatus/mail"
+	"github.com/praelatus/praelatus/models"
+)
+
+// flakySender fails until it has been called failures times
+type flakySender struct {
+	failures int
+	calls    int
+}
+
+func (fs *flakySender) Send(m mail.Message) error {
+	fs.calls++
+	if fs.calls <= fs.failures {
+		return errors.New("connection refused")
+	}
+
+	return nil
+}
+
+func queued() []mail.Message {
+	var msgs []mail.Message
+
+	for {
+		select {
+		case m := <-sendEmailChan:
+			msgs = append(msgs, m)
+		default:
+			return msgs
+		}
+	}
+}
+
+func commentEvent() event.Event {
+	return event.Comment{
+		User:      models.User{Username: "commenter"},
+		InProject: models.Project{Key: "TEST"},
+		ActionedTicket: models.Ticket{
+			Key:     "TEST-1",
+			Summary: "Something is <broken>",
+		},
+		Comment: models.Comment{Body: "I can reproduce this"},
+	}
+}
+
+func notificationFor(e event.Event) models.Notification {
+	return models.Notification{
+		Type:           string(e.Type()),
+		ActionedTicket: e.Ticket().Key,
+		ActioningUser:  e.ActioningUser().Username,
+		Body:           e.String(),
+	}
+}
+
+func TestEmailWatcher(t *testing.T) {
+	e := commentEvent()
+	n := notificationFor(e)
+
+	watcher := models.User{Username: "watcher", Email: "watcher@example.com"}
+
+	ReplyAddress, ReplySecret = "praelatus@example.com", "secret"
+	defer func() { ReplyAddress, ReplySecret = "", "" }()
+
+	err := emailWatcher(watcher, n, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := queued()
+	if len(msgs) != 1 {
+		t.Fatalf("Expected 1 email Got %d", len(msgs))
+	}
+
+	m := msgs[0]
+
+	if m.To[0] != "watcher@example.com" || m.Subject != "[TEST-1] Something is <broken>" {
+		t.Errorf("Unexpected email %v %s", m.To, m.Subject)
+	}
+
+	token := mail.ReplyToken("secret", "watcher", "TEST-1")
+	if m.ReplyTo != "praelatus+TEST-1."+token+"@example.com" {
+		t.Errorf("Expected replies to go to the watcher's ticket address Got %s", m.ReplyTo)
+	}
+
+	for _, expected := range []string{"commenter commented on TEST-1", "I can reproduce this",
+		"http://localhost:8080/tickets/TEST-1"} {
+		if !strings.Contains(m.Body, expected) || !strings.Contains(m.HTML, expected) {
+			t.Errorf("Expected both versions to contain %q\n%s\n%s", expected, m.Body, m.HTML)
+		}
+	}
+
+	if !strings.Contains(m.HTML, "Something is &lt;broken&gt;") {
+		t.Errorf("Expected the summary to be escaped in\n%s", m.HTML)
+	}
+
+	for _, settings := range []models.EmailSettings{
+		{Disabled: true},
+		{Events: []string{string(event.TransitionEvent)}},
+	} {
+		watcher.Settings.Email = settings
+
+		if err = emailWatcher(watcher, n, e); err != nil {
+			t.Fatal(err)
+		}
+
+		if msgs = queued(); len(msgs) != 0 {
+			t.Errorf("Expected no email with settings %v Got %v", settings, msgs)
+		}
+	}
+
+	watcher.Settings.Email = models.EmailSettings{Events: []string{"comment"}}
+
+	if err = emailWatcher(watcher, n, e); err != nil {
+		t.Fatal(err)
+	}
+
+	if msgs = queued(); len(msgs) != 1 {
+		t.Errorf("Expected comments to be emailed Got %d emails", len(msgs))
+	}
+}
+
+func TestDigest(t *testing.T) {
+	e := commentEvent()
+	n := notificationFor(e)
+
+	watcher := models.User{
+		Username: "digester",
+		Email:    "digester@example.com",
+		Settings: models.Settings{Email: models.EmailSettings{Digest: true}},
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := emailWatcher(watcher, n, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if msgs := queued(); len(msgs) != 0 {
+		t.Fatalf("Expected nothing to be sent until the digest Got %v", msgs)
+	}
+
+	flushDigests()
+
+	msgs := queued()
+	if len(msgs) != 1 {
+		t.Fatalf("Expected 1 digest Got %d", len(msgs))
+	}
+
+	if msgs[0].Subject != "2 updates to tickets you're watching" ||
+		strings.Count(msgs[0].Body, "commenter commented on TEST-1") != 2 {
+		t.Errorf("Unexpected digest %s\n%s", msgs[0].Subject, msgs[0].Body)
+	}
+
+	flushDigests()
+
+	if msgs = queued(); len(msgs) != 0 {
+		t.Errorf("Expected digests to be emptied once sent Got %v", msgs)
+	}
+}
+
+func TestDigestsSentOnShutdown(t *testing.T) {
+	e := commentEvent()
+
+	watcher := models.User{
+		Username: "digester",
+		Email:    "digester@example.com",
+		Settings: models.Settings{Email: models.EmailSettings{Digest: true}},
+	}
+
+	if err := emailWatcher(watcher, notificationFor(e), e); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	sendDigests(ctx)
+
+	if msgs := queued(); len(msgs) != 1 {
+		t.Errorf("Expected the waiting digest to be sent Got %d", len(msgs))
+	}
+
+	// The workers send what is still queued before they stop
+	sent := &flakySender{}
+	Mail = sent
+	defer func() { Mail = nil }()
+
+	sendEmailChan <- mail.Message{}
+	sendEmailWorker(ctx, sendEmailChan)
+
+	if sent.calls != 1 || len(queued()) != 0 {
+		t.Errorf("Expected the queued email to be sent Got %d sends", sent.calls)
+	}
+}
+
+func TestEmailQueueFull(t *testing.T) {
+	timeout := emailQueueTimeout
+	emailQueueTimeout = time.Millisecond
+	defer func() { emailQueueTimeout = timeout }()
+
+	for i := 0; i < cap(sendEmailChan); i++ {
+		sendEmailChan <- mail.Message{}
+	}
+
+	defer queued()
+
+	e := commentEvent()
+	watcher := models.User{Username: "watcher", Email: "watcher@example.com"}
+
+	if err := emailWatcher(watcher, notificationFor(e), e); err == nil {
+		t.Error("Expected a full queue not to block")
+	}
+}
+
+func TestSendEmailRetries(t *testing.T) {
+	delay := emailRetryDelay
+	emailRetryDelay = time.Millisecond
+
+	defer func() {
+		Mail = nil
+		emailRetryDelay = delay
+	}()
+
+	flaky := &flakySender{failures: emailRetries}
+	Mail = flaky
+
+	if err := sendEmail(mail.Message{}, time.Time{}); err != nil || flaky.calls != emailRetries+1 {
+		t.Errorf("Expected to succeed on the last attempt Got %v after %d", err, flaky.calls)
+	}
+
+	broken := &flakySender{failures: emailRetries + 1}
+	Mail = broken
+
+	if err := sendEmail(mail.Message{}, time.Time{}); err == nil || broken.calls != emailRetries+1 {
+		t.Errorf("Expected to give up Got %v after %d", err, broken.calls)
+	}
+}
+
+func TestSendEmailDeadline(t *testing.T) {
+	defer func() { Mail = nil }()
+
+	broken := &flakySender{failures: emailRetries + 1}
+	Mail = broken
+
+	// No time to retry before the deadline
+	if err := sendEmail(mail.Message{}, time.Now()); err == nil || broken.calls != 1 {
+		t.Errorf("Expected to give up at the deadline Got %v after %d", err, broken.calls)
+	}
+
+	sent := &flakySender{}
+	Mail = sent
+
+	sendEmailChan <- mail.Message{}
+	drainEmails(sendEmailChan, time.Now().Add(-time.Second))
+
+	if sent.calls != 0 || len(queued()) != 1 {
+		t.Errorf("Expected queued emails not to be sent after the deadline Got %d sends", sent.calls)
+	}
+}
@@ -13,8 +13,13 @@ type Type string
 const (
 	TransitionEvent Type = "TRANSITION"
 	CommentEvent         = "COMMENT"
+	CreatedEvent    Type = "CREATED"
+	UpdatedEvent    Type = "UPDATED"
 )
 
+// Types is every type of event
+var Types = []Type{CreatedEvent, UpdatedEvent, CommentEvent, TransitionEvent}
+
 // Event represents an event happening on a given ticket, Data contains
 // additional data about the event for example if it is a transition event then
 // the transition will be in Data, if it is a comment added event then Data
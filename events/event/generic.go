@@ -11,12 +11,13 @@ import (
 )
 
 // Generic is an event used when there needs to be a notification recorded but
-// nothing else.
+// nothing else. Changes are the fields which changed when a ticket is updated.
 type Generic struct {
 	User           models.User
 	InProject      models.Project
 	ActionedTicket models.Ticket
 	EventType      Type
+	Changes        []models.FieldChange
 }
 
 // ActioningUser will return the user who performed the transition
@@ -28,8 +29,14 @@ func (ge Generic) Project() models.Project { return ge.InProject }
 // Ticket will return the ticket being transitioned
 func (ge Generic) Ticket() models.Ticket { return ge.ActionedTicket }
 
-// Data returns nothing for a Generic event
-func (ge Generic) Data() interface{} { return nil }
+// Data returns the changed fields if there are any, otherwise nothing
+func (ge Generic) Data() interface{} {
+	if len(ge.Changes) == 0 {
+		return nil
+	}
+
+	return ge.Changes
+}
 
 // Type will return the appropriate event type
 func (ge Generic) Type() Type { return ge.EventType }
@@ -0,0 +1,47 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package event
+
+import (
+	"encoding/json"
+
+	"github.com/praelatus/praelatus/models"
+)
+
+// Payload is how an event is represented when it is sent to clients
+type Payload struct {
+	Type          Type          `json:"type"`
+	Description   string        `json:"description"`
+	ActioningUser interface{}   `json:"actioningUser"`
+	Project       string        `json:"project"`
+	Ticket        models.Ticket `json:"ticket"`
+	Data          interface{}   `json:"data,omitempty"`
+}
+
+// NewPayload returns the payload for the event, the actioning user is
+// sanitized and the hooks of transitions are left out since they can contain
+// secrets
+func NewPayload(e Event) Payload {
+	data := e.Data()
+
+	if t, ok := data.(models.Transition); ok {
+		t.Hooks = nil
+		data = t
+	}
+
+	return Payload{
+		Type:          e.Type(),
+		Description:   e.String(),
+		ActioningUser: e.ActioningUser().Sanitize(),
+		Project:       e.Project().Key,
+		Ticket:        e.Ticket(),
+		Data:          data,
+	}
+}
+
+// MarshalJSON returns the event's payload as JSON
+func MarshalJSON(e Event) ([]byte, error) {
+	return json.Marshal(NewPayload(e))
+}
@@ -0,0 +1,37 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package event
+
+import "github.com/praelatus/praelatus/models"
+
+// FromOutbox returns the event stored in the outbox
+func FromOutbox(o models.OutboxEvent) Event {
+	project := models.Project{Key: o.Project}
+
+	switch {
+	case o.Type == models.CommentEvent && o.Comment != nil:
+		return Comment{
+			User:           o.ActioningUser,
+			InProject:      project,
+			ActionedTicket: o.Ticket,
+			Comment:        *o.Comment,
+		}
+	case o.Type == models.TransitionEvent && o.Transition != nil:
+		return Transition{
+			User:           o.ActioningUser,
+			InProject:      project,
+			ActionedTicket: o.Ticket,
+			Transition:     *o.Transition,
+		}
+	default:
+		return Generic{
+			User:           o.ActioningUser,
+			InProject:      project,
+			ActionedTicket: o.Ticket,
+			EventType:      Type(o.Type),
+			Changes:        o.Changes,
+		}
+	}
+}
@@ -8,72 +8,333 @@
 package events
 
 import (
+	"context"
+	"expvar"
 	"log"
+	"sync"
 
 	"github.com/praelatus/praelatus/config"
 	"github.com/praelatus/praelatus/events/event"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
 )
 
-// TODO it's possible that one go routine taking a long time (such as the hook
-// event manager thread) can lock up the whole event system, need to investigate
-// the cost of spinning up new threads and channels to determine what the best
-// way to go is.
-
 var eventLog = log.New(config.LogWriter(), "[EVENT] ", log.LstdFlags)
 
-// Stop is a global channel used to stop the running event managers
-var Stop = make(chan int)
+// QueueSize is how many events each of the built in subscribers can have
+// waiting before their policy applies
+var QueueSize = 100
+
+// Policy decides what happens to an event fired while a subscriber's queue
+// is full
+type Policy int
+
+const (
+	// DropNewest drops the event being fired
+	DropNewest Policy = iota
+	// DropOldest drops the oldest event in the queue to make room
+	DropOldest
+	// Overflow keeps events which don't fit in the queue in a backlog, it
+	// never drops events but the backlog can grow without limit
+	Overflow
+)
+
+func (p Policy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop newest"
+	case DropOldest:
+		return "drop oldest"
+	case Overflow:
+		return "overflow"
+	default:
+		return "unknown"
+	}
+}
+
+// Subscriber receives the events fired on the EventManager it subscribed to
+type Subscriber struct {
+	Name   string
+	Policy Policy
+
+	queue chan event.Event
+	done  chan struct{}
+
+	mu        sync.Mutex
+	backlog   []event.Event
+	delivered uint64
+	dropped   uint64
+}
+
+// Events returns the subscriber's queue
+func (s *Subscriber) Events() <-chan event.Event {
+	return s.queue
+}
+
+// Done is closed when the subscriber is unsubscribed
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+// deliver will queue the event according to the subscriber's policy, it
+// never blocks
+func (s *Subscriber) deliver(e event.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Events can't jump ahead of the backlog
+	if len(s.backlog) == 0 {
+		select {
+		case s.queue <- e:
+			s.delivered++
+			return
+		default:
+		}
+	}
+
+	switch s.Policy {
+	case DropNewest:
+		s.dropped++
+	case DropOldest:
+		select {
+		case <-s.queue:
+			s.dropped++
+		default:
+		}
 
-// EventManager manages registered event listeners and dispatching those events
+		select {
+		case s.queue <- e:
+			s.delivered++
+		default:
+			s.dropped++
+		}
+	case Overflow:
+		s.backlog = append(s.backlog, e)
+		if len(s.backlog) == 1 {
+			go s.drain()
+		}
+	}
+}
+
+// drain moves the backlog into the queue as there is room
+func (s *Subscriber) drain() {
+	for {
+		s.mu.Lock()
+		if len(s.backlog) == 0 {
+			s.mu.Unlock()
+			return
+		}
+
+		e := s.backlog[0]
+		s.mu.Unlock()
+
+		select {
+		case s.queue <- e:
+		case <-s.done:
+			return
+		}
+
+		s.mu.Lock()
+		s.backlog[0] = nil
+		s.backlog = s.backlog[1:]
+		s.delivered++
+		s.mu.Unlock()
+	}
+}
+
+// SubscriberStats are the metrics for a subscriber's queue
+type SubscriberStats struct {
+	Name      string `json:"name"`
+	Policy    string `json:"policy"`
+	Queued    int    `json:"queued"`
+	Capacity  int    `json:"capacity"`
+	Backlog   int    `json:"backlog"`
+	Delivered uint64 `json:"delivered"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+// Stats returns the subscriber's metrics
+func (s *Subscriber) Stats() SubscriberStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return SubscriberStats{
+		Name:      s.Name,
+		Policy:    s.Policy.String(),
+		Queued:    len(s.queue),
+		Capacity:  cap(s.queue),
+		Backlog:   len(s.backlog),
+		Delivered: s.delivered,
+		Dropped:   s.dropped,
+	}
+}
+
+// EventManager dispatches fired events to its subscribers
 type EventManager struct {
-	Listeners []chan event.Event
+	mu          sync.RWMutex
+	subscribers []*Subscriber
+}
+
+// NewEventManager returns an EventManager with no subscribers
+func NewEventManager() *EventManager {
+	return &EventManager{}
 }
 
 // evm is the global event manager which is interfaced with using the functions
 // whose names match the methods of an EventManager
-var evm = EventManager{
-	Listeners: []chan event.Event{
-		hookEventChan,
-		notificationsEventChan,
-	},
+var evm = NewEventManager()
+
+func init() {
+	expvar.Publish("events", expvar.Func(func() interface{} {
+		return Stats()
+	}))
 }
 
-// Run starts the global event manager, should be called in a go routine normally
-func Run() {
-	go handleHookEvent()
-	go recordNofiticationEvent()
+// Subscribe will add a subscriber with a queue of the given size to the
+// EventManager, it receives every event fired until it is unsubscribed
+func (em *EventManager) Subscribe(name string, size int, policy Policy) *Subscriber {
+	s := &Subscriber{
+		Name:   name,
+		Policy: policy,
+		queue:  make(chan event.Event, size),
+		done:   make(chan struct{}),
+	}
 
-	for {
-		_ = <-Stop
-		eventLog.Println("Event Manager Shutting Down")
-		return
+	em.mu.Lock()
+	em.subscribers = append(em.subscribers, s)
+	em.mu.Unlock()
+
+	return s
+}
+
+// Unsubscribe will stop the subscriber receiving events and close its Done
+// channel
+func (em *EventManager) Unsubscribe(s *Subscriber) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	for i, sub := range em.subscribers {
+		if sub == s {
+			em.subscribers = append(em.subscribers[:i], em.subscribers[i+1:]...)
+			close(s.done)
+			return
+		}
 	}
 }
 
-// FireEvent calls the method of the same name on the global EventManager
-func (em EventManager) FireEvent(e event.Event) {
+// FireEvent sends the given event to all subscribers, it doesn't wait for
+// them to handle it
+func (em *EventManager) FireEvent(e event.Event) {
 	eventLog.Println("fired", e.Type(), "for", e.Ticket().Key)
-	// FireEvent sends the given event to all registered listeners
-	for _, listener := range em.Listeners {
-		listener <- e
-		eventLog.Println("sent")
+
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+
+	for _, s := range em.subscribers {
+		s.deliver(e)
 	}
+}
+
+// Stats returns the metrics for each subscriber
+func (em *EventManager) Stats() []SubscriberStats {
+	em.mu.RLock()
+	defer em.mu.RUnlock()
 
-	// Return to guarantee that this is killed if called in a goroutine
-	return
+	stats := make([]SubscriberStats, len(em.subscribers))
+	for i, s := range em.subscribers {
+		stats[i] = s.Stats()
+	}
+
+	return stats
 }
 
-// RegisterListener adds a listener to the EventManager
-func (em EventManager) RegisterListener(ev chan event.Event) {
-	em.Listeners = append(em.Listeners, ev)
+// Run dispatches events from the outbox and subscribes the built in event
+// handlers to the global event manager, it runs them until the context is
+// cancelled. It returns once the waiting digests and queued emails are sent.
+func Run(ctx context.Context) {
+	handlers := []struct {
+		name   string
+		policy Policy
+		handle func(context.Context, *Subscriber)
+	}{
+		{"websockets", DropOldest, handleWsEvents},
+		{"sse", DropOldest, handleSSEEvents},
+	}
+
+	for _, h := range handlers {
+		sub := Subscribe(h.name, QueueSize, h.policy)
+		defer Unsubscribe(sub)
+
+		go h.handle(ctx, sub)
+	}
+
+	// The email workers are stopped after the digests are flushed so that
+	// the digests are sent before Run returns
+	workers, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < emailWorkers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			sendEmailWorker(workers, sendEmailChan)
+		}()
+	}
+
+	digestsSent := make(chan struct{})
+
+	go func() {
+		defer close(digestsSent)
+		sendDigests(ctx)
+	}()
+
+	// The dispatcher uses the repo so it has to stop before Run returns
+	dispatched := make(chan struct{})
+
+	go func() {
+		defer close(dispatched)
+		dispatchOutbox(ctx)
+	}()
+
+	<-ctx.Done()
+	eventLog.Println("Event Manager Shutting Down")
+
+	<-dispatched
+	<-digestsSent
+	stopWorkers()
+	wg.Wait()
+}
+
+// Subscribe calls the method of the same name on the global EventManager
+func Subscribe(name string, size int, policy Policy) *Subscriber {
+	return evm.Subscribe(name, size, policy)
 }
 
-// RegisterListener calls the method of the same name on the global EventManager
-func RegisterListener(ev chan event.Event) {
-	evm.RegisterListener(ev)
+// Unsubscribe calls the method of the same name on the global EventManager
+func Unsubscribe(s *Subscriber) {
+	evm.Unsubscribe(s)
 }
 
 // FireEvent calls the method of the same name on the global EventManager
 func FireEvent(ev event.Event) {
 	evm.FireEvent(ev)
 }
+
+// Stats calls the method of the same name on the global EventManager
+func Stats() []SubscriberStats {
+	return evm.Stats()
+}
+
+// canView returns true if the user has permission to view the project the
+// event happened in
+func canView(user models.User, e event.Event) bool {
+	if repo.GlobalRepo == nil {
+		return false
+	}
+
+	_, err := repo.Projects().Get(&user, e.Project().Key)
+	return err == nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package events
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/praelatus/praelatus/events/event"
+	"github.com/praelatus/praelatus/repo"
+)
+
+func keys(t *testing.T, s *Subscriber, n int) []string {
+	var received []string
+
+	for i := 0; i < n; i++ {
+		select {
+		case e := <-s.Events():
+			received = append(received, e.Ticket().Key)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %d events Got %v", n, received)
+		}
+	}
+
+	return received
+}
+
+func fireN(em *EventManager, n int) {
+	for i := 1; i <= n; i++ {
+		em.FireEvent(ticketEvent(fmt.Sprintf("TEST-%d", i)))
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	em := NewEventManager()
+	a := em.Subscribe("a", 10, DropNewest)
+	b := em.Subscribe("b", 10, DropNewest)
+
+	fireN(em, 1)
+
+	if k := keys(t, a, 1); k[0] != "TEST-1" {
+		t.Errorf("Expected TEST-1 Got %s", k[0])
+	}
+
+	if k := keys(t, b, 1); k[0] != "TEST-1" {
+		t.Errorf("Expected TEST-1 Got %s", k[0])
+	}
+
+	em.Unsubscribe(a)
+
+	select {
+	case <-a.Done():
+	default:
+		t.Error("Expected Done to be closed")
+	}
+
+	fireN(em, 1)
+
+	if len(a.Events()) != 0 || len(b.Events()) != 1 {
+		t.Errorf("Expected only b to receive the event Got a=%d b=%d",
+			len(a.Events()), len(b.Events()))
+	}
+
+	if stats := em.Stats(); len(stats) != 1 || stats[0].Name != "b" {
+		t.Errorf("Expected stats for b Got %v", stats)
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	em := NewEventManager()
+	newest := em.Subscribe("newest", 2, DropNewest)
+	oldest := em.Subscribe("oldest", 2, DropOldest)
+	overflow := em.Subscribe("overflow", 2, Overflow)
+
+	fireN(em, 5)
+
+	stats := em.Stats()
+
+	if stats[0].Dropped != 3 || stats[0].Queued != 2 {
+		t.Errorf("Expected 3 dropped and 2 queued Got %+v", stats[0])
+	}
+
+	if stats[1].Dropped != 3 || stats[1].Queued != 2 {
+		t.Errorf("Expected 3 dropped and 2 queued Got %+v", stats[1])
+	}
+
+	if stats[2].Dropped != 0 {
+		t.Errorf("Expected nothing dropped Got %+v", stats[2])
+	}
+
+	if k := keys(t, newest, 2); k[0] != "TEST-1" || k[1] != "TEST-2" {
+		t.Errorf("Expected the first events Got %v", k)
+	}
+
+	if k := keys(t, oldest, 2); k[0] != "TEST-4" || k[1] != "TEST-5" {
+		t.Errorf("Expected the last events Got %v", k)
+	}
+
+	k := keys(t, overflow, 5)
+	for i, key := range k {
+		if key != fmt.Sprintf("TEST-%d", i+1) {
+			t.Errorf("Expected every event in order Got %v", k)
+			break
+		}
+	}
+
+	if stats := overflow.Stats(); stats.Backlog != 0 || stats.Delivered != 5 {
+		t.Errorf("Expected the backlog to be drained Got %+v", stats)
+	}
+}
+
+func TestRunShutdown(t *testing.T) {
+	repo.GlobalRepo = repo.NewMockRepo()
+	defer func() { repo.GlobalRepo = nil }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
+	go func() {
+		Run(ctx)
+		close(stopped)
+	}()
+
+	// Wait for the handlers to subscribe
+	for i := 0; len(Stats()) != 2 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(Stats()) != 2 {
+		t.Errorf("Expected 2 subscribers Got %v", Stats())
+	}
+
+	cancel()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Run to return")
+	}
+
+	if len(Stats()) != 0 {
+		t.Errorf("Expected no subscribers Got %v", Stats())
+	}
+
+	// Firing with nobody subscribed must not block
+	FireEvent(event.Comment{})
+}
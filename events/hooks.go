@@ -6,66 +6,107 @@ package events
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"strings"
+	"syscall"
 	"text/template"
+	"time"
 
 	"github.com/praelatus/praelatus/config"
-	"github.com/praelatus/praelatus/events/event"
 	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"gopkg.in/mgo.v2/bson"
 )
 
-var (
-	hookEventChan = make(chan event.Event)
-	webWorkers    = config.WebWorkers()
-)
+var webWorkers = config.WebWorkers()
 
-type hookEvent struct {
-	ticket     models.Ticket
-	transition models.Transition
-	hook       models.Hook
-}
+// HookTimeout is how long a webhook has to respond before the request is
+// considered failed and retried
+var HookTimeout = 10 * time.Second
 
-func handleHookEvent() {
-	outEvents := make(chan hookEvent)
+// maxDeliveryBody is how much of a webhook's response is kept in the
+// delivery log
+const maxDeliveryBody = 64 * 1024
 
-	for i := 0; i < webWorkers; i++ {
-		go webWorker(outEvents)
-	}
+// AllowPrivateHooks lets hooks be sent to loopback, private and link-local
+// addresses. They are refused by default so that project administrators
+// can't use webhooks to reach internal services.
+var AllowPrivateHooks bool
 
-	for {
-		e := <-hookEventChan
+// CaptureHookResponses keeps the headers and body of hook responses in the
+// delivery log, by default only the status is kept so that the deliveries
+// endpoints can't be used to read other services' responses
+var CaptureHookResponses bool
 
-		if e.Type() != event.TransitionEvent {
-			continue
-		}
+// ErrPrivateAddress is returned for hooks sent to an address which isn't
+// allowed
+var ErrPrivateAddress = errors.New("hooks can't be sent to loopback, private or link-local addresses")
 
-		transition, ok := e.Data().(models.Transition)
-		if !ok {
-			continue
-		}
+// sharedAddresses is the carrier-grade NAT range, which is private in
+// practice but not to net.IP.IsPrivate
+var sharedAddresses = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
 
-		for _, hook := range transition.Hooks {
-			outEvents <- hookEvent{
-				ticket:     e.Ticket(),
-				hook:       hook,
-				transition: transition,
-			}
-		}
+// PrivateAddress reports whether hooks may not be sent to the ip
+func PrivateAddress(ip net.IP) bool {
+	if AllowPrivateHooks {
+		return false
 	}
+
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddresses.Contains(ip)
 }
 
-func webWorker(inEvent chan hookEvent) {
-	for {
-		e := <-inEvent
-		err := runHook(e.ticket, e.transition, e.hook)
-		if err != nil {
-			eventLog.Println("|Web Worker|", err)
-		}
+// checkDial refuses connections to private addresses. It is run after the
+// host name is resolved so a name which resolves to one is refused too.
+func checkDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || PrivateAddress(ip) {
+		return ErrPrivateAddress
+	}
+
+	return nil
+}
+
+// hookClient returns the client hooks are sent with. Redirects aren't
+// followed, the redirect is recorded as a failed response instead.
+func hookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: HookTimeout, Control: checkDial}
+
+	return &http.Client{
+		Timeout: HookTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: HookTimeout,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
 	}
 }
 
+// Headers sent with every webhook request. SignatureHeader is only sent when
+// the hook has a secret, it is sha256= followed by the hex encoded HMAC-SHA256
+// of the body using the secret.
+const (
+	SignatureHeader = "X-Praelatus-Signature"
+	DeliveryHeader  = "X-Praelatus-Delivery"
+	EventHeader     = "X-Praelatus-Event"
+)
+
 func renderBody(ticket models.Ticket, transition models.Transition, hook models.Hook) (io.Reader, error) {
 	tmpl, err := template.New("hook-body").Parse(hook.Body)
 	if err != nil {
@@ -84,28 +125,152 @@ func renderBody(ticket models.Ticket, transition models.Transition, hook models.
 	return body, nil
 }
 
+// Sign returns the value of the SignatureHeader for the body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newDelivery returns a delivery of the body to the URL, it is signed with the
+// secret if there is one
+func newDelivery(method, url string, body []byte, eventType string, secret string) models.HookDelivery {
+	id := bson.NewObjectId()
+
+	headers := map[string]string{
+		DeliveryHeader: id.Hex(),
+		EventHeader:    eventType,
+	}
+
+	if secret != "" {
+		headers[SignatureHeader] = Sign(secret, body)
+	}
+
+	return models.HookDelivery{
+		ID: id,
+		Request: models.DeliveryRequest{
+			Method:  method,
+			URL:     url,
+			Headers: headers,
+			Body:    string(body),
+		},
+	}
+}
+
+// send makes the request of the delivery and records the response in it
+func send(d models.HookDelivery) models.HookDelivery {
+	d.CreatedDate = time.Now()
+
+	r, err := http.NewRequest(d.Request.Method, d.Request.URL,
+		strings.NewReader(d.Request.Body))
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+
+	for k, v := range d.Request.Headers {
+		r.Header.Set(k, v)
+	}
+
+	resp, err := hookClient().Do(r)
+	d.Latency = int64(time.Since(d.CreatedDate) / time.Millisecond)
+
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDeliveryBody))
+	if err != nil {
+		d.Error = err.Error()
+	}
+
+	d.Response = models.DeliveryResponse{Status: resp.StatusCode}
+
+	if CaptureHookResponses {
+		d.Response.Body = string(body)
+		d.Response.Headers = make(map[string]string, len(resp.Header))
+
+		for k := range resp.Header {
+			d.Response.Headers[k] = resp.Header.Get(k)
+		}
+	}
+
+	d.Success = err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !d.Success && d.Error == "" {
+		d.Error = "responded with " + resp.Status
+	}
+
+	return d
+}
+
+// record will store the delivery and return an error if it failed
+func record(d models.HookDelivery) (models.HookDelivery, error) {
+	d, err := repo.Deliveries().Create(outboxUser, d)
+	if err != nil {
+		eventLog.Println("|Hooks| unable to record delivery", d.ID.Hex(), err)
+	}
+
+	if !d.Success {
+		return d, fmt.Errorf("request failed Ticket=%s: URL=%s Error=%s",
+			d.Ticket, d.Request.URL, d.Error)
+	}
+
+	return d, nil
+}
+
+// runHook sends the request for the hook, it returns an error if the
+// request failed or the response wasn't a 2xx so the outbox retries it
 func runHook(ticket models.Ticket, transition models.Transition, hook models.Hook) error {
 	body, err := renderBody(ticket, transition, hook)
 	if err != nil {
 		return err
 	}
 
-	r, err := http.NewRequest(hook.Method, hook.Endpoint, body)
+	b, err := ioutil.ReadAll(body)
 	if err != nil {
-		return fmt.Errorf("error creating request %s: %s %s",
-			ticket.Key, transition.Name, err.Error())
+		return err
+	}
+
+	d := newDelivery(hook.Method, hook.Endpoint, b, models.TransitionEvent, hook.Secret)
+	d.Workflow = ticket.Workflow
+	d.Transition = transition.Name
+	d.Ticket = ticket.Key
+
+	_, err = record(send(d))
+	return err
+}
+
+// Redeliver sends the request of the delivery again, the body and signature
+// are the same as the original request and Matrix webhooks use their current
+// access token. It returns the new delivery and an error if it failed.
+func Redeliver(d models.HookDelivery) (models.HookDelivery, error) {
+	redelivery := models.HookDelivery{
+		ID:           bson.NewObjectId(),
+		Workflow:     d.Workflow,
+		Transition:   d.Transition,
+		Webhook:      d.Webhook,
+		Ticket:       d.Ticket,
+		RedeliveryOf: d.ID,
+		Request:      d.Request,
 	}
 
-	client := http.Client{}
-	resp, err := client.Do(r)
-	if resp != nil {
-		_ = resp.Body.Close()
+	headers := make(map[string]string, len(d.Request.Headers))
+	for k, v := range d.Request.Headers {
+		headers[k] = v
 	}
 
-	if err != nil {
-		return fmt.Errorf("request failed Ticket=%s: Status=%s Error=%s",
-			ticket.Key, transition.Name, err.Error())
+	headers[DeliveryHeader] = redelivery.ID.Hex()
+	redelivery.Request.Headers = headers
+
+	if d.Webhook != "" {
+		w, err := repo.Webhooks().Get(outboxUser, d.Webhook.Hex())
+		if err == nil {
+			return record(sendTo(w, redelivery))
+		}
 	}
 
-	return nil
+	return record(send(redelivery))
 }
@@ -3,3 +3,178 @@
 // the LICENSE file.
 
 package events
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var hookTicket = models.Ticket{Key: "TEST-1", Project: "TEST", Workflow: bson.NewObjectId()}
+
+// deliveries returns the deliveries recorded for hookTicket's workflow
+func deliveries(t *testing.T) []models.HookDelivery {
+	ds, err := repo.Deliveries().ForWorkflow(outboxUser, hookTicket.Workflow.Hex(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ds
+}
+
+func TestRunHookSigns(t *testing.T) {
+	defer useOutbox(t)()
+
+	var signature, body string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body, signature = string(b), r.Header.Get(SignatureHeader)
+		w.Write([]byte("thanks"))
+	}))
+	defer srv.Close()
+
+	hook := models.Hook{
+		Endpoint: srv.URL,
+		Method:   "POST",
+		Body:     `{"key": "{{ .Key }}"}`,
+		Secret:   "s3cret",
+	}
+
+	err := runHook(hookTicket, models.Transition{Name: "Done"}, hook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body != `{"key": "TEST-1"}` {
+		t.Errorf("Expected the rendered body Got %s", body)
+	}
+
+	if signature != Sign("s3cret", []byte(body)) {
+		t.Errorf("Expected %s Got %s", Sign("s3cret", []byte(body)), signature)
+	}
+
+	ds := deliveries(t)
+	if len(ds) != 1 {
+		t.Fatalf("Expected 1 delivery Got %v", ds)
+	}
+
+	d := ds[0]
+	if !d.Success || d.Response.Status != 200 || d.Response.Body != "thanks" {
+		t.Errorf("Expected a successful delivery Got %v", d)
+	}
+
+	if d.Request.Headers[DeliveryHeader] != d.ID.Hex() || d.Transition != "Done" {
+		t.Errorf("Expected the request to be recorded Got %v", d.Request)
+	}
+}
+
+func TestRunHookFailures(t *testing.T) {
+	defer useOutbox(t)()
+	defer func(timeout time.Duration) { HookTimeout = timeout }(HookTimeout)
+	HookTimeout = 50 * time.Millisecond
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	for _, url := range []string{broken.URL, slow.URL} {
+		err := runHook(hookTicket, models.Transition{Name: "Done"},
+			models.Hook{Endpoint: url, Method: "POST"})
+		if err == nil {
+			t.Errorf("Expected %s to fail", url)
+		}
+	}
+
+	ds := deliveries(t)
+	if len(ds) != 2 {
+		t.Fatalf("Expected 2 deliveries Got %v", ds)
+	}
+
+	for _, d := range ds {
+		if d.Success || d.Error == "" {
+			t.Errorf("Expected a failed delivery Got %v", d)
+		}
+
+		if _, signed := d.Request.Headers[SignatureHeader]; signed {
+			t.Errorf("Expected hooks without a secret not to be signed Got %v", d.Request.Headers)
+		}
+	}
+
+	if ds[1].Response.Status != http.StatusBadGateway {
+		t.Errorf("Expected the response status to be recorded Got %v", ds[1].Response)
+	}
+}
+
+func TestHookPrivateAddresses(t *testing.T) {
+	defer useOutbox(t)()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer srv.Close()
+
+	// Names are checked once they are resolved so localhost is refused
+	// as well as 127.0.0.1
+	AllowPrivateHooks = false
+
+	for _, endpoint := range []string{srv.URL, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)} {
+		d := send(newDelivery("POST", endpoint, nil, models.CommentEvent, ""))
+		if d.Success || !strings.Contains(d.Error, ErrPrivateAddress.Error()) {
+			t.Errorf("Expected %s to be refused Got %v", endpoint, d)
+		}
+	}
+
+	private := []string{"127.0.0.1", "10.1.2.3", "192.168.0.1", "169.254.169.254", "100.64.0.1", "::1", "fd00::1"}
+	for _, ip := range private {
+		if !PrivateAddress(net.ParseIP(ip)) {
+			t.Errorf("Expected %s to be private", ip)
+		}
+	}
+
+	if PrivateAddress(net.ParseIP("93.184.216.34")) {
+		t.Error("Expected a public address to be allowed")
+	}
+}
+
+func TestHookResponses(t *testing.T) {
+	defer useOutbox(t)()
+
+	redirected := false
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected = true
+	}))
+	defer target.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Secret", "internal")
+		http.Redirect(w, r, target.URL, http.StatusFound)
+	}))
+	defer srv.Close()
+
+	CaptureHookResponses = false
+
+	d := send(newDelivery("POST", srv.URL, nil, models.CommentEvent, ""))
+	if redirected || d.Success || d.Response.Status != http.StatusFound {
+		t.Errorf("Expected the redirect not to be followed Got %v", d)
+	}
+
+	if d.Response.Body != "" || len(d.Response.Headers) != 0 {
+		t.Errorf("Expected only the status to be kept Got %v", d.Response)
+	}
+}
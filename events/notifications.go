@@ -8,47 +8,68 @@ import (
 	"github.com/praelatus/praelatus/repo"
 )
 
-var (
-	notificationsEventChan = make(chan event.Event)
+// notificationHandlers returns a handler for each watcher of the ticket so
+// that failing to notify one watcher doesn't notify the others again
+func notificationHandlers(e event.Event) []outboxHandler {
+	var handlers []outboxHandler
 
-	// sendNotificationsChan = make(chan models.Notification)
-	// notificationWorkers   = 10
-)
+	for _, w := range e.Ticket().Watchers {
+		w := w
 
-func recordNofiticationEvent() {
-	// for i := 0; i < notificationWorkers; i++ {
-	// 	go sendNotificationWorker(result)
-	// }
-
-	for {
-		e := <-notificationsEventChan
-
-		n := models.Notification{
-			Type:           string(e.Type()),
-			ActionedTicket: e.Ticket().Key,
-			ActioningUser:  e.ActioningUser().Username,
-			Project:        e.Project().Key,
-			CreatedDate:    time.Now(),
-			Body:           e.String(),
-			Read:           false,
-		}
-
-		eventLog.Println(n)
-
-		for _, w := range e.Ticket().Watchers {
-			if w == e.ActioningUser().Username {
-				n.Watcher = w
-			}
-
-			_, err := repo.Notifications().Create(nil, n)
-			if err != nil {
-				eventLog.Println("|Notification Recorder|", err)
-			}
-		}
+		handlers = append(handlers, outboxHandler{
+			name: "notify:" + w,
+			handle: func(e event.Event) error {
+				return notifyWatcher(w, e)
+			},
+		})
 	}
+
+	return handlers
 }
 
-// TODO: Support email notifications via this function
-// func sendNotificationWorker(result chan Result) {
+// notifyWatcher stores a notification of the event for the watcher and emails
+// them, watchers who can't view the ticket's project are skipped. It returns
+// an error if the notification couldn't be stored, emails which fail are only
+// logged so they aren't sent twice.
+func notifyWatcher(watcher string, e event.Event) error {
+	user, err := repo.Users().Get(nil, watcher)
+	if err == repo.ErrNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !canView(user, e) {
+		return nil
+	}
+
+	n := models.Notification{
+		Type:           string(e.Type()),
+		ActionedTicket: e.Ticket().Key,
+		ActioningUser:  e.ActioningUser().Username,
+		Watcher:        watcher,
+		Project:        e.Project().Key,
+		CreatedDate:    time.Now(),
+		Body:           e.String(),
+		Read:           false,
+	}
+
+	_, err = repo.Notifications().Create(nil, n)
+	if err != nil {
+		return err
+	}
+
+	// Nobody needs an email about what they just did
+	if Mail == nil || watcher == e.ActioningUser().Username {
+		return nil
+	}
+
+	err = emailWatcher(user, n, e)
+	if err != nil {
+		eventLog.Println("|Notification Recorder|", watcher, err)
+	}
 
-// }
+	return nil
+}
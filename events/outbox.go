@@ -0,0 +1,222 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package events
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/praelatus/praelatus/events/event"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+)
+
+// Settings for dispatching events from the outbox
+var (
+	// OutboxPoll is how often the outbox is checked for events which are
+	// due, it is also checked whenever Wake is called
+	OutboxPoll = 5 * time.Second
+	// OutboxBatch is how many events are dispatched at once
+	OutboxBatch = 50
+	// OutboxMaxAttempts is how many times an event is tried before it is
+	// marked failed, failed events are tried again when Praelatus restarts
+	OutboxMaxAttempts = 10
+	// OutboxRetryDelay is how long to wait before the first retry, it
+	// doubles with each attempt up to OutboxMaxRetryDelay
+	OutboxRetryDelay    = 5 * time.Second
+	OutboxMaxRetryDelay = time.Hour
+	// OutboxRetention is how long events which are done are kept
+	OutboxRetention = 7 * 24 * time.Hour
+	// DeliveryRetention is how long the log of webhook deliveries is kept
+	DeliveryRetention = 30 * 24 * time.Hour
+)
+
+// outboxUser is used to read and update the outbox
+var outboxUser = &models.User{Username: "system", IsAdmin: true}
+
+var wake = make(chan struct{}, 1)
+
+// Wake tells the dispatcher there are new events in the outbox so it doesn't
+// wait until it next polls
+func Wake() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// outboxHandler handles events from the outbox, an event is retried until
+// every handler has returned nil for it
+type outboxHandler struct {
+	name   string
+	handle func(e event.Event) error
+}
+
+// handlersFor returns the handlers for the event, every watcher, webhook and
+// hook on a transition is its own handler so a failing one doesn't cause the
+// others to run again
+func handlersFor(e event.Event) []outboxHandler {
+	handlers := []outboxHandler{
+		{"live", func(e event.Event) error {
+			FireEvent(e)
+			return nil
+		}},
+	}
+
+	handlers = append(handlers, notificationHandlers(e)...)
+	handlers = append(handlers, webhookHandlers(e)...)
+
+	transition, ok := e.Data().(models.Transition)
+	if !ok {
+		return handlers
+	}
+
+	for i, hook := range transition.Hooks {
+		hook := hook
+
+		handlers = append(handlers, outboxHandler{
+			name: fmt.Sprintf("hook:%d", i),
+			handle: func(e event.Event) error {
+				return runHook(e.Ticket(), transition, hook)
+			},
+		})
+	}
+
+	return handlers
+}
+
+// retryDelay returns how long to wait before the next attempt
+func retryDelay(attempts int) time.Duration {
+	delay := OutboxRetryDelay
+
+	for i := 1; i < attempts && delay < OutboxMaxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > OutboxMaxRetryDelay {
+		delay = OutboxMaxRetryDelay
+	}
+
+	return delay
+}
+
+// deliver runs the handlers which haven't handled the event yet and records
+// the result in the outbox
+func deliver(o models.OutboxEvent) error {
+	e := event.FromOutbox(o)
+
+	var errs []string
+
+	for _, h := range handlersFor(e) {
+		if o.HasHandled(h.name) {
+			continue
+		}
+
+		err := h.handle(e)
+		if err != nil {
+			errs = append(errs, h.name+": "+err.Error())
+			continue
+		}
+
+		o.Handled = append(o.Handled, h.name)
+	}
+
+	now := time.Now()
+
+	o.Attempts++
+	o.UpdatedDate = now
+	o.LastError = strings.Join(errs, "; ")
+
+	switch {
+	case len(errs) == 0:
+		o.Status = models.OutboxDone
+	case o.Attempts >= OutboxMaxAttempts:
+		eventLog.Println("|Outbox|", o.ID.Hex(), "failed:", o.LastError)
+		o.Status = models.OutboxFailed
+	default:
+		o.NextAttempt = now.Add(retryDelay(o.Attempts))
+	}
+
+	return repo.Outbox().Update(outboxUser, o.ID.Hex(), o)
+}
+
+// dispatchDue delivers the events in the outbox which are due using up to
+// webWorkers go routines, it returns true if there may be more due
+func dispatchDue() bool {
+	due, err := repo.Outbox().Due(outboxUser, time.Now(), OutboxBatch)
+	if err != nil {
+		eventLog.Println("|Outbox|", err)
+		return false
+	}
+
+	jobs := make(chan models.OutboxEvent)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < webWorkers && i < len(due); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for o := range jobs {
+				if err := deliver(o); err != nil {
+					eventLog.Println("|Outbox|", o.ID.Hex(), err)
+				}
+			}
+		}()
+	}
+
+	for _, o := range due {
+		jobs <- o
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	return len(due) == OutboxBatch
+}
+
+// dispatchOutbox delivers the events in the outbox until the context is
+// cancelled. Events which failed are tried again when it starts and those
+// which were pending when Praelatus stopped are delivered straight away.
+func dispatchOutbox(ctx context.Context) {
+	err := repo.Outbox().Requeue(outboxUser)
+	if err != nil {
+		eventLog.Println("|Outbox|", err)
+	}
+
+	poll := time.NewTicker(OutboxPoll)
+	defer poll.Stop()
+
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	for {
+		if dispatchDue() {
+			Wake()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-poll.C:
+		case <-prune.C:
+			err = repo.Outbox().Prune(outboxUser, time.Now().Add(-OutboxRetention))
+			if err != nil {
+				eventLog.Println("|Outbox|", err)
+			}
+
+			err = repo.Deliveries().Prune(outboxUser, time.Now().Add(-DeliveryRetention))
+			if err != nil {
+				eventLog.Println("|Outbox|", err)
+			}
+		}
+	}
+}
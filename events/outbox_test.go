@@ -0,0 +1,253 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package events
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"github.com/praelatus/praelatus/repo/bolt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// useOutbox makes a bolt repo the global repo for the test
+func useOutbox(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "praelatus-outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := bolt.New(filepath.Join(dir, "praelatus.db"))
+	repo.GlobalRepo = r
+
+	// Tests send hooks to servers on localhost and check their responses
+	AllowPrivateHooks, CaptureHookResponses = true, true
+
+	return func() {
+		AllowPrivateHooks, CaptureHookResponses = false, false
+		repo.GlobalRepo = nil
+		_ = r.(bolt.Repo).DB.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func createOutboxEvent(t *testing.T, o models.OutboxEvent) {
+	_, err := repo.Outbox().Create(outboxUser, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getOutboxEvent(t *testing.T, o models.OutboxEvent) models.OutboxEvent {
+	o, err := repo.Outbox().Get(outboxUser, o.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return o
+}
+
+func transitionEvent(hooks ...models.Hook) models.OutboxEvent {
+	o := models.NewOutboxEvent(models.TransitionEvent,
+		&models.User{Username: "testadmin"},
+		models.Ticket{Key: "TEST-1", Project: "TEST", Workflow: bson.NewObjectId()})
+	o.Transition = &models.Transition{Name: "In Progress", Hooks: hooks}
+	return o
+}
+
+func TestDeliver(t *testing.T) {
+	defer useOutbox(t)()
+
+	var hits int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer srv.Close()
+
+	o := transitionEvent(models.Hook{Endpoint: srv.URL, Method: "POST"})
+	createOutboxEvent(t, o)
+
+	if err := deliver(o); err != nil {
+		t.Fatal(err)
+	}
+
+	o = getOutboxEvent(t, o)
+	if o.Status != models.OutboxDone || o.Attempts != 1 {
+		t.Errorf("Expected the event to be done Got %v", o)
+	}
+
+	for _, h := range []string{"live", "hook:0"} {
+		if !o.HasHandled(h) {
+			t.Errorf("Expected %s to have handled the event Got %v", h, o.Handled)
+		}
+	}
+
+	if atomic.LoadInt32(&hits) != 1 {
+		t.Errorf("Expected the hook to be called once Got %d", hits)
+	}
+}
+
+func TestNotifyWatchers(t *testing.T) {
+	defer useOutbox(t)()
+	defer func() { Mail = nil }()
+
+	Mail = &flakySender{}
+	defer queued()
+
+	_, err := repo.Projects().Create(outboxUser, models.Project{Key: "TEST", Name: "Test", FieldScheme: bson.NewObjectId()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, u := range []models.User{
+		{Username: "admin", Email: "admin@example.com", IsAdmin: true},
+		{Username: "outsider", Email: "outsider@example.com"},
+	} {
+		if _, err = repo.Users().Create(outboxUser, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	o := models.NewOutboxEvent(models.CommentEvent,
+		&models.User{Username: "commenter"},
+		models.Ticket{Key: "TEST-1", Project: "TEST", Workflow: bson.NewObjectId(),
+			Watchers: []string{"admin", "outsider"}})
+	o.Comment = &models.Comment{ID: bson.NewObjectId(), Body: "The secret is in the logs", Author: "commenter"}
+	createOutboxEvent(t, o)
+
+	if err = deliver(o); err != nil {
+		t.Fatal(err)
+	}
+
+	o = getOutboxEvent(t, o)
+	for _, h := range []string{"notify:admin", "notify:outsider"} {
+		if !o.HasHandled(h) {
+			t.Errorf("Expected %s to have handled the event Got %v", h, o.Handled)
+		}
+	}
+
+	for watcher, expected := range map[string]int{"admin": 1, "outsider": 0} {
+		n, err := repo.Notifications().ForUser(outboxUser, models.User{Username: watcher}, false, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(n) != expected {
+			t.Errorf("Expected %s to have %d notifications Got %v", watcher, expected, n)
+		}
+	}
+
+	msgs := queued()
+	if len(msgs) != 1 || msgs[0].To[0] != "admin@example.com" {
+		t.Errorf("Expected only the admin to be emailed Got %v", msgs)
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	defer useOutbox(t)()
+	defer func(max int) { OutboxMaxAttempts = max }(OutboxMaxAttempts)
+	OutboxMaxAttempts = 2
+
+	var hits int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer srv.Close()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	o := transitionEvent(
+		models.Hook{Endpoint: srv.URL, Method: "POST"},
+		models.Hook{Endpoint: down.URL, Method: "POST"},
+	)
+	createOutboxEvent(t, o)
+
+	if err := deliver(o); err != nil {
+		t.Fatal(err)
+	}
+
+	o = getOutboxEvent(t, o)
+	if o.Status != models.OutboxPending || o.LastError == "" {
+		t.Errorf("Expected the event to be retried Got %v", o)
+	}
+
+	if o.HasHandled("hook:1") || !o.HasHandled("hook:0") {
+		t.Errorf("Expected only the working hook to have handled it Got %v", o.Handled)
+	}
+
+	if !o.NextAttempt.After(time.Now()) {
+		t.Errorf("Expected the retry to be delayed Got %s", o.NextAttempt)
+	}
+
+	if err := deliver(o); err != nil {
+		t.Fatal(err)
+	}
+
+	o = getOutboxEvent(t, o)
+	if o.Status != models.OutboxFailed || o.Attempts != 2 {
+		t.Errorf("Expected the event to have failed Got %v", o)
+	}
+
+	if atomic.LoadInt32(&hits) != 1 {
+		t.Errorf("Expected the working hook not to be called again Got %d", hits)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, OutboxRetryDelay},
+		{2, 2 * OutboxRetryDelay},
+		{3, 4 * OutboxRetryDelay},
+		{100, OutboxMaxRetryDelay},
+	}
+
+	for _, test := range tests {
+		if d := retryDelay(test.attempts); d != test.expected {
+			t.Errorf("Expected %s after %d attempts Got %s", test.expected, test.attempts, d)
+		}
+	}
+}
+
+func TestDispatchOutbox(t *testing.T) {
+	defer useOutbox(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	failed := transitionEvent()
+	failed.Status = models.OutboxFailed
+	createOutboxEvent(t, failed)
+
+	go dispatchOutbox(ctx)
+
+	o := transitionEvent()
+	createOutboxEvent(t, o)
+	Wake()
+
+	for _, e := range []models.OutboxEvent{failed, o} {
+		for i := 0; getOutboxEvent(t, e).Status != models.OutboxDone && i < 100; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if e = getOutboxEvent(t, e); e.Status != models.OutboxDone {
+			t.Errorf("Expected the event to be delivered Got %v", e)
+		}
+	}
+}
@@ -5,50 +5,447 @@
 package events
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/praelatus/praelatus/events/event"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/ql/ast"
+	"github.com/praelatus/praelatus/ql/lexer"
+	"github.com/praelatus/praelatus/ql/parser"
+	"github.com/praelatus/praelatus/ql/token"
+	"github.com/praelatus/praelatus/repo"
 )
 
-// TODO: Add this to event system need to have a better design first
+// Timeouts and limits for websockets, a client which doesn't answer a ping
+// within PongWait is disconnected
+var (
+	WriteWait  = 10 * time.Second
+	PongWait   = 60 * time.Second
+	PingPeriod = PongWait * 9 / 10
 
-var wsEventChan = make(chan event.Event, 10)
+	// wsBuffer is how many events can be waiting to be sent to a websocket
+	// before it is considered too slow and disconnected
+	wsBuffer = 64
 
-// ManagedWebsocket wraps a websocket connection and should be managed inside of a
-// single go routine. Websockets are not safe for Concurrent reads and writes
-// and so the ManagedWebsocket is used to guarantee that only one go routine is
-// reading or writing at any given time.
+	// maxWsMessage is the largest message clients can send
+	maxWsMessage int64 = 4096
+)
+
+// ErrSlowConsumer is the reason given to websockets which are disconnected
+// because they weren't reading events fast enough
+var ErrSlowConsumer = errors.New("not reading events fast enough")
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     checkOrigin,
+}
+
+// checkOrigin only allows browsers to open websockets from pages served by
+// Praelatus, either at URL or the host the request was sent to. Clients which
+// aren't browsers don't send an Origin and are allowed.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	o, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	if strings.EqualFold(o.Host, r.Host) {
+		return true
+	}
+
+	u, err := url.Parse(URL)
+	return err == nil && strings.EqualFold(o.Host, u.Host)
+}
+
+// Subscription is what a websocket has asked to receive, events for tickets in
+// any of the projects, any of the tickets or tickets matching any of the PQL
+// queries are sent
+type Subscription struct {
+	Projects []string `json:"projects,omitempty"`
+	Tickets  []string `json:"tickets,omitempty"`
+	Queries  []string `json:"queries,omitempty"`
+}
+
+// wsRequest is a message from a client changing its subscription, Action is
+// either subscribe or unsubscribe
+type wsRequest struct {
+	Action string `json:"action"`
+	Subscription
+}
+
+// wsMessage is sent to clients, either an event, their subscription after
+// they change it or an error
+type wsMessage struct {
+	Event      *event.Payload `json:"event,omitempty"`
+	Subscribed *Subscription  `json:"subscribed,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// ManagedWebsocket wraps a websocket connection. Websockets are not safe for
+// concurrent writes so everything is written by a single writer go routine,
+// events and replies are passed to it over channels.
 type ManagedWebsocket struct {
 	Socket *websocket.Conn
+
+	user    models.User
+	events  chan event.Event
+	replies chan wsMessage
+	closed  chan struct{}
+	once    sync.Once
+
+	mu           sync.Mutex
+	subscription Subscription
+	queries      []ast.AST
+}
+
+func newManagedWebsocket(conn *websocket.Conn, user models.User) *ManagedWebsocket {
+	return &ManagedWebsocket{
+		Socket:  conn,
+		user:    user,
+		events:  make(chan event.Event, wsBuffer),
+		replies: make(chan wsMessage, 8),
+		closed:  make(chan struct{}),
+	}
 }
 
 // WSManager is used to send events to listening websockets
 type WSManager struct {
-	activeWS []ManagedWebsocket
+	mu       sync.Mutex
+	activeWS map[*ManagedWebsocket]bool
 }
 
 var wsm = WSManager{
-	activeWS: make([]ManagedWebsocket, 0),
+	activeWS: make(map[*ManagedWebsocket]bool),
 }
 
-// AddWs will upgrade the given http connection to a websocket and register it
-// with the Websocket Manager
-func (e *WSManager) AddWs(w http.ResponseWriter, r *http.Request, h http.Header) error {
-	conn, err := websocket.Upgrade(w, r, h, 1024, 1024)
+// AddWs will upgrade the given http connection to a websocket for the user and
+// register it with the Websocket Manager, it returns once the websocket is
+// closed
+func (e *WSManager) AddWs(w http.ResponseWriter, r *http.Request, user models.User) error {
+	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return err
 	}
 
-	ws := ManagedWebsocket{
-		Socket: conn,
-	}
+	ws := newManagedWebsocket(conn, user)
+
+	e.mu.Lock()
+	e.activeWS[ws] = true
+	e.mu.Unlock()
+
+	defer func() {
+		e.mu.Lock()
+		delete(e.activeWS, ws)
+		e.mu.Unlock()
+	}()
+
+	go ws.writer()
+	ws.reader()
 
-	e.activeWS = append(e.activeWS, ws)
 	return nil
 }
 
+// Broadcast will queue the event for every websocket subscribed to it,
+// websockets whose queue is full are disconnected instead of holding up the
+// others
+func (e *WSManager) Broadcast(ev event.Event) {
+	e.mu.Lock()
+	sockets := make([]*ManagedWebsocket, 0, len(e.activeWS))
+	for ws := range e.activeWS {
+		sockets = append(sockets, ws)
+	}
+	e.mu.Unlock()
+
+	results := &queryResults{key: ev.Ticket().Key, matched: make(map[string]bool)}
+
+	for _, ws := range sockets {
+		if !ws.matches(ev, results) {
+			continue
+		}
+
+		select {
+		case ws.events <- ev:
+		default:
+			ws.close(websocket.CloseTryAgainLater, ErrSlowConsumer.Error())
+		}
+	}
+}
+
 // AddWs calls the method of the same name on the global WSManager
-func AddWs(w http.ResponseWriter, r *http.Request, h http.Header) {
-	wsm.AddWs(w, r, h)
+func AddWs(w http.ResponseWriter, r *http.Request, user models.User) error {
+	return wsm.AddWs(w, r, user)
+}
+
+func handleWsEvents(ctx context.Context, sub *Subscriber) {
+	for {
+		select {
+		case e := <-sub.Events():
+			wsm.Broadcast(e)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// close will tell the writer to send a close message and stop, it's safe to
+// call more than once
+func (ws *ManagedWebsocket) close(code int, reason string) {
+	ws.once.Do(func() {
+		_ = ws.Socket.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(code, reason), time.Now().Add(WriteWait))
+		close(ws.closed)
+	})
+}
+
+// reader handles messages from the client until the connection is closed
+func (ws *ManagedWebsocket) reader() {
+	defer ws.close(websocket.CloseNormalClosure, "")
+
+	ws.Socket.SetReadLimit(maxWsMessage)
+	_ = ws.Socket.SetReadDeadline(time.Now().Add(PongWait))
+	ws.Socket.SetPongHandler(func(string) error {
+		return ws.Socket.SetReadDeadline(time.Now().Add(PongWait))
+	})
+
+	for {
+		var req wsRequest
+
+		err := ws.Socket.ReadJSON(&req)
+		if _, ok := err.(*json.UnmarshalTypeError); ok || err == nil && req.Action == "" {
+			ws.reply(wsMessage{Error: "messages must be JSON with an action"})
+			continue
+		}
+
+		if err != nil {
+			return
+		}
+
+		sub, err := ws.update(req)
+		if err != nil {
+			ws.reply(wsMessage{Error: err.Error()})
+			continue
+		}
+
+		ws.reply(wsMessage{Subscribed: &sub})
+	}
+}
+
+// reply queues a message for the writer, replies are dropped if the client
+// sends requests faster than it reads the replies
+func (ws *ManagedWebsocket) reply(m wsMessage) {
+	select {
+	case ws.replies <- m:
+	default:
+	}
+}
+
+// writer is the only go routine which writes to the websocket
+func (ws *ManagedWebsocket) writer() {
+	ticker := time.NewTicker(PingPeriod)
+
+	defer func() {
+		ticker.Stop()
+		ws.Socket.Close()
+	}()
+
+	for {
+		var err error
+
+		select {
+		case <-ws.closed:
+			return
+		case e := <-ws.events:
+			payload := event.NewPayload(e)
+			err = ws.write(wsMessage{Event: &payload})
+		case m := <-ws.replies:
+			err = ws.write(m)
+		case <-ticker.C:
+			err = ws.Socket.WriteControl(websocket.PingMessage, nil, time.Now().Add(WriteWait))
+		}
+
+		if err != nil {
+			ws.close(websocket.CloseGoingAway, "")
+			return
+		}
+	}
+}
+
+func (ws *ManagedWebsocket) write(m wsMessage) error {
+	_ = ws.Socket.SetWriteDeadline(time.Now().Add(WriteWait))
+	return ws.Socket.WriteJSON(m)
+}
+
+// update will change the subscription as requested and return the new one
+func (ws *ManagedWebsocket) update(req wsRequest) (Subscription, error) {
+	queries := make([]ast.AST, len(req.Queries))
+
+	for i, q := range req.Queries {
+		p := parser.New(lexer.New(q))
+		queries[i] = p.Parse()
+
+		if p.Errors() != nil {
+			return Subscription{}, p.Errors()
+		}
+
+		// Queries are combined with the key of each event's ticket so
+		// they have to be something the repos can search with
+		if err := queries[i].Validate(); err != nil {
+			return Subscription{}, err
+		}
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	switch req.Action {
+	case "subscribe":
+		ws.subscription.Projects = union(ws.subscription.Projects, req.Projects)
+		ws.subscription.Tickets = union(ws.subscription.Tickets, req.Tickets)
+
+		for i, q := range req.Queries {
+			if !contains(ws.subscription.Queries, q) {
+				ws.subscription.Queries = append(ws.subscription.Queries, q)
+				ws.queries = append(ws.queries, queries[i])
+			}
+		}
+	case "unsubscribe":
+		ws.subscription.Projects = difference(ws.subscription.Projects, req.Projects)
+		ws.subscription.Tickets = difference(ws.subscription.Tickets, req.Tickets)
+
+		for i := len(ws.subscription.Queries) - 1; i >= 0; i-- {
+			if contains(req.Queries, ws.subscription.Queries[i]) {
+				ws.subscription.Queries = append(ws.subscription.Queries[:i], ws.subscription.Queries[i+1:]...)
+				ws.queries = append(ws.queries[:i], ws.queries[i+1:]...)
+			}
+		}
+	default:
+		return Subscription{}, errors.New("unknown action " + req.Action)
+	}
+
+	return ws.subscription, nil
+}
+
+// queryResults remembers which queries the ticket of an event matches so that
+// each query is only searched once per event, however many websockets are
+// subscribed to it
+type queryResults struct {
+	key     string
+	matched map[string]bool
+}
+
+// matches reports whether the ticket matches the query q whose text is raw.
+// Whether the user can see the ticket is checked separately so the search is
+// done as the system user.
+func (qr *queryResults) matches(raw string, q ast.AST) bool {
+	matched, ok := qr.matched[raw]
+	if ok {
+		return matched
+	}
+
+	tickets, err := repo.Tickets().Search(outboxUser, forTicket(q, qr.key))
+	matched = err == nil && len(tickets) != 0
+
+	qr.matched[raw] = matched
+	return matched
+}
+
+// matches returns true if the event is one the client subscribed to and for
+// a ticket the user can see
+func (ws *ManagedWebsocket) matches(e event.Event, results *queryResults) bool {
+	// Unsubscribing removes queries in place so they're copied
+	ws.mu.Lock()
+	sub := ws.subscription
+	queries := append([]ast.AST(nil), ws.queries...)
+	raw := append([]string(nil), sub.Queries...)
+	ws.mu.Unlock()
+
+	key := e.Ticket().Key
+
+	subscribed := contains(sub.Projects, e.Project().Key) || contains(sub.Tickets, key)
+	if !subscribed && len(queries) == 0 {
+		return false
+	}
+
+	if !canView(ws.user, e) {
+		return false
+	}
+
+	if subscribed {
+		return true
+	}
+
+	for i, q := range queries {
+		if results.matches(raw[i], q) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forTicket returns the query limited to the ticket with the given key
+func forTicket(q ast.AST, key string) ast.AST {
+	var exp ast.Expression = ast.InfixExpression{
+		Token:    token.Token{Type: token.EQ, Literal: "="},
+		Left:     ast.FieldLiteral{Token: token.Token{Type: token.IDENT, Literal: "key"}, Value: "key"},
+		Operator: "=",
+		Right:    ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: key}, Value: key},
+	}
+
+	if q.Query.Expression != nil {
+		exp = ast.InfixExpression{
+			Token:    token.Token{Type: token.AND, Literal: "AND"},
+			Left:     exp,
+			Operator: "AND",
+			Right:    q.Query.Expression,
+		}
+	}
+
+	return ast.AST{Query: ast.ExpressionStatement{Expression: exp}}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func union(a, b []string) []string {
+	for _, s := range b {
+		if !contains(a, s) {
+			a = append(a, s)
+		}
+	}
+
+	return a
+}
+
+func difference(a, b []string) []string {
+	var result []string
+
+	for _, s := range a {
+		if !contains(b, s) {
+			result = append(result, s)
+		}
+	}
+
+	return result
 }
@@ -0,0 +1,252 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package events
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/praelatus/praelatus/events/event"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/ql/ast"
+	"github.com/praelatus/praelatus/repo"
+)
+
+func dialWs(t *testing.T) (*websocket.Conn, func()) {
+	repo.GlobalRepo = repo.NewMockRepo()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = AddWs(w, r, models.User{Username: "testuser"})
+	}))
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn, func() {
+		conn.Close()
+		srv.Close()
+		repo.GlobalRepo = nil
+	}
+}
+
+func readWs(t *testing.T, conn *websocket.Conn) wsMessage {
+	var m wsMessage
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	err := conn.ReadJSON(&m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestWebsocketSubscribe(t *testing.T) {
+	conn, cleanup := dialWs(t)
+	defer cleanup()
+
+	err := conn.WriteJSON(wsRequest{
+		Action:       "subscribe",
+		Subscription: Subscription{Projects: []string{"TEST"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := readWs(t, conn)
+	if m.Subscribed == nil || len(m.Subscribed.Projects) != 1 {
+		t.Fatalf("Expected subscription to TEST Got %#v", m)
+	}
+
+	wsm.Broadcast(event.Comment{
+		User:           models.User{Username: "commenter"},
+		InProject:      models.Project{Key: "OTHER"},
+		ActionedTicket: models.Ticket{Key: "OTHER-1"},
+	})
+	wsm.Broadcast(commentEvent())
+
+	m = readWs(t, conn)
+	if m.Event == nil {
+		t.Fatalf("Expected an event Got %#v", m)
+	}
+
+	if m.Event.Ticket.Key != "TEST-1" || m.Event.Type != event.CommentEvent {
+		t.Errorf("Expected COMMENT on TEST-1 Got %s on %s",
+			m.Event.Type, m.Event.Ticket.Key)
+	}
+
+	if m.Event.Project != "TEST" {
+		t.Errorf("Expected project TEST Got %s", m.Event.Project)
+	}
+}
+
+func TestWebsocketQuery(t *testing.T) {
+	conn, cleanup := dialWs(t)
+	defer cleanup()
+
+	err := conn.WriteJSON(wsRequest{
+		Action:       "subscribe",
+		Subscription: Subscription{Queries: []string{"summary = "}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := readWs(t, conn)
+	if m.Error == "" {
+		t.Fatalf("Expected an error for an invalid query Got %#v", m)
+	}
+
+	// A bare field parses but would match every ticket, or fail to
+	// search at all, once combined with the event's ticket key
+	err = conn.WriteJSON(wsRequest{
+		Action:       "subscribe",
+		Subscription: Subscription{Queries: []string{"summary"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m = readWs(t, conn)
+	if m.Error != ast.ErrInvalidExpression.Error() {
+		t.Fatalf("Expected an error for a bare field query Got %#v", m)
+	}
+
+	err = conn.WriteJSON(wsRequest{
+		Action:       "subscribe",
+		Subscription: Subscription{Queries: []string{`summary = "broken"`}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m = readWs(t, conn)
+	if m.Subscribed == nil || len(m.Subscribed.Queries) != 1 {
+		t.Fatalf("Expected subscription to the query Got %#v", m)
+	}
+
+	wsm.Broadcast(commentEvent())
+
+	m = readWs(t, conn)
+	if m.Event == nil || m.Event.Ticket.Key != "TEST-1" {
+		t.Fatalf("Expected an event for TEST-1 Got %#v", m)
+	}
+
+	err = conn.WriteJSON(wsRequest{
+		Action:       "unsubscribe",
+		Subscription: Subscription{Queries: []string{`summary = "broken"`}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m = readWs(t, conn)
+	if m.Subscribed == nil || len(m.Subscribed.Queries) != 0 {
+		t.Errorf("Expected no subscriptions Got %#v", m)
+	}
+}
+
+func TestWebsocketSlowConsumer(t *testing.T) {
+	conns := make(chan *websocket.Conn, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+		}
+
+		conns <- conn
+	}))
+	defer srv.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	repo.GlobalRepo = repo.NewMockRepo()
+	defer func() { repo.GlobalRepo = nil }()
+
+	// Without a writer nothing reads the queued events
+	ws := newManagedWebsocket(<-conns, models.User{Username: "testuser"})
+	ws.subscription.Projects = []string{"TEST"}
+	defer ws.Socket.Close()
+
+	wsm.mu.Lock()
+	wsm.activeWS[ws] = true
+	wsm.mu.Unlock()
+
+	defer func() {
+		wsm.mu.Lock()
+		delete(wsm.activeWS, ws)
+		wsm.mu.Unlock()
+	}()
+
+	for i := 0; i < wsBuffer+1; i++ {
+		wsm.Broadcast(commentEvent())
+	}
+
+	select {
+	case <-ws.closed:
+	default:
+		t.Fatal("Expected the websocket to be closed")
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	_, _, err = client.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+		t.Errorf("Expected close code %d Got %v", websocket.CloseTryAgainLater, err)
+	}
+}
+
+func TestWebsocketOrigin(t *testing.T) {
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"", true},
+		{"http://localhost:8080", true},
+		{"http://praelatus.example.com", true},
+		{"https://evil.example.com", false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "http://praelatus.example.com/api/v1/events", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+
+		if checkOrigin(r) != test.allowed {
+			t.Errorf("(%q) Expected allowed to be %v", test.origin, test.allowed)
+		}
+	}
+}
+
+func TestQueryResults(t *testing.T) {
+	repo.GlobalRepo = repo.NewMockRepo()
+	defer func() { repo.GlobalRepo = nil }()
+
+	results := &queryResults{key: "TEST-1", matched: map[string]bool{}}
+
+	// Cached results are used without searching again
+	results.matched["cached"] = true
+	if !results.matches("cached", ast.AST{}) {
+		t.Error("Expected the cached result to be used")
+	}
+
+	if !results.matches("everything", ast.AST{}) || !results.matched["everything"] {
+		t.Errorf("Expected the result to be remembered Got %v", results.matched)
+	}
+}
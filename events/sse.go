@@ -0,0 +1,213 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/praelatus/praelatus/events/event"
+	"github.com/praelatus/praelatus/models"
+)
+
+// ReplaySize is how many of the most recent events are kept so clients which
+// reconnect with a Last-Event-ID can be sent the events they missed
+var ReplaySize = 1000
+
+// SSEKeepAlive is how often a comment is sent on idle event streams so
+// proxies don't close them
+var SSEKeepAlive = 30 * time.Second
+
+// sseBuffer is how many events can be waiting to be sent to an event stream
+// before it is considered too slow and disconnected
+var sseBuffer = 64
+
+// ErrStreamingUnsupported is returned when the response writer can't be
+// flushed so events would never reach the client
+var ErrStreamingUnsupported = errors.New("streaming is not supported")
+
+// sseEvent is an event and the id clients resume from
+type sseEvent struct {
+	id    uint64
+	event event.Event
+}
+
+type sseClient struct {
+	user    models.User
+	events  chan sseEvent
+	dropped chan struct{}
+}
+
+// SSEManager is used to send events to server-sent event streams, it keeps
+// the most recent events so they can be replayed to clients which reconnect
+type SSEManager struct {
+	mu      sync.Mutex
+	lastID  uint64
+	replay  []sseEvent
+	clients map[*sseClient]bool
+}
+
+var ssem = SSEManager{
+	clients: make(map[*sseClient]bool),
+}
+
+// ServeSSE calls the method of the same name on the global SSEManager
+func ServeSSE(w http.ResponseWriter, r *http.Request, user models.User) error {
+	return ssem.ServeSSE(w, r, user)
+}
+
+func handleSSEEvents(ctx context.Context, sub *Subscriber) {
+	for {
+		select {
+		case e := <-sub.Events():
+			ssem.Publish(e)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Publish will add the event to the replay buffer and queue it for every
+// event stream, streams whose queue is full are disconnected instead of
+// holding up the others. They can reconnect and resume from the replay buffer.
+func (m *SSEManager) Publish(e event.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastID++
+	se := sseEvent{id: m.lastID, event: e}
+
+	m.replay = append(m.replay, se)
+	if len(m.replay) > ReplaySize {
+		m.replay = append(m.replay[:0], m.replay[len(m.replay)-ReplaySize:]...)
+	}
+
+	for c := range m.clients {
+		select {
+		case c.events <- se:
+		default:
+			delete(m.clients, c)
+			close(c.dropped)
+		}
+	}
+}
+
+// subscribe will register the client and return the events after lastID which
+// are still in the replay buffer
+func (m *SSEManager) subscribe(c *sseClient, lastID uint64, resume bool) []sseEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.clients[c] = true
+
+	if !resume {
+		return nil
+	}
+
+	// Ids start again when Praelatus restarts so everything is newer
+	if lastID > m.lastID {
+		lastID = 0
+	}
+
+	var missed []sseEvent
+
+	for _, se := range m.replay {
+		if se.id > lastID {
+			missed = append(missed, se)
+		}
+	}
+
+	return missed
+}
+
+func (m *SSEManager) unsubscribe(c *sseClient) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.clients, c)
+}
+
+// ServeSSE will stream the events for tickets the user can view as
+// server-sent events until the client disconnects. If the request has a
+// Last-Event-ID header the events since then are sent first.
+func (m *SSEManager) ServeSSE(w http.ResponseWriter, r *http.Request, user models.User) error {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		return ErrStreamingUnsupported
+	}
+
+	lastID, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	c := &sseClient{
+		user:    user,
+		events:  make(chan sseEvent, sseBuffer),
+		dropped: make(chan struct{}),
+	}
+
+	missed := m.subscribe(c, lastID, err == nil)
+	defer m.unsubscribe(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stops nginx buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = fmt.Fprint(w, ": connected\n\n")
+	if err != nil {
+		return err
+	}
+
+	for _, se := range missed {
+		err = writeSSE(w, user, se)
+		if err != nil {
+			return err
+		}
+	}
+
+	f.Flush()
+
+	ticker := time.NewTicker(SSEKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-c.dropped:
+			return ErrSlowConsumer
+		case se := <-c.events:
+			err = writeSSE(w, user, se)
+		case <-ticker.C:
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		}
+
+		if err != nil {
+			return err
+		}
+
+		f.Flush()
+	}
+}
+
+// writeSSE will write the event to the stream if the user can view it
+func writeSSE(w http.ResponseWriter, user models.User, se sseEvent) error {
+	if !canView(user, se.event) {
+		return nil
+	}
+
+	jsn, err := event.MarshalJSON(se.event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", se.id, jsn)
+	return err
+}
@@ -0,0 +1,166 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/praelatus/praelatus/events/event"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"github.com/praelatus/praelatus/repo/bolt"
+)
+
+// openSSE will connect to an event stream for the user, resuming after
+// lastID if it isn't empty
+func openSSE(t *testing.T, user models.User, lastID string) (*bufio.Reader, func()) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = ServeSSE(w, r, user)
+	}))
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Expected text/event-stream Got %s", res.Header.Get("Content-Type"))
+	}
+
+	return bufio.NewReader(res.Body), func() {
+		res.Body.Close()
+		srv.Close()
+	}
+}
+
+// readSSE returns the id and payload of the next event on the stream
+func readSSE(t *testing.T, stream *bufio.Reader) (uint64, event.Payload) {
+	var id uint64
+	var payload event.Payload
+
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id, _ = strconv.ParseUint(strings.TrimPrefix(line, "id: "), 10, 64)
+		case strings.HasPrefix(line, "data: "):
+			err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+		case line == "" && id != 0:
+			return id, payload
+		}
+	}
+}
+
+func ticketEvent(key string) event.Event {
+	return event.Comment{
+		User:           models.User{Username: "commenter"},
+		InProject:      models.Project{Key: strings.Split(key, "-")[0]},
+		ActionedTicket: models.Ticket{Key: key},
+	}
+}
+
+func TestSSEReplay(t *testing.T) {
+	repo.GlobalRepo = repo.NewMockRepo()
+	defer func() { repo.GlobalRepo = nil }()
+
+	ssem.Publish(ticketEvent("TEST-1"))
+	first := ssem.lastID
+	ssem.Publish(ticketEvent("TEST-2"))
+	ssem.Publish(ticketEvent("TEST-3"))
+
+	stream, cleanup := openSSE(t, models.User{Username: "testuser"},
+		strconv.FormatUint(first, 10))
+	defer cleanup()
+
+	for _, key := range []string{"TEST-2", "TEST-3"} {
+		id, payload := readSSE(t, stream)
+		if payload.Ticket.Key != key {
+			t.Errorf("Expected %s Got %s", key, payload.Ticket.Key)
+		}
+
+		if id <= first {
+			t.Errorf("Expected an id after %d Got %d", first, id)
+		}
+	}
+
+	ssem.Publish(ticketEvent("TEST-4"))
+
+	id, payload := readSSE(t, stream)
+	if payload.Ticket.Key != "TEST-4" || id != ssem.lastID {
+		t.Errorf("Expected TEST-4 with id %d Got %s with id %d",
+			ssem.lastID, payload.Ticket.Key, id)
+	}
+}
+
+func TestSSEReplayIsBounded(t *testing.T) {
+	defer func(size int) { ReplaySize = size }(ReplaySize)
+	ReplaySize = 2
+
+	for i := 0; i < 5; i++ {
+		ssem.Publish(ticketEvent("TEST-1"))
+	}
+
+	c := &sseClient{}
+	missed := ssem.subscribe(c, 0, true)
+	ssem.unsubscribe(c)
+
+	if len(missed) != 2 || missed[1].id != ssem.lastID {
+		t.Errorf("Expected the last 2 events Got %v", missed)
+	}
+}
+
+func TestSSEPermissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "praelatus-sse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	r := bolt.New(filepath.Join(dir, "praelatus.db"))
+	defer r.(bolt.Repo).DB.Close()
+
+	if err = repo.Seed(r); err != nil {
+		t.Fatal(err)
+	}
+
+	repo.GlobalRepo = r
+	defer func() { repo.GlobalRepo = nil }()
+
+	stream, cleanup := openSSE(t, models.User{Username: "anonymous"},
+		strconv.FormatUint(ssem.lastID, 10))
+	defer cleanup()
+
+	ssem.Publish(ticketEvent("TEST2-1"))
+	ssem.Publish(ticketEvent("TEST-1"))
+
+	_, payload := readSSE(t, stream)
+	if payload.Ticket.Key != "TEST-1" {
+		t.Errorf("Expected TEST-1 from the public project Got %s", payload.Ticket.Key)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package events
+
+import (
+	"strings"
+
+	"github.com/praelatus/praelatus/events/event"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/ql/lexer"
+	"github.com/praelatus/praelatus/ql/parser"
+	"github.com/praelatus/praelatus/repo"
+)
+
+// webhookHandlers returns a handler for each of the project's webhooks which
+// wants the event. If the project no longer exists there is nothing to send,
+// otherwise failing to find the webhooks fails the event so it is retried.
+func webhookHandlers(e event.Event) []outboxHandler {
+	webhooks, err := repo.Webhooks().ForProject(outboxUser, e.Project().Key)
+	if err == repo.ErrNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return []outboxHandler{{"webhooks", func(event.Event) error { return err }}}
+	}
+
+	var handlers []outboxHandler
+
+	for _, w := range webhooks {
+		if !w.Wants(string(e.Type())) {
+			continue
+		}
+
+		w := w
+
+		handlers = append(handlers, outboxHandler{
+			name: "webhook:" + w.ID.Hex(),
+			handle: func(e event.Event) error {
+				return sendWebhook(w, e)
+			},
+		})
+	}
+
+	return handlers
+}
+
+// webhookMatches reports whether the event's ticket matches the webhook's
+// query
+func webhookMatches(w models.Webhook, e event.Event) (bool, error) {
+	if w.Query == "" {
+		return true, nil
+	}
+
+	p := parser.New(lexer.New(w.Query))
+	q := p.Parse()
+
+	if p.Errors() != nil {
+		return false, p.Errors()
+	}
+
+	if err := q.Validate(); err != nil {
+		return false, err
+	}
+
+	tickets, err := repo.Tickets().Search(outboxUser, forTicket(q, e.Ticket().Key))
+	return len(tickets) != 0, err
+}
+
+// sendTo sends the delivery for the webhook. Matrix webhooks send their secret
+// as the access token, it is left out of the recorded request.
+func sendTo(w models.Webhook, d models.HookDelivery) models.HookDelivery {
+	if w.Format != models.WebhookMatrix || w.Secret == "" {
+		return send(d)
+	}
+
+	recorded := d.Request.Headers
+
+	d.Request.Headers = make(map[string]string, len(recorded)+1)
+	for k, v := range recorded {
+		d.Request.Headers[k] = v
+	}
+
+	d.Request.Headers["Authorization"] = "Bearer " + w.Secret
+
+	d = send(d)
+	d.Request.Headers = recorded
+	return d
+}
+
+// sendWebhook sends the event to the webhook in its format if the ticket
+// matches its query, it returns an error if the request failed or the
+// response wasn't a 2xx so the outbox retries it
+func sendWebhook(w models.Webhook, e event.Event) error {
+	matches, err := webhookMatches(w, e)
+	if err != nil || !matches {
+		return err
+	}
+
+	body, err := formatEvent(w.Format, e)
+	if err != nil {
+		return err
+	}
+
+	secret := w.Secret
+	if w.Format == models.WebhookMatrix {
+		secret = ""
+	}
+
+	d := newDelivery("POST", w.URL, body, string(e.Type()), secret)
+	d.Request.Headers["Content-Type"] = "application/json"
+	d.Webhook = w.ID
+	d.Ticket = e.Ticket().Key
+
+	// Matrix messages are PUT to the room with a transaction id, the delivery
+	// id is used so redelivering can't post the message twice
+	if w.Format == models.WebhookMatrix {
+		d.Request.Method = "PUT"
+		d.Request.URL = strings.TrimSuffix(w.URL, "/") + "/" + d.ID.Hex()
+	}
+
+	_, err = record(sendTo(w, d))
+	return err
+}
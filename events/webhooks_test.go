@@ -0,0 +1,110 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package events
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/praelatus/praelatus/events/event"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+)
+
+func commentOn(key string) event.Event {
+	return event.Comment{
+		User:           models.User{Username: "testadmin"},
+		InProject:      models.Project{Key: "TEST"},
+		ActionedTicket: models.Ticket{Key: key, Project: "TEST"},
+		Comment:        models.Comment{Body: "Hello", Author: "testadmin"},
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	defer useOutbox(t)()
+
+	if err := repo.Seed(repo.GlobalRepo); err != nil {
+		t.Fatal(err)
+	}
+
+	var requests []*http.Request
+	var bodies [][]byte
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		requests, bodies = append(requests, r), append(bodies, b)
+	}))
+	defer srv.Close()
+
+	comments, err := repo.Webhooks().Create(outboxUser, models.Webhook{
+		Project: "TEST",
+		URL:     srv.URL,
+		Events:  []string{string(event.CommentEvent)},
+		Query:   `key = "TEST-1"`,
+		Secret:  "s3cret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repo.Webhooks().Create(outboxUser, models.Webhook{
+		Project: "TEST",
+		URL:     srv.URL,
+		Events:  []string{string(event.CreatedEvent)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"TEST-1", "TEST-2"} {
+		e := commentOn(key)
+
+		handlers := webhookHandlers(e)
+		if len(handlers) != 1 || handlers[0].name != "webhook:"+comments.ID.Hex() {
+			t.Fatalf("Expected only the comment webhook Got %v", handlers)
+		}
+
+		if err := handlers[0].handle(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(requests) != 1 {
+		t.Fatalf("Expected only TEST-1 to match the query Got %d requests", len(requests))
+	}
+
+	if requests[0].Header.Get(SignatureHeader) != Sign("s3cret", bodies[0]) {
+		t.Errorf("Expected the payload to be signed Got %v", requests[0].Header)
+	}
+
+	if requests[0].Header.Get(EventHeader) != string(event.CommentEvent) {
+		t.Errorf("Expected the event type header Got %v", requests[0].Header)
+	}
+
+	var payload struct {
+		event.Payload
+		Data models.Comment `json:"data"`
+	}
+
+	if err := json.Unmarshal(bodies[0], &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if payload.Ticket.Key != "TEST-1" || payload.Project != "TEST" || payload.Data.Body != "Hello" {
+		t.Errorf("Expected the full event Got %s", bodies[0])
+	}
+
+	ds, err := repo.Deliveries().ForWebhook(outboxUser, comments.ID.Hex(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ds) != 1 || !ds[0].Success || ds[0].Ticket != "TEST-1" {
+		t.Errorf("Expected the delivery to be recorded Got %v", ds)
+	}
+}
@@ -23,6 +23,11 @@ func New() FS {
 	return FS{config.DataDir()}
 }
 
+// NewAt will open a filesystem.FS at the given path
+func NewAt(baseDir string) FS {
+	return FS{baseDir}
+}
+
 // Init will create the baseDir
 func (f FS) Init() error {
 	return os.MkdirAll(f.baseDir, os.ModePerm)
@@ -35,7 +40,13 @@ func (f FS) Get(path string) (io.ReadCloser, error) {
 
 // Save will generate a unique file name and save the file to the baseDir
 func (f FS) Save(file *os.File) (string, error) {
-	fn, err := files.GenUniqueFileName(file)
+	return f.Put(file.Name(), file)
+}
+
+// Put will generate a unique file name from name and save the contents of r
+// to the baseDir
+func (f FS) Put(name string, r io.Reader) (string, error) {
+	fn, err := files.GenUniqueName(name)
 	if err != nil {
 		return "", err
 	}
@@ -45,6 +56,10 @@ func (f FS) Save(file *os.File) (string, error) {
 		return "", err
 	}
 
-	_, err = io.Copy(file, newFile)
+	_, err = io.Copy(newFile, r)
+	if cerr := newFile.Close(); err == nil {
+		err = cerr
+	}
+
 	return fn, err
 }
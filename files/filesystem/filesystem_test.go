@@ -0,0 +1,49 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPut(t *testing.T) {
+	dir, err := ioutil.TempDir("", "praelatus-fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fs := NewAt(dir)
+
+	first, err := fs.Put("notes.txt", strings.NewReader("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := fs.Put("notes.txt", strings.NewReader("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first == second {
+		t.Fatalf("Expected files with the same name to be stored separately")
+	}
+
+	f, err := fs.Get(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	data, _ := ioutil.ReadAll(f)
+	if string(data) != "first" {
+		t.Errorf("Expected first Got %s", data)
+	}
+}
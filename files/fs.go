@@ -21,13 +21,19 @@ type FS interface {
 
 	Get(path string) (io.ReadCloser, error)
 	Save(file *os.File) (string, error)
+	Put(name string, r io.Reader) (string, error)
 }
 
 // GenUniqueFileName takes a file and hashes it's name with a salt to avoid
 // collisions.
 func GenUniqueFileName(file *os.File) (string, error) {
+	return GenUniqueName(file.Name())
+}
+
+// GenUniqueName hashes the name with a salt to avoid collisions.
+func GenUniqueName(name string) (string, error) {
 	saltString, err := newSalt(16)
-	fn := fmt.Sprintf("%x", md5.Sum([]byte(file.Name()+saltString)))
+	fn := fmt.Sprintf("%x", md5.Sum([]byte(name+saltString)))
 	return fn, err
 }
 
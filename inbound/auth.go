@@ -0,0 +1,89 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package inbound
+
+import (
+	"crypto/hmac"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	pmail "github.com/praelatus/praelatus/mail"
+	"github.com/praelatus/praelatus/models"
+)
+
+// comments are removed from Authentication-Results headers before they are
+// read, they can't be nested in the headers mail servers write
+var comments = regexp.MustCompile(`\([^()]*\)`)
+
+// validToken reports whether token is the reply token for the user and
+// ticket, it is always false when no secret is configured
+func (p *Processor) validToken(user models.User, key, token string) bool {
+	if p.secret == "" || token == "" {
+		return false
+	}
+
+	expected := pmail.ReplyToken(p.secret, user.Username, key)
+	return hmac.Equal([]byte(strings.ToLower(token)), []byte(expected))
+}
+
+// authenticated reports whether the mail server named by the processor's
+// authserv-id found that the email really came from the domain of from. Only
+// the first Authentication-Results header with that id is read since the mail
+// server adds its own above any the sender added.
+func (p *Processor) authenticated(h mail.Header, from string) bool {
+	if p.authServID == "" {
+		return false
+	}
+
+	domain := strings.ToLower(from[strings.LastIndex(from, "@")+1:])
+
+	for _, value := range h["Authentication-Results"] {
+		results := strings.Split(comments.ReplaceAllString(value, ""), ";")
+
+		id := strings.Fields(results[0])
+		if len(id) == 0 || !strings.EqualFold(id[0], p.authServID) {
+			continue
+		}
+
+		for _, result := range results[1:] {
+			if passes(result, domain) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return false
+}
+
+// passes reports whether a result such as dkim=pass header.d=example.com is
+// a pass for a method which is aligned with domain
+func passes(result, domain string) bool {
+	props := make(map[string]string)
+
+	for _, field := range strings.Fields(result) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) == 2 {
+			props[strings.ToLower(kv[0])] = strings.ToLower(strings.Trim(kv[1], `"`))
+		}
+	}
+
+	sameDomain := func(address string) bool {
+		return address != "" && address[strings.LastIndex(address, "@")+1:] == domain
+	}
+
+	switch {
+	case props["dmarc"] == "pass":
+		return sameDomain(props["header.from"])
+	case props["dkim"] == "pass":
+		return sameDomain(props["header.d"]) || sameDomain(props["header.i"])
+	case props["spf"] == "pass":
+		return sameDomain(props["smtp.mailfrom"])
+	}
+
+	return false
+}
@@ -0,0 +1,344 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+// Package inbound turns email sent to Praelatus into comments on tickets and
+// new tickets. Replies to notification emails go to the ticket's plus address,
+// such as praelatus+TEST-1@example.com, and email sent to a project's plus
+// address, such as praelatus+TEST@example.com, creates a ticket.
+package inbound
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/praelatus/praelatus/config"
+	"github.com/praelatus/praelatus/events"
+	"github.com/praelatus/praelatus/files"
+	pmail "github.com/praelatus/praelatus/mail"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/models/permission"
+	"github.com/praelatus/praelatus/repo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var inboundLog = log.New(config.LogWriter(), "[INBOUND] ", log.LstdFlags)
+
+// system is used to look up senders
+var system = &models.User{Username: "system", IsAdmin: true}
+
+// Errors returned when an email can't be processed
+var (
+	ErrAutomated     = errors.New("ignoring automated email")
+	ErrUnknownSender = errors.New("sender does not have an account")
+	ErrUnverified    = errors.New("email could not be verified as coming from the sender")
+	ErrNoTarget      = errors.New("no ticket or project found for email")
+	ErrEmpty         = errors.New("email has no text or attachments")
+)
+
+// ticketKey matches ticket keys in subjects such as Re: [TEST-12] Summary
+var ticketKey = regexp.MustCompile(`\b([A-Z][A-Z0-9]*-[0-9]+)\b`)
+
+// isTicketKey is used to tell ticket plus addresses from project ones
+var isTicketKey = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*-[0-9]+$`)
+
+// Processor turns emails into comments and tickets
+type Processor struct {
+	address    string
+	secret     string
+	authServID string
+	repo       repo.Repo
+	files      files.FS
+}
+
+// New will return a Processor for email sent to the configured address and
+// its plus addresses, attachments are saved to fs
+func New(c config.InboundConfig, r repo.Repo, fs files.FS) *Processor {
+	return &Processor{
+		address:    c.Address,
+		secret:     c.Secret,
+		authServID: c.AuthServID,
+		repo:       r,
+		files:      fs,
+	}
+}
+
+// Accepts returns true if the address is the processor's address or one of
+// its plus addresses
+func (p *Processor) Accepts(address string) bool {
+	_, ok := p.tag(address)
+	return ok
+}
+
+// tag returns the plus address tag of address if it belongs to the processor
+func (p *Processor) tag(address string) (string, bool) {
+	base, tag := pmail.SplitPlusAddress(address)
+	return tag, strings.EqualFold(base, p.address)
+}
+
+// Process reads an email and adds it to the ticket or project it was sent to,
+// as the user with the sender's email address. recipients are the addresses
+// it was delivered to, if there are none they are read from the headers.
+//
+// The From header is easily forged so the email must either be a reply to a
+// notification email, sent to the reply address signed for the user, or
+// have passed the DKIM, SPF or DMARC checks of the mail server named by the
+// processor's authserv-id.
+func (p *Processor) Process(r io.Reader, recipients ...string) error {
+	return p.process(r, true, recipients...)
+}
+
+// process is Process, trustResults is false when the email may not have come
+// through the mail server which writes the Authentication-Results headers
+func (p *Processor) process(r io.Reader, trustResults bool, recipients ...string) error {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return err
+	}
+
+	if automated(msg.Header) {
+		return ErrAutomated
+	}
+
+	user, err := p.sender(msg.Header)
+	if err != nil {
+		return err
+	}
+
+	verified := trustResults && p.authenticated(msg.Header, user.Email)
+
+	if len(recipients) == 0 {
+		recipients = headerRecipients(msg.Header)
+	}
+
+	subject := decodeHeader(msg.Header.Get("Subject"))
+
+	content, err := parse(msg.Header, msg.Body)
+	if err != nil {
+		return err
+	}
+
+	for _, address := range recipients {
+		tag, ok := p.tag(address)
+		if !ok || tag == "" {
+			continue
+		}
+
+		// Ticket addresses may be followed by the reply token
+		tag, token := splitToken(tag)
+
+		if isTicketKey.MatchString(tag) {
+			key := strings.ToUpper(tag)
+			if !verified && !p.validToken(user, key, token) {
+				return ErrUnverified
+			}
+
+			return p.comment(user, key, content)
+		}
+
+		if !verified {
+			return ErrUnverified
+		}
+
+		return p.createTicket(user, strings.ToUpper(tag), subject, content)
+	}
+
+	if key := ticketKey.FindString(subject); key != "" {
+		if !verified {
+			return ErrUnverified
+		}
+
+		return p.comment(user, key, content)
+	}
+
+	return ErrNoTarget
+}
+
+// splitToken splits a plus address tag such as TEST-1.0123abcd into the
+// ticket key and reply token
+func splitToken(tag string) (string, string) {
+	if i := strings.Index(tag, "."); i != -1 {
+		return tag[:i], tag[i+1:]
+	}
+
+	return tag, ""
+}
+
+// automated returns true for auto-replies, mailing list traffic and
+// Praelatus' own emails so they don't end up in a loop
+func automated(h mail.Header) bool {
+	if auto := h.Get("Auto-Submitted"); auto != "" && !strings.EqualFold(auto, "no") {
+		return true
+	}
+
+	switch strings.ToLower(h.Get("Precedence")) {
+	case "bulk", "junk", "list", "auto_reply":
+		return true
+	}
+
+	return false
+}
+
+// sender returns the user who sent the email
+func (p *Processor) sender(h mail.Header) (models.User, error) {
+	from, err := mail.ParseAddress(h.Get("From"))
+	if err != nil {
+		return models.User{}, ErrUnknownSender
+	}
+
+	if p.Accepts(from.Address) {
+		return models.User{}, ErrAutomated
+	}
+
+	user, err := p.repo.Users().GetByEmail(system, from.Address)
+	if err == repo.ErrNotFound || user.PendingVerification {
+		return models.User{}, ErrUnknownSender
+	}
+
+	return user, err
+}
+
+// headerRecipients returns the addresses in the headers the email was
+// delivered to
+func headerRecipients(h mail.Header) []string {
+	var recipients []string
+
+	for _, key := range []string{"Delivered-To", "X-Original-To", "To", "Cc"} {
+		for _, value := range h[key] {
+			addresses, err := mail.ParseAddressList(value)
+			if err != nil {
+				continue
+			}
+
+			for _, a := range addresses {
+				recipients = append(recipients, a.Address)
+			}
+		}
+	}
+
+	return recipients
+}
+
+// saveAttachments stores the attachments in the file store
+func (p *Processor) saveAttachments(parts []attachment) ([]models.Attachment, error) {
+	var attachments []models.Attachment
+
+	for _, part := range parts {
+		path, err := p.files.Put(part.name, bytes.NewReader(part.data))
+		if err != nil {
+			return nil, err
+		}
+
+		attachments = append(attachments, models.Attachment{
+			ID:          bson.NewObjectId(),
+			Name:        part.name,
+			ContentType: part.contentType,
+			Size:        int64(len(part.data)),
+			Path:        path,
+		})
+	}
+
+	return attachments, nil
+}
+
+// comment will add the email to the ticket as a comment by the user
+func (p *Processor) comment(user models.User, key string, c content) error {
+	ticket, err := p.repo.Tickets().Get(&user, key)
+	if err != nil {
+		return fmt.Errorf("%s: %s", key, err.Error())
+	}
+
+	project, err := p.repo.Projects().Get(&user, ticket.Project)
+	if err != nil {
+		return fmt.Errorf("%s: %s", key, err.Error())
+	}
+
+	if len(models.HasPermission(permission.CommentTicket, user, project)) == 0 {
+		return fmt.Errorf("%s: %s", key, repo.ErrUnauthorized.Error())
+	}
+
+	body := stripReply(c.text)
+	if body == "" && len(c.attachments) == 0 {
+		return ErrEmpty
+	}
+
+	attachments, err := p.saveAttachments(c.attachments)
+	if err != nil {
+		return err
+	}
+
+	comment := models.Comment{
+		Author:      user.Username,
+		Body:        body,
+		Attachments: attachments,
+	}
+
+	_, err = p.repo.Tickets().AddComment(&user, key, comment)
+	if err != nil {
+		return err
+	}
+
+	inboundLog.Println(user.Username, "commented on", key, "by email")
+
+	events.Wake()
+
+	return nil
+}
+
+// createTicket will create a ticket in the project from the email reported by
+// the user, it has the project's first ticket type
+func (p *Processor) createTicket(user models.User, projectKey, subject string, c content) error {
+	project, err := p.repo.Projects().Get(&user, projectKey)
+	if err != nil {
+		return fmt.Errorf("%s: %s", projectKey, err.Error())
+	}
+
+	if len(project.TicketTypes) == 0 {
+		return fmt.Errorf("%s: project has no ticket types", projectKey)
+	}
+
+	summary := strings.TrimSpace(subject)
+	if summary == "" {
+		summary = "(no subject)"
+	}
+
+	ticket, err := p.repo.Tickets().Create(&user, models.Ticket{
+		Summary:     summary,
+		Description: stripSignature(c.text),
+		Reporter:    user.Username,
+		Type:        project.TicketTypes[0],
+		Project:     project.Key,
+		Watchers:    []string{user.Username},
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %s", projectKey, err.Error())
+	}
+
+	inboundLog.Println(user.Username, "created", ticket.Key, "by email")
+
+	if len(c.attachments) != 0 {
+		attachments, err := p.saveAttachments(c.attachments)
+		if err != nil {
+			return err
+		}
+
+		_, err = p.repo.Tickets().AddComment(&user, ticket.Key, models.Comment{
+			Author:      user.Username,
+			Body:        "Attached to the email which created this ticket.",
+			Attachments: attachments,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	events.Wake()
+
+	return nil
+}
@@ -0,0 +1,313 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package inbound
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/praelatus/praelatus/config"
+	"github.com/praelatus/praelatus/files/filesystem"
+	pmail "github.com/praelatus/praelatus/mail"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"github.com/praelatus/praelatus/repo/bolt"
+)
+
+var (
+	r     repo.Repo
+	fs    filesystem.FS
+	p     *Processor
+	admin = models.User{Username: "testadmin", IsAdmin: true}
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "praelatus-inbound")
+	if err != nil {
+		panic(err)
+	}
+
+	r = bolt.New(filepath.Join(dir, "praelatus.db"))
+
+	if err = repo.Seed(r); err != nil {
+		panic(err)
+	}
+
+	mailer, _ := models.NewUser("mailer", "test", "Mail User", "mailer@example.com", false)
+	mailer.IsActive = true
+	mailer.Roles = []models.UserRole{{Role: "User", Project: "TEST"}}
+
+	if _, err = r.Users().Create(&admin, *mailer); err != nil {
+		panic(err)
+	}
+
+	fs = filesystem.NewAt(filepath.Join(dir, "files"))
+	if err = fs.Init(); err != nil {
+		panic(err)
+	}
+
+	p = New(config.InboundConfig{
+		Address:    "praelatus@example.com",
+		Secret:     "secret",
+		AuthServID: "mx.example.com",
+	}, r, fs)
+
+	code := m.Run()
+
+	r.(bolt.Repo).DB.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// verified is the header the mail server adds to email from example.com which
+// passed DKIM
+const verified = "Authentication-Results: mx.example.com; dkim=pass (good signature) header.d=example.com\n"
+
+// crlf converts the test messages to the line endings email uses
+func crlf(s string) string {
+	return strings.Replace(s, "\n", "\r\n", -1)
+}
+
+func lastComment(t *testing.T, key string) models.Comment {
+	ticket, err := r.Tickets().Get(&admin, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ticket.Comments) == 0 {
+		t.Fatalf("Expected %s to have comments", key)
+	}
+
+	return ticket.Comments[len(ticket.Comments)-1]
+}
+
+const reply = `From: Mail User <mailer@example.com>
+To: Praelatus <praelatus+test-1@example.com>
+Subject: Re: [TEST-1] A ticket
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: multipart/alternative; boundary="inner"
+
+--inner
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+I can still reproduce this on the latest version, logs are attached.
+
+On Tue, 10 Oct 2017 at 10:00, Praelatus <praelatus@example.com> wrote:
+> testadmin commented on TEST-1
+>
+> Can you try again?
+
+--=20
+Mail User
+--inner
+Content-Type: text/html; charset=utf-8
+
+<p>I can still reproduce this on the latest version, logs are attached.</p>
+--inner--
+--outer
+Content-Type: text/plain; name="app.log"
+Content-Disposition: attachment; filename="app.log"
+Content-Transfer-Encoding: base64
+
+cGFuaWM6IHJ1bnRpbWUgZXJyb3I=
+--outer--
+`
+
+func TestReplyComment(t *testing.T) {
+	// Mail servers may lower case the address
+	token := pmail.ReplyToken("secret", "mailer", "TEST-1")
+	msg := strings.Replace(reply, "praelatus+test-1@", "praelatus+test-1."+token+"@", 1)
+
+	err := p.Process(strings.NewReader(crlf(msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := lastComment(t, "TEST-1")
+
+	if c.Author != "mailer" ||
+		c.Body != "I can still reproduce this on the latest version, logs are attached." {
+		t.Errorf("Unexpected comment by %s:\n%q", c.Author, c.Body)
+	}
+
+	if len(c.Attachments) != 1 || c.Attachments[0].Name != "app.log" || c.Attachments[0].Size != 20 {
+		t.Fatalf("Unexpected attachments %v", c.Attachments)
+	}
+
+	f, err := fs.Get(c.Attachments[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	data, _ := ioutil.ReadAll(f)
+	if string(data) != "panic: runtime error" {
+		t.Errorf("Unexpected attachment contents %q", data)
+	}
+}
+
+func TestSubjectComment(t *testing.T) {
+	msg := verified + `From: mailer@example.com
+To: praelatus@example.com
+Subject: Re: [TEST-2] Another ticket
+
+Fixed by upgrading.
+`
+
+	err := p.Process(strings.NewReader(crlf(msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := lastComment(t, "TEST-2"); c.Body != "Fixed by upgrading." {
+		t.Errorf("Unexpected comment %q", c.Body)
+	}
+}
+
+func TestCreateTicket(t *testing.T) {
+	key, err := r.Tickets().NextTicketKey(&admin, "TEST")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := verified + `From: mailer@example.com
+To: praelatus+TEST@example.com
+Subject: =?utf-8?q?Printer_is_on_fire?=
+
+The printer on the second floor is on fire.
+`
+
+	err = p.Process(strings.NewReader(crlf(msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ticket, err := r.Tickets().Get(&admin, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ticket.Summary != "Printer is on fire" || ticket.Reporter != "mailer" ||
+		ticket.Description != "The printer on the second floor is on fire." {
+		t.Errorf("Unexpected ticket %v", ticket)
+	}
+}
+
+func TestRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		err  error
+	}{
+		{
+			name: "Unknown Sender",
+			msg:  "From: stranger@example.com\nTo: praelatus+TEST-1@example.com\n\nHello\n",
+			err:  ErrUnknownSender,
+		},
+		{
+			name: "Auto Reply",
+			msg:  "From: mailer@example.com\nTo: praelatus+TEST-1@example.com\nAuto-Submitted: auto-replied\n\nI'm on holiday\n",
+			err:  ErrAutomated,
+		},
+		{
+			name: "Own Email",
+			msg:  "From: praelatus@example.com\nTo: praelatus+TEST-1@example.com\n\nHello\n",
+			err:  ErrAutomated,
+		},
+		{
+			name: "No Ticket",
+			msg:  "From: mailer@example.com\nTo: praelatus@example.com\nSubject: Hello\n\nHello\n",
+			err:  ErrNoTarget,
+		},
+		{
+			name: "Empty",
+			msg:  verified + "From: mailer@example.com\nTo: praelatus+TEST-1@example.com\n\n> quoted\n",
+			err:  ErrEmpty,
+		},
+		{
+			name: "Unverified",
+			msg:  "From: mailer@example.com\nTo: praelatus+TEST-1@example.com\n\nHello\n",
+			err:  ErrUnverified,
+		},
+		{
+			name: "Unverified Subject",
+			msg:  "From: mailer@example.com\nTo: praelatus@example.com\nSubject: Re: [TEST-1]\n\nHello\n",
+			err:  ErrUnverified,
+		},
+		{
+			name: "Unverified Project",
+			msg:  "From: mailer@example.com\nTo: praelatus+TEST@example.com\nSubject: Hi\n\nHello\n",
+			err:  ErrUnverified,
+		},
+		{
+			name: "Wrong Token",
+			msg:  "From: mailer@example.com\nTo: praelatus+TEST-1.0123456789abcdef0123@example.com\n\nHello\n",
+			err:  ErrUnverified,
+		},
+		{
+			name: "Other Ticket's Token",
+			msg: "From: mailer@example.com\nTo: praelatus+TEST-1." +
+				pmail.ReplyToken("secret", "mailer", "TEST-2") + "@example.com\n\nHello\n",
+			err: ErrUnverified,
+		},
+		{
+			name: "Other User's Token",
+			msg: "From: mailer@example.com\nTo: praelatus+TEST-1." +
+				pmail.ReplyToken("secret", "testuser", "TEST-1") + "@example.com\n\nHello\n",
+			err: ErrUnverified,
+		},
+		{
+			name: "Untrusted Results",
+			msg: "Authentication-Results: evil.example.com; dkim=pass header.d=example.com\n" +
+				"From: mailer@example.com\nTo: praelatus+TEST-1@example.com\n\nHello\n",
+			err: ErrUnverified,
+		},
+		{
+			name: "Forged Results",
+			msg: "Authentication-Results: mx.example.com; dkim=fail header.d=example.com\n" + verified +
+				"From: mailer@example.com\nTo: praelatus+TEST-1@example.com\n\nHello\n",
+			err: ErrUnverified,
+		},
+		{
+			name: "Other Domain",
+			msg: "Authentication-Results: mx.example.com; spf=pass smtp.mailfrom=evil.example.net\n" +
+				"From: mailer@example.com\nTo: praelatus+TEST-1@example.com\n\nHello\n",
+			err: ErrUnverified,
+		},
+	}
+
+	for _, test := range tests {
+		err := p.Process(strings.NewReader(crlf(test.msg)))
+		if err != test.err {
+			t.Errorf("(%s) Expected %v Got %v", test.name, test.err, err)
+		}
+	}
+
+	// mailer has no role on the private TEST2 project
+	msg := verified + "From: mailer@example.com\nTo: praelatus+TEST2@example.com\nSubject: Hi\n\nHello\n"
+	if err := p.Process(strings.NewReader(crlf(msg))); err == nil {
+		t.Error("Expected creating tickets in other projects to fail")
+	}
+}
+
+func TestStripReply(t *testing.T) {
+	text := `Sounds good.
+
+-----Original Message-----
+From: Praelatus
+Please reply`
+
+	if stripped := stripReply(text); stripped != "Sounds good." {
+		t.Errorf("Unexpected text %q", stripped)
+	}
+}
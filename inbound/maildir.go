@@ -0,0 +1,87 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package inbound
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Maildir processes the emails delivered to a Maildir
+type Maildir struct {
+	dir       string
+	processor *Processor
+}
+
+// NewMaildir will return a Maildir which gives the emails delivered to dir to
+// the processor
+func NewMaildir(dir string, p *Processor) Maildir {
+	return Maildir{dir: dir, processor: p}
+}
+
+// Poll will process every email in the Maildir's new directory and move it to
+// cur. Emails which were processed are marked seen and ones which couldn't be
+// are flagged, either way they aren't processed again.
+func (m Maildir) Poll() error {
+	infos, err := ioutil.ReadDir(filepath.Join(m.dir, "new"))
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+
+		flag := "S"
+
+		err = m.process(info.Name())
+		if err != nil {
+			inboundLog.Println("|Maildir|", info.Name(), err)
+			flag = "F"
+		}
+
+		err = os.Rename(filepath.Join(m.dir, "new", info.Name()),
+			filepath.Join(m.dir, "cur", info.Name()+":2,"+flag))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m Maildir) process(name string) error {
+	f, err := os.Open(filepath.Join(m.dir, "new", name))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	return m.processor.Process(f)
+}
+
+// Watch will poll the Maildir every interval until stop is closed
+func (m Maildir) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := m.Poll()
+		if err != nil {
+			inboundLog.Println("|Maildir|", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package inbound
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMaildir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "praelatus-maildir")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	for _, sub := range []string{"new", "cur", "tmp"} {
+		if err = os.Mkdir(filepath.Join(dir, sub), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages := map[string]string{
+		"good": verified + "From: mailer@example.com\nTo: praelatus+TEST-1@example.com\n\nFrom the Maildir\n",
+		"bad":  "From: stranger@example.com\nTo: praelatus+TEST-1@example.com\n\nHello\n",
+	}
+
+	for name, msg := range messages {
+		err = ioutil.WriteFile(filepath.Join(dir, "new", name), []byte(crlf(msg)), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = NewMaildir(dir, p).Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"good:2,S", "bad:2,F"} {
+		if _, err = os.Stat(filepath.Join(dir, "cur", name)); err != nil {
+			t.Errorf("Expected %s to be moved to cur: %s", name, err)
+		}
+	}
+
+	if left, _ := ioutil.ReadDir(filepath.Join(dir, "new")); len(left) != 0 {
+		t.Errorf("Expected new to be empty Got %d emails", len(left))
+	}
+
+	if c := lastComment(t, "TEST-1"); c.Body != "From the Maildir" {
+		t.Errorf("Unexpected comment %q", c.Body)
+	}
+}
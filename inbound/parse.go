@@ -0,0 +1,218 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package inbound
+
+import (
+	"encoding/base64"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// maxDepth limits how deeply nested multipart emails can be
+const maxDepth = 10
+
+// attachment is a file attached to an email
+type attachment struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+// content is the text and attachments of an email
+type content struct {
+	text        string
+	attachments []attachment
+}
+
+// header is implemented by both mail.Header and textproto.MIMEHeader
+type header interface {
+	Get(key string) string
+}
+
+func decodeHeader(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+
+	return decoded
+}
+
+// parse returns the text of the email, preferring the plain text version to
+// the HTML one, and its attachments
+func parse(h header, body io.Reader) (content, error) {
+	var c content
+	var htmlText string
+
+	err := walk(h, body, 0, &c, &htmlText)
+	if err != nil {
+		return c, err
+	}
+
+	if c.text == "" && htmlText != "" {
+		c.text = stripHTML(htmlText)
+	}
+
+	c.text = strings.TrimSpace(strings.Replace(c.text, "\r\n", "\n", -1))
+	return c, nil
+}
+
+func walk(h header, body io.Reader, depth int, c *content, htmlText *string) error {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	switch strings.ToLower(h.Get("Content-Transfer-Encoding")) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && depth < maxDepth {
+		mr := multipart.NewReader(body, params["boundary"])
+
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			err = walk(part.Header, part, depth+1, c, htmlText)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	name := filename(h)
+
+	switch {
+	case name == "" && mediaType == "text/plain" && c.text == "":
+		c.text = string(data)
+	case name == "" && mediaType == "text/html" && *htmlText == "":
+		*htmlText = string(data)
+	case name != "" || !strings.HasPrefix(mediaType, "text/"):
+		if name == "" {
+			name = "attachment"
+		}
+
+		c.attachments = append(c.attachments, attachment{
+			name:        name,
+			contentType: mediaType,
+			data:        data,
+		})
+	}
+
+	return nil
+}
+
+// filename returns the name of an attached file, it's empty for parts which
+// aren't attachments
+func filename(h header) string {
+	var name string
+
+	if _, params, err := mime.ParseMediaType(h.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+
+	if name == "" {
+		if _, params, err := mime.ParseMediaType(h.Get("Content-Type")); err == nil {
+			name = params["name"]
+		}
+	}
+
+	if name == "" {
+		return ""
+	}
+
+	return filepath.Base(filepath.Clean("/" + decodeHeader(name)))
+}
+
+var (
+	htmlBreaks = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</div>|</li>`)
+	htmlTags   = regexp.MustCompile(`(?s)<[^>]*>`)
+	htmlHidden = regexp.MustCompile(`(?is)<(head|style|script)[^>]*>.*?</(head|style|script)>`)
+)
+
+// stripHTML returns the text of an HTML email
+func stripHTML(s string) string {
+	s = htmlHidden.ReplaceAllString(s, "")
+	s = htmlBreaks.ReplaceAllString(s, "\n")
+	s = htmlTags.ReplaceAllString(s, "")
+	return html.UnescapeString(s)
+}
+
+// replyHeaders are the lines email clients put before the quoted email when
+// replying
+var replyHeaders = []*regexp.Regexp{
+	regexp.MustCompile(`^On .*wrote:$`),
+	regexp.MustCompile(`^-+ ?Original Message ?-+$`),
+	regexp.MustCompile(`^From: .+$`),
+}
+
+// stripReply returns the new text of a reply, without the quoted email it is
+// replying to or a signature
+func stripReply(text string) string {
+	var lines []string
+
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if isReplyHeader(trimmed) {
+			break
+		}
+
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	return stripSignature(strings.Join(lines, "\n"))
+}
+
+func isReplyHeader(line string) bool {
+	for _, re := range replyHeaders {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stripSignature removes a signature which follows the standard "-- "
+// delimiter
+func stripSignature(text string) string {
+	lines := strings.Split(text, "\n")
+
+	for i, line := range lines {
+		if line == "-- " || line == "--" {
+			lines = lines[:i]
+			break
+		}
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
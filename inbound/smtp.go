@@ -0,0 +1,245 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package inbound
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxMessageSize is the largest email the SMTP server accepts
+var MaxMessageSize int64 = 25 << 20
+
+// commandTimeout is how long the SMTP server waits for each command
+const commandTimeout = 5 * time.Minute
+
+// Server is a minimal SMTP server which accepts email for the processor's
+// addresses. It doesn't support TLS or authentication so it should only
+// listen where your mail server can reach it, such as localhost, with the mail
+// server relaying email for the inbound address to it. Authentication-Results
+// headers are only trusted on connections from the loopback interface, email
+// from anywhere else must be sent to a signed reply address.
+type Server struct {
+	processor *Processor
+
+	mu       sync.Mutex
+	listener net.Listener
+}
+
+// NewServer will return a Server which gives the emails it receives to the
+// processor
+func NewServer(p *Processor) *Server {
+	return &Server{processor: p}
+}
+
+// ListenAndServe will listen on addr and serve SMTP connections
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ln)
+}
+
+// Serve will serve SMTP connections from the listener until Close is called
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	s.listener = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.handle(conn)
+	}
+}
+
+// Close stops the server listening
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+
+	return s.listener.Close()
+}
+
+// session is the state of an SMTP conversation, local is true for
+// connections from the loopback interface
+type session struct {
+	from       string
+	recipients []string
+	local      bool
+}
+
+// path returns the address from a MAIL FROM or RCPT TO argument, such as
+// FROM:<foo@example.com> SIZE=100
+func path(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	arg = strings.TrimSpace(arg[len(prefix):])
+
+	start, end := strings.Index(arg, "<"), strings.Index(arg, ">")
+	if start != 0 || end == -1 {
+		return "", false
+	}
+
+	return arg[1:end], true
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	reply := func(format string, args ...interface{}) {
+		_ = tp.PrintfLine(format, args...)
+	}
+
+	reply("220 praelatus ESMTP ready")
+
+	local := isLoopback(conn.RemoteAddr())
+	sess := session{local: local}
+
+	for {
+		_ = conn.SetDeadline(time.Now().Add(commandTimeout))
+
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg := line, ""
+		if i := strings.Index(line, " "); i != -1 {
+			verb, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			sess = session{local: local}
+			reply("250 praelatus")
+		case "EHLO":
+			sess = session{local: local}
+			reply("250-praelatus")
+			reply("250-8BITMIME")
+			reply("250 SIZE %d", MaxMessageSize)
+		case "MAIL":
+			from, ok := path(arg, "FROM:")
+			if !ok {
+				reply("501 5.5.4 Syntax: MAIL FROM:<address>")
+				continue
+			}
+
+			sess = session{from: from, local: local}
+			reply("250 2.1.0 Ok")
+		case "RCPT":
+			to, ok := path(arg, "TO:")
+			if !ok {
+				reply("501 5.5.4 Syntax: RCPT TO:<address>")
+				continue
+			}
+
+			if !s.processor.Accepts(to) {
+				reply("550 5.1.1 <%s>: Recipient address rejected", to)
+				continue
+			}
+
+			sess.recipients = append(sess.recipients, to)
+			reply("250 2.1.5 Ok")
+		case "DATA":
+			if len(sess.recipients) == 0 {
+				reply("503 5.5.1 Need RCPT command")
+				continue
+			}
+
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			s.data(tp, sess, reply)
+			sess = session{local: local}
+		case "RSET":
+			sess = session{local: local}
+			reply("250 2.0.0 Ok")
+		case "NOOP":
+			reply("250 2.0.0 Ok")
+		case "VRFY":
+			reply("252 2.0.0 Cannot VRFY user")
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			reply("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+// data reads the email after a DATA command and processes it, the reply
+// tells the sending server whether to bounce it
+func (s *Server) data(tp *textproto.Conn, sess session, reply func(string, ...interface{})) {
+	dr := tp.DotReader()
+
+	msg, err := ioutil.ReadAll(io.LimitReader(dr, MaxMessageSize+1))
+	if err != nil {
+		reply("451 4.3.0 Error reading message")
+		return
+	}
+
+	if int64(len(msg)) > MaxMessageSize {
+		// Read the rest so the connection can carry on
+		_, _ = io.Copy(ioutil.Discard, dr)
+		reply("552 5.3.4 Message too big")
+		return
+	}
+
+	err = s.processor.process(bytes.NewReader(msg), sess.local, sess.recipients...)
+	if err == ErrAutomated {
+		// Accepting it stops the sender retrying or bouncing it
+		reply("250 2.0.0 Ok: ignored")
+		return
+	}
+
+	if err != nil {
+		inboundLog.Println("|SMTP|", sess.from, err)
+		reply("550 5.7.1 %s", sanitize(err.Error()))
+		return
+	}
+
+	reply("250 2.0.0 Ok: queued")
+}
+
+// isLoopback reports whether the address is on the loopback interface
+func isLoopback(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && tcp.IP.IsLoopback()
+}
+
+// sanitize makes an error safe to put in an SMTP reply
+func sanitize(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return ' '
+		}
+
+		return r
+	}, s)
+
+	if len(s) > 200 {
+		s = s[:200]
+	}
+
+	return fmt.Sprintf("Not delivered: %s", s)
+}
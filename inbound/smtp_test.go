@@ -0,0 +1,52 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package inbound
+
+import (
+	"net"
+	"net/smtp"
+	"strings"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(p)
+	go s.Serve(ln)
+	defer s.Close()
+
+	addr := ln.Addr().String()
+
+	msg := []byte(crlf(verified + "From: mailer@example.com\nTo: Praelatus <praelatus@example.com>\n" +
+		"Subject: Re: [TEST-1] A ticket\n\nOver SMTP\n"))
+
+	// The ticket comes from the envelope recipient not the headers
+	err = smtp.SendMail(addr, nil, "mailer@example.com",
+		[]string{"praelatus+TEST-4@example.com"}, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := lastComment(t, "TEST-4"); c.Body != "Over SMTP" {
+		t.Errorf("Unexpected comment %q", c.Body)
+	}
+
+	err = smtp.SendMail(addr, nil, "mailer@example.com",
+		[]string{"someone@example.com"}, msg)
+	if err == nil || !strings.Contains(err.Error(), "550") {
+		t.Errorf("Expected other recipients to be rejected Got %v", err)
+	}
+
+	err = smtp.SendMail(addr, nil, "stranger@example.com",
+		[]string{"praelatus+TEST-1@example.com"},
+		[]byte(crlf("From: stranger@example.com\n\nHello\n")))
+	if err == nil || !strings.Contains(err.Error(), ErrUnknownSender.Error()) {
+		t.Errorf("Expected unknown senders to be bounced Got %v", err)
+	}
+}
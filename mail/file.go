@@ -0,0 +1,69 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// File writes each message to its own .eml file in a directory instead of
+// sending it, it's meant for testing and development
+type File struct {
+	from string
+	dir  string
+
+	mu    sync.Mutex
+	count int
+}
+
+// NewFile will return a File sender which writes to dir, creating it if it
+// doesn't exist
+func NewFile(from, dir string) (*File, error) {
+	if dir == "" {
+		return nil, errors.New("file mail sender requires a Dir")
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{from: from, dir: dir}, nil
+}
+
+// Send implements Sender
+func (f *File) Send(m Message) error {
+	msg, err := m.Bytes(f.from)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.count++
+	name := fmt.Sprintf("%d-%04d.eml", time.Now().UnixNano(), f.count)
+
+	return ioutil.WriteFile(filepath.Join(f.dir, name), msg, 0600)
+}
+
+// Files returns the paths of the messages that have been written in the
+// order they were sent
+func (f *File) Files() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(f.dir, "*.eml"))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,207 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+// Package mail sends the emails praelatus sends to users, either through an
+// SMTP server or by writing them to files for testing.
+package mail
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/praelatus/praelatus/config"
+)
+
+var mailLog = log.New(config.LogWriter(), "[MAIL] ", log.LstdFlags)
+
+// Message is an email, Body is the plain text version and if HTML is set the
+// email is sent with both versions
+type Message struct {
+	To      []string
+	ReplyTo string
+	Subject string
+	Body    string
+	HTML    string
+}
+
+// Sender delivers messages
+type Sender interface {
+	Send(m Message) error
+}
+
+// New will return the Sender for the given configuration, it returns nil if
+// sending mail is not configured
+func New(c config.MailConfig) (Sender, error) {
+	var s Sender
+	var err error
+
+	switch c.Sender {
+	case "":
+		return nil, nil
+	case "smtp":
+		s, err = NewSMTP(c.From, c.SMTP)
+	case "file":
+		s, err = NewFile(c.From, c.Dir)
+	default:
+		return nil, fmt.Errorf("unknown mail sender: %s", c.Sender)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// stripNewlines removes line breaks from header values so they can't be
+// used to add headers
+func stripNewlines(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// PlusAddress returns the address with tag added to the local part, for
+// example praelatus@example.com and TEST-1 gives praelatus+TEST-1@example.com
+func PlusAddress(address, tag string) string {
+	i := strings.LastIndex(address, "@")
+	if i == -1 || tag == "" {
+		return address
+	}
+
+	return address[:i] + "+" + tag + address[i:]
+}
+
+// ReplyToken returns the token put in the reply address of emails sent to the
+// user about the ticket, replies with it must come from someone who received
+// the email
+func ReplyToken(secret, username, ticketKey string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(username + "\x00" + strings.ToUpper(ticketKey)))
+	return hex.EncodeToString(mac.Sum(nil)[:10])
+}
+
+// SplitPlusAddress is the reverse of PlusAddress, it returns the address
+// without the tag and the tag
+func SplitPlusAddress(address string) (string, string) {
+	i := strings.LastIndex(address, "@")
+	if i == -1 {
+		return address, ""
+	}
+
+	local, domain := address[:i], address[i:]
+
+	plus := strings.Index(local, "+")
+	if plus == -1 {
+		return address, ""
+	}
+
+	return local[:plus] + domain, local[plus+1:]
+}
+
+// domain returns the domain of the email address
+func domain(address string) string {
+	address = strings.TrimSuffix(address, ">")
+
+	i := strings.LastIndex(address, "@")
+	if i == -1 {
+		return "localhost"
+	}
+
+	return address[i+1:]
+}
+
+// Bytes returns the message formatted as an email from the given address
+func (m Message) Bytes(from string) ([]byte, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	to := make([]string, len(m.To))
+	for i := range m.To {
+		to[i] = stripNewlines(m.To[i])
+	}
+
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "From: %s\r\n", stripNewlines(from))
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+
+	if m.ReplyTo != "" {
+		fmt.Fprintf(&b, "Reply-To: %s\r\n", stripNewlines(m.ReplyTo))
+	}
+
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", stripNewlines(m.Subject)))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain(from))
+	// Stops auto-responders replying and inbound mail treating them as replies
+	b.WriteString("Auto-Submitted: auto-generated\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	if m.HTML == "" {
+		err := writePart(&b, "text/plain", m.Body)
+		return b.Bytes(), err
+	}
+
+	mw := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+
+	// Clients show the last part they understand so plain text goes first
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", m.Body},
+		{"text/html", m.HTML},
+	} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType+"; charset=utf-8")
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+		w, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = writeBody(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// writePart writes the headers and quoted-printable body of a single part
+// message
+func writePart(b *bytes.Buffer, contentType, body string) error {
+	fmt.Fprintf(b, "Content-Type: %s; charset=utf-8\r\n", contentType)
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	b.WriteString("\r\n")
+
+	return writeBody(b, body)
+}
+
+// writeBody writes body quoted-printable encoded with CRLF line endings
+func writeBody(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	body = strings.Replace(body, "\r\n", "\n", -1)
+
+	if _, err := qp.Write([]byte(strings.Replace(body, "\n", "\r\n", -1))); err != nil {
+		return err
+	}
+
+	return qp.Close()
+}
@@ -0,0 +1,302 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/praelatus/praelatus/config"
+)
+
+func parse(t *testing.T, raw []byte) (*mail.Message, string) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := ioutil.ReadAll(msg.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return msg, string(body)
+}
+
+func TestMessageBytes(t *testing.T) {
+	m := Message{
+		To:      []string{"foo@example.com"},
+		Subject: "Héllo\r\nBcc: evil@example.com",
+		Body:    "Line one\nLine two",
+	}
+
+	raw, err := m.Bytes("praelatus@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, body := parse(t, raw)
+
+	if msg.Header.Get("Auto-Submitted") != "auto-generated" {
+		t.Error("Expected the message to be marked as automated")
+	}
+
+	if msg.Header.Get("Bcc") != "" {
+		t.Error("Expected newlines in the subject to be stripped")
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "HélloBcc: evil@example.com" {
+		t.Errorf("Unexpected subject %s %v", subject, err)
+	}
+
+	if !strings.HasSuffix(msg.Header.Get("Message-ID"), "@example.com>") {
+		t.Errorf("Unexpected Message-ID %s", msg.Header.Get("Message-ID"))
+	}
+
+	if body != "Line one\r\nLine two" {
+		t.Errorf("Unexpected body %q", body)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "praelatus-mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	f, err := NewFile("praelatus@example.com", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, subject := range []string{"first", "second"} {
+		err = f.Send(Message{To: []string{"foo@example.com"}, Subject: subject})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := f.Files()
+	if err != nil || len(files) != 2 {
+		t.Fatalf("Expected 2 files Got %v %v", files, err)
+	}
+
+	raw, err := ioutil.ReadFile(files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, _ := parse(t, raw)
+	if msg.Header.Get("Subject") != "second" {
+		t.Errorf("Expected second Got %s", msg.Header.Get("Subject"))
+	}
+}
+
+// smtpServer accepts a single message returning what it received on done
+func smtpServer(t *testing.T) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan string, 1)
+
+	go func() {
+		defer l.Close()
+
+		conn, err := l.Accept()
+		if err != nil {
+			done <- ""
+			return
+		}
+
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		var received bytes.Buffer
+		data := false
+
+		conn.Write([]byte("220 localhost ESMTP\r\n"))
+
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				done <- received.String()
+				return
+			}
+
+			if data {
+				if line == ".\r\n" {
+					data = false
+					conn.Write([]byte("250 OK\r\n"))
+					continue
+				}
+
+				received.WriteString(line)
+				continue
+			}
+
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				conn.Write([]byte("250 localhost\r\n"))
+			case strings.HasPrefix(cmd, "DATA"):
+				data = true
+				conn.Write([]byte("354 Go ahead\r\n"))
+			case strings.HasPrefix(cmd, "QUIT"):
+				conn.Write([]byte("221 Bye\r\n"))
+				done <- received.String()
+				return
+			default:
+				received.WriteString(line)
+				conn.Write([]byte("250 OK\r\n"))
+			}
+		}
+	}()
+
+	return l.Addr().String(), done
+}
+
+func TestSMTP(t *testing.T) {
+	addr, done := smtpServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+
+	s, err := New(config.MailConfig{
+		Sender: "smtp",
+		From:   "praelatus@example.com",
+		SMTP:   config.SMTPConfig{Host: host, Port: port},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Send(Message{
+		To:      []string{"foo@example.com"},
+		Subject: "Hello",
+		Body:    "Hi there",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := <-done
+
+	for _, expected := range []string{
+		"MAIL FROM:<praelatus@example.com>",
+		"RCPT TO:<foo@example.com>",
+		"Subject: Hello",
+		"Hi there",
+	} {
+		if !strings.Contains(received, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, received)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if s, err := New(config.MailConfig{}); s != nil || err != nil {
+		t.Errorf("Expected no sender Got %v %v", s, err)
+	}
+
+	if _, err := New(config.MailConfig{Sender: "smtp"}); err == nil {
+		t.Error("Expected an error without a host")
+	}
+
+	if _, err := New(config.MailConfig{Sender: "carrier-pigeon"}); err == nil {
+		t.Error("Expected an error for an unknown sender")
+	}
+}
+
+func TestMessageBytesHTML(t *testing.T) {
+	m := Message{
+		To:      []string{"foo@example.com"},
+		Subject: "Both",
+		Body:    "Plain text",
+		HTML:    "<p>Some <b>HTML</b></p>",
+	}
+
+	raw, err := m.Bytes("praelatus@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Expected multipart/alternative Got %s %v", mediaType, err)
+	}
+
+	var parts []string
+
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// NextPart decodes quoted-printable parts
+		body, _ := ioutil.ReadAll(p)
+		parts = append(parts, strings.SplitN(p.Header.Get("Content-Type"), ";", 2)[0]+" "+string(body))
+	}
+
+	expected := []string{"text/plain Plain text", "text/html <p>Some <b>HTML</b></p>"}
+	if strings.Join(parts, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected parts %v Got %v", expected, parts)
+	}
+}
+
+func TestPlusAddress(t *testing.T) {
+	address := PlusAddress("praelatus@example.com", "TEST-1")
+	if address != "praelatus+TEST-1@example.com" {
+		t.Errorf("Unexpected address %s", address)
+	}
+
+	base, tag := SplitPlusAddress(address)
+	if base != "praelatus@example.com" || tag != "TEST-1" {
+		t.Errorf("Unexpected split %s %s", base, tag)
+	}
+
+	base, tag = SplitPlusAddress("praelatus@example.com")
+	if base != "praelatus@example.com" || tag != "" {
+		t.Errorf("Unexpected split %s %s", base, tag)
+	}
+}
+
+func TestReplyToken(t *testing.T) {
+	token := ReplyToken("secret", "foouser", "TEST-1")
+	if len(token) != 20 || token != ReplyToken("secret", "foouser", "test-1") {
+		t.Errorf("Unexpected token %s", token)
+	}
+
+	for _, other := range []string{
+		ReplyToken("other", "foouser", "TEST-1"),
+		ReplyToken("secret", "baruser", "TEST-1"),
+		ReplyToken("secret", "foouser", "TEST-2"),
+	} {
+		if other == token {
+			t.Errorf("Expected a different token Got %s", other)
+		}
+	}
+}
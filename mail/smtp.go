@@ -0,0 +1,55 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package mail
+
+import (
+	"errors"
+	"net"
+	"net/smtp"
+
+	"github.com/praelatus/praelatus/config"
+)
+
+// SMTP sends messages through an SMTP server. STARTTLS is used whenever the
+// server supports it.
+type SMTP struct {
+	from   string
+	config config.SMTPConfig
+}
+
+// NewSMTP will return an SMTP sender which sends messages from the given
+// address
+func NewSMTP(from string, c config.SMTPConfig) (*SMTP, error) {
+	if c.Host == "" {
+		return nil, errors.New("smtp requires a Host")
+	}
+
+	if c.Port == "" {
+		c.Port = "25"
+	}
+
+	return &SMTP{from: from, config: c}, nil
+}
+
+// Send implements Sender
+func (s *SMTP) Send(m Message) error {
+	msg, err := m.Bytes(s.from)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.config.Username != "" {
+		auth = smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)
+	}
+
+	err = smtp.SendMail(net.JoinHostPort(s.config.Host, s.config.Port),
+		auth, s.from, m.To, msg)
+	if err != nil {
+		mailLog.Println("ERROR:", err)
+	}
+
+	return err
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// FieldChange is a change to one field of a ticket. Field is the JSON name of
+// the field, custom fields are named fields. followed by their name.
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// normalize returns the value as it would be decoded from JSON so that values
+// decoded from JSON and BSON compare equal, nil and empty slices are both nil
+func normalize(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+
+	var n interface{}
+	_ = json.Unmarshal(b, &n)
+
+	if s, ok := n.([]interface{}); ok && len(s) == 0 {
+		return nil
+	}
+
+	return n
+}
+
+func sameValue(a, b interface{}) bool {
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+// Changes returns the changes to the fields users can edit from t to updated,
+// the status, comments, key, project and workflow can't be edited
+func (t Ticket) Changes(updated Ticket) []FieldChange {
+	var changes []FieldChange
+
+	fields := []struct {
+		name     string
+		from, to interface{}
+	}{
+		{"summary", t.Summary, updated.Summary},
+		{"description", t.Description, updated.Description},
+		{"reporter", t.Reporter, updated.Reporter},
+		{"assignee", t.Assignee, updated.Assignee},
+		{"type", t.Type, updated.Type},
+		{"labels", t.Labels, updated.Labels},
+		{"watchers", t.Watchers, updated.Watchers},
+	}
+
+	for _, f := range fields {
+		if !sameValue(f.from, f.to) {
+			changes = append(changes, FieldChange{f.name, f.from, f.to})
+		}
+	}
+
+	var names []string
+	from := make(map[string]interface{})
+	to := make(map[string]interface{})
+
+	for _, f := range t.Fields {
+		names = append(names, f.Name)
+		from[f.Name] = f.Value
+	}
+
+	for _, f := range updated.Fields {
+		if _, ok := from[f.Name]; !ok {
+			names = append(names, f.Name)
+		}
+
+		to[f.Name] = f.Value
+	}
+
+	for _, name := range names {
+		if !sameValue(from[name], to[name]) {
+			changes = append(changes, FieldChange{"fields." + name, from[name], to[name]})
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,43 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package models
+
+import "testing"
+
+func TestTicketChanges(t *testing.T) {
+	ticket := Ticket{
+		Summary: "Before",
+		Labels:  nil,
+		Fields: []Field{
+			{Name: "Story Points", DataType: "INT", Value: 3},
+			{Name: "Priority", DataType: "OPT", Value: "Low"},
+		},
+	}
+
+	updated := ticket
+	updated.Summary = "After"
+	updated.Labels = []string{}
+	updated.Fields = []Field{
+		{Name: "Story Points", DataType: "INT", Value: float64(3)},
+		{Name: "Priority", DataType: "OPT", Value: "High"},
+	}
+
+	changes := ticket.Changes(updated)
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes Got %v", changes)
+	}
+
+	if changes[0].Field != "summary" || changes[0].From != "Before" || changes[0].To != "After" {
+		t.Errorf("Expected the summary change Got %v", changes[0])
+	}
+
+	if changes[1].Field != "fields.Priority" || changes[1].From != "Low" || changes[1].To != "High" {
+		t.Errorf("Expected the Priority change Got %v", changes[1])
+	}
+
+	if changes := ticket.Changes(ticket); len(changes) != 0 {
+		t.Errorf("Expected no changes Got %v", changes)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package models
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// HookDelivery records a request sent for a webhook and the response to it,
+// every attempt and redelivery is recorded separately. It is either for a hook
+// on a Transition of a Workflow or for a project's Webhook.
+type HookDelivery struct {
+	ID         bson.ObjectId `bson:"_id" json:"id"`
+	Workflow   bson.ObjectId `json:"workflow,omitempty" bson:",omitempty"`
+	Transition string        `json:"transition,omitempty"`
+	Webhook    bson.ObjectId `json:"webhook,omitempty" bson:",omitempty"`
+	Ticket     string        `json:"ticket"`
+
+	// RedeliveryOf is the delivery this one sent again
+	RedeliveryOf bson.ObjectId `json:"redeliveryOf,omitempty" bson:",omitempty"`
+
+	Request  DeliveryRequest  `json:"request"`
+	Response DeliveryResponse `json:"response"`
+
+	// Success is true when a response with a 2xx status was received
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	// Latency is how long the request took in milliseconds
+	Latency     int64     `json:"latency"`
+	CreatedDate time.Time `json:"createdDate"`
+}
+
+// DeliveryRequest is the request sent for a webhook
+type DeliveryRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// DeliveryResponse is the response to a webhook, Status is 0 if there wasn't
+// one
+type DeliveryResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+func (d HookDelivery) String() string {
+	return jsonString(d)
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package models
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The fields of history entries which aren't a change to a field, a created
+// entry has no values and a comment entry has the comment's id as its To
+const (
+	HistoryCreated = "created"
+	HistoryComment = "comment"
+)
+
+// HistoryEntry records a change to one field of a ticket, who made it and
+// when. Entries are never changed after they are made.
+type HistoryEntry struct {
+	ID     bson.ObjectId `bson:"_id" json:"id"`
+	Ticket string        `json:"ticket"`
+	Field  string        `json:"field"`
+	From   interface{}   `json:"from"`
+	To     interface{}   `json:"to"`
+	Actor  string        `json:"actor"`
+	Date   time.Time     `json:"date"`
+}
+
+func (h HistoryEntry) String() string {
+	return jsonString(h)
+}
+
+// NewHistory returns an entry for each of the changes u made to the ticket
+// with the given key, all made at the same time
+func NewHistory(u *User, ticketKey string, changes []FieldChange) []HistoryEntry {
+	var actor string
+	if u != nil {
+		actor = u.Username
+	}
+
+	now := time.Now()
+	entries := make([]HistoryEntry, len(changes))
+
+	for i, c := range changes {
+		entries[i] = HistoryEntry{
+			ID:     bson.NewObjectId(),
+			Ticket: ticketKey,
+			Field:  c.Field,
+			From:   c.From,
+			To:     c.To,
+			Actor:  actor,
+			Date:   now,
+		}
+	}
+
+	return entries
+}
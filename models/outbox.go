@@ -0,0 +1,99 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package models
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The types of event stored in the outbox, they match the event types in the
+// events package
+const (
+	CreatedEvent    = "CREATED"
+	UpdatedEvent    = "UPDATED"
+	CommentEvent    = "COMMENT"
+	TransitionEvent = "TRANSITION"
+)
+
+// The statuses an OutboxEvent can have
+const (
+	OutboxPending = "pending"
+	OutboxDone    = "done"
+	OutboxFailed  = "failed"
+)
+
+// OutboxEvent is a ticket event which is stored in the same operation as the
+// change to the ticket, so it is handled even if Praelatus stops before it
+// gets to it. It stays pending until every handler has handled it.
+type OutboxEvent struct {
+	ID            bson.ObjectId `bson:"_id" json:"id"`
+	Type          string        `json:"type"`
+	ActioningUser User          `json:"actioningUser"`
+	Project       string        `json:"project"`
+	Ticket        Ticket        `json:"ticket"`
+
+	Comment    *Comment    `json:"comment,omitempty" bson:",omitempty"`
+	Transition *Transition `json:"transition,omitempty" bson:",omitempty"`
+	// Changes are the fields which were changed by an update
+	Changes []FieldChange `json:"changes,omitempty" bson:",omitempty"`
+
+	Status string `json:"status"`
+	// Handled is the names of the handlers which have handled the event,
+	// they aren't run again when it is retried
+	Handled     []string  `json:"handled"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"lastError,omitempty"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	CreatedDate time.Time `json:"createdDate"`
+	UpdatedDate time.Time `json:"updatedDate"`
+}
+
+// NewOutboxEvent returns a pending event of the given type for the ticket,
+// only the public details of the actioning user are kept
+func NewOutboxEvent(eventType string, u *User, ticket Ticket) OutboxEvent {
+	var actioner User
+	if u != nil {
+		actioner = User{
+			Username:   u.Username,
+			Email:      u.Email,
+			FullName:   u.FullName,
+			ProfilePic: u.ProfilePic,
+			IsAdmin:    u.IsAdmin,
+		}
+	}
+
+	now := time.Now()
+
+	return OutboxEvent{
+		ID:            bson.NewObjectId(),
+		Type:          eventType,
+		ActioningUser: actioner,
+		Project:       ticket.Project,
+		Ticket:        ticket,
+		Status:        OutboxPending,
+		Handled:       []string{},
+		NextAttempt:   now,
+		CreatedDate:   now,
+		UpdatedDate:   now,
+	}
+}
+
+// HasHandled reports whether the handler with the given name has handled the
+// event
+func (e OutboxEvent) HasHandled(name string) bool {
+	for _, h := range e.Handled {
+		if h == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e OutboxEvent) String() string {
+	return jsonString(e)
+}
@@ -100,10 +100,15 @@ func (p Project) GetPermsForRoles(roles ...Role) permission.Permissions {
 }
 
 // HasPermission will return a slice of projects for which the given user has
-// the permission indicated out of the projects given.
+// the permission indicated out of the projects given. Users authenticated with
+// a scoped API token only have the permissions in its scopes.
 func HasPermission(permName permission.Permission, user User,
 	projects ...Project) []Project {
 
+	if len(user.Scopes) != 0 && !user.Scopes.Contains(permName) {
+		return []Project{}
+	}
+
 	if user.IsAdmin {
 		return projects
 	}
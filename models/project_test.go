@@ -136,4 +136,21 @@ func TestHashPermission(t *testing.T) {
 	if len(has) == 0 {
 		t.Error("Expected anon user to have view permission to public project.")
 	}
+	scoped := User{
+		Roles: []UserRole{
+			{
+				Project: "UNITTEST",
+				Role:    Role("Administrator"),
+			},
+		},
+		Scopes: permission.Permissions{}.Add(permission.CreateTicket),
+	}
+
+	if len(HasPermission(permission.CreateTicket, scoped, p)) == 0 {
+		t.Error("Expected scoped user to have permissions in their scopes.")
+	}
+
+	if len(HasPermission(permission.AdminProject, scoped, p)) != 0 {
+		t.Error("Expected scoped user to not have permissions outside their scopes.")
+	}
 }
@@ -4,10 +4,9 @@
 
 package models
 
-// Session ties a token to a specific client
+// Session is a token which has been issued and not yet revoked
 type Session struct {
-	Token    string `bson:"_id"`
-	ClientID string
+	Token string `bson:"_id"`
 }
 
 func (s Session) String() string {
@@ -5,10 +5,8 @@
 package models
 
 import (
-	"log"
 	"time"
 
-	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -58,19 +56,12 @@ func (t Ticket) String() string {
 	return jsonString(t)
 }
 
-// Transition searches through the available transitions for the ticket
-// returning a boolean indicating success or failure and the transition
-func (t Ticket) Transition(db *mgo.Database, name string) (Transition, bool) {
-	var workflow Workflow
-
-	err := db.C("workflows").FindId(t.Workflow).One(&workflow)
-	if err != nil {
-		log.Println(err.Error())
-		return Transition{}, false
-	}
-
+// Transition searches through the transitions of the given workflow for one
+// named name which can be performed from the ticket's current status,
+// returning the transition and a boolean indicating success or failure
+func (t Ticket) Transition(workflow Workflow, name string) (Transition, bool) {
 	for _, transition := range workflow.Transitions {
-		if transition.Name == name && t.Status == transition.FromStatus {
+		if transition.Name == name && transition.ValidFrom(t.Status) {
 			return transition, true
 		}
 	}
@@ -78,6 +69,20 @@ func (t Ticket) Transition(db *mgo.Database, name string) (Transition, bool) {
 	return Transition{}, false
 }
 
+// AvailableTransitions returns the transitions of the given workflow which can
+// be performed from the ticket's current status
+func (t Ticket) AvailableTransitions(workflow Workflow) []Transition {
+	available := make([]Transition, 0)
+
+	for _, transition := range workflow.Transitions {
+		if transition.ValidFrom(t.Status) {
+			available = append(available, transition)
+		}
+	}
+
+	return available
+}
+
 // Comment is a comment on an issue / ticket.
 type Comment struct {
 	ID          bson.ObjectId `json:"id"`
@@ -85,8 +90,33 @@ type Comment struct {
 	CreatedDate time.Time     `json:"createdDate"`
 	Body        string        `json:"body" required:"true"`
 	Author      string        `json:"author" required:"true"`
+	Attachments []Attachment  `json:"attachments,omitempty"`
 }
 
 func (c *Comment) String() string {
 	return jsonString(c)
 }
+
+// Attachment is a file attached to a comment, Path is where the file store
+// keeps it
+type Attachment struct {
+	ID          bson.ObjectId `json:"id"`
+	Name        string        `json:"name"`
+	ContentType string        `json:"contentType"`
+	Size        int64         `json:"size"`
+	Path        string        `json:"-"`
+}
+
+// Attachment returns the attachment with the given ID from any of the
+// ticket's comments
+func (t Ticket) Attachment(id string) (Attachment, bool) {
+	for _, c := range t.Comments {
+		for _, a := range c.Attachments {
+			if a.ID.Hex() == id {
+				return a, true
+			}
+		}
+	}
+
+	return Attachment{}, false
+}
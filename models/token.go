@@ -0,0 +1,38 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package models
+
+import (
+	"time"
+
+	"github.com/praelatus/praelatus/models/permission"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// APIToken is a named long lived token which a user can create for scripts and
+// CI so they don't need to store the user's password. Only a hash of the
+// token's secret is stored, the token itself is only shown when it's created.
+type APIToken struct {
+	ID       bson.ObjectId `bson:"_id" json:"id"`
+	Name     string        `json:"name" required:"true"`
+	Username string        `json:"username"`
+	Hash     string        `json:"-"`
+
+	// Scopes limits the token to the given permissions, a token without
+	// scopes has every permission its user has.
+	Scopes permission.Permissions `json:"scopes"`
+
+	CreatedDate time.Time  `json:"createdDate"`
+	ExpiresDate *time.Time `json:"expiresDate,omitempty"`
+}
+
+// Expired reports whether the token can no longer be used
+func (t APIToken) Expired() bool {
+	return t.ExpiresDate != nil && !t.ExpiresDate.After(time.Now())
+}
+
+func (t APIToken) String() string {
+	return jsonString(t)
+}
@@ -11,6 +11,7 @@ import (
 
 	"log"
 
+	"github.com/praelatus/praelatus/models/permission"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -23,6 +24,13 @@ type Sanitizer interface {
 // Users is an alias for a slice of Users which implements Sanitize
 type Users []User
 
+// Inactive will return true if the user can't log in. Only users who still
+// have to verify their email are inactive, IsActive isn't checked since
+// accounts created before it was set on new users have it false.
+func (u User) Inactive() bool {
+	return u.PendingVerification
+}
+
 // Sanitize implements models.Sanitizer so that we don't send sensitive info
 // back to the client
 func (ur Users) Sanitize() interface{} {
@@ -52,7 +60,57 @@ type User struct {
 	IsActive   bool     `json:"isActive,omitempty"`
 	Settings   Settings `json:"settings,omitempty"`
 
+	// PendingVerification is set for users who registered themselves until
+	// they verify their email, they can't log in until they do
+	PendingVerification bool `json:"pendingVerification,omitempty"`
+
+	// EmailVerified is set once the user has proven they own their email by
+	// following a verification or password reset link, it is cleared when
+	// their email changes
+	EmailVerified bool `json:"emailVerified,omitempty"`
+
+	// Provider is the authentication provider which created the user, it
+	// is empty for local accounts
+	Provider string `json:"provider,omitempty"`
+
 	Roles []UserRole `json:"roles"`
+
+	TwoFactor TwoFactor `json:"twoFactor"`
+
+	// ChatAccount is the chat user whose slash commands run as this user,
+	// it is empty until the user links one
+	ChatAccount ChatAccount `json:"chatAccount"`
+
+	// Scopes limits the user to the given permissions when they are
+	// authenticated with a scoped API token, it is never stored.
+	Scopes permission.Permissions `json:"-" bson:"-"`
+}
+
+// ChatAccount identifies a chat user by the team, or workspace, they belong to
+// and their id in it. Unlike usernames chat users can't change these.
+type ChatAccount struct {
+	TeamID string `json:"teamId"`
+	UserID string `json:"userId"`
+}
+
+// Linked reports whether the account identifies a chat user
+func (c ChatAccount) Linked() bool {
+	return c.TeamID != "" && c.UserID != ""
+}
+
+// TwoFactor is a user's enrollment in TOTP two-factor authentication. Secret
+// is set when they start enrolling and Enabled once they have confirmed it
+// with a code from their authenticator.
+type TwoFactor struct {
+	Enabled bool   `json:"enabled"`
+	Secret  string `json:"-"`
+
+	// RecoveryCodes are the SHA-256 hashes of the unused recovery codes
+	RecoveryCodes []string `json:"-"`
+
+	// LastCounter is the time step of the last code used so that a code
+	// can't be used twice
+	LastCounter int64 `json:"-"`
 }
 
 // CheckPw will verify if the given password matches for this user. Logs any
@@ -104,9 +162,15 @@ func (u User) RolesForProject(p Project) []Role {
 	return roles
 }
 
+// HashPassword returns the hash of the password which is stored for users
+func HashPassword(password string) (string, error) {
+	pw, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(pw), err
+}
+
 // NewUser will create the user after encrypting the password with bcrypt
 func NewUser(username, password, fullName, email string, admin bool) (*User, error) {
-	pw, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	pw, err := HashPassword(password)
 	if err != nil {
 		return &User{}, err
 	}
@@ -116,16 +180,52 @@ func NewUser(username, password, fullName, email string, admin bool) (*User, err
 
 	return &User{
 		Username:   username,
-		Password:   string(pw),
+		Password:   pw,
 		Email:      email,
 		FullName:   fullName,
 		ProfilePic: "https://www.gravatar.com/avatar/" + eh,
 		IsAdmin:    admin,
+		IsActive:   true,
 	}, nil
 }
 
 // Settings represents an individual users preferences
 type Settings struct {
-	DefaultProject string `json:"defaultProject,omitempty"`
-	DefaultView    string `json:"defaultView,omitempty"`
+	DefaultProject string        `json:"defaultProject,omitempty"`
+	DefaultView    string        `json:"defaultView,omitempty"`
+	Email          EmailSettings `json:"email"`
+}
+
+// EmailSettings controls which notifications about tickets a user is watching
+// are emailed to them
+type EmailSettings struct {
+	// Disabled stops all notification emails
+	Disabled bool `json:"disabled"`
+
+	// Events are the event types which are emailed, if empty every type is
+	Events []string `json:"events,omitempty"`
+
+	// Digest batches notifications into one email sent periodically instead
+	// of sending an email for each
+	Digest bool `json:"digest"`
+}
+
+// Wants returns true if notifications of the given event type should be
+// emailed
+func (es EmailSettings) Wants(eventType string) bool {
+	if es.Disabled {
+		return false
+	}
+
+	if len(es.Events) == 0 {
+		return true
+	}
+
+	for _, t := range es.Events {
+		if strings.EqualFold(t, eventType) {
+			return true
+		}
+	}
+
+	return false
 }
@@ -0,0 +1,76 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package models
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The formats webhooks can send events in, the JSON payload of the event is
+// sent when a webhook has no format
+const (
+	WebhookJSON       = "json"
+	WebhookSlack      = "slack"
+	WebhookMattermost = "mattermost"
+	WebhookMatrix     = "matrix"
+)
+
+// WebhookFormats are all of the formats webhooks can send events in
+var WebhookFormats = []string{WebhookJSON, WebhookSlack, WebhookMattermost, WebhookMatrix}
+
+// Webhook sends events of the given types for tickets in a project to URL.
+// When Query is set only events for tickets matching the PQL query are sent.
+// Format is how the events are sent, chat formats send a message for the
+// event instead of its JSON. When Secret is set requests are signed with it,
+// except for Matrix which uses it as the access token.
+type Webhook struct {
+	ID          bson.ObjectId `bson:"_id" json:"id"`
+	Project     string        `json:"project"`
+	URL         string        `json:"url" required:"true"`
+	Events      []string      `json:"events" required:"true"`
+	Query       string        `json:"query,omitempty"`
+	Format      string        `json:"format,omitempty"`
+	Secret      string        `json:"secret,omitempty"`
+	CreatedDate time.Time     `json:"createdDate"`
+}
+
+// Wants reports whether the webhook is sent events of the given type
+func (w Webhook) Wants(eventType string) bool {
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Sanitize implements models.Sanitizer so that the secret isn't sent back to
+// the client
+func (w Webhook) Sanitize() interface{} {
+	w.Secret = ""
+	return w
+}
+
+func (w Webhook) String() string {
+	return jsonString(w)
+}
+
+// Webhooks is an alias for a slice of Webhooks which implements Sanitize
+type Webhooks []Webhook
+
+// Sanitize implements models.Sanitizer so that secrets aren't sent back to the
+// client
+func (ws Webhooks) Sanitize() interface{} {
+	sanitized := make([]interface{}, len(ws))
+
+	for i := range ws {
+		sanitized[i] = ws[i].Sanitize()
+	}
+
+	return sanitized
+}
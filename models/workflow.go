@@ -17,10 +17,57 @@ func (w Workflow) String() string {
 	return jsonString(w)
 }
 
+// Sanitize implements models.Sanitizer so that hook secrets aren't sent back
+// to the client
+func (w Workflow) Sanitize() interface{} {
+	w.Transitions = Transitions(w.Transitions).sanitized()
+	return w
+}
+
+// KeepSecrets will give hooks without a secret the secret of the same hook in
+// the stored workflow, secrets are never sent to clients so they don't send
+// them back when updating a workflow
+func (w *Workflow) KeepSecrets(stored Workflow) {
+	for i, t := range w.Transitions {
+		for j, h := range t.Hooks {
+			if h.Secret != "" {
+				continue
+			}
+
+			for _, st := range stored.Transitions {
+				if st.Name != t.Name {
+					continue
+				}
+
+				for _, sh := range st.Hooks {
+					if sh.Endpoint == h.Endpoint && sh.Method == h.Method {
+						w.Transitions[i].Hooks[j].Secret = sh.Secret
+					}
+				}
+			}
+		}
+	}
+}
+
+// Workflows is an alias for a slice of Workflows which implements Sanitize
+type Workflows []Workflow
+
+// Sanitize implements models.Sanitizer so that hook secrets aren't sent back
+// to the client
+func (ws Workflows) Sanitize() interface{} {
+	sanitized := make([]interface{}, len(ws))
+
+	for i := range ws {
+		sanitized[i] = ws[i].Sanitize()
+	}
+
+	return sanitized
+}
+
 // CreateTransition will return the transition to perform on a ticket during creation
 func (w Workflow) CreateTransition() Transition {
 	for _, t := range w.Transitions {
-		if t.FromStatus.Name == "Create" {
+		if t.IsCreate() {
 			return t
 		}
 	}
@@ -41,12 +88,61 @@ func (t Transition) String() string {
 	return jsonString(t)
 }
 
+// Transitions is an alias for a slice of Transitions which implements
+// Sanitize
+type Transitions []Transition
+
+// Sanitize implements models.Sanitizer so that hook secrets aren't sent back
+// to the client
+func (ts Transitions) Sanitize() interface{} {
+	return ts.sanitized()
+}
+
+func (ts Transitions) sanitized() []Transition {
+	sanitized := make([]Transition, len(ts))
+
+	for i, t := range ts {
+		if len(t.Hooks) != 0 {
+			hooks := make([]Hook, len(t.Hooks))
+
+			for j, h := range t.Hooks {
+				h.Secret = ""
+				hooks[j] = h
+			}
+
+			t.Hooks = hooks
+		}
+
+		sanitized[i] = t
+	}
+
+	return sanitized
+}
+
+// IsCreate reports whether this is the transition run on ticket creation
+func (t Transition) IsCreate() bool {
+	return t.FromStatus.Name == "Create"
+}
+
+// ValidFrom reports whether this transition can be performed on a ticket in
+// the given status. Transitions whose FromStatus is of type StatusNull can be
+// performed from any status, except for the create transition.
+func (t Transition) ValidFrom(status Status) bool {
+	if t.IsCreate() {
+		return false
+	}
+
+	return t.FromStatus == status || t.FromStatus.Type == StatusNull
+}
+
 // Hook contains information about what webhooks to fire when a given
-// transition is run.
+// transition is run. When Secret is set requests are signed with it so the
+// endpoint can check they came from Praelatus.
 type Hook struct {
 	Endpoint string `json:"endpoint"`
 	Method   string `json:"method"`
 	Body     string `json:"body"`
+	Secret   string `json:"secret,omitempty"`
 }
 
 func (h Hook) String() string {
@@ -6,6 +6,7 @@
 package ast
 
 import (
+	"errors"
 	"strings"
 	"time"
 
@@ -28,6 +29,46 @@ func (a AST) String() string {
 	return q
 }
 
+// ErrInvalidExpression is returned for queries which parse but aren't
+// comparisons of fields to values joined by AND and OR, such as a bare field
+var ErrInvalidExpression = errors.New("queries must compare fields to values")
+
+// Validate returns ErrInvalidExpression unless the query is empty or only
+// compares fields to values, which is all the repos know how to search for
+func (a AST) Validate() error {
+	if a.Query.Expression == nil {
+		return nil
+	}
+
+	return validate(a.Query.Expression)
+}
+
+func validate(exp Expression) error {
+	infix, ok := exp.(InfixExpression)
+	if !ok {
+		return ErrInvalidExpression
+	}
+
+	switch infix.Operator {
+	case "AND", "OR":
+		if err := validate(infix.Left); err != nil {
+			return err
+		}
+
+		return validate(infix.Right)
+	}
+
+	if _, ok := infix.Left.(FieldLiteral); !ok {
+		return ErrInvalidExpression
+	}
+
+	if _, ok := infix.Right.(Literal); !ok {
+		return ErrInvalidExpression
+	}
+
+	return nil
+}
+
 // TODO: Implement methods to make traversing the tree simpler.
 
 // Expression represents an AST node that evaluates to a value
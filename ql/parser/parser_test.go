@@ -119,3 +119,33 @@ func TestParseLimitOrderBy(t *testing.T) {
 		t.Errorf("Unexpected Parsing Error Got: %s \nErrors: %v", tree.String(), p.Errors())
 	}
 }
+
+func TestValidate(t *testing.T) {
+	valid := []string{
+		"",
+		"summary = \"test\"",
+		"summary = \"test this parser\" OR (project = \"TEST\" AND (key = \"TEST-1\"))",
+	}
+
+	for _, q := range valid {
+		if err := New(lexer.New(q)).Parse().Validate(); err != nil {
+			t.Errorf("Expected %s to be valid Got %v", q, err)
+		}
+	}
+
+	tree := New(lexer.New("summary")).Parse()
+	if err := tree.Validate(); err != ast.ErrInvalidExpression {
+		t.Errorf("Expected a bare field to be invalid Got %v", err)
+	}
+
+	nested := New(lexer.New("key = \"TEST-1\"")).Parse()
+	nested.Query.Expression = ast.InfixExpression{
+		Left:     nested.Query.Expression,
+		Operator: "AND",
+		Right:    tree.Query.Expression,
+	}
+
+	if err := nested.Validate(); err != ast.ErrInvalidExpression {
+		t.Errorf("Expected a bare field inside AND to be invalid Got %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package bolt
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/ql/ast"
+	"github.com/praelatus/praelatus/ql/token"
+)
+
+// fieldValue returns the value of the PQL field name on the ticket, anything
+// which isn't a default field is looked up in the ticket's custom fields. The
+// boolean is false if the ticket has no such field.
+func fieldValue(t models.Ticket, name string) (interface{}, bool) {
+	switch strings.ToLower(name) {
+	case "createddate":
+		return t.CreatedDate, true
+	case "updateddate":
+		return t.UpdatedDate, true
+	case "key":
+		return t.Key, true
+	case "summary":
+		return t.Summary, true
+	case "description":
+		return t.Description, true
+	case "status":
+		return t.Status.Name, true
+	case "statuscategory":
+		return string(t.Status.Type), true
+	case "reporter":
+		return t.Reporter, true
+	case "assignee":
+		return t.Assignee, true
+	case "type":
+		return t.Type, true
+	case "project":
+		return t.Project, true
+	case "labels":
+		return t.Labels, true
+	}
+
+	for _, f := range t.Fields {
+		if f.Name == name {
+			return f.Value, f.Value != nil
+		}
+	}
+
+	return nil, false
+}
+
+// toFloat converts any of the numeric types a field value can have
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+// compareValues returns -1, 0 or 1 if a is less than, equal to or greater
+// than b. The boolean is false if the values can't be compared.
+func compareValues(a, b interface{}) (int, bool) {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		default:
+			return 0, true
+		}
+	}
+
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+
+		return strings.Compare(x, y), true
+	case time.Time:
+		y, ok := b.(time.Time)
+		if !ok {
+			return 0, false
+		}
+
+		switch {
+		case x.Before(y):
+			return -1, true
+		case x.After(y):
+			return 1, true
+		default:
+			return 0, true
+		}
+	}
+
+	return 0, false
+}
+
+// match reports whether value op literal is true
+func match(value interface{}, op string, literal interface{}) bool {
+	if op == "~" {
+		return matcher(fmt.Sprint(literal))(fmt.Sprint(value))
+	}
+
+	c, ok := compareValues(value, literal)
+	if !ok {
+		return op == "!="
+	}
+
+	switch op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case ">":
+		return c > 0
+	case "<":
+		return c < 0
+	case ">=":
+		return c >= 0
+	case "<=":
+		return c <= 0
+	default:
+		return true
+	}
+}
+
+func eval(exp ast.InfixExpression, t models.Ticket) bool {
+	switch exp.Operator {
+	case "AND", "OR":
+		left, ok := exp.Left.(ast.InfixExpression)
+		if !ok {
+			return true
+		}
+
+		right, ok := exp.Right.(ast.InfixExpression)
+		if !ok {
+			return true
+		}
+
+		if exp.Operator == "AND" {
+			return eval(left, t) && eval(right, t)
+		}
+
+		return eval(left, t) || eval(right, t)
+	}
+
+	val, ok := exp.Right.(ast.Literal)
+	if !ok {
+		return true
+	}
+
+	fn, ok := exp.Left.(ast.FieldLiteral)
+	if !ok {
+		return true
+	}
+
+	value, ok := fieldValue(t, fn.Value)
+	if !ok {
+		return false
+	}
+
+	labels, ok := value.([]string)
+	if !ok {
+		return match(value, exp.Operator, val.GetValue())
+	}
+
+	// Tickets which have no labels should match a != query so check that no
+	// label is equal instead.
+	if exp.Operator == "!=" {
+		for _, label := range labels {
+			if match(label, "=", val.GetValue()) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	for _, label := range labels {
+		if match(label, exp.Operator, val.GetValue()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// evalAST reports whether the ticket matches the query, an empty query matches
+// every ticket
+func evalAST(a ast.AST, t models.Ticket) bool {
+	infix, ok := a.Query.Expression.(ast.InfixExpression)
+	if !ok {
+		return true
+	}
+
+	return eval(infix, t)
+}
+
+// keyNumber returns the numeric part of a ticket key
+func keyNumber(key string) int {
+	n, _ := strconv.Atoi(key[strings.LastIndex(key, "-")+1:])
+	return n
+}
+
+// applyModifiers will sort and limit the tickets according to the modifiers
+// of the given query. Tickets are ordered by creation by default.
+func applyModifiers(a ast.AST, tks []models.Ticket) []models.Ticket {
+	orderBy := "createdDate"
+	limit := -1
+
+	for _, mod := range a.Modifiers {
+		switch mod.Token.Type {
+		case token.ORDER:
+			fn, ok := mod.Value.(ast.FieldLiteral)
+			if !ok {
+				continue
+			}
+
+			orderBy = fn.Value
+		case token.LIMIT:
+			il, ok := mod.Value.(ast.IntegerLiteral)
+			if !ok {
+				continue
+			}
+
+			limit = int(il.Value)
+		}
+	}
+
+	sort.SliceStable(tks, func(i, j int) bool {
+		a, _ := fieldValue(tks[i], orderBy)
+		b, _ := fieldValue(tks[j], orderBy)
+
+		if c, ok := compareValues(a, b); ok && c != 0 {
+			return c < 0
+		}
+
+		if tks[i].Project != tks[j].Project {
+			return tks[i].Project < tks[j].Project
+		}
+
+		return keyNumber(tks[i].Key) < keyNumber(tks[j].Key)
+	})
+
+	if limit >= 0 && limit < len(tks) {
+		tks = tks[:limit]
+	}
+
+	return tks
+}
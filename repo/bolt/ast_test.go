@@ -0,0 +1,117 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package bolt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/ql/ast"
+	"github.com/praelatus/praelatus/ql/lexer"
+	"github.com/praelatus/praelatus/ql/parser"
+)
+
+var testTickets = []models.Ticket{
+	{
+		Key:         "TEST-1",
+		Project:     "TEST",
+		Summary:     "test",
+		Assignee:    "bob",
+		CreatedDate: time.Now(),
+		Labels:      []string{"bug"},
+		Fields: []models.Field{
+			{Name: "storyPoints", DataType: models.IntField, Value: 3},
+		},
+	},
+	{
+		Key:         "TEST-2",
+		Project:     "TEST",
+		Summary:     "test this parser",
+		Assignee:    "alice",
+		CreatedDate: time.Now(),
+		Fields: []models.Field{
+			{Name: "storyPoints", DataType: models.IntField, Value: 8},
+		},
+	},
+	{
+		Key:         "OTHER-1",
+		Project:     "OTHER",
+		Summary:     "test",
+		Assignee:    "carol",
+		CreatedDate: time.Now(),
+	},
+}
+
+func parse(t *testing.T, query string) ast.AST {
+	p := parser.New(lexer.New(query))
+	a := p.Parse()
+
+	if p.Errors() != nil {
+		t.Fatalf("Parsing error: %v", p.Errors())
+	}
+
+	return a
+}
+
+func matching(a ast.AST) []string {
+	keys := []string{}
+
+	for _, tk := range testTickets {
+		if evalAST(a, tk) {
+			keys = append(keys, tk.Key)
+		}
+	}
+
+	return keys
+}
+
+func TestSimpleEval(t *testing.T) {
+	keys := matching(parse(t, "summary = \"test\""))
+
+	if len(keys) != 2 || keys[0] != "TEST-1" || keys[1] != "OTHER-1" {
+		t.Errorf("Expected: [TEST-1 OTHER-1] Got: %v", keys)
+	}
+}
+
+func TestComplexEval(t *testing.T) {
+	keys := matching(parse(t, "summary = \"test this parser\" OR (project = \"OTHER\" AND (key = \"OTHER-1\"))"))
+
+	if len(keys) != 2 || keys[0] != "TEST-2" || keys[1] != "OTHER-1" {
+		t.Errorf("Expected: [TEST-2 OTHER-1] Got: %v", keys)
+	}
+}
+
+func TestCustomFieldEval(t *testing.T) {
+	keys := matching(parse(t, "storyPoints > 5"))
+
+	if len(keys) != 1 || keys[0] != "TEST-2" {
+		t.Errorf("Expected: [TEST-2] Got: %v", keys)
+	}
+}
+
+func TestLabelsEval(t *testing.T) {
+	keys := matching(parse(t, "labels != \"bug\""))
+
+	if len(keys) != 2 || keys[0] != "TEST-2" || keys[1] != "OTHER-1" {
+		t.Errorf("Expected: [TEST-2 OTHER-1] Got: %v", keys)
+	}
+}
+
+func TestModifiers(t *testing.T) {
+	tks := make([]models.Ticket, len(testTickets))
+	copy(tks, testTickets)
+
+	tks = applyModifiers(parse(t, "summary = \"test\" ORDER_BY assignee LIMIT 2"), tks)
+
+	if len(tks) != 2 {
+		t.Errorf("Expected 2 tickets Got %d", len(tks))
+		return
+	}
+
+	if tks[0].Assignee != "alice" || tks[1].Assignee != "bob" {
+		t.Errorf("Expected: alice, bob Got: %s, %s", tks[0].Assignee, tks[1].Assignee)
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+// Package bolt implements repo.Repo for an embedded BoltDB database file so
+// that praelatus can run without any external services.
+package bolt
+
+import (
+	"errors"
+	"log"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/praelatus/praelatus/config"
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/models/permission"
+	"github.com/praelatus/praelatus/repo"
+	boltdb "go.etcd.io/bbolt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var dbLog = log.New(config.LogWriter(), "[BOLT] ", log.LstdFlags)
+
+// BoltDB bucket names
+const (
+	projects      = "projects"
+	fieldSchemes  = "field_schemes"
+	tickets       = "tickets"
+	users         = "users"
+	workflows     = "workflows"
+	notifications = "notifications"
+	tokens        = "tokens"
+	outbox        = "outbox"
+	deliveries    = "deliveries"
+	webhooks      = "webhooks"
+	history       = "history"
+)
+
+var buckets = []string{
+	projects,
+	fieldSchemes,
+	tickets,
+	users,
+	workflows,
+	notifications,
+	tokens,
+	outbox,
+	deliveries,
+	webhooks,
+	history,
+}
+
+// errExists is returned when creating a document with a key that is taken
+var errExists = errors.New("already exists")
+
+func boltErr(e error) error {
+	if e == nil {
+		return e
+	}
+
+	if e != repo.ErrNotFound {
+		dbLog.Println("ERROR:", e)
+	}
+
+	return e
+}
+
+// get will decode the document stored at key in bucket into v returning
+// repo.ErrNotFound if there is no such document
+func get(tx *boltdb.Tx, bucket, key string, v interface{}) error {
+	data := tx.Bucket([]byte(bucket)).Get([]byte(key))
+	if data == nil {
+		return repo.ErrNotFound
+	}
+
+	return bson.Unmarshal(data, v)
+}
+
+// put will encode v and store it at key in bucket
+func put(tx *boltdb.Tx, bucket, key string, v interface{}) error {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket([]byte(bucket)).Put([]byte(key), data)
+}
+
+// remove will delete the document stored at key in bucket returning
+// repo.ErrNotFound if there is no such document
+func remove(tx *boltdb.Tx, bucket, key string) error {
+	b := tx.Bucket([]byte(bucket))
+	if b.Get([]byte(key)) == nil {
+		return repo.ErrNotFound
+	}
+
+	return b.Delete([]byte(key))
+}
+
+// exists reports whether there is a document stored at key in bucket
+func exists(tx *boltdb.Tx, bucket, key string) bool {
+	return tx.Bucket([]byte(bucket)).Get([]byte(key)) != nil
+}
+
+// each will call fn with every raw document in bucket in key order
+func each(tx *boltdb.Tx, bucket string, fn func(data []byte) error) error {
+	return tx.Bucket([]byte(bucket)).ForEach(func(k, v []byte) error {
+		return fn(v)
+	})
+}
+
+// dbUser will load the stored copy of u so that their roles are available
+// for permission checks, sessions do not carry roles. An anonymous user is
+// returned for nil and users which are not stored are returned as is. The
+// scopes of the session are kept so API tokens stay limited to them.
+func dbUser(tx *boltdb.Tx, u *models.User) models.User {
+	if u == nil {
+		return models.User{}
+	}
+
+	var user models.User
+
+	err := get(tx, users, u.Username, &user)
+	if err != nil {
+		return *u
+	}
+
+	user.IsAdmin = user.IsAdmin || u.IsAdmin
+	user.Scopes = u.Scopes
+	return user
+}
+
+// canView reports whether the user is allowed to view the given project
+func canView(user models.User, p models.Project) bool {
+	return p.Public || len(models.HasPermission(permission.ViewProject, user, p)) != 0
+}
+
+// matcher returns a function which reports whether any of the given strings
+// match query case insensitively, queries are regular expressions like in the
+// mongo repo. An empty query matches everything.
+func matcher(query string) func(strs ...string) bool {
+	if query == "" {
+		return func(strs ...string) bool { return true }
+	}
+
+	re, err := regexp.Compile("(?i)" + query)
+	if err != nil {
+		re = regexp.MustCompile("(?i)" + regexp.QuoteMeta(query))
+	}
+
+	return func(strs ...string) bool {
+		for _, s := range strs {
+			if re.MatchString(s) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Repo contains all model specific repos.
+type Repo struct {
+	DB            *boltdb.DB
+	tickets       ticketRepo
+	users         userRepo
+	projects      projectRepo
+	fieldSchemes  fieldSchemeRepo
+	workflows     workflowRepo
+	notifications notificationRepo
+	tokens        tokenRepo
+	outbox        outboxRepo
+	deliveries    deliveryRepo
+	webhooks      webhookRepo
+}
+
+// Fields returns the fieldSchemeRepo implementation for bolt
+func (r Repo) Fields() repo.FieldSchemeRepo {
+	return r.fieldSchemes
+}
+
+// Tickets returns the ticketRepo implementation for bolt
+func (r Repo) Tickets() repo.TicketRepo {
+	return r.tickets
+}
+
+// Projects returns the projectRepo implementation for bolt
+func (r Repo) Projects() repo.ProjectRepo {
+	return r.projects
+}
+
+// Workflows returns the workflowRepo implementation for bolt
+func (r Repo) Workflows() repo.WorkflowRepo {
+	return r.workflows
+}
+
+// Notifications returns the notificationRepo implementation for bolt
+func (r Repo) Notifications() repo.NotificationRepo {
+	return r.notifications
+}
+
+// Tokens returns the tokenRepo implementation for bolt
+func (r Repo) Tokens() repo.TokenRepo {
+	return r.tokens
+}
+
+// Outbox returns the outboxRepo implementation for bolt
+func (r Repo) Outbox() repo.OutboxRepo {
+	return r.outbox
+}
+
+// Deliveries returns the deliveryRepo implementation for bolt
+func (r Repo) Deliveries() repo.DeliveryRepo {
+	return r.deliveries
+}
+
+// Webhooks returns the webhookRepo implementation for bolt
+func (r Repo) Webhooks() repo.WebhookRepo {
+	return r.webhooks
+}
+
+// Users returns the userRepo implementation for bolt
+func (r Repo) Users() repo.UserRepo {
+	return r.users
+}
+
+// Clean will remove all data from the database
+func (r Repo) Clean() error {
+	return r.DB.Update(func(tx *boltdb.Tx) error {
+		for _, name := range buckets {
+			err := tx.DeleteBucket([]byte(name))
+			if err != nil && err != boltdb.ErrBucketNotFound {
+				return err
+			}
+
+			_, err = tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Test will test that the database file is still open
+func (r Repo) Test() error {
+	return r.DB.View(func(tx *boltdb.Tx) error {
+		return nil
+	})
+}
+
+// Init will create any buckets which do not exist yet
+func (r Repo) Init() error {
+	return r.DB.Update(func(tx *boltdb.Tx) error {
+		for _, name := range buckets {
+			_, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// New will open or create the BoltDB database file at path and return the
+// repo.Repo that is backed by it.
+func New(path string) repo.Repo {
+	db, err := boltdb.Open(path, 0600, &boltdb.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		dbLog.Printf("Unable to open database: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	r := Repo{
+		DB:            db,
+		tickets:       ticketRepo{db},
+		projects:      projectRepo{db},
+		workflows:     workflowRepo{db},
+		fieldSchemes:  fieldSchemeRepo{db},
+		users:         userRepo{db},
+		notifications: notificationRepo{db},
+		tokens:        tokenRepo{db},
+		outbox:        outboxRepo{db},
+		deliveries:    deliveryRepo{db},
+		webhooks:      webhookRepo{db},
+	}
+
+	err = r.Init()
+	if err != nil {
+		dbLog.Printf("Unable to create buckets: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	return r
+}
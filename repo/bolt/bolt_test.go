@@ -0,0 +1,70 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package bolt_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"github.com/praelatus/praelatus/repo/bolt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var r repo.Repo
+
+var admin = models.User{
+	Username: "testadmin",
+	IsAdmin:  true,
+}
+
+var user = models.User{
+	Username: "testuser",
+	IsAdmin:  false,
+}
+
+var fsID bson.ObjectId
+var wID bson.ObjectId
+
+func TestMain(m *testing.M) {
+	dir, e := ioutil.TempDir("", "praelatus-bolt")
+	if e != nil {
+		panic(e)
+	}
+
+	r = bolt.New(filepath.Join(dir, "praelatus.db"))
+	e = r.Clean()
+	if e != nil {
+		panic(e)
+	}
+
+	e = repo.Seed(r)
+	if e != nil {
+		panic(e)
+	}
+
+	fs, e := r.Fields().Search(&admin, "")
+	if e != nil || len(fs) == 0 {
+		panic("Should have seeded field schemes.")
+	}
+
+	fsID = fs[0].ID
+
+	ws, e := r.Workflows().Search(&admin, "One Way")
+	if e != nil || len(ws) == 0 {
+		panic("Should have seeded workflows.")
+	}
+
+	// Use the workflow which the seeded tickets are not using so deleting
+	// it doesn't affect the ticket tests.
+	wID = ws[0].ID
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,140 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package bolt
+
+import (
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	boltdb "go.etcd.io/bbolt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type deliveryRepo struct {
+	db *boltdb.DB
+}
+
+// eachDelivery will call fn with every delivery, keys are object ids so they
+// are in the order they were created
+func eachDelivery(tx *boltdb.Tx, fn func(d models.HookDelivery) error) error {
+	return each(tx, deliveries, func(data []byte) error {
+		var d models.HookDelivery
+
+		err := bson.Unmarshal(data, &d)
+		if err != nil {
+			return err
+		}
+
+		return fn(d)
+	})
+}
+
+func (dr deliveryRepo) Get(u *models.User, uid string) (models.HookDelivery, error) {
+	var d models.HookDelivery
+
+	if u == nil || !u.IsAdmin {
+		return d, repo.ErrAdminRequired
+	}
+
+	err := dr.db.View(func(tx *boltdb.Tx) error {
+		return get(tx, deliveries, uid, &d)
+	})
+
+	return d, boltErr(err)
+}
+
+func (dr deliveryRepo) Create(u *models.User, d models.HookDelivery) (models.HookDelivery, error) {
+	if u == nil || !u.IsAdmin {
+		return d, repo.ErrAdminRequired
+	}
+
+	if d.ID == "" {
+		d.ID = bson.NewObjectId()
+	}
+
+	err := dr.db.Update(func(tx *boltdb.Tx) error {
+		if exists(tx, deliveries, d.ID.Hex()) {
+			return errExists
+		}
+
+		return put(tx, deliveries, d.ID.Hex(), d)
+	})
+
+	return d, boltErr(err)
+}
+
+// newest returns up to limit of the deliveries which match, newest first
+func (dr deliveryRepo) newest(limit int, match func(d models.HookDelivery) bool) ([]models.HookDelivery, error) {
+	var found []models.HookDelivery
+
+	err := dr.db.View(func(tx *boltdb.Tx) error {
+		return eachDelivery(tx, func(d models.HookDelivery) error {
+			if match(d) {
+				found = append(found, d)
+			}
+
+			return nil
+		})
+	})
+
+	ds := []models.HookDelivery{}
+
+	for i := len(found) - 1; i >= 0 && len(ds) < limit; i-- {
+		ds = append(ds, found[i])
+	}
+
+	return ds, boltErr(err)
+}
+
+func (dr deliveryRepo) ForWorkflow(u *models.User, workflowID string, limit int) ([]models.HookDelivery, error) {
+	if u == nil || !u.IsAdmin {
+		return nil, repo.ErrAdminRequired
+	}
+
+	return dr.newest(limit, func(d models.HookDelivery) bool {
+		return d.Workflow.Hex() == workflowID
+	})
+}
+
+func (dr deliveryRepo) ForWebhook(u *models.User, webhookID string, limit int) ([]models.HookDelivery, error) {
+	if u == nil || !u.IsAdmin {
+		return nil, repo.ErrAdminRequired
+	}
+
+	return dr.newest(limit, func(d models.HookDelivery) bool {
+		return d.Webhook.Hex() == webhookID
+	})
+}
+
+func (dr deliveryRepo) Prune(u *models.User, before time.Time) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	return boltErr(dr.db.Update(func(tx *boltdb.Tx) error {
+		var old []string
+
+		err := eachDelivery(tx, func(d models.HookDelivery) error {
+			if d.CreatedDate.Before(before) {
+				old = append(old, d.ID.Hex())
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, id := range old {
+			err = remove(tx, deliveries, id)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}))
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package bolt_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestDeliveries(t *testing.T) {
+	workflow := bson.NewObjectId()
+	created := time.Now().Add(-time.Hour)
+
+	var ids []bson.ObjectId
+
+	for i := 0; i < 3; i++ {
+		d, e := r.Deliveries().Create(&admin, models.HookDelivery{
+			Workflow:   workflow,
+			Transition: "In Progress",
+			Ticket:     "TEST-1",
+			Request: models.DeliveryRequest{
+				Method:  "POST",
+				URL:     "http://example.com",
+				Headers: map[string]string{"X-Praelatus-Delivery": "1"},
+			},
+			Response:    models.DeliveryResponse{Status: 200},
+			Success:     true,
+			CreatedDate: created.Add(time.Duration(i) * time.Minute),
+		})
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		ids = append(ids, d.ID)
+	}
+
+	d, e := r.Deliveries().Get(&admin, ids[0].Hex())
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if d.Request.Headers["X-Praelatus-Delivery"] != "1" || d.Response.Status != 200 {
+		t.Errorf("Expected the request and response Got %v", d)
+	}
+
+	ds, e := r.Deliveries().ForWorkflow(&admin, workflow.Hex(), 2)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if len(ds) != 2 || ds[0].ID != ids[2] || ds[1].ID != ids[1] {
+		t.Errorf("Expected the newest 2 deliveries Got %v", ds)
+	}
+
+	e = r.Deliveries().Prune(&admin, created.Add(90*time.Second))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	ds, e = r.Deliveries().ForWorkflow(&admin, workflow.Hex(), 10)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if len(ds) != 1 || ds[0].ID != ids[2] {
+		t.Errorf("Expected only the newest delivery to be kept Got %v", ds)
+	}
+
+	if _, e = r.Deliveries().ForWorkflow(&user, workflow.Hex(), 10); e != repo.ErrAdminRequired {
+		t.Errorf("Expected %s Got %v", repo.ErrAdminRequired, e)
+	}
+}
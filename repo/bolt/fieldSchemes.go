@@ -0,0 +1,103 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package bolt
+
+import (
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	boltdb "go.etcd.io/bbolt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type fieldSchemeRepo struct {
+	db *boltdb.DB
+}
+
+func (fs fieldSchemeRepo) Get(u *models.User, uid string) (models.FieldScheme, error) {
+	if u == nil {
+		return models.FieldScheme{}, repo.ErrLoginRequired
+	}
+
+	var f models.FieldScheme
+
+	err := fs.db.View(func(tx *boltdb.Tx) error {
+		return get(tx, fieldSchemes, uid, &f)
+	})
+
+	return f, boltErr(err)
+}
+
+func (fs fieldSchemeRepo) Update(u *models.User, uid string, updated models.FieldScheme) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	// FIXME: Handle what to do with tickets and projects associated with this
+	// field scheme
+
+	return boltErr(fs.db.Update(func(tx *boltdb.Tx) error {
+		if !exists(tx, fieldSchemes, uid) {
+			return repo.ErrNotFound
+		}
+
+		updated.ID = bson.ObjectIdHex(uid)
+		return put(tx, fieldSchemes, uid, updated)
+	}))
+}
+
+func (fs fieldSchemeRepo) Create(u *models.User, fieldScheme models.FieldScheme) (models.FieldScheme, error) {
+	if u == nil || !u.IsAdmin {
+		return models.FieldScheme{}, repo.ErrAdminRequired
+	}
+
+	fieldScheme.ID = bson.NewObjectId()
+
+	err := fs.db.Update(func(tx *boltdb.Tx) error {
+		return put(tx, fieldSchemes, fieldScheme.ID.Hex(), fieldScheme)
+	})
+
+	return fieldScheme, boltErr(err)
+}
+
+func (fs fieldSchemeRepo) Delete(u *models.User, uid string) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	// FIXME: Handle what to do with tickets and projects associated with this
+	// field scheme
+
+	return boltErr(fs.db.Update(func(tx *boltdb.Tx) error {
+		return remove(tx, fieldSchemes, uid)
+	}))
+}
+
+func (fs fieldSchemeRepo) Search(u *models.User, query string) ([]models.FieldScheme, error) {
+	if u == nil || !u.IsAdmin {
+		return []models.FieldScheme{}, repo.ErrAdminRequired
+	}
+
+	matches := matcher(query)
+	schemes := []models.FieldScheme{}
+
+	err := fs.db.View(func(tx *boltdb.Tx) error {
+		return each(tx, fieldSchemes, func(data []byte) error {
+			var f models.FieldScheme
+
+			err := bson.Unmarshal(data, &f)
+			if err != nil {
+				return err
+			}
+
+			if matches(f.Name) {
+				schemes = append(schemes, f)
+			}
+
+			return nil
+		})
+	})
+
+	return schemes, boltErr(err)
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package bolt
+
+import (
+	"sort"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	boltdb "go.etcd.io/bbolt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type notificationRepo struct {
+	db *boltdb.DB
+}
+
+func (nr notificationRepo) Create(u *models.User, n models.Notification) (models.Notification, error) {
+	n.ID = bson.NewObjectId()
+
+	err := nr.db.Update(func(tx *boltdb.Tx) error {
+		return put(tx, notifications, n.ID.Hex(), n)
+	})
+
+	return n, boltErr(err)
+}
+
+func (nr notificationRepo) MarkRead(u *models.User, uid string) error {
+	if u == nil {
+		return repo.ErrLoginRequired
+	}
+
+	return boltErr(nr.db.Update(func(tx *boltdb.Tx) error {
+		var n models.Notification
+
+		err := get(tx, notifications, uid, &n)
+		if err != nil {
+			return err
+		}
+
+		if n.Watcher != u.Username {
+			return repo.ErrNotFound
+		}
+
+		n.Read = true
+		return put(tx, notifications, uid, n)
+	}))
+}
+
+// selectNotifications will return the notifications for which keep returns
+// true in projects the user can view, newest first
+func (nr notificationRepo) selectNotifications(u *models.User, keep func(n models.Notification) bool, onlyUnread bool, last int) ([]models.Notification, error) {
+	found := []models.Notification{}
+
+	err := nr.db.View(func(tx *boltdb.Tx) error {
+		viewable, err := viewableProjects(tx, u)
+		if err != nil {
+			return err
+		}
+
+		keys := make(map[string]bool, len(viewable))
+		for _, p := range viewable {
+			keys[p.Key] = true
+		}
+
+		return each(tx, notifications, func(data []byte) error {
+			var n models.Notification
+
+			err := bson.Unmarshal(data, &n)
+			if err != nil {
+				return err
+			}
+
+			if keys[n.Project] && keep(n) && !(onlyUnread && n.Read) {
+				found = append(found, n)
+			}
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, boltErr(err)
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].CreatedDate.After(found[j].CreatedDate)
+	})
+
+	if last > 0 && last < len(found) {
+		found = found[:last]
+	}
+
+	return found, nil
+}
+
+func (nr notificationRepo) ForProject(u *models.User, project models.Project, onlyUnread bool, last int) ([]models.Notification, error) {
+	return nr.selectNotifications(u, func(n models.Notification) bool {
+		return n.Project == project.Key
+	}, onlyUnread, last)
+}
+
+func (nr notificationRepo) ForUser(u *models.User, user models.User, onlyUnread bool, last int) ([]models.Notification, error) {
+	if u == nil || (!u.IsAdmin && u.Username != user.Username) {
+		return nil, repo.ErrUnauthorized
+	}
+
+	return nr.selectNotifications(u, func(n models.Notification) bool {
+		return n.Watcher == user.Username
+	}, onlyUnread, last)
+}
+
+func (nr notificationRepo) ActivityForUser(u *models.User, user models.User, onlyUnread bool, last int) ([]models.Notification, error) {
+	return nr.selectNotifications(u, func(n models.Notification) bool {
+		return n.ActioningUser == user.Username
+	}, onlyUnread, last)
+}
@@ -0,0 +1,185 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package bolt
+
+import (
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	boltdb "go.etcd.io/bbolt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type outboxRepo struct {
+	db *boltdb.DB
+}
+
+// putOutboxEvent stores the event, the ticket repo uses it to store events in
+// the same transaction as the change to the ticket
+func putOutboxEvent(tx *boltdb.Tx, e models.OutboxEvent) error {
+	return put(tx, outbox, e.ID.Hex(), e)
+}
+
+// eachOutboxEvent will call fn with every event in the outbox, keys are
+// object ids so they are in the order they were created
+func eachOutboxEvent(tx *boltdb.Tx, fn func(e models.OutboxEvent) error) error {
+	return each(tx, outbox, func(data []byte) error {
+		var e models.OutboxEvent
+
+		err := bson.Unmarshal(data, &e)
+		if err != nil {
+			return err
+		}
+
+		return fn(e)
+	})
+}
+
+func (or outboxRepo) Get(u *models.User, uid string) (models.OutboxEvent, error) {
+	var e models.OutboxEvent
+
+	if u == nil || !u.IsAdmin {
+		return e, repo.ErrAdminRequired
+	}
+
+	err := or.db.View(func(tx *boltdb.Tx) error {
+		return get(tx, outbox, uid, &e)
+	})
+
+	return e, boltErr(err)
+}
+
+func (or outboxRepo) Create(u *models.User, e models.OutboxEvent) (models.OutboxEvent, error) {
+	if u == nil || !u.IsAdmin {
+		return e, repo.ErrAdminRequired
+	}
+
+	if e.ID == "" {
+		e.ID = bson.NewObjectId()
+	}
+
+	err := or.db.Update(func(tx *boltdb.Tx) error {
+		if exists(tx, outbox, e.ID.Hex()) {
+			return errExists
+		}
+
+		return putOutboxEvent(tx, e)
+	})
+
+	return e, boltErr(err)
+}
+
+func (or outboxRepo) Update(u *models.User, uid string, e models.OutboxEvent) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	return boltErr(or.db.Update(func(tx *boltdb.Tx) error {
+		var stored models.OutboxEvent
+
+		err := get(tx, outbox, uid, &stored)
+		if err != nil {
+			return err
+		}
+
+		stored.Status = e.Status
+		stored.Handled = e.Handled
+		stored.Attempts = e.Attempts
+		stored.LastError = e.LastError
+		stored.NextAttempt = e.NextAttempt
+		stored.UpdatedDate = e.UpdatedDate
+
+		return putOutboxEvent(tx, stored)
+	}))
+}
+
+func (or outboxRepo) Due(u *models.User, before time.Time, limit int) ([]models.OutboxEvent, error) {
+	if u == nil || !u.IsAdmin {
+		return nil, repo.ErrAdminRequired
+	}
+
+	due := []models.OutboxEvent{}
+
+	err := or.db.View(func(tx *boltdb.Tx) error {
+		return eachOutboxEvent(tx, func(e models.OutboxEvent) error {
+			if len(due) < limit && e.Status == models.OutboxPending &&
+				!e.NextAttempt.After(before) {
+				due = append(due, e)
+			}
+
+			return nil
+		})
+	})
+
+	return due, boltErr(err)
+}
+
+func (or outboxRepo) Requeue(u *models.User) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	now := time.Now()
+
+	return boltErr(or.db.Update(func(tx *boltdb.Tx) error {
+		var failed []models.OutboxEvent
+
+		err := eachOutboxEvent(tx, func(e models.OutboxEvent) error {
+			if e.Status == models.OutboxFailed {
+				failed = append(failed, e)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, e := range failed {
+			e.Status = models.OutboxPending
+			e.Attempts = 0
+			e.NextAttempt = now
+			e.UpdatedDate = now
+
+			err = putOutboxEvent(tx, e)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}))
+}
+
+func (or outboxRepo) Prune(u *models.User, before time.Time) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	return boltErr(or.db.Update(func(tx *boltdb.Tx) error {
+		var done []string
+
+		err := eachOutboxEvent(tx, func(e models.OutboxEvent) error {
+			if e.Status == models.OutboxDone && e.UpdatedDate.Before(before) {
+				done = append(done, e.ID.Hex())
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, id := range done {
+			err = remove(tx, outbox, id)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}))
+}
@@ -0,0 +1,187 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package bolt
+
+import (
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/models/permission"
+	"github.com/praelatus/praelatus/repo"
+	boltdb "go.etcd.io/bbolt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type projectRepo struct {
+	db *boltdb.DB
+}
+
+// selectProjects will return all projects for which keep returns true
+func selectProjects(tx *boltdb.Tx, keep func(p models.Project) bool) ([]models.Project, error) {
+	found := []models.Project{}
+
+	err := each(tx, projects, func(data []byte) error {
+		var p models.Project
+
+		err := bson.Unmarshal(data, &p)
+		if err != nil {
+			return err
+		}
+
+		if keep(p) {
+			found = append(found, p)
+		}
+
+		return nil
+	})
+
+	return found, err
+}
+
+// viewableProjects will return all projects the user is allowed to view
+func viewableProjects(tx *boltdb.Tx, u *models.User) ([]models.Project, error) {
+	user := dbUser(tx, u)
+
+	return selectProjects(tx, func(p models.Project) bool {
+		return canView(user, p)
+	})
+}
+
+func (p projectRepo) Get(u *models.User, uid string) (models.Project, error) {
+	var project models.Project
+
+	err := p.db.View(func(tx *boltdb.Tx) error {
+		err := get(tx, projects, uid, &project)
+		if err != nil {
+			return err
+		}
+
+		// Don't reveal the existence of projects the user can't see
+		if !canView(dbUser(tx, u), project) {
+			project = models.Project{}
+			return repo.ErrNotFound
+		}
+
+		return nil
+	})
+
+	return project, boltErr(err)
+}
+
+func (p projectRepo) Update(u *models.User, uid string, updated models.Project) error {
+	return boltErr(p.db.Update(func(tx *boltdb.Tx) error {
+		var project models.Project
+
+		err := get(tx, projects, uid, &project)
+		if err != nil {
+			return err
+		}
+
+		if len(models.HasPermission(permission.AdminProject, dbUser(tx, u), project)) == 0 {
+			return repo.ErrNotFound
+		}
+
+		updated.Key = project.Key
+		updated.CreatedDate = project.CreatedDate
+
+		return put(tx, projects, uid, updated)
+	}))
+}
+
+func (p projectRepo) Create(u *models.User, project models.Project) (models.Project, error) {
+	if u == nil || !u.IsAdmin {
+		return models.Project{}, repo.ErrAdminRequired
+	}
+
+	project.CreatedDate = time.Now()
+
+	err := p.db.Update(func(tx *boltdb.Tx) error {
+		if exists(tx, projects, project.Key) {
+			return errExists
+		}
+
+		return put(tx, projects, project.Key, project)
+	})
+
+	return project, boltErr(err)
+}
+
+func (p projectRepo) Delete(u *models.User, uid string) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	return boltErr(p.db.Update(func(tx *boltdb.Tx) error {
+		return remove(tx, projects, uid)
+	}))
+}
+
+func (p projectRepo) Search(u *models.User, query string) ([]models.Project, error) {
+	matches := matcher(query)
+
+	var found []models.Project
+
+	err := p.db.View(func(tx *boltdb.Tx) error {
+		user := dbUser(tx, u)
+
+		var err error
+
+		found, err = selectProjects(tx, func(p models.Project) bool {
+			return canView(user, p) && matches(p.Name, p.Key, p.Lead)
+		})
+
+		return err
+	})
+
+	return found, boltErr(err)
+}
+
+func (p projectRepo) HasLead(u *models.User, lead models.User) ([]models.Project, error) {
+	var found []models.Project
+
+	err := p.db.View(func(tx *boltdb.Tx) error {
+		user := dbUser(tx, u)
+
+		var err error
+
+		found, err = selectProjects(tx, func(p models.Project) bool {
+			return canView(user, p) && p.Lead == lead.Username
+		})
+
+		return err
+	})
+
+	return found, boltErr(err)
+}
+
+// HasPermissionTo will return all projects which the given user has the given
+// permissions to
+func (p projectRepo) HasPermissionTo(u *models.User, perms permission.Permissions) ([]models.Project, error) {
+	var found []models.Project
+
+	err := p.db.View(func(tx *boltdb.Tx) error {
+		user := dbUser(tx, u)
+
+		var err error
+
+		found, err = selectProjects(tx, func(p models.Project) bool {
+			if user.IsAdmin {
+				return true
+			}
+
+			for _, perm := range perms {
+				if len(models.HasPermission(permission.Permission(perm), user, p)) != 0 {
+					return true
+				}
+			}
+
+			return false
+		})
+
+		return err
+	})
+
+	return found, boltErr(err)
+}
@@ -0,0 +1,494 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package bolt
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/models/permission"
+	"github.com/praelatus/praelatus/ql/ast"
+	"github.com/praelatus/praelatus/repo"
+	boltdb "go.etcd.io/bbolt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type ticketRepo struct {
+	db *boltdb.DB
+}
+
+// hasPermission will check that the user has the given permission on the
+// project with projectKey returning the project if so
+func hasPermission(tx *boltdb.Tx, u *models.User, perm permission.Permission, projectKey string) (models.Project, error) {
+	if u == nil {
+		return models.Project{}, repo.ErrLoginRequired
+	}
+
+	var p models.Project
+
+	err := get(tx, projects, projectKey, &p)
+	if err != nil {
+		return p, err
+	}
+
+	if len(models.HasPermission(perm, dbUser(tx, u), p)) == 0 {
+		return p, repo.ErrUnauthorized
+	}
+
+	return p, nil
+}
+
+// validateTicket checks the ticket type and fields against the project and
+// its field scheme
+func validateTicket(tx *boltdb.Tx, p models.Project, ticket models.Ticket) error {
+	if !p.HasTicketType(ticket.Type) {
+		return repo.ErrInvalidTicketType
+	}
+
+	var fs models.FieldScheme
+
+	err := get(tx, fieldSchemes, p.FieldScheme.Hex(), &fs)
+	if err != nil {
+		return err
+	}
+
+	if err := fs.ValidateTicket(ticket); err != nil {
+		return repo.ErrInvalidFieldsForTicket
+	}
+
+	return nil
+}
+
+// historyPrefix is what the keys of a ticket's history entries start with,
+// they are followed by the entry's object id so they are in the order they
+// were made
+func historyPrefix(ticketKey string) []byte {
+	return []byte(ticketKey + "/")
+}
+
+// putHistory records the changes u made to the ticket
+func putHistory(tx *boltdb.Tx, u *models.User, ticketKey string, changes []models.FieldChange) error {
+	for _, h := range models.NewHistory(u, ticketKey, changes) {
+		err := put(tx, history, string(historyPrefix(ticketKey))+h.ID.Hex(), h)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t ticketRepo) Get(u *models.User, uid string) (models.Ticket, error) {
+	var ticket models.Ticket
+
+	err := t.db.View(func(tx *boltdb.Tx) error {
+		err := get(tx, tickets, uid, &ticket)
+		if err != nil {
+			return err
+		}
+
+		var p models.Project
+
+		err = get(tx, projects, ticket.Project, &p)
+		if err != nil {
+			return err
+		}
+
+		if !canView(dbUser(tx, u), p) {
+			return repo.ErrUnauthorized
+		}
+
+		return nil
+	})
+
+	return ticket, boltErr(err)
+}
+
+func (t ticketRepo) Update(u *models.User, uid string, updated models.Ticket) error {
+	return boltErr(t.db.Update(func(tx *boltdb.Tx) error {
+		var ticket models.Ticket
+
+		err := get(tx, tickets, uid, &ticket)
+		if err != nil {
+			return err
+		}
+
+		p, err := hasPermission(tx, u, permission.EditTicket, ticket.Project)
+		if err != nil {
+			return err
+		}
+
+		// Status can only be changed by transitions, comments are
+		// added on their own and the project and workflow can not be
+		// changed by an update
+		updated.Key = ticket.Key
+		updated.Project = ticket.Project
+		updated.Workflow = ticket.Workflow
+		updated.Status = ticket.Status
+		updated.Comments = ticket.Comments
+		updated.CreatedDate = ticket.CreatedDate
+		updated.UpdatedDate = time.Now()
+
+		err = validateTicket(tx, p, updated)
+		if err != nil {
+			return err
+		}
+
+		changes := ticket.Changes(updated)
+		if len(changes) == 0 {
+			return nil
+		}
+
+		err = put(tx, tickets, uid, updated)
+		if err != nil {
+			return err
+		}
+
+		err = putHistory(tx, u, uid, changes)
+		if err != nil {
+			return err
+		}
+
+		e := models.NewOutboxEvent(models.UpdatedEvent, u, updated)
+		e.Changes = changes
+		return putOutboxEvent(tx, e)
+	}))
+}
+
+func (t ticketRepo) Transition(u *models.User, uid string, name string) (models.Ticket, models.Transition, error) {
+	var ticket models.Ticket
+	var transition models.Transition
+
+	err := t.db.Update(func(tx *boltdb.Tx) error {
+		err := get(tx, tickets, uid, &ticket)
+		if err != nil {
+			return err
+		}
+
+		_, err = hasPermission(tx, u, permission.TransitionTicket, ticket.Project)
+		if err != nil {
+			return err
+		}
+
+		var wkf models.Workflow
+
+		err = get(tx, workflows, ticket.Workflow.Hex(), &wkf)
+		if err != nil {
+			return err
+		}
+
+		var ok bool
+
+		transition, ok = ticket.Transition(wkf, name)
+		if !ok {
+			return repo.ErrInvalidTransition
+		}
+
+		change := models.FieldChange{Field: "status",
+			From: ticket.Status.Name, To: transition.ToStatus.Name}
+
+		ticket.Status = transition.ToStatus
+		ticket.UpdatedDate = time.Now()
+
+		err = put(tx, tickets, uid, ticket)
+		if err != nil {
+			return err
+		}
+
+		err = putHistory(tx, u, uid, []models.FieldChange{change})
+		if err != nil {
+			return err
+		}
+
+		e := models.NewOutboxEvent(models.TransitionEvent, u, ticket)
+		e.Transition = &transition
+		return putOutboxEvent(tx, e)
+	})
+
+	return ticket, transition, boltErr(err)
+}
+
+func (t ticketRepo) AddComment(u *models.User, uid string, comment models.Comment) (models.Ticket, error) {
+	var ticket models.Ticket
+
+	comment.CreatedDate = time.Now()
+	comment.UpdatedDate = time.Now()
+	comment.ID = bson.NewObjectId()
+
+	err := t.db.Update(func(tx *boltdb.Tx) error {
+		err := get(tx, tickets, uid, &ticket)
+		if err != nil {
+			return err
+		}
+
+		ticket.Comments = append(ticket.Comments, comment)
+
+		err = put(tx, tickets, uid, ticket)
+		if err != nil {
+			return err
+		}
+
+		err = putHistory(tx, u, uid, []models.FieldChange{
+			{Field: models.HistoryComment, To: comment.ID.Hex()}})
+		if err != nil {
+			return err
+		}
+
+		e := models.NewOutboxEvent(models.CommentEvent, u, ticket)
+		e.Comment = &comment
+		return putOutboxEvent(tx, e)
+	})
+
+	return ticket, boltErr(err)
+}
+
+func (t ticketRepo) Create(u *models.User, ticket models.Ticket) (models.Ticket, error) {
+	err := t.db.Update(func(tx *boltdb.Tx) error {
+		p, err := hasPermission(tx, u, permission.CreateTicket, ticket.Project)
+		if err != nil {
+			return err
+		}
+
+		err = validateTicket(tx, p, ticket)
+		if err != nil {
+			return err
+		}
+
+		ticket.Workflow = p.GetWorkflow(ticket.Type)
+
+		var wkf models.Workflow
+
+		err = get(tx, workflows, ticket.Workflow.Hex(), &wkf)
+		if err != nil {
+			return err
+		}
+
+		ticket.Key = nextTicketKey(tx, ticket.Project)
+		ticket.CreatedDate = time.Now()
+		ticket.UpdatedDate = time.Now()
+		ticket.Comments = []models.Comment{}
+		ticket.Status = wkf.CreateTransition().ToStatus
+		ticket.Watchers = []string{u.Username}
+
+		err = put(tx, tickets, ticket.Key, ticket)
+		if err != nil {
+			return err
+		}
+
+		err = putHistory(tx, u, ticket.Key,
+			[]models.FieldChange{{Field: models.HistoryCreated}})
+		if err != nil {
+			return err
+		}
+
+		return putOutboxEvent(tx, models.NewOutboxEvent(models.CreatedEvent, u, ticket))
+	})
+
+	if err != nil {
+		return models.Ticket{}, boltErr(err)
+	}
+
+	return ticket, nil
+}
+
+func (t ticketRepo) Delete(u *models.User, uid string) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrUnauthorized
+	}
+
+	return boltErr(t.db.Update(func(tx *boltdb.Tx) error {
+		var ticket models.Ticket
+
+		err := get(tx, tickets, uid, &ticket)
+		if err != nil {
+			return err
+		}
+
+		var p models.Project
+
+		err = get(tx, projects, ticket.Project, &p)
+		if err != nil {
+			return err
+		}
+
+		if len(models.HasPermission(permission.RemoveTicket, *u, p)) == 0 {
+			return repo.ErrUnauthorized
+		}
+
+		err = remove(tx, tickets, uid)
+		if err != nil {
+			return err
+		}
+
+		var keys [][]byte
+
+		prefix := historyPrefix(uid)
+		c := tx.Bucket([]byte(history)).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, k)
+		}
+
+		for _, k := range keys {
+			err = tx.Bucket([]byte(history)).Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}))
+}
+
+func (t ticketRepo) History(u *models.User, uid string) ([]models.HistoryEntry, error) {
+	entries := []models.HistoryEntry{}
+
+	_, err := t.Get(u, uid)
+	if err != nil {
+		return entries, err
+	}
+
+	err = t.db.View(func(tx *boltdb.Tx) error {
+		prefix := historyPrefix(uid)
+
+		c := tx.Bucket([]byte(history)).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var h models.HistoryEntry
+
+			err := bson.Unmarshal(v, &h)
+			if err != nil {
+				return err
+			}
+
+			entries = append(entries, h)
+		}
+
+		return nil
+	})
+
+	return entries, boltErr(err)
+}
+
+// selectTickets will return all tickets in projects the user can view for
+// which keep returns true
+func selectTickets(tx *boltdb.Tx, u *models.User, keep func(t models.Ticket) bool) ([]models.Ticket, error) {
+	viewable, err := viewableProjects(tx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]bool, len(viewable))
+	for _, p := range viewable {
+		keys[p.Key] = true
+	}
+
+	found := []models.Ticket{}
+
+	err = each(tx, tickets, func(data []byte) error {
+		var ticket models.Ticket
+
+		err := bson.Unmarshal(data, &ticket)
+		if err != nil {
+			return err
+		}
+
+		if keys[ticket.Project] && keep(ticket) {
+			found = append(found, ticket)
+		}
+
+		return nil
+	})
+
+	return found, err
+}
+
+// Search evaluates the PQL query against every ticket the user can view since
+// bolt has no query language of its own
+func (t ticketRepo) Search(u *models.User, query ast.AST) ([]models.Ticket, error) {
+	var found []models.Ticket
+
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+
+	err := t.db.View(func(tx *boltdb.Tx) error {
+		var err error
+
+		found, err = selectTickets(tx, u, func(ticket models.Ticket) bool {
+			return evalAST(query, ticket)
+		})
+
+		return err
+	})
+
+	if err != nil {
+		return nil, boltErr(err)
+	}
+
+	return applyModifiers(query, found), nil
+}
+
+// nextTicketKey finds the highest ticket number in the project and returns
+// the key that comes after it
+func nextTicketKey(tx *boltdb.Tx, projectKey string) string {
+	prefix := []byte(projectKey + "-")
+	highest := 0
+
+	c := tx.Bucket([]byte(tickets)).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		n, err := strconv.Atoi(string(k[len(prefix):]))
+		if err == nil && n > highest {
+			highest = n
+		}
+	}
+
+	return projectKey + "-" + strconv.Itoa(highest+1)
+}
+
+func (t ticketRepo) NextTicketKey(u *models.User, projectKey string) (string, error) {
+	var key string
+
+	err := t.db.View(func(tx *boltdb.Tx) error {
+		key = nextTicketKey(tx, projectKey)
+		return nil
+	})
+
+	return key, boltErr(err)
+}
+
+func (t ticketRepo) LabelSearch(u *models.User, query string) ([]string, error) {
+	if u == nil {
+		return []string{}, repo.ErrUnauthorized
+	}
+
+	matches := matcher(query)
+	seen := make(map[string]bool)
+	labels := []string{}
+
+	err := t.db.View(func(tx *boltdb.Tx) error {
+		return each(tx, tickets, func(data []byte) error {
+			var ticket models.Ticket
+
+			err := bson.Unmarshal(data, &ticket)
+			if err != nil {
+				return err
+			}
+
+			for _, label := range ticket.Labels {
+				if !seen[label] && matches(label) {
+					seen[label] = true
+					labels = append(labels, label)
+				}
+			}
+
+			return nil
+		})
+	})
+
+	sort.Strings(labels)
+	return labels, boltErr(err)
+}
@@ -0,0 +1,314 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package bolt_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/ql/ast"
+	"github.com/praelatus/praelatus/ql/lexer"
+	"github.com/praelatus/praelatus/ql/parser"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestTicketGet(t *testing.T) {
+	ticket, err := r.Tickets().Get(&admin, "TEST-1")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if ticket.Key == "" {
+		t.Error("Expected a key got: ", ticket)
+	}
+}
+
+func TestTicketSearch(t *testing.T) {
+	tks, e := r.Tickets().Search(&admin, ast.AST{})
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if tks == nil || len(tks) == 0 {
+		t.Error("Expected to get tickets instead got none.")
+	}
+}
+
+func TestTicketSearchLimit(t *testing.T) {
+	l := lexer.New("LIMIT 5")
+	p := parser.New(l)
+	a := p.Parse()
+
+	if p.Errors() != nil {
+		t.Errorf("Parsing error: %v", p.Errors())
+		return
+	}
+
+	tks, e := r.Tickets().Search(&admin, a)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if tks == nil || len(tks) == 0 {
+		t.Error("Expected to get tickets instead got none.")
+	}
+
+	if len(tks) != 5 {
+		t.Errorf("Expected 5 tickets Got %d", len(tks))
+	}
+}
+
+func TestTicketUpdate(t *testing.T) {
+	tk, e := r.Tickets().Get(&admin, "TEST-4")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	comments := len(tk.Comments)
+	from := tk.Summary
+
+	tk.Summary = "Test ticket save"
+	tk.Comments = nil
+
+	e = r.Tickets().Update(&admin, tk.Key, tk)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	tk2, e := r.Tickets().Get(&admin, "TEST-4")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if tk2.Summary != "Test ticket save" {
+		t.Errorf("Expected: Test ticket save Got: %s\n", tk.Summary)
+	}
+
+	if len(tk2.Comments) != comments {
+		t.Errorf("Expected updates not to change the %d comments Got %d", comments, len(tk2.Comments))
+	}
+
+	due, e := r.Outbox().Due(&admin, time.Now().Add(time.Minute), 1000)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	var changes []models.FieldChange
+
+	for _, o := range due {
+		if o.Type == models.UpdatedEvent && o.Ticket.Key == "TEST-4" {
+			changes = o.Changes
+		}
+	}
+
+	if len(changes) != 1 || changes[0].Field != "summary" ||
+		changes[0].From != from || changes[0].To != "Test ticket save" {
+		t.Errorf("Expected an UPDATED event with the summary change Got %v", changes)
+	}
+
+	history, e := r.Tickets().History(&admin, "TEST-4")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if len(history) < 2 || history[0].Field != models.HistoryCreated {
+		t.Fatalf("Expected the history to start with the ticket being created Got %v", history)
+	}
+
+	last := history[len(history)-1]
+	if last.Field != "summary" || last.From != from || last.To != "Test ticket save" ||
+		last.Actor != admin.Username {
+		t.Errorf("Expected the summary change in the history Got %v", history)
+	}
+}
+
+func TestTicketSearchQuery(t *testing.T) {
+	l := lexer.New(`project = "TEST" AND (status = "Backlog" OR summary = "nothing")`)
+	p := parser.New(l)
+	a := p.Parse()
+
+	if p.Errors() != nil {
+		t.Errorf("Parsing error: %v", p.Errors())
+		return
+	}
+
+	tks, e := r.Tickets().Search(&admin, a)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if len(tks) == 0 {
+		t.Error("Expected to get tickets instead got none.")
+	}
+
+	for _, tk := range tks {
+		if tk.Project != "TEST" {
+			t.Errorf("Expected TEST Got %s", tk.Project)
+		}
+	}
+}
+
+func TestTicketSearchLabels(t *testing.T) {
+	l := lexer.New(`labels = "test" LIMIT 3`)
+	p := parser.New(l)
+	a := p.Parse()
+
+	if p.Errors() != nil {
+		t.Errorf("Parsing error: %v", p.Errors())
+		return
+	}
+
+	tks, e := r.Tickets().Search(&admin, a)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if len(tks) != 3 {
+		t.Errorf("Expected 3 tickets Got %d", len(tks))
+	}
+
+	for _, tk := range tks {
+		if !hasLabel(tk, "test") {
+			t.Errorf("Expected ticket to have label test Got %v", tk.Labels)
+		}
+	}
+}
+
+func hasLabel(tk models.Ticket, label string) bool {
+	for _, l := range tk.Labels {
+		if l == label {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestTicketSearchPermissions(t *testing.T) {
+	tks, e := r.Tickets().Search(nil, ast.AST{})
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if len(tks) == 0 {
+		t.Error("Expected to get tickets from public projects instead got none.")
+	}
+}
+
+func TestTicketTransition(t *testing.T) {
+	tk, transition, e := r.Tickets().Transition(&admin, "TEST-5", "In Progress")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if transition.Name != "In Progress" {
+		t.Errorf("Expected In Progress Got: %s", transition.Name)
+	}
+
+	tk2, e := r.Tickets().Get(&admin, tk.Key)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if tk2.Status.Name != "In Progress" {
+		t.Errorf("Expected In Progress Got: %s", tk2.Status.Name)
+	}
+
+	history, e := r.Tickets().History(&admin, tk.Key)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if last := history[len(history)-1]; last.Field != "status" || last.To != "In Progress" {
+		t.Errorf("Expected the status change in the history Got %v", history)
+	}
+
+	_, _, e = r.Tickets().Transition(&admin, "TEST-5", "Backlog")
+	if e == nil {
+		t.Error("Expected an error running the create transition but got none.")
+	}
+}
+
+func TestTicketAddCommentAttachments(t *testing.T) {
+	c := models.Comment{
+		Author: "testadmin",
+		Body:   "see attached",
+		Attachments: []models.Attachment{
+			{
+				ID:          bson.NewObjectId(),
+				Name:        "log.txt",
+				ContentType: "text/plain",
+				Size:        12,
+				Path:        "0123abcd",
+			},
+		},
+	}
+
+	ticket, e := r.Tickets().AddComment(&admin, "TEST-4", c)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	a, ok := ticket.Attachment(c.Attachments[0].ID.Hex())
+	if !ok || a != c.Attachments[0] {
+		t.Errorf("Expected %v Got %v", c.Attachments[0], a)
+	}
+
+	history, e := r.Tickets().History(&admin, "TEST-4")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	comment := ticket.Comments[len(ticket.Comments)-1]
+	if last := history[len(history)-1]; last.Field != models.HistoryComment ||
+		last.To != comment.ID.Hex() {
+		t.Errorf("Expected the comment in the history Got %v", history)
+	}
+}
+
+func TestTicketDelete(t *testing.T) {
+	e := r.Tickets().Delete(&admin, "TEST-3")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if _, e = r.Tickets().Get(&admin, "TEST-3"); e == nil {
+		t.Errorf("Expected an error getting ticket but got none.")
+	}
+}
+
+func TestLabelSearch(t *testing.T) {
+	lbls, e := r.Tickets().LabelSearch(&admin, "example")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if len(lbls) == 0 {
+		t.Error("Expected 2 Labels Got 0")
+		return
+	}
+
+	for i := range lbls {
+		if !strings.HasPrefix(lbls[i], "example") {
+			t.Errorf("Expected labels to start with example- Got %s\n", lbls[i])
+			return
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package bolt
+
+import (
+	"sort"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	boltdb "go.etcd.io/bbolt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type tokenRepo struct {
+	db *boltdb.DB
+}
+
+// Get does not check permissions since it is used to authenticate the request
+func (tr tokenRepo) Get(u *models.User, uid string) (models.APIToken, error) {
+	var token models.APIToken
+
+	err := tr.db.View(func(tx *boltdb.Tx) error {
+		return get(tx, tokens, uid, &token)
+	})
+
+	return token, boltErr(err)
+}
+
+func (tr tokenRepo) ForUser(u *models.User, user models.User) ([]models.APIToken, error) {
+	if u == nil || (!u.IsAdmin && u.Username != user.Username) {
+		return nil, repo.ErrUnauthorized
+	}
+
+	found := []models.APIToken{}
+
+	err := tr.db.View(func(tx *boltdb.Tx) error {
+		return each(tx, tokens, func(data []byte) error {
+			var token models.APIToken
+
+			err := bson.Unmarshal(data, &token)
+			if err != nil {
+				return err
+			}
+
+			if token.Username == user.Username {
+				found = append(found, token)
+			}
+
+			return nil
+		})
+	})
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].CreatedDate.Before(found[j].CreatedDate)
+	})
+
+	return found, boltErr(err)
+}
+
+func (tr tokenRepo) Create(u *models.User, token models.APIToken) (models.APIToken, error) {
+	if u == nil || (!u.IsAdmin && u.Username != token.Username) {
+		return token, repo.ErrUnauthorized
+	}
+
+	if token.ID == "" {
+		token.ID = bson.NewObjectId()
+	}
+
+	err := tr.db.Update(func(tx *boltdb.Tx) error {
+		if exists(tx, tokens, token.ID.Hex()) {
+			return errExists
+		}
+
+		return put(tx, tokens, token.ID.Hex(), token)
+	})
+
+	return token, boltErr(err)
+}
+
+func (tr tokenRepo) Delete(u *models.User, uid string) error {
+	if u == nil {
+		return repo.ErrUnauthorized
+	}
+
+	return boltErr(tr.db.Update(func(tx *boltdb.Tx) error {
+		var token models.APIToken
+
+		err := get(tx, tokens, uid, &token)
+		if err != nil {
+			return err
+		}
+
+		if !u.IsAdmin && u.Username != token.Username {
+			return repo.ErrUnauthorized
+		}
+
+		return remove(tx, tokens, uid)
+	}))
+}
@@ -0,0 +1,305 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package bolt
+
+import (
+	"strings"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	boltdb "go.etcd.io/bbolt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type userRepo struct {
+	db *boltdb.DB
+}
+
+func (ur userRepo) Get(u *models.User, uid string) (models.User, error) {
+	var user models.User
+
+	err := ur.db.View(func(tx *boltdb.Tx) error {
+		return get(tx, users, uid, &user)
+	})
+
+	return user, boltErr(err)
+}
+
+func (ur userRepo) Update(u *models.User, uid string, updated models.User) error {
+	if u == nil || (!u.IsAdmin && u.Username != uid) {
+		return repo.ErrUnauthorized
+	}
+
+	return boltErr(ur.db.Update(func(tx *boltdb.Tx) error {
+		var user models.User
+
+		err := get(tx, users, uid, &user)
+		if err != nil {
+			return err
+		}
+
+		// Anyone but an administrator has to verify a new email before
+		// they can log in again
+		if updated.Email != user.Email && !u.IsAdmin {
+			user.PendingVerification = true
+		}
+
+		if updated.Email != user.Email {
+			user.EmailVerified = false
+		}
+
+		user.Email = updated.Email
+		user.ProfilePic = updated.ProfilePic
+		user.FullName = updated.FullName
+		user.Settings = updated.Settings
+
+		return put(tx, users, uid, user)
+	}))
+}
+
+func (ur userRepo) Create(u *models.User, user models.User) (models.User, error) {
+	if u == nil || !u.IsAdmin {
+		user.IsAdmin = false
+	}
+
+	err := ur.db.Update(func(tx *boltdb.Tx) error {
+		if exists(tx, users, user.Username) {
+			return errExists
+		}
+
+		return put(tx, users, user.Username, user)
+	})
+
+	return user, boltErr(err)
+}
+
+func (ur userRepo) Delete(u *models.User, uid string) error {
+	if u == nil || (!u.IsAdmin && u.Username != uid) {
+		return repo.ErrUnauthorized
+	}
+
+	return boltErr(ur.db.Update(func(tx *boltdb.Tx) error {
+		return remove(tx, users, uid)
+	}))
+}
+
+// GetByEmail will return the user with the given email ignoring case
+func (ur userRepo) GetByEmail(u *models.User, email string) (models.User, error) {
+	var user models.User
+
+	err := ur.db.View(func(tx *boltdb.Tx) error {
+		err := each(tx, users, func(data []byte) error {
+			var found models.User
+
+			err := bson.Unmarshal(data, &found)
+			if err != nil {
+				return err
+			}
+
+			// Users are iterated in username order so keep the first
+			if user.Username == "" && strings.EqualFold(found.Email, email) {
+				user = found
+			}
+
+			return nil
+		})
+
+		if err == nil && user.Username == "" {
+			return repo.ErrNotFound
+		}
+
+		return err
+	})
+
+	return user, boltErr(err)
+}
+
+// GetByChatAccount will return the user who linked the chat account
+func (ur userRepo) GetByChatAccount(u *models.User, account models.ChatAccount) (models.User, error) {
+	var user models.User
+
+	if !account.Linked() {
+		return user, repo.ErrNotFound
+	}
+
+	err := ur.db.View(func(tx *boltdb.Tx) error {
+		err := each(tx, users, func(data []byte) error {
+			var found models.User
+
+			err := bson.Unmarshal(data, &found)
+			if err != nil {
+				return err
+			}
+
+			if found.ChatAccount == account {
+				user = found
+			}
+
+			return nil
+		})
+
+		if err == nil && user.Username == "" {
+			return repo.ErrNotFound
+		}
+
+		return err
+	})
+
+	return user, boltErr(err)
+}
+
+// SetChatAccount will link the user to the chat account, unlinking it from
+// any other user. Only the user themselves or an admin can link an account.
+func (ur userRepo) SetChatAccount(u *models.User, uid string, account models.ChatAccount) error {
+	if u == nil || (!u.IsAdmin && u.Username != uid) {
+		return repo.ErrUnauthorized
+	}
+
+	return boltErr(ur.db.Update(func(tx *boltdb.Tx) error {
+		var user models.User
+
+		err := get(tx, users, uid, &user)
+		if err != nil {
+			return err
+		}
+
+		if account.Linked() {
+			var linked []models.User
+
+			err = each(tx, users, func(data []byte) error {
+				var found models.User
+
+				err := bson.Unmarshal(data, &found)
+				if err == nil && found.ChatAccount == account &&
+					found.Username != uid {
+					linked = append(linked, found)
+				}
+
+				return err
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, other := range linked {
+				other.ChatAccount = models.ChatAccount{}
+
+				err = put(tx, users, other.Username, other)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		user.ChatAccount = account
+		return put(tx, users, uid, user)
+	}))
+}
+
+// SetRoles will replace the roles of the user, only administrators can change
+// roles
+func (ur userRepo) SetRoles(u *models.User, uid string, roles []models.UserRole) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrUnauthorized
+	}
+
+	return boltErr(ur.db.Update(func(tx *boltdb.Tx) error {
+		var user models.User
+
+		err := get(tx, users, uid, &user)
+		if err != nil {
+			return err
+		}
+
+		user.Roles = roles
+		return put(tx, users, uid, user)
+	}))
+}
+
+// SetTwoFactor will replace the two-factor authentication settings of the
+// user, only the user themselves or an admin can change them
+func (ur userRepo) SetTwoFactor(u *models.User, uid string, tf models.TwoFactor) error {
+	if u == nil || (!u.IsAdmin && u.Username != uid) {
+		return repo.ErrUnauthorized
+	}
+
+	return boltErr(ur.db.Update(func(tx *boltdb.Tx) error {
+		var user models.User
+
+		err := get(tx, users, uid, &user)
+		if err != nil {
+			return err
+		}
+
+		user.TwoFactor = tf
+		return put(tx, users, uid, user)
+	}))
+}
+
+// SetPassword will replace the password hash of the user, only the user
+// themselves or an admin can change it
+func (ur userRepo) SetPassword(u *models.User, uid string, password string) error {
+	if u == nil || (!u.IsAdmin && u.Username != uid) {
+		return repo.ErrUnauthorized
+	}
+
+	return boltErr(ur.db.Update(func(tx *boltdb.Tx) error {
+		var user models.User
+
+		err := get(tx, users, uid, &user)
+		if err != nil {
+			return err
+		}
+
+		user.Password = password
+		return put(tx, users, uid, user)
+	}))
+}
+
+// Activate will mark the user as active once they have verified their email,
+// only the user themselves or an admin can activate them
+func (ur userRepo) Activate(u *models.User, uid string) error {
+	if u == nil || (!u.IsAdmin && u.Username != uid) {
+		return repo.ErrUnauthorized
+	}
+
+	return boltErr(ur.db.Update(func(tx *boltdb.Tx) error {
+		var user models.User
+
+		err := get(tx, users, uid, &user)
+		if err != nil {
+			return err
+		}
+
+		user.IsActive = true
+		user.PendingVerification = false
+		user.EmailVerified = true
+		return put(tx, users, uid, user)
+	}))
+}
+
+func (ur userRepo) Search(u *models.User, query string) ([]models.User, error) {
+	matches := matcher(query)
+	found := []models.User{}
+
+	err := ur.db.View(func(tx *boltdb.Tx) error {
+		return each(tx, users, func(data []byte) error {
+			var user models.User
+
+			err := bson.Unmarshal(data, &user)
+			if err != nil {
+				return err
+			}
+
+			if matches(user.Username, user.Email, user.FullName) {
+				found = append(found, user)
+			}
+
+			return nil
+		})
+	})
+
+	return found, boltErr(err)
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package bolt
+
+import (
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/models/permission"
+	boltdb "go.etcd.io/bbolt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type webhookRepo struct {
+	db *boltdb.DB
+}
+
+// getWebhook returns the webhook if the user can administer its project
+func getWebhook(tx *boltdb.Tx, u *models.User, uid string) (models.Webhook, error) {
+	var w models.Webhook
+
+	err := get(tx, webhooks, uid, &w)
+	if err != nil {
+		return w, err
+	}
+
+	_, err = hasPermission(tx, u, permission.AdminProject, w.Project)
+	return w, err
+}
+
+func (wr webhookRepo) Get(u *models.User, uid string) (models.Webhook, error) {
+	var w models.Webhook
+
+	err := wr.db.View(func(tx *boltdb.Tx) error {
+		var err error
+		w, err = getWebhook(tx, u, uid)
+		return err
+	})
+
+	return w, boltErr(err)
+}
+
+func (wr webhookRepo) ForProject(u *models.User, projectKey string) ([]models.Webhook, error) {
+	ws := []models.Webhook{}
+
+	err := wr.db.View(func(tx *boltdb.Tx) error {
+		_, err := hasPermission(tx, u, permission.AdminProject, projectKey)
+		if err != nil {
+			return err
+		}
+
+		return each(tx, webhooks, func(data []byte) error {
+			var w models.Webhook
+
+			err := bson.Unmarshal(data, &w)
+			if err != nil {
+				return err
+			}
+
+			if w.Project == projectKey {
+				ws = append(ws, w)
+			}
+
+			return nil
+		})
+	})
+
+	return ws, boltErr(err)
+}
+
+func (wr webhookRepo) Create(u *models.User, w models.Webhook) (models.Webhook, error) {
+	w.ID = bson.NewObjectId()
+	w.CreatedDate = time.Now()
+
+	err := wr.db.Update(func(tx *boltdb.Tx) error {
+		_, err := hasPermission(tx, u, permission.AdminProject, w.Project)
+		if err != nil {
+			return err
+		}
+
+		return put(tx, webhooks, w.ID.Hex(), w)
+	})
+
+	return w, boltErr(err)
+}
+
+func (wr webhookRepo) Update(u *models.User, uid string, w models.Webhook) error {
+	return boltErr(wr.db.Update(func(tx *boltdb.Tx) error {
+		stored, err := getWebhook(tx, u, uid)
+		if err != nil {
+			return err
+		}
+
+		// Secrets are never sent to clients so they don't send them back
+		if w.Secret == "" {
+			w.Secret = stored.Secret
+		}
+
+		w.ID = stored.ID
+		w.Project = stored.Project
+		w.CreatedDate = stored.CreatedDate
+
+		return put(tx, webhooks, uid, w)
+	}))
+}
+
+func (wr webhookRepo) Delete(u *models.User, uid string) error {
+	return boltErr(wr.db.Update(func(tx *boltdb.Tx) error {
+		_, err := getWebhook(tx, u, uid)
+		if err != nil {
+			return err
+		}
+
+		return remove(tx, webhooks, uid)
+	}))
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package bolt_test
+
+import (
+	"testing"
+
+	"github.com/praelatus/praelatus/models"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestWebhooks(t *testing.T) {
+	w, e := r.Webhooks().Create(&admin, models.Webhook{
+		Project: "TEST",
+		URL:     "http://example.com",
+		Events:  []string{"CREATED", "COMMENT"},
+		Query:   "summary = \"test\"",
+		Format:  models.WebhookSlack,
+		Secret:  "s3cret",
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if w.ID == "" || w.CreatedDate.IsZero() {
+		t.Errorf("Expected an ID and created date Got %v", w)
+	}
+
+	w.Events = []string{"UPDATED"}
+	w.Secret = ""
+	w.Format = models.WebhookSlack
+
+	e = r.Webhooks().Update(&admin, w.ID.Hex(), w)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	stored, e := r.Webhooks().Get(&admin, w.ID.Hex())
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if stored.Secret != "s3cret" || stored.Format != models.WebhookSlack ||
+		!stored.Wants("UPDATED") || stored.Wants("COMMENT") {
+		t.Errorf("Expected the events to change and the secret to be kept Got %v", stored)
+	}
+
+	ws, e := r.Webhooks().ForProject(&admin, "TEST")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	found := false
+	for _, pw := range ws {
+		found = found || pw.ID == w.ID
+	}
+
+	if !found {
+		t.Errorf("Expected %s in %v", w.ID.Hex(), ws)
+	}
+
+	if _, e = r.Webhooks().ForProject(&user, "TEST"); e == nil {
+		t.Error("Expected only project administrators to see webhooks")
+	}
+
+	e = r.Webhooks().Delete(&admin, w.ID.Hex())
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if _, e = r.Webhooks().Get(&admin, w.ID.Hex()); e == nil {
+		t.Error("Expected the webhook to be deleted")
+	}
+
+	if _, e = r.Webhooks().Get(&admin, bson.NewObjectId().Hex()); e == nil {
+		t.Error("Expected an error for a missing webhook")
+	}
+}
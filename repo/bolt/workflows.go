@@ -0,0 +1,103 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package bolt
+
+import (
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	boltdb "go.etcd.io/bbolt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type workflowRepo struct {
+	db *boltdb.DB
+}
+
+func (w workflowRepo) Get(u *models.User, uid string) (models.Workflow, error) {
+	if u == nil {
+		return models.Workflow{}, repo.ErrLoginRequired
+	}
+
+	var wkf models.Workflow
+
+	err := w.db.View(func(tx *boltdb.Tx) error {
+		return get(tx, workflows, uid, &wkf)
+	})
+
+	return wkf, boltErr(err)
+}
+
+func (w workflowRepo) Update(u *models.User, uid string, updated models.Workflow) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	// FIXME: Handle what to do with tickets and projects associated with this
+	// workflow
+
+	return boltErr(w.db.Update(func(tx *boltdb.Tx) error {
+		if !exists(tx, workflows, uid) {
+			return repo.ErrNotFound
+		}
+
+		updated.ID = bson.ObjectIdHex(uid)
+		return put(tx, workflows, uid, updated)
+	}))
+}
+
+func (w workflowRepo) Create(u *models.User, workflow models.Workflow) (models.Workflow, error) {
+	if u == nil || !u.IsAdmin {
+		return models.Workflow{}, repo.ErrAdminRequired
+	}
+
+	workflow.ID = bson.NewObjectId()
+
+	err := w.db.Update(func(tx *boltdb.Tx) error {
+		return put(tx, workflows, workflow.ID.Hex(), workflow)
+	})
+
+	return workflow, boltErr(err)
+}
+
+func (w workflowRepo) Delete(u *models.User, uid string) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	// FIXME: Handle what to do with tickets and projects associated with this
+	// workflow
+
+	return boltErr(w.db.Update(func(tx *boltdb.Tx) error {
+		return remove(tx, workflows, uid)
+	}))
+}
+
+func (w workflowRepo) Search(u *models.User, query string) ([]models.Workflow, error) {
+	if u == nil || !u.IsAdmin {
+		return []models.Workflow{}, repo.ErrAdminRequired
+	}
+
+	matches := matcher(query)
+	ws := []models.Workflow{}
+
+	err := w.db.View(func(tx *boltdb.Tx) error {
+		return each(tx, workflows, func(data []byte) error {
+			var wkf models.Workflow
+
+			err := bson.Unmarshal(data, &wkf)
+			if err != nil {
+				return err
+			}
+
+			if matches(wkf.Name) {
+				ws = append(ws, wkf)
+			}
+
+			return nil
+		})
+	})
+
+	return ws, boltErr(err)
+}
@@ -0,0 +1,278 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sync"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	boltdb "go.etcd.io/bbolt"
+)
+
+// BoltDB bucket names
+var (
+	valuesBucket   = []byte("cache")
+	sessionsBucket = []byte("sessions")
+)
+
+// Values are gob encoded so types other than the builtin ones must be
+// registered with gob.Register before they are stored in a Bolt cache.
+type valueEntry struct {
+	Value   interface{}
+	Expires time.Time
+}
+
+type sessionEntry struct {
+	Session models.Session
+	Expires time.Time
+}
+
+func expired(expires time.Time) bool {
+	return !expires.IsZero() && time.Now().After(expires)
+}
+
+// expiresEntry decodes only the expiry of a valueEntry or sessionEntry
+type expiresEntry struct {
+	Expires time.Time
+}
+
+// Bolt is a repo.Cache stored in a BoltDB file so sessions persist across
+// restarts, values and sessions expire after ttl. A ttl of 0 means they never
+// expire. Expired entries are removed when they are read and by a sweep
+// which runs at most once every sweepInterval when something is stored.
+type Bolt struct {
+	DB  *boltdb.DB
+	ttl time.Duration
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewBolt will open or create the BoltDB file at path for use as a cache
+func NewBolt(path string, ttl time.Duration) (*Bolt, error) {
+	db, err := boltdb.Open(path, 0600, &boltdb.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *boltdb.Tx) error {
+		for _, name := range [][]byte{valuesBucket, sessionsBucket} {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Bolt{DB: db, ttl: ttl}, nil
+}
+
+func (b *Bolt) expiry() time.Time {
+	if b.ttl <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(b.ttl)
+}
+
+// load will decode the entry stored at key in bucket into v, the returned
+// bool is false if there is no such entry
+func (b *Bolt) load(bucket []byte, key string, v interface{}) (bool, error) {
+	var found bool
+
+	err := b.DB.View(func(tx *boltdb.Tx) error {
+		data := tx.Bucket(bucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+
+		found = true
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+	})
+
+	return found, err
+}
+
+func (b *Bolt) store(bucket []byte, key string, v interface{}) error {
+	if b.sweepDue() {
+		if err := b.Sweep(); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+
+	err := gob.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return err
+	}
+
+	return b.DB.Update(func(tx *boltdb.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), buf.Bytes())
+	})
+}
+
+func (b *Bolt) remove(bucket []byte, key string) error {
+	return b.DB.Update(func(tx *boltdb.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(key))
+	})
+}
+
+// sweepDue will return true if a sweep should be run, only one caller is told
+// to run it each sweepInterval
+func (b *Bolt) sweepDue() bool {
+	if b.ttl <= 0 {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(b.lastSweep) <= sweepInterval {
+		return false
+	}
+
+	b.lastSweep = now
+	return true
+}
+
+// Sweep will remove every expired value and session
+func (b *Bolt) Sweep() error {
+	return b.DB.Update(func(tx *boltdb.Tx) error {
+		for _, name := range [][]byte{valuesBucket, sessionsBucket} {
+			bucket := tx.Bucket(name)
+
+			var keys [][]byte
+
+			err := bucket.ForEach(func(k, v []byte) error {
+				var e expiresEntry
+
+				err := gob.NewDecoder(bytes.NewReader(v)).Decode(&e)
+				if err != nil {
+					return err
+				}
+
+				if expired(e.Expires) {
+					keys = append(keys, k)
+				}
+
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, k := range keys {
+				if err = bucket.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// Get will return the value stored at key or repo.ErrNotFound
+func (b *Bolt) Get(key string) (interface{}, error) {
+	var e valueEntry
+
+	found, err := b.load(valuesBucket, key, &e)
+	if err != nil || !found {
+		return nil, notFound(err)
+	}
+
+	if expired(e.Expires) {
+		return nil, notFound(b.remove(valuesBucket, key))
+	}
+
+	return e.Value, nil
+}
+
+// Set will store value at key
+func (b *Bolt) Set(key string, value interface{}) error {
+	return b.store(valuesBucket, key, valueEntry{value, b.expiry()})
+}
+
+// Remove will remove the value stored at key if any
+func (b *Bolt) Remove(key string) error {
+	return b.remove(valuesBucket, key)
+}
+
+// GetSession will return the session stored at key or repo.ErrNotFound
+func (b *Bolt) GetSession(key string) (models.Session, error) {
+	var e sessionEntry
+
+	found, err := b.load(sessionsBucket, key, &e)
+	if err != nil || !found {
+		return models.Session{}, notFound(err)
+	}
+
+	if expired(e.Expires) {
+		return models.Session{}, notFound(b.remove(sessionsBucket, key))
+	}
+
+	return e.Session, nil
+}
+
+// SetSession will store the session at key
+func (b *Bolt) SetSession(key string, session models.Session) error {
+	return b.store(sessionsBucket, key, sessionEntry{session, b.expiry()})
+}
+
+// RemoveSession will remove the session stored at key if any
+func (b *Bolt) RemoveSession(key string) error {
+	return b.remove(sessionsBucket, key)
+}
+
+// TakeSession will remove and return the session stored at key or
+// repo.ErrNotFound, both happen in one transaction so a session can only be
+// taken once
+func (b *Bolt) TakeSession(key string) (models.Session, error) {
+	var e sessionEntry
+	var found bool
+
+	err := b.DB.Update(func(tx *boltdb.Tx) error {
+		bucket := tx.Bucket(sessionsBucket)
+
+		data := bucket.Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+
+		err := gob.NewDecoder(bytes.NewReader(data)).Decode(&e)
+		if err != nil {
+			return err
+		}
+
+		found = !expired(e.Expires)
+		return bucket.Delete([]byte(key))
+	})
+	if err != nil || !found {
+		return models.Session{}, notFound(err)
+	}
+
+	return e.Session, nil
+}
+
+// notFound returns err if there was one otherwise repo.ErrNotFound
+func notFound(err error) error {
+	if err != nil {
+		return err
+	}
+
+	return repo.ErrNotFound
+}
@@ -0,0 +1,33 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+// Package cache implements repo.Cache with an in memory LRU and with a BoltDB
+// file for sessions which should survive restarts.
+package cache
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/praelatus/praelatus/repo"
+)
+
+// DefaultTTL is used when creating a cache from the configuration, it matches
+// the lifetime of a refresh token. The cache holds sessions, refresh tokens
+// and two-factor challenges so it never evicts them before they expire,
+// caches which may evict need their own instance of Memory.
+const DefaultTTL = time.Hour * 24 * 30
+
+// New will return the repo.Cache for the given store name, url is the file
+// location for stores which need one
+func New(store, url string) (repo.Cache, error) {
+	switch store {
+	case "memory":
+		return NewMemory(0, DefaultTTL), nil
+	case "bolt":
+		return NewBolt(url, DefaultTTL)
+	default:
+		return nil, fmt.Errorf("unknown session store: %s", store)
+	}
+}
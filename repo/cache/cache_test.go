@@ -0,0 +1,230 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package cache_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"github.com/praelatus/praelatus/repo/cache"
+	boltdb "go.etcd.io/bbolt"
+)
+
+func testCaches(t *testing.T, ttl time.Duration) (map[string]repo.Cache, func()) {
+	dir, err := ioutil.TempDir("", "praelatus-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := cache.NewBolt(filepath.Join(dir, "sessions.db"), ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]repo.Cache{
+		"memory": cache.NewMemory(10, ttl),
+		"bolt":   b,
+	}, func() {
+		b.DB.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCacheValues(t *testing.T) {
+	caches, cleanup := testCaches(t, 0)
+	defer cleanup()
+
+	for name, c := range caches {
+		err := c.Set("keys", []string{"TEST", "TEST2"})
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		v, err := c.Get("keys")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		keys, ok := v.([]string)
+		if !ok || len(keys) != 2 || keys[1] != "TEST2" {
+			t.Errorf("%s: Expected [TEST TEST2] Got %v", name, v)
+		}
+
+		err = c.Remove("keys")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+
+		if _, err = c.Get("keys"); err != repo.ErrNotFound {
+			t.Errorf("%s: Expected %s Got %v", name, repo.ErrNotFound, err)
+		}
+	}
+}
+
+func TestCacheSessions(t *testing.T) {
+	caches, cleanup := testCaches(t, 0)
+	defer cleanup()
+
+	session := models.Session{Token: "token"}
+
+	for name, c := range caches {
+		err := c.SetSession(session.Token, session)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		s, err := c.GetSession(session.Token)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		if s != session {
+			t.Errorf("%s: Expected %v Got %v", name, session, s)
+		}
+
+		err = c.RemoveSession(session.Token)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+
+		if _, err = c.GetSession(session.Token); err != repo.ErrNotFound {
+			t.Errorf("%s: Expected %s Got %v", name, repo.ErrNotFound, err)
+		}
+	}
+}
+
+func TestCacheTakeSession(t *testing.T) {
+	caches, cleanup := testCaches(t, 0)
+	defer cleanup()
+
+	session := models.Session{Token: "token"}
+
+	for name, c := range caches {
+		err := c.SetSession(session.Token, session)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		s, err := c.TakeSession(session.Token)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		if s != session {
+			t.Errorf("%s: Expected %v Got %v", name, session, s)
+		}
+
+		if _, err = c.TakeSession(session.Token); err != repo.ErrNotFound {
+			t.Errorf("%s: Expected %s Got %v", name, repo.ErrNotFound, err)
+		}
+
+		if _, err = c.GetSession(session.Token); err != repo.ErrNotFound {
+			t.Errorf("%s: Expected %s Got %v", name, repo.ErrNotFound, err)
+		}
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	caches, cleanup := testCaches(t, time.Millisecond)
+	defer cleanup()
+
+	for name, c := range caches {
+		err := c.Set("expires", "soon")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		time.Sleep(5 * time.Millisecond)
+
+		if _, err = c.Get("expires"); err != repo.ErrNotFound {
+			t.Errorf("%s: Expected %s Got %v", name, repo.ErrNotFound, err)
+		}
+	}
+}
+
+func TestBoltSweep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "praelatus-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	b, err := cache.NewBolt(filepath.Join(dir, "sessions.db"), time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer b.DB.Close()
+
+	b.Set("expires", "soon")
+	b.SetSession("token", models.Session{Token: "token"})
+
+	time.Sleep(5 * time.Millisecond)
+
+	if err = b.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.DB.View(func(tx *boltdb.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *boltdb.Bucket) error {
+			if n := bucket.Stats().KeyN; n != 0 {
+				t.Errorf("Expected %s to be empty Got %d entries", name, n)
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemoryEviction(t *testing.T) {
+	c := cache.NewMemory(2, 0)
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+
+	// Use a so b is the least recently used
+	c.Get("a")
+	c.Set("c", 3)
+
+	if _, err := c.Get("b"); err != repo.ErrNotFound {
+		t.Errorf("Expected b to be evicted Got %v", err)
+	}
+
+	if v, err := c.Get("a"); err != nil || v != 1 {
+		t.Errorf("Expected 1 Got %v %v", v, err)
+	}
+}
+
+func TestSessionStoreNeverEvicts(t *testing.T) {
+	c, err := cache.New("memory", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20000; i++ {
+		c.SetSession(strconv.Itoa(i), models.Session{})
+	}
+
+	if _, err = c.GetSession("0"); err != nil {
+		t.Errorf("Expected the first session to be kept Got %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+)
+
+type entry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+func (e entry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+// sweepInterval is how often expired entries are removed from an lru, without
+// it entries which are never read again would only be removed by eviction
+const sweepInterval = time.Hour
+
+// lru is a least recently used list of entries, it is not safe for
+// concurrent use
+type lru struct {
+	size      int
+	ttl       time.Duration
+	items     map[string]*list.Element
+	order     *list.List
+	lastSweep time.Time
+}
+
+func newLRU(size int, ttl time.Duration) *lru {
+	return &lru{
+		size:  size,
+		ttl:   ttl,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+func (l *lru) get(key string) (interface{}, bool) {
+	el, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := el.Value.(*entry)
+	if e.expired(time.Now()) {
+		l.removeElement(el)
+		return nil, false
+	}
+
+	l.order.MoveToFront(el)
+	return e.value, true
+}
+
+// sweep removes every expired entry
+func (l *lru) sweep(now time.Time) {
+	for el := l.order.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*entry).expired(now) {
+			l.removeElement(el)
+		}
+
+		el = next
+	}
+
+	l.lastSweep = now
+}
+
+func (l *lru) set(key string, value interface{}) {
+	now := time.Now()
+
+	var expires time.Time
+	if l.ttl > 0 {
+		expires = now.Add(l.ttl)
+
+		if now.Sub(l.lastSweep) > sweepInterval {
+			l.sweep(now)
+		}
+	}
+
+	if el, ok := l.items[key]; ok {
+		e := el.Value.(*entry)
+		e.value = value
+		e.expires = expires
+		l.order.MoveToFront(el)
+		return
+	}
+
+	l.items[key] = l.order.PushFront(&entry{key, value, expires})
+
+	if l.size > 0 && l.order.Len() > l.size {
+		l.removeElement(l.order.Back())
+	}
+}
+
+func (l *lru) remove(key string) {
+	if el, ok := l.items[key]; ok {
+		l.removeElement(el)
+	}
+}
+
+func (l *lru) removeElement(el *list.Element) {
+	l.order.Remove(el)
+	delete(l.items, el.Value.(*entry).key)
+}
+
+// Memory is an in memory repo.Cache which holds at most size values and
+// sessions, evicting the least recently used first, and expires them after
+// ttl. A size or ttl of 0 means no limit. The cache used for sessions must
+// have no size limit or users are logged out when it is full.
+type Memory struct {
+	mu       sync.Mutex
+	values   *lru
+	sessions *lru
+}
+
+// NewMemory will create an empty Memory cache
+func NewMemory(size int, ttl time.Duration) *Memory {
+	return &Memory{
+		values:   newLRU(size, ttl),
+		sessions: newLRU(size, ttl),
+	}
+}
+
+// Get will return the value stored at key or repo.ErrNotFound
+func (m *Memory) Get(key string) (interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, ok := m.values.get(key)
+	if !ok {
+		return nil, repo.ErrNotFound
+	}
+
+	return v, nil
+}
+
+// Set will store value at key
+func (m *Memory) Set(key string, value interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.values.set(key, value)
+	return nil
+}
+
+// Remove will remove the value stored at key if any
+func (m *Memory) Remove(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.values.remove(key)
+	return nil
+}
+
+// GetSession will return the session stored at key or repo.ErrNotFound
+func (m *Memory) GetSession(key string) (models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, ok := m.sessions.get(key)
+	if !ok {
+		return models.Session{}, repo.ErrNotFound
+	}
+
+	return v.(models.Session), nil
+}
+
+// SetSession will store the session at key
+func (m *Memory) SetSession(key string, session models.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions.set(key, session)
+	return nil
+}
+
+// RemoveSession will remove the session stored at key if any
+func (m *Memory) RemoveSession(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions.remove(key)
+	return nil
+}
+
+// TakeSession will remove and return the session stored at key or
+// repo.ErrNotFound
+func (m *Memory) TakeSession(key string) (models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, ok := m.sessions.get(key)
+	if !ok {
+		return models.Session{}, repo.ErrNotFound
+	}
+
+	m.sessions.remove(key)
+	return v.(models.Session), nil
+}
@@ -9,6 +9,9 @@ package repo
 import (
 	"math/rand"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/praelatus/praelatus/models"
 	"github.com/praelatus/praelatus/models/permission"
@@ -162,8 +165,23 @@ func (t mockTicketRepo) AddComment(u *models.User, uid string, comment models.Co
 	return tickets[0], nil
 }
 
+func (t mockTicketRepo) Transition(u *models.User, uid string, name string) (models.Ticket, models.Transition, error) {
+	transition, ok := tickets[0].Transition(workflows[0], name)
+	if !ok {
+		return models.Ticket{}, models.Transition{}, ErrInvalidTransition
+	}
+
+	ticket := tickets[0]
+	ticket.Status = transition.ToStatus
+	return ticket, transition, nil
+}
+
 func (t mockTicketRepo) NextTicketKey(u *models.User, projectKey string) (string, error) {
-	return projectKey + string(len(tickets)+1), nil
+	return projectKey + strconv.Itoa(len(tickets)+1), nil
+}
+
+func (t mockTicketRepo) History(u *models.User, uid string) ([]models.HistoryEntry, error) {
+	return []models.HistoryEntry{}, nil
 }
 
 type mockUserRepo struct{}
@@ -188,6 +206,40 @@ func (ur mockUserRepo) Delete(u *models.User, uid string) error {
 	return nil
 }
 
+func (ur mockUserRepo) GetByEmail(u *models.User, email string) (models.User, error) {
+	for _, user := range users {
+		if strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+
+	return models.User{}, ErrNotFound
+}
+
+func (ur mockUserRepo) GetByChatAccount(u *models.User, account models.ChatAccount) (models.User, error) {
+	return models.User{}, ErrNotFound
+}
+
+func (ur mockUserRepo) SetChatAccount(u *models.User, uid string, account models.ChatAccount) error {
+	return nil
+}
+
+func (ur mockUserRepo) SetRoles(u *models.User, uid string, roles []models.UserRole) error {
+	return nil
+}
+
+func (ur mockUserRepo) SetTwoFactor(u *models.User, uid string, tf models.TwoFactor) error {
+	return nil
+}
+
+func (ur mockUserRepo) SetPassword(u *models.User, uid string, password string) error {
+	return nil
+}
+
+func (ur mockUserRepo) Activate(u *models.User, uid string) error {
+	return nil
+}
+
 type mockFieldRepo struct{}
 
 func (fsr mockFieldRepo) Get(u *models.User, uid string) (models.FieldScheme, error) {
@@ -262,6 +314,135 @@ func (nr mockNotificationRepo) ActivityForUser(u *models.User, user models.User,
 	return nil, nil
 }
 
+// mockTokenRepo keeps tokens in memory so they can be used after they are
+// created
+type mockTokenRepo struct{}
+
+var (
+	apiTokensMu sync.Mutex
+	apiTokens   = make(map[string]models.APIToken)
+)
+
+func (tr mockTokenRepo) Get(u *models.User, uid string) (models.APIToken, error) {
+	apiTokensMu.Lock()
+	defer apiTokensMu.Unlock()
+
+	token, ok := apiTokens[uid]
+	if !ok {
+		return token, ErrNotFound
+	}
+
+	return token, nil
+}
+
+func (tr mockTokenRepo) ForUser(u *models.User, user models.User) ([]models.APIToken, error) {
+	apiTokensMu.Lock()
+	defer apiTokensMu.Unlock()
+
+	found := []models.APIToken{}
+	for _, token := range apiTokens {
+		if token.Username == user.Username {
+			found = append(found, token)
+		}
+	}
+
+	return found, nil
+}
+
+func (tr mockTokenRepo) Create(u *models.User, token models.APIToken) (models.APIToken, error) {
+	apiTokensMu.Lock()
+	defer apiTokensMu.Unlock()
+
+	if token.ID == "" {
+		token.ID = bson.NewObjectId()
+	}
+
+	apiTokens[token.ID.Hex()] = token
+	return token, nil
+}
+
+func (tr mockTokenRepo) Delete(u *models.User, uid string) error {
+	apiTokensMu.Lock()
+	defer apiTokensMu.Unlock()
+
+	if _, ok := apiTokens[uid]; !ok {
+		return ErrNotFound
+	}
+
+	delete(apiTokens, uid)
+	return nil
+}
+
+type mockOutboxRepo struct{}
+
+func (or mockOutboxRepo) Get(u *models.User, uid string) (models.OutboxEvent, error) {
+	return models.OutboxEvent{}, ErrNotFound
+}
+
+func (or mockOutboxRepo) Create(u *models.User, e models.OutboxEvent) (models.OutboxEvent, error) {
+	return e, nil
+}
+
+func (or mockOutboxRepo) Update(u *models.User, uid string, e models.OutboxEvent) error {
+	return nil
+}
+
+func (or mockOutboxRepo) Due(u *models.User, before time.Time, limit int) ([]models.OutboxEvent, error) {
+	return nil, nil
+}
+
+func (or mockOutboxRepo) Requeue(u *models.User) error {
+	return nil
+}
+
+func (or mockOutboxRepo) Prune(u *models.User, before time.Time) error {
+	return nil
+}
+
+type mockDeliveryRepo struct{}
+
+func (dr mockDeliveryRepo) Get(u *models.User, uid string) (models.HookDelivery, error) {
+	return models.HookDelivery{}, ErrNotFound
+}
+
+func (dr mockDeliveryRepo) Create(u *models.User, d models.HookDelivery) (models.HookDelivery, error) {
+	return d, nil
+}
+
+func (dr mockDeliveryRepo) ForWorkflow(u *models.User, workflowID string, limit int) ([]models.HookDelivery, error) {
+	return nil, nil
+}
+
+func (dr mockDeliveryRepo) ForWebhook(u *models.User, webhookID string, limit int) ([]models.HookDelivery, error) {
+	return nil, nil
+}
+
+func (dr mockDeliveryRepo) Prune(u *models.User, before time.Time) error {
+	return nil
+}
+
+type mockWebhookRepo struct{}
+
+func (wr mockWebhookRepo) Get(u *models.User, uid string) (models.Webhook, error) {
+	return models.Webhook{}, ErrNotFound
+}
+
+func (wr mockWebhookRepo) ForProject(u *models.User, projectKey string) ([]models.Webhook, error) {
+	return nil, nil
+}
+
+func (wr mockWebhookRepo) Create(u *models.User, w models.Webhook) (models.Webhook, error) {
+	return w, nil
+}
+
+func (wr mockWebhookRepo) Update(u *models.User, uid string, w models.Webhook) error {
+	return nil
+}
+
+func (wr mockWebhookRepo) Delete(u *models.User, uid string) error {
+	return nil
+}
+
 func (m mockRepo) Projects() ProjectRepo {
 	return mockProjectRepo{}
 }
@@ -286,6 +467,22 @@ func (m mockRepo) Notifications() NotificationRepo {
 	return mockNotificationRepo{}
 }
 
+func (m mockRepo) Tokens() TokenRepo {
+	return mockTokenRepo{}
+}
+
+func (m mockRepo) Outbox() OutboxRepo {
+	return mockOutboxRepo{}
+}
+
+func (m mockRepo) Deliveries() DeliveryRepo {
+	return mockDeliveryRepo{}
+}
+
+func (m mockRepo) Webhooks() WebhookRepo {
+	return mockWebhookRepo{}
+}
+
 func (m mockRepo) Clean() error { return nil }
 func (m mockRepo) Test() error  { return nil }
 func (m mockRepo) Init() error  { return nil }
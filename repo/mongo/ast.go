@@ -5,94 +5,92 @@
 package mongo
 
 import (
+	"strings"
+
 	"github.com/praelatus/praelatus/ql/ast"
 	"gopkg.in/mgo.v2/bson"
 )
 
-func eval(exp ast.InfixExpression) bson.M {
-	b := bson.M{}
+// mongoOperators maps the comparison operators of PQL to mongo query
+// operators, = matches the value itself
+var mongoOperators = map[string]string{
+	"=":  "",
+	"~":  "$regex",
+	"!=": "$ne",
+	">":  "$gt",
+	"<":  "$lt",
+	">=": "$gte",
+	"<=": "$lte",
+}
 
+func eval(exp ast.InfixExpression) (bson.M, error) {
 	switch exp.Operator {
-	case "AND":
-		opName := "$and"
-		if _, ok := b[opName]; ok {
-			b[opName] = append(b[opName].([]bson.M),
-				eval(exp.Left.(ast.InfixExpression)), eval(exp.Right.(ast.InfixExpression)))
-		} else {
-			b[opName] = []bson.M{
-				eval(exp.Left.(ast.InfixExpression)),
-				eval(exp.Right.(ast.InfixExpression)),
-			}
-		}
-	case "OR":
-		opName := "$or"
-		if _, ok := b[opName]; ok {
-			b[opName] = append(b[opName].([]bson.M),
-				eval(exp.Left.(ast.InfixExpression)), eval(exp.Right.(ast.InfixExpression)))
-		} else {
-			b[opName] = []bson.M{
-				eval(exp.Left.(ast.InfixExpression)),
-				eval(exp.Right.(ast.InfixExpression)),
-			}
-		}
-	case "=":
-		val, ok := exp.Right.(ast.Literal)
-		if !ok {
-			break
-		}
-		makeFieldSearchDoc(exp, b, val.GetValue())
-	case "~":
-		val, ok := exp.Right.(ast.Literal)
+	case "AND", "OR":
+		left, ok := exp.Left.(ast.InfixExpression)
 		if !ok {
-			break
+			return nil, ast.ErrInvalidExpression
 		}
-		makeFieldSearchDoc(exp, b, bson.M{"$regex": val.GetValue()})
-	case "!=":
-		val, ok := exp.Right.(ast.Literal)
-		if !ok {
-			break
-		}
-		makeFieldSearchDoc(exp, b, bson.M{"$ne": val.GetValue()})
-	case ">":
-		val, ok := exp.Right.(ast.Literal)
-		if !ok {
-			break
-		}
-		makeFieldSearchDoc(exp, b, bson.M{"$gt": val.GetValue()})
-	case "<":
-		val, ok := exp.Right.(ast.Literal)
+
+		right, ok := exp.Right.(ast.InfixExpression)
 		if !ok {
-			break
+			return nil, ast.ErrInvalidExpression
 		}
-		makeFieldSearchDoc(exp, b, bson.M{"$lt": val.GetValue()})
-	case ">=":
-		val, ok := exp.Right.(ast.Literal)
-		if !ok {
-			break
+
+		l, err := eval(left)
+		if err != nil {
+			return nil, err
 		}
-		makeFieldSearchDoc(exp, b, bson.M{"$gte": val.GetValue()})
-	case "<=":
-		val, ok := exp.Right.(ast.Literal)
-		if !ok {
-			break
+
+		r, err := eval(right)
+		if err != nil {
+			return nil, err
 		}
-		makeFieldSearchDoc(exp, b, bson.M{"$lte": val.GetValue()})
+
+		return bson.M{"$" + strings.ToLower(exp.Operator): []bson.M{l, r}}, nil
+	}
+
+	op, ok := mongoOperators[exp.Operator]
+	if !ok {
+		return nil, ast.ErrInvalidExpression
+	}
+
+	val, ok := exp.Right.(ast.Literal)
+	if !ok {
+		return nil, ast.ErrInvalidExpression
 	}
 
-	return b
+	fn, ok := exp.Left.(ast.FieldLiteral)
+	if !ok {
+		return nil, ast.ErrInvalidExpression
+	}
+
+	var valDoc interface{} = val.GetValue()
+	if op != "" {
+		valDoc = bson.M{op: valDoc}
+	}
+
+	b := bson.M{}
+	makeFieldSearchDoc(fn, b, valDoc)
+	return b, nil
 }
 
-func evalAST(a ast.AST) bson.M {
+// evalAST returns the mongo query for the query, it returns
+// ast.ErrInvalidExpression rather than matching everything for queries which
+// can't be searched with
+func evalAST(a ast.AST) (bson.M, error) {
+	if a.Query.Expression == nil {
+		return bson.M{}, nil
+	}
+
 	infix, ok := a.Query.Expression.(ast.InfixExpression)
 	if !ok {
-		return nil
+		return nil, ast.ErrInvalidExpression
 	}
 
 	return eval(infix)
 }
 
-func makeFieldSearchDoc(exp ast.InfixExpression, b bson.M, valDoc interface{}) {
-	fn := exp.Left.(ast.FieldLiteral)
+func makeFieldSearchDoc(fn ast.FieldLiteral, b bson.M, valDoc interface{}) {
 	if fn.Value == "status" {
 		b["status.name"] = valDoc
 	} else if fn.Value == "statusCategory" {
@@ -9,6 +9,7 @@ import (
 
 	"gopkg.in/mgo.v2/bson"
 
+	"github.com/praelatus/praelatus/ql/ast"
 	"github.com/praelatus/praelatus/ql/lexer"
 	"github.com/praelatus/praelatus/ql/parser"
 )
@@ -19,7 +20,11 @@ func TestSimpleEval(t *testing.T) {
 	p := parser.New(l)
 	a := p.Parse()
 
-	b := evalAST(a)
+	b, err := evalAST(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	q := bson.M{"summary": "test"}
 
 	if b["summary"] != q["summary"] {
@@ -33,7 +38,11 @@ func TestComplexEval(t *testing.T) {
 	p := parser.New(l)
 	a := p.Parse()
 
-	b := evalAST(a)
+	b, err := evalAST(a)
+	if err != nil {
+		t.Error(err)
+	}
+
 	t.Log(b)
 }
 
@@ -43,6 +52,29 @@ func TestMultipleCompounds(t *testing.T) {
 	p := parser.New(l)
 	a := p.Parse()
 
-	b := evalAST(a)
+	b, err := evalAST(a)
+	if err != nil {
+		t.Error(err)
+	}
+
 	t.Log(b)
 }
+
+func TestBareFieldEval(t *testing.T) {
+	a := parser.New(lexer.New("summary")).Parse()
+
+	if _, err := evalAST(a); err != ast.ErrInvalidExpression {
+		t.Errorf("Expected %s Got %v", ast.ErrInvalidExpression, err)
+	}
+
+	key := parser.New(lexer.New("key = \"TEST-1\"")).Parse()
+	a.Query.Expression = ast.InfixExpression{
+		Left:     key.Query.Expression,
+		Operator: "AND",
+		Right:    a.Query.Expression,
+	}
+
+	if _, err := evalAST(a); err != ast.ErrInvalidExpression {
+		t.Errorf("Expected %s Got %v", ast.ErrInvalidExpression, err)
+	}
+}
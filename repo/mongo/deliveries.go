@@ -0,0 +1,88 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package mongo
+
+import (
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type deliveryRepo struct {
+	conn *mgo.Session
+}
+
+func (dr deliveryRepo) coll() *mgo.Collection {
+	return dr.conn.DB(dbName).C(deliveries)
+}
+
+func (dr deliveryRepo) Get(u *models.User, uid string) (models.HookDelivery, error) {
+	var d models.HookDelivery
+
+	if u == nil || !u.IsAdmin {
+		return d, repo.ErrAdminRequired
+	}
+
+	if !bson.IsObjectIdHex(uid) {
+		return d, repo.ErrNotFound
+	}
+
+	err := dr.coll().FindId(bson.ObjectIdHex(uid)).One(&d)
+	return d, mongoErr(err)
+}
+
+func (dr deliveryRepo) Create(u *models.User, d models.HookDelivery) (models.HookDelivery, error) {
+	if u == nil || !u.IsAdmin {
+		return d, repo.ErrAdminRequired
+	}
+
+	if d.ID == "" {
+		d.ID = bson.NewObjectId()
+	}
+
+	return d, mongoErr(dr.coll().Insert(d))
+}
+
+// newest returns up to limit of the deliveries whose field is the id, newest
+// first
+func (dr deliveryRepo) newest(field, id string, limit int) ([]models.HookDelivery, error) {
+	ds := []models.HookDelivery{}
+
+	if !bson.IsObjectIdHex(id) {
+		return ds, nil
+	}
+
+	err := dr.coll().Find(bson.M{field: bson.ObjectIdHex(id)}).
+		Sort("-_id").Limit(limit).All(&ds)
+	return ds, mongoErr(err)
+}
+
+func (dr deliveryRepo) ForWorkflow(u *models.User, workflowID string, limit int) ([]models.HookDelivery, error) {
+	if u == nil || !u.IsAdmin {
+		return nil, repo.ErrAdminRequired
+	}
+
+	return dr.newest("workflow", workflowID, limit)
+}
+
+func (dr deliveryRepo) ForWebhook(u *models.User, webhookID string, limit int) ([]models.HookDelivery, error) {
+	if u == nil || !u.IsAdmin {
+		return nil, repo.ErrAdminRequired
+	}
+
+	return dr.newest("webhook", webhookID, limit)
+}
+
+func (dr deliveryRepo) Prune(u *models.User, before time.Time) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	_, err := dr.coll().RemoveAll(bson.M{"createddate": bson.M{"$lt": before}})
+	return mongoErr(err)
+}
@@ -31,6 +31,11 @@ const (
 	cache         = "cache"
 	workflows     = "workflows"
 	notifications = "notifications"
+	tokens        = "tokens"
+	outbox        = "outbox"
+	deliveries    = "deliveries"
+	webhooks      = "webhooks"
+	history       = "history"
 )
 
 func mongoErr(e error) error {
@@ -108,6 +113,10 @@ type Repo struct {
 	fieldSchemes  fieldSchemeRepo
 	workflows     workflowRepo
 	notifications notificationRepo
+	tokens        tokenRepo
+	outbox        outboxRepo
+	deliveries    deliveryRepo
+	webhooks      webhookRepo
 }
 
 // Fields returns the fieldSchemesRepo implementation for mongodb
@@ -135,6 +144,26 @@ func (r Repo) Notifications() repo.NotificationRepo {
 	return r.notifications
 }
 
+// Tokens returns the tokenRepo implementation for mongodb
+func (r Repo) Tokens() repo.TokenRepo {
+	return r.tokens
+}
+
+// Outbox returns the outboxRepo implementation for mongodb
+func (r Repo) Outbox() repo.OutboxRepo {
+	return r.outbox
+}
+
+// Deliveries returns the deliveryRepo implementation for mongodb
+func (r Repo) Deliveries() repo.DeliveryRepo {
+	return r.deliveries
+}
+
+// Webhooks returns the webhookRepo implementation for mongodb
+func (r Repo) Webhooks() repo.WebhookRepo {
+	return r.webhooks
+}
+
 // Users returns the userRepo implementation for mongodb
 func (r Repo) Users() repo.UserRepo {
 	return r.users
@@ -152,7 +181,12 @@ func (r Repo) Test() error {
 
 // Init will setup the indexes on the database
 func (r Repo) Init() error {
-	return nil
+	// The dispatcher looks for tickets with pending events every time it
+	// polls the outbox
+	return r.Conn.DB(dbName).C(tickets).EnsureIndex(mgo.Index{
+		Key:    []string{pendingEvents + "._id"},
+		Sparse: true,
+	})
 }
 
 // New will attempt to connect to the MongoDB instance at connURL and return
@@ -170,7 +204,7 @@ func New(connURL string) repo.Repo {
 		os.Exit(1)
 	}
 
-	return Repo{
+	r := Repo{
 		Conn:          conn,
 		tickets:       ticketRepo{conn},
 		projects:      projectRepo{conn},
@@ -178,5 +212,17 @@ func New(connURL string) repo.Repo {
 		fieldSchemes:  fieldSchemeRepo{conn},
 		users:         userRepo{conn},
 		notifications: notificationRepo{conn},
+		tokens:        tokenRepo{conn},
+		outbox:        outboxRepo{conn},
+		deliveries:    deliveryRepo{conn},
+		webhooks:      webhookRepo{conn},
+	}
+
+	err = r.Init()
+	if err != nil {
+		dbLog.Printf("Unable to create indexes: %s\n", err.Error())
+		os.Exit(1)
 	}
+
+	return r
 }
@@ -0,0 +1,170 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package mongo
+
+import (
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type outboxRepo struct {
+	conn *mgo.Session
+}
+
+func (or outboxRepo) coll() *mgo.Collection {
+	return or.conn.DB(dbName).C(outbox)
+}
+
+// pendingEvents is the field of ticket documents the ticket repo pushes
+// events into. MongoDB can't update more than one document atomically so
+// events are stored with the change to the ticket and moved into the outbox
+// by the dispatcher, that way an event is never lost when Praelatus stops
+// between the two writes.
+const pendingEvents = "pendingevents"
+
+// ticketDocument is how tickets are stored, with the events waiting to be
+// moved into the outbox
+type ticketDocument struct {
+	models.Ticket `bson:",inline"`
+	PendingEvents []models.OutboxEvent `bson:"pendingevents,omitempty"`
+}
+
+// insertOutboxEvent stores the event in the outbox
+func insertOutboxEvent(conn *mgo.Session, e models.OutboxEvent) error {
+	return conn.DB(dbName).C(outbox).Insert(e)
+}
+
+// movePendingEvents moves the pending events of the tickets matching query
+// into the outbox. An event is only removed from its ticket once it is in the
+// outbox so an event which was moved before Praelatus stopped is skipped.
+func movePendingEvents(conn *mgo.Session, query bson.M) error {
+	query[pendingEvents+"._id"] = bson.M{"$exists": true}
+
+	var docs []ticketDocument
+
+	err := conn.DB(dbName).C(tickets).Find(query).
+		Select(bson.M{pendingEvents: 1}).All(&docs)
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		for _, e := range doc.PendingEvents {
+			err = insertOutboxEvent(conn, e)
+			if err != nil && !mgo.IsDup(err) {
+				return err
+			}
+
+			err = conn.DB(dbName).C(tickets).UpdateId(doc.Key, bson.M{
+				"$pull": bson.M{pendingEvents: bson.M{"_id": e.ID}},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (or outboxRepo) Get(u *models.User, uid string) (models.OutboxEvent, error) {
+	var e models.OutboxEvent
+
+	if u == nil || !u.IsAdmin {
+		return e, repo.ErrAdminRequired
+	}
+
+	if !bson.IsObjectIdHex(uid) {
+		return e, repo.ErrNotFound
+	}
+
+	err := or.coll().FindId(bson.ObjectIdHex(uid)).One(&e)
+	return e, mongoErr(err)
+}
+
+func (or outboxRepo) Create(u *models.User, e models.OutboxEvent) (models.OutboxEvent, error) {
+	if u == nil || !u.IsAdmin {
+		return e, repo.ErrAdminRequired
+	}
+
+	if e.ID == "" {
+		e.ID = bson.NewObjectId()
+	}
+
+	return e, mongoErr(insertOutboxEvent(or.conn, e))
+}
+
+func (or outboxRepo) Update(u *models.User, uid string, e models.OutboxEvent) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	if !bson.IsObjectIdHex(uid) {
+		return repo.ErrNotFound
+	}
+
+	return mongoErr(or.coll().UpdateId(bson.ObjectIdHex(uid), bson.M{
+		"$set": bson.M{
+			"status":      e.Status,
+			"handled":     e.Handled,
+			"attempts":    e.Attempts,
+			"lasterror":   e.LastError,
+			"nextattempt": e.NextAttempt,
+			"updateddate": e.UpdatedDate,
+		},
+	}))
+}
+
+func (or outboxRepo) Due(u *models.User, before time.Time, limit int) ([]models.OutboxEvent, error) {
+	if u == nil || !u.IsAdmin {
+		return nil, repo.ErrAdminRequired
+	}
+
+	err := movePendingEvents(or.conn, bson.M{})
+	if err != nil {
+		return nil, mongoErr(err)
+	}
+
+	due := []models.OutboxEvent{}
+	err = or.coll().Find(bson.M{
+		"status":      models.OutboxPending,
+		"nextattempt": bson.M{"$lte": before},
+	}).Sort("createddate", "_id").Limit(limit).All(&due)
+	return due, mongoErr(err)
+}
+
+func (or outboxRepo) Requeue(u *models.User) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	now := time.Now()
+
+	_, err := or.coll().UpdateAll(bson.M{"status": models.OutboxFailed}, bson.M{
+		"$set": bson.M{
+			"status":      models.OutboxPending,
+			"attempts":    0,
+			"nextattempt": now,
+			"updateddate": now,
+		},
+	})
+	return mongoErr(err)
+}
+
+func (or outboxRepo) Prune(u *models.User, before time.Time) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	_, err := or.coll().RemoveAll(bson.M{
+		"status":      models.OutboxDone,
+		"updateddate": bson.M{"$lt": before},
+	})
+	return mongoErr(err)
+}
@@ -0,0 +1,147 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package mongo_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+)
+
+// commentEvent adds a comment to the ticket and returns the event for it from
+// the outbox
+func commentEvent(t *testing.T, key, body string) models.OutboxEvent {
+	_, e := r.Tickets().AddComment(&admin, key, models.Comment{
+		Author: "testadmin",
+		Body:   body,
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	due, e := r.Outbox().Due(&admin, time.Now().Add(time.Minute), 1000)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	for _, o := range due {
+		if o.Comment != nil && o.Comment.Body == body {
+			return o
+		}
+	}
+
+	t.Fatalf("Expected an event for the comment Got %v", due)
+	return models.OutboxEvent{}
+}
+
+func TestOutboxTicketWrites(t *testing.T) {
+	o := commentEvent(t, "TEST-1", "written to the outbox")
+
+	if o.Type != models.CommentEvent || o.Status != models.OutboxPending {
+		t.Errorf("Expected a pending COMMENT event Got %v", o)
+	}
+
+	if o.Ticket.Key != "TEST-1" || o.Project != "TEST" {
+		t.Errorf("Expected TEST-1 in TEST Got %s in %s", o.Ticket.Key, o.Project)
+	}
+
+	if o.ActioningUser.Username != "testadmin" || o.ActioningUser.Password != "" {
+		t.Errorf("Expected the public details of testadmin Got %v", o.ActioningUser)
+	}
+}
+
+func TestOutboxUpdate(t *testing.T) {
+	o := commentEvent(t, "TEST-1", "retried later")
+
+	o.Attempts = 1
+	o.Handled = []string{"notifications"}
+	o.LastError = "hook:0: connection refused"
+	o.NextAttempt = time.Now().Add(time.Hour)
+
+	e := r.Outbox().Update(&admin, o.ID.Hex(), o)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	o2, e := r.Outbox().Get(&admin, o.ID.Hex())
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if o2.Attempts != 1 || !o2.HasHandled("notifications") || o2.LastError != o.LastError {
+		t.Errorf("Expected %v Got %v", o, o2)
+	}
+
+	due, e := r.Outbox().Due(&admin, time.Now(), 1000)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	for _, d := range due {
+		if d.ID == o.ID {
+			t.Errorf("Expected the event not to be due until %s", o.NextAttempt)
+		}
+	}
+}
+
+func TestOutboxRequeue(t *testing.T) {
+	o := commentEvent(t, "TEST-1", "failed too often")
+
+	o.Status = models.OutboxFailed
+	o.Attempts = 10
+	o.NextAttempt = time.Now().Add(time.Hour)
+
+	e := r.Outbox().Update(&admin, o.ID.Hex(), o)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	e = r.Outbox().Requeue(&admin)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	o2, e := r.Outbox().Get(&admin, o.ID.Hex())
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if o2.Status != models.OutboxPending || o2.Attempts != 0 {
+		t.Errorf("Expected the event to be pending again Got %v", o2)
+	}
+}
+
+func TestOutboxPrune(t *testing.T) {
+	o := commentEvent(t, "TEST-1", "delivered")
+
+	o.Status = models.OutboxDone
+	o.UpdatedDate = time.Now()
+
+	e := r.Outbox().Update(&admin, o.ID.Hex(), o)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	e = r.Outbox().Prune(&admin, time.Now().Add(time.Minute))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if _, e = r.Outbox().Get(&admin, o.ID.Hex()); e != repo.ErrNotFound {
+		t.Errorf("Expected %s Got %v", repo.ErrNotFound, e)
+	}
+}
+
+func TestOutboxAdminRequired(t *testing.T) {
+	if _, e := r.Outbox().Due(&user, time.Now(), 10); e != repo.ErrAdminRequired {
+		t.Errorf("Expected %s Got %v", repo.ErrAdminRequired, e)
+	}
+
+	if e := r.Outbox().Requeue(nil); e != repo.ErrAdminRequired {
+		t.Errorf("Expected %s Got %v", repo.ErrAdminRequired, e)
+	}
+}
@@ -42,6 +42,8 @@ func (p projectRepo) Get(u *models.User, uid string) (models.Project, error) {
 }
 
 func (p projectRepo) Update(u *models.User, uid string, updated models.Project) error {
+	defer invalidatePermCache()
+
 	q := permWithID(u, uid)
 	return mongoErr(p.coll().Update(q, updated))
 }
@@ -51,6 +53,8 @@ func (p projectRepo) Create(u *models.User, project models.Project) (models.Proj
 		return models.Project{}, repo.ErrAdminRequired
 	}
 
+	defer invalidatePermCache()
+
 	project.CreatedDate = time.Now()
 	return project, mongoErr(p.coll().Insert(project))
 }
@@ -60,6 +64,8 @@ func (p projectRepo) Delete(u *models.User, uid string) error {
 		return repo.ErrAdminRequired
 	}
 
+	defer invalidatePermCache()
+
 	return p.coll().RemoveId(uid)
 }
 
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/praelatus/praelatus/models"
@@ -16,6 +17,7 @@ import (
 	"github.com/praelatus/praelatus/ql/ast"
 	"github.com/praelatus/praelatus/ql/token"
 	"github.com/praelatus/praelatus/repo"
+	repocache "github.com/praelatus/praelatus/repo/cache"
 	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -28,6 +30,18 @@ func (t ticketRepo) coll() *mgo.Collection {
 	return t.conn.DB(dbName).C(tickets)
 }
 
+// insertHistory records the changes u made to the ticket
+func (t ticketRepo) insertHistory(u *models.User, ticketKey string, changes []models.FieldChange) error {
+	for _, h := range models.NewHistory(u, ticketKey, changes) {
+		err := t.conn.DB(dbName).C(history).Insert(h)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (t ticketRepo) Get(u *models.User, uid string) (models.Ticket, error) {
 	if u == nil {
 		u = &models.User{}
@@ -52,30 +66,65 @@ func (t ticketRepo) Get(u *models.User, uid string) (models.Ticket, error) {
 	return ticket, err
 }
 
+// ticketUpdate returns the fields to $set for the changes to the ticket
+func ticketUpdate(updated models.Ticket, changes []models.FieldChange) bson.M {
+	set := bson.M{"updateddate": updated.UpdatedDate}
+
+	for _, c := range changes {
+		switch c.Field {
+		case "summary":
+			set["summary"] = updated.Summary
+		case "description":
+			set["description"] = updated.Description
+		case "reporter":
+			set["reporter"] = updated.Reporter
+		case "assignee":
+			set["assignee"] = updated.Assignee
+		case "type":
+			set["type"] = updated.Type
+		case "labels":
+			set["labels"] = updated.Labels
+		case "watchers":
+			set["watchers"] = updated.Watchers
+		default:
+			set["fields"] = updated.Fields
+		}
+	}
+
+	return set
+}
+
 func (t ticketRepo) Update(u *models.User, uid string, updated models.Ticket) error {
-	// TODO: Have to validate field schema.
+	if u == nil {
+		return repo.ErrLoginRequired
+	}
+
+	var ticket models.Ticket
 	var p models.Project
 	var dbUser models.User
 
-	err := t.conn.DB(dbName).C(projects).FindId(updated.Project).One(&p)
+	err := t.coll().FindId(uid).One(&ticket)
+	if err != nil {
+		return mongoErr(err)
+	}
+
+	err = t.conn.DB(dbName).C(projects).FindId(ticket.Project).One(&p)
 	if err != nil {
-		//return models.Ticket{}, mongoErr(err)
 		return mongoErr(err)
 	}
 
 	err = t.conn.DB(dbName).C(users).FindId(u.Username).One(&dbUser)
 	if err != nil {
-		//return models.Ticket{}, mongoErr(err)
 		return mongoErr(err)
 	}
 
-	if len(models.HasPermission(permission.CreateTicket, dbUser, p)) == 0 {
-		//return models.Ticket{}, repo.ErrUnauthorized
+	dbUser.Scopes = u.Scopes
+
+	if len(models.HasPermission(permission.EditTicket, dbUser, p)) == 0 {
 		return repo.ErrUnauthorized
 	}
 
 	if !p.HasTicketType(updated.Type) {
-		//return models.Ticket{}, repo.ErrInvalidTicketType
 		return repo.ErrInvalidTicketType
 	}
 
@@ -83,66 +132,63 @@ func (t ticketRepo) Update(u *models.User, uid string, updated models.Ticket) er
 
 	err = t.conn.DB(dbName).C(fieldSchemes).FindId(p.FieldScheme).One(&fs)
 	if err != nil {
-		//return models.Ticket{}, mongoErr(err)
 		return mongoErr(err)
 	}
 
 	if err := fs.ValidateTicket(updated); err != nil {
-		if err.Error() == "no fields set for this ticket type and default not set" {
-
-			//return models.Ticket{}, repo.ErrInvalidFieldsForTicket
-			return mongoErr(err)
-		}
-
-		//return models.Ticket{}, repo.ErrInvalidFieldsForTicket
-		return mongoErr(err)
+		return repo.ErrInvalidFieldsForTicket
 	}
 
-	updated.Workflow = p.GetWorkflow(updated.Type)
-
-	var wkf models.Workflow
-
-	err = t.conn.DB(dbName).C(workflows).FindId(updated.Workflow).One(&wkf)
-	if err != nil {
-		//return models.Ticket{}, mongoErr(err)
-		return mongoErr(err)
+	changes := ticket.Changes(updated)
+	if len(changes) == 0 {
+		return nil
 	}
 
-	var ticket models.Ticket
-	err = t.coll().FindId(uid).One(&ticket)
-
-	//key, err := t.NextTicketKey(u, ticket.Project)
-	//if err != nil {
-	//      return models.Ticket{}, mongoErr(err)
-	//}
+	// Only the changed fields are set so the status, comments, key,
+	// project and workflow are never touched by an update
+	updated.UpdatedDate = time.Now()
+	updated.Key = ticket.Key
+	updated.CreatedDate = ticket.CreatedDate
+	updated.Status = ticket.Status
+	updated.Comments = ticket.Comments
+	updated.Project = ticket.Project
+	updated.Workflow = ticket.Workflow
 
-	//ticket.Key = key
-	//ticket.CreatedDate = time.Now()
-	ticket.UpdatedDate = time.Now()
-	ticket.Comments = updated.Comments
-	ticket.Status = updated.Status
-	ticket.Watchers = updated.Watchers
+	e := models.NewOutboxEvent(models.UpdatedEvent, u, updated)
+	e.Changes = changes
 
-	err = t.coll().Insert(ticket)
+	err = t.coll().UpdateId(uid, bson.M{
+		"$set":  ticketUpdate(updated, changes),
+		"$push": bson.M{pendingEvents: e},
+	})
 	if err != nil {
-		//return ticket, mongoErr(err)
 		return mongoErr(err)
 	}
 
-	//return ticket, nil
-	return nil
+	return mongoErr(t.insertHistory(u, uid, changes))
 }
 
 func (t ticketRepo) AddComment(u *models.User, uid string, comment models.Comment) (models.Ticket, error) {
 	var ticket models.Ticket
 
+	err := t.coll().FindId(uid).One(&ticket)
+	if err != nil {
+		return ticket, mongoErr(err)
+	}
+
 	comment.CreatedDate = time.Now()
 	comment.UpdatedDate = time.Now()
 	comment.ID = bson.NewObjectId()
 
-	err := t.coll().UpdateId(uid, bson.M{
+	ticket.Comments = append(ticket.Comments, comment)
+
+	e := models.NewOutboxEvent(models.CommentEvent, u, ticket)
+	e.Comment = &comment
+
+	err = t.coll().UpdateId(uid, bson.M{
 		"$push": bson.M{
-			"comments": comment,
+			"comments":    comment,
+			pendingEvents: e,
 		},
 	})
 
@@ -150,10 +196,83 @@ func (t ticketRepo) AddComment(u *models.User, uid string, comment models.Commen
 		return ticket, mongoErr(err)
 	}
 
-	err = t.coll().FindId(uid).One(&ticket)
+	err = t.insertHistory(u, uid, []models.FieldChange{
+		{Field: models.HistoryComment, To: comment.ID.Hex()}})
 	return ticket, mongoErr(err)
 }
 
+func (t ticketRepo) Transition(u *models.User, uid string, name string) (models.Ticket, models.Transition, error) {
+	if u == nil {
+		return models.Ticket{}, models.Transition{}, repo.ErrLoginRequired
+	}
+
+	var ticket models.Ticket
+	var p models.Project
+	var dbUser models.User
+
+	err := t.coll().FindId(uid).One(&ticket)
+	if err != nil {
+		return ticket, models.Transition{}, mongoErr(err)
+	}
+
+	err = t.conn.DB(dbName).C(projects).FindId(ticket.Project).One(&p)
+	if err != nil {
+		return ticket, models.Transition{}, mongoErr(err)
+	}
+
+	err = t.conn.DB(dbName).C(users).FindId(u.Username).One(&dbUser)
+	if err != nil {
+		return ticket, models.Transition{}, mongoErr(err)
+	}
+
+	dbUser.Scopes = u.Scopes
+
+	if len(models.HasPermission(permission.TransitionTicket, dbUser, p)) == 0 {
+		return ticket, models.Transition{}, repo.ErrUnauthorized
+	}
+
+	var wkf models.Workflow
+
+	err = t.conn.DB(dbName).C(workflows).FindId(ticket.Workflow).One(&wkf)
+	if err != nil {
+		return ticket, models.Transition{}, mongoErr(err)
+	}
+
+	transition, ok := ticket.Transition(wkf, name)
+	if !ok {
+		return ticket, transition, repo.ErrInvalidTransition
+	}
+
+	change := models.FieldChange{Field: "status",
+		From: ticket.Status.Name, To: transition.ToStatus.Name}
+
+	ticket.Status = transition.ToStatus
+	ticket.UpdatedDate = time.Now()
+
+	e := models.NewOutboxEvent(models.TransitionEvent, u, ticket)
+	e.Transition = &transition
+
+	// Only update the ticket if it is still in the status the transition was
+	// checked against, otherwise a concurrent transition already moved it
+	err = t.coll().Update(bson.M{"_id": uid, "status.name": change.From}, bson.M{
+		"$set": bson.M{
+			"status":      ticket.Status,
+			"updateddate": ticket.UpdatedDate,
+		},
+		"$push": bson.M{pendingEvents: e},
+	})
+	if err == mgo.ErrNotFound {
+		return ticket, transition, repo.ErrConflict
+	}
+
+	if err != nil {
+		return ticket, transition, mongoErr(err)
+	}
+
+	err = t.insertHistory(u, uid, []models.FieldChange{change})
+	return ticket, transition, mongoErr(err)
+}
+
 func (t ticketRepo) Create(u *models.User, ticket models.Ticket) (models.Ticket, error) {
 	var p models.Project
 	var dbUser models.User
@@ -168,6 +287,8 @@ func (t ticketRepo) Create(u *models.User, ticket models.Ticket) (models.Ticket,
 		return models.Ticket{}, mongoErr(err)
 	}
 
+	dbUser.Scopes = u.Scopes
+
 	if len(models.HasPermission(permission.CreateTicket, dbUser, p)) == 0 {
 		return models.Ticket{}, repo.ErrUnauthorized
 	}
@@ -213,12 +334,19 @@ func (t ticketRepo) Create(u *models.User, ticket models.Ticket) (models.Ticket,
 	ticket.Status = wkf.CreateTransition().ToStatus
 	ticket.Watchers = []string{u.Username}
 
-	err = t.coll().Insert(ticket)
+	err = t.coll().Insert(ticketDocument{
+		Ticket: ticket,
+		PendingEvents: []models.OutboxEvent{
+			models.NewOutboxEvent(models.CreatedEvent, u, ticket),
+		},
+	})
 	if err != nil {
 		return ticket, mongoErr(err)
 	}
 
-	return ticket, nil
+	err = t.insertHistory(u, ticket.Key,
+		[]models.FieldChange{{Field: models.HistoryCreated}})
+	return ticket, mongoErr(err)
 }
 
 func (t ticketRepo) Delete(u *models.User, uid string) error {
@@ -245,7 +373,32 @@ func (t ticketRepo) Delete(u *models.User, uid string) error {
 		return repo.ErrUnauthorized
 	}
 
-	return mongoErr(t.coll().RemoveId(uid))
+	// Events for the ticket are still delivered after it is deleted
+	err = movePendingEvents(t.conn, bson.M{"_id": uid})
+	if err != nil {
+		return mongoErr(err)
+	}
+
+	err = t.coll().RemoveId(uid)
+	if err != nil {
+		return mongoErr(err)
+	}
+
+	_, err = t.conn.DB(dbName).C(history).RemoveAll(bson.M{"ticket": uid})
+	return mongoErr(err)
+}
+
+func (t ticketRepo) History(u *models.User, uid string) ([]models.HistoryEntry, error) {
+	entries := []models.HistoryEntry{}
+
+	_, err := t.Get(u, uid)
+	if err != nil {
+		return entries, err
+	}
+
+	err = t.conn.DB(dbName).C(history).Find(bson.M{"ticket": uid}).
+		Sort("_id").All(&entries)
+	return entries, mongoErr(err)
 }
 
 func (t ticketRepo) Search(u *models.User, query ast.AST) ([]models.Ticket, error) {
@@ -256,6 +409,11 @@ func (t ticketRepo) Search(u *models.User, query ast.AST) ([]models.Ticket, erro
 
 	var tickets []models.Ticket
 
+	queryDoc, err := evalAST(query)
+	if err != nil {
+		return nil, err
+	}
+
 	tQuery := bson.M{
 		"$and": []bson.M{
 			{
@@ -263,7 +421,7 @@ func (t ticketRepo) Search(u *models.User, query ast.AST) ([]models.Ticket, erro
 					"$in": keys,
 				},
 			},
-			evalAST(query),
+			queryDoc,
 		},
 	}
 
@@ -327,7 +485,38 @@ func (t ticketRepo) LabelSearch(u *models.User, query string) ([]string, error)
 	return labelDoc.Labels, mongoErr(err)
 }
 
+// permCache holds the keys of the projects users can view. It has its own
+// cache so that evicting old entries never evicts sessions from
+// repo.GlobalCache.
+var permCache = repocache.NewMemory(1000, time.Minute*10)
+
+// permGeneration is incremented whenever a project changes so that the
+// project keys cached for users are no longer used, the old entries are
+// evicted from permCache
+var permGeneration uint64
+
+func invalidatePermCache() {
+	atomic.AddUint64(&permGeneration, 1)
+}
+
+func permCacheKey(u *models.User) string {
+	if u == nil {
+		u = &models.User{}
+	}
+
+	return fmt.Sprintf("project_keys:%d:%s:%t:%v",
+		atomic.LoadUint64(&permGeneration), u.Username, u.IsAdmin, u.Roles)
+}
+
 func getKeysUserHasPermissionTo(u *models.User, conn *mgo.Session) ([]string, error) {
+	cacheKey := permCacheKey(u)
+
+	if cached, err := permCache.Get(cacheKey); err == nil {
+		if keys, ok := cached.([]string); ok {
+			return keys, nil
+		}
+	}
+
 	permQ := hasViewPermissionQuery(u)
 	var p []models.Project
 
@@ -342,5 +531,10 @@ func getKeysUserHasPermissionTo(u *models.User, conn *mgo.Session) ([]string, er
 		keys[i] = prj.Key
 	}
 
+	err = permCache.Set(cacheKey, keys)
+	if err != nil {
+		dbLog.Println("ERROR: caching project keys:", err)
+	}
+
 	return keys, nil
 }
@@ -7,10 +7,13 @@ package mongo_test
 import (
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/praelatus/praelatus/models"
 	"github.com/praelatus/praelatus/ql/ast"
 	"github.com/praelatus/praelatus/ql/lexer"
 	"github.com/praelatus/praelatus/ql/parser"
+	"gopkg.in/mgo.v2/bson"
 )
 
 func TestTicketGet(t *testing.T) {
@@ -62,7 +65,6 @@ func TestTicketSearchLimit(t *testing.T) {
 }
 
 func TestTicketUpdate(t *testing.T) {
-	t.Skip("Ticket Update is unimplemented")
 
 	tk, e := r.Tickets().Get(&admin, "TEST-4")
 	if e != nil {
@@ -70,7 +72,11 @@ func TestTicketUpdate(t *testing.T) {
 		return
 	}
 
+	comments := len(tk.Comments)
+	from := tk.Summary
+
 	tk.Summary = "Test ticket save"
+	tk.Comments = nil
 
 	e = r.Tickets().Update(&admin, tk.Key, tk)
 	if e != nil {
@@ -87,6 +93,117 @@ func TestTicketUpdate(t *testing.T) {
 	if tk2.Summary != "Test ticket save" {
 		t.Errorf("Expected: Test ticket save Got: %s\n", tk.Summary)
 	}
+
+	if len(tk2.Comments) != comments {
+		t.Errorf("Expected updates not to change the %d comments Got %d", comments, len(tk2.Comments))
+	}
+
+	due, e := r.Outbox().Due(&admin, time.Now().Add(time.Minute), 1000)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	var changes []models.FieldChange
+
+	for _, o := range due {
+		if o.Type == models.UpdatedEvent && o.Ticket.Key == "TEST-4" {
+			changes = o.Changes
+		}
+	}
+
+	if len(changes) != 1 || changes[0].Field != "summary" ||
+		changes[0].From != from || changes[0].To != "Test ticket save" {
+		t.Errorf("Expected an UPDATED event with the summary change Got %v", changes)
+	}
+
+	history, e := r.Tickets().History(&admin, "TEST-4")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if len(history) < 2 || history[0].Field != models.HistoryCreated {
+		t.Fatalf("Expected the history to start with the ticket being created Got %v", history)
+	}
+
+	last := history[len(history)-1]
+	if last.Field != "summary" || last.From != from || last.To != "Test ticket save" ||
+		last.Actor != admin.Username {
+		t.Errorf("Expected the summary change in the history Got %v", history)
+	}
+}
+
+func TestTicketTransition(t *testing.T) {
+	tk, transition, e := r.Tickets().Transition(&admin, "TEST-5", "In Progress")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if transition.Name != "In Progress" {
+		t.Errorf("Expected In Progress Got: %s", transition.Name)
+	}
+
+	tk2, e := r.Tickets().Get(&admin, tk.Key)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if tk2.Status.Name != "In Progress" {
+		t.Errorf("Expected In Progress Got: %s", tk2.Status.Name)
+	}
+
+	history, e := r.Tickets().History(&admin, tk.Key)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if last := history[len(history)-1]; last.Field != "status" || last.To != "In Progress" {
+		t.Errorf("Expected the status change in the history Got %v", history)
+	}
+
+	_, _, e = r.Tickets().Transition(&admin, "TEST-5", "Backlog")
+	if e == nil {
+		t.Error("Expected an error running the create transition but got none.")
+	}
+}
+
+func TestTicketAddCommentAttachments(t *testing.T) {
+	c := models.Comment{
+		Author: "testadmin",
+		Body:   "see attached",
+		Attachments: []models.Attachment{
+			{
+				ID:          bson.NewObjectId(),
+				Name:        "log.txt",
+				ContentType: "text/plain",
+				Size:        12,
+				Path:        "0123abcd",
+			},
+		},
+	}
+
+	ticket, e := r.Tickets().AddComment(&admin, "TEST-4", c)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	a, ok := ticket.Attachment(c.Attachments[0].ID.Hex())
+	if !ok || a != c.Attachments[0] {
+		t.Errorf("Expected %v Got %v", c.Attachments[0], a)
+	}
+
+	history, e := r.Tickets().History(&admin, "TEST-4")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	comment := ticket.Comments[len(ticket.Comments)-1]
+	if last := history[len(history)-1]; last.Field != models.HistoryComment ||
+		last.To != comment.ID.Hex() {
+		t.Errorf("Expected the comment in the history Got %v", history)
+	}
 }
 
 func TestTicketDelete(t *testing.T) {
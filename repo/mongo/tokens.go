@@ -0,0 +1,72 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package mongo
+
+import (
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type tokenRepo struct {
+	conn *mgo.Session
+}
+
+func (tr tokenRepo) coll() *mgo.Collection {
+	return tr.conn.DB(dbName).C(tokens)
+}
+
+// Get does not check permissions since it is used to authenticate the request
+func (tr tokenRepo) Get(u *models.User, uid string) (models.APIToken, error) {
+	var token models.APIToken
+
+	if !bson.IsObjectIdHex(uid) {
+		return token, repo.ErrNotFound
+	}
+
+	err := tr.coll().FindId(bson.ObjectIdHex(uid)).One(&token)
+	return token, mongoErr(err)
+}
+
+func (tr tokenRepo) ForUser(u *models.User, user models.User) ([]models.APIToken, error) {
+	if u == nil || (!u.IsAdmin && u.Username != user.Username) {
+		return nil, repo.ErrUnauthorized
+	}
+
+	tks := []models.APIToken{}
+	err := tr.coll().Find(bson.M{"username": user.Username}).
+		Sort("createddate").All(&tks)
+	return tks, mongoErr(err)
+}
+
+func (tr tokenRepo) Create(u *models.User, token models.APIToken) (models.APIToken, error) {
+	if u == nil || (!u.IsAdmin && u.Username != token.Username) {
+		return token, repo.ErrUnauthorized
+	}
+
+	if token.ID == "" {
+		token.ID = bson.NewObjectId()
+	}
+
+	return token, mongoErr(tr.coll().Insert(token))
+}
+
+func (tr tokenRepo) Delete(u *models.User, uid string) error {
+	if u == nil {
+		return repo.ErrUnauthorized
+	}
+
+	token, err := tr.Get(u, uid)
+	if err != nil {
+		return err
+	}
+
+	if !u.IsAdmin && u.Username != token.Username {
+		return repo.ErrUnauthorized
+	}
+
+	return mongoErr(tr.coll().RemoveId(token.ID))
+}
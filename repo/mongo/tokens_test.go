@@ -0,0 +1,101 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package mongo_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/models/permission"
+	"github.com/praelatus/praelatus/repo"
+)
+
+func TestTokenCreate(t *testing.T) {
+	user := models.User{Username: "testuser"}
+	expires := time.Now().Add(time.Hour).Round(time.Second)
+
+	token, err := r.Tokens().Create(&user, models.APIToken{
+		Name:        "ci",
+		Username:    "testuser",
+		Hash:        "hash",
+		Scopes:      permission.Permissions{}.Add(permission.CreateTicket),
+		CreatedDate: time.Now(),
+		ExpiresDate: &expires,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	stored, err := r.Tokens().Get(nil, token.ID.Hex())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stored.Hash != "hash" || !stored.Scopes.Contains(permission.CreateTicket) {
+		t.Errorf("Expected the stored token to match Got: %v", stored)
+	}
+
+	if stored.ExpiresDate == nil || !stored.ExpiresDate.Equal(expires) {
+		t.Errorf("Expected expiry %s Got: %v", expires, stored.ExpiresDate)
+	}
+
+	tokens, err := r.Tokens().ForUser(&user, user)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(tokens) == 0 {
+		t.Error("Expected to get tokens instead got none.")
+	}
+}
+
+func TestTokenCreateForOtherUser(t *testing.T) {
+	_, err := r.Tokens().Create(&models.User{Username: "testuser"}, models.APIToken{
+		Name:     "ci",
+		Username: "testadmin",
+	})
+	if err != repo.ErrUnauthorized {
+		t.Errorf("Expected %s Got: %v", repo.ErrUnauthorized, err)
+	}
+
+	_, err = r.Tokens().ForUser(&models.User{Username: "testuser"}, admin)
+	if err != repo.ErrUnauthorized {
+		t.Errorf("Expected %s Got: %v", repo.ErrUnauthorized, err)
+	}
+}
+
+func TestTokenDelete(t *testing.T) {
+	user := models.User{Username: "testuser"}
+
+	token, err := r.Tokens().Create(&user, models.APIToken{
+		Name:        "ci",
+		Username:    "testuser",
+		CreatedDate: time.Now(),
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = r.Tokens().Delete(&models.User{Username: "someoneelse"}, token.ID.Hex())
+	if err != repo.ErrUnauthorized {
+		t.Errorf("Expected %s Got: %v", repo.ErrUnauthorized, err)
+	}
+
+	err = r.Tokens().Delete(&user, token.ID.Hex())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = r.Tokens().Get(nil, token.ID.Hex())
+	if err != repo.ErrNotFound {
+		t.Errorf("Expected %s Got: %v", repo.ErrNotFound, err)
+	}
+}
@@ -5,6 +5,8 @@
 package mongo
 
 import (
+	"regexp"
+
 	"github.com/praelatus/praelatus/models"
 	"github.com/praelatus/praelatus/repo"
 	mgo "gopkg.in/mgo.v2"
@@ -30,6 +32,21 @@ func (ur userRepo) Update(u *models.User, uid string, updated models.User) error
 		return repo.ErrUnauthorized
 	}
 
+	// Anyone but an administrator has to verify a new email before they
+	// can log in again, and a new email is never verified
+	changed := bson.M{"emailverified": false}
+	if !u.IsAdmin {
+		changed["pendingverification"] = true
+	}
+
+	err := ur.coll().Update(
+		bson.M{"_id": uid, "email": bson.M{"$ne": updated.Email}},
+		bson.M{"$set": changed},
+	)
+	if err != nil && err != mgo.ErrNotFound {
+		return mongoErr(err)
+	}
+
 	return mongoErr(ur.coll().UpdateId(uid, bson.M{
 		"$set": bson.M{
 			"username":   updated.Username,
@@ -57,6 +74,108 @@ func (ur userRepo) Delete(u *models.User, uid string) error {
 	return mongoErr(ur.coll().RemoveId(uid))
 }
 
+// GetByEmail will return the user with the given email ignoring case
+func (ur userRepo) GetByEmail(u *models.User, email string) (models.User, error) {
+	var user models.User
+
+	err := ur.coll().Find(bson.M{
+		"email": bson.M{
+			"$regex":   "^" + regexp.QuoteMeta(email) + "$",
+			"$options": "i",
+		},
+	}).Sort("_id").One(&user)
+	return user, mongoErr(err)
+}
+
+// GetByChatAccount will return the user who linked the chat account
+func (ur userRepo) GetByChatAccount(u *models.User, account models.ChatAccount) (models.User, error) {
+	var user models.User
+
+	if !account.Linked() {
+		return user, repo.ErrNotFound
+	}
+
+	err := ur.coll().Find(bson.M{"chataccount": account}).One(&user)
+	return user, mongoErr(err)
+}
+
+// SetChatAccount will link the user to the chat account, unlinking it from
+// any other user. Only the user themselves or an admin can link an account.
+func (ur userRepo) SetChatAccount(u *models.User, uid string, account models.ChatAccount) error {
+	if u == nil || (!u.IsAdmin && u.Username != uid) {
+		return repo.ErrUnauthorized
+	}
+
+	if account.Linked() {
+		_, err := ur.coll().UpdateAll(
+			bson.M{"_id": bson.M{"$ne": uid}, "chataccount": account},
+			bson.M{"$set": bson.M{"chataccount": models.ChatAccount{}}},
+		)
+		if err != nil {
+			return mongoErr(err)
+		}
+	}
+
+	return mongoErr(ur.coll().UpdateId(uid, bson.M{
+		"$set": bson.M{"chataccount": account},
+	}))
+}
+
+// SetRoles will replace the roles of the user, only administrators can change
+// roles
+func (ur userRepo) SetRoles(u *models.User, uid string, roles []models.UserRole) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrUnauthorized
+	}
+
+	err := ur.coll().UpdateId(uid, bson.M{
+		"$set": bson.M{"roles": roles},
+	})
+
+	invalidatePermCache()
+	return mongoErr(err)
+}
+
+// SetTwoFactor will replace the two-factor authentication settings of the
+// user, only the user themselves or an admin can change them
+func (ur userRepo) SetTwoFactor(u *models.User, uid string, tf models.TwoFactor) error {
+	if u == nil || (!u.IsAdmin && u.Username != uid) {
+		return repo.ErrUnauthorized
+	}
+
+	return mongoErr(ur.coll().UpdateId(uid, bson.M{
+		"$set": bson.M{"twofactor": tf},
+	}))
+}
+
+// SetPassword will replace the password hash of the user, only the user
+// themselves or an admin can change it
+func (ur userRepo) SetPassword(u *models.User, uid string, password string) error {
+	if u == nil || (!u.IsAdmin && u.Username != uid) {
+		return repo.ErrUnauthorized
+	}
+
+	return mongoErr(ur.coll().UpdateId(uid, bson.M{
+		"$set": bson.M{"password": password},
+	}))
+}
+
+// Activate will mark the user as active once they have verified their email,
+// only the user themselves or an admin can activate them
+func (ur userRepo) Activate(u *models.User, uid string) error {
+	if u == nil || (!u.IsAdmin && u.Username != uid) {
+		return repo.ErrUnauthorized
+	}
+
+	return mongoErr(ur.coll().UpdateId(uid, bson.M{
+		"$set": bson.M{
+			"isactive":            true,
+			"pendingverification": false,
+			"emailverified":       true,
+		},
+	}))
+}
+
 func (ur userRepo) Search(u *models.User, query string) ([]models.User, error) {
 	var users []models.User
 
@@ -6,6 +6,9 @@ package mongo_test
 
 import (
 	"testing"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
 )
 
 func TestUserGet(t *testing.T) {
@@ -58,6 +61,134 @@ func TestUserUpdate(t *testing.T) {
 	}
 }
 
+func TestUserGetByEmail(t *testing.T) {
+	u, e := r.Users().GetByEmail(&admin, "TEST@example.com")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if u.Username != "testadmin" {
+		t.Errorf("Expected: testadmin Got: %s\n", u.Username)
+	}
+
+	if _, e = r.Users().GetByEmail(&admin, "nobody@example.com"); e != repo.ErrNotFound {
+		t.Errorf("Expected %s Got: %v", repo.ErrNotFound, e)
+	}
+}
+
+func TestUserSetTwoFactor(t *testing.T) {
+	tf := models.TwoFactor{
+		Enabled:       true,
+		Secret:        "JBSWY3DPEHPK3PXP",
+		RecoveryCodes: []string{"hash"},
+		LastCounter:   42,
+	}
+
+	e := r.Users().SetTwoFactor(&admin, "testadmin", tf)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	defer r.Users().SetTwoFactor(&admin, "testadmin", models.TwoFactor{})
+
+	u, e := r.Users().Get(&admin, "testadmin")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if !u.TwoFactor.Enabled || u.TwoFactor.Secret != tf.Secret ||
+		len(u.TwoFactor.RecoveryCodes) != 1 || u.TwoFactor.LastCounter != 42 {
+		t.Errorf("Expected: %v Got: %v\n", tf, u.TwoFactor)
+	}
+
+	other := models.User{Username: "someone"}
+	if e = r.Users().SetTwoFactor(&other, "testadmin", tf); e != repo.ErrUnauthorized {
+		t.Errorf("Expected %s Got: %v", repo.ErrUnauthorized, e)
+	}
+}
+
+func TestUserSetPassword(t *testing.T) {
+	u, e := models.NewUser("pwuser", "oldpass", "Password User", "pw@example.com", false)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	u.PendingVerification = true
+
+	_, e = r.Users().Create(&admin, *u)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	defer r.Users().Delete(&admin, "pwuser")
+
+	changed, _ := models.NewUser("pwuser", "newpass", "", "", false)
+
+	e = r.Users().SetPassword(u, "pwuser", changed.Password)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	e = r.Users().Activate(u, "pwuser")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	stored, e := r.Users().Get(&admin, "pwuser")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if !stored.CheckPw([]byte("newpass")) {
+		t.Error("Expected the password to be changed")
+	}
+
+	if !stored.IsActive || stored.PendingVerification {
+		t.Errorf("Expected the user to be active Got: %v %v", stored.IsActive, stored.PendingVerification)
+	}
+
+	other := models.User{Username: "someone"}
+	if e = r.Users().SetPassword(&other, "pwuser", "x"); e != repo.ErrUnauthorized {
+		t.Errorf("Expected %s Got: %v", repo.ErrUnauthorized, e)
+	}
+
+	if e = r.Users().Activate(&other, "pwuser"); e != repo.ErrUnauthorized {
+		t.Errorf("Expected %s Got: %v", repo.ErrUnauthorized, e)
+	}
+}
+
+func TestUserSetRoles(t *testing.T) {
+	roles := []models.UserRole{{Project: "TEST", Role: "Administrator"}}
+
+	e := r.Users().SetRoles(&models.User{Username: "testuser"}, "testuser", roles)
+	if e != repo.ErrUnauthorized {
+		t.Errorf("Expected %s Got: %v", repo.ErrUnauthorized, e)
+	}
+
+	e = r.Users().SetRoles(&admin, "testuser", roles)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	u, e := r.Users().Get(&admin, "testuser")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if len(u.Roles) != 1 || u.Roles[0] != roles[0] {
+		t.Errorf("Expected: %v Got: %v\n", roles, u.Roles)
+	}
+}
+
 func TestUserDelete(t *testing.T) {
 	e := r.Users().Delete(&admin, "testuser")
 	if e != nil {
@@ -0,0 +1,122 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package mongo
+
+import (
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/models/permission"
+	"github.com/praelatus/praelatus/repo"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type webhookRepo struct {
+	conn *mgo.Session
+}
+
+func (wr webhookRepo) coll() *mgo.Collection {
+	return wr.conn.DB(dbName).C(webhooks)
+}
+
+// canAdminProject returns an error if the user can't administer the project,
+// users who aren't stored such as the system user keep their own permissions
+func (wr webhookRepo) canAdminProject(u *models.User, projectKey string) error {
+	if u == nil {
+		return repo.ErrLoginRequired
+	}
+
+	var p models.Project
+
+	err := wr.conn.DB(dbName).C(projects).FindId(projectKey).One(&p)
+	if err != nil {
+		return mongoErr(err)
+	}
+
+	dbUser := *u
+
+	var stored models.User
+	if wr.conn.DB(dbName).C(users).FindId(u.Username).One(&stored) == nil {
+		stored.IsAdmin = stored.IsAdmin || u.IsAdmin
+		stored.Scopes = u.Scopes
+		dbUser = stored
+	}
+
+	if len(models.HasPermission(permission.AdminProject, dbUser, p)) == 0 {
+		return repo.ErrUnauthorized
+	}
+
+	return nil
+}
+
+func (wr webhookRepo) Get(u *models.User, uid string) (models.Webhook, error) {
+	var w models.Webhook
+
+	if !bson.IsObjectIdHex(uid) {
+		return w, repo.ErrNotFound
+	}
+
+	err := wr.coll().FindId(bson.ObjectIdHex(uid)).One(&w)
+	if err != nil {
+		return w, mongoErr(err)
+	}
+
+	return w, wr.canAdminProject(u, w.Project)
+}
+
+func (wr webhookRepo) ForProject(u *models.User, projectKey string) ([]models.Webhook, error) {
+	err := wr.canAdminProject(u, projectKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ws := []models.Webhook{}
+	err = wr.coll().Find(bson.M{"project": projectKey}).Sort("_id").All(&ws)
+	return ws, mongoErr(err)
+}
+
+func (wr webhookRepo) Create(u *models.User, w models.Webhook) (models.Webhook, error) {
+	err := wr.canAdminProject(u, w.Project)
+	if err != nil {
+		return w, err
+	}
+
+	w.ID = bson.NewObjectId()
+	w.CreatedDate = time.Now()
+
+	return w, mongoErr(wr.coll().Insert(w))
+}
+
+func (wr webhookRepo) Update(u *models.User, uid string, w models.Webhook) error {
+	stored, err := wr.Get(u, uid)
+	if err != nil {
+		return err
+	}
+
+	// Secrets are never sent to clients so they don't send them back
+	if w.Secret == "" {
+		w.Secret = stored.Secret
+	}
+
+	return mongoErr(wr.coll().UpdateId(stored.ID, bson.M{
+		"$set": bson.M{
+			"url":    w.URL,
+			"events": w.Events,
+			"query":  w.Query,
+			"format": w.Format,
+			"secret": w.Secret,
+		},
+	}))
+}
+
+func (wr webhookRepo) Delete(u *models.User, uid string) error {
+	stored, err := wr.Get(u, uid)
+	if err != nil {
+		return err
+	}
+
+	return mongoErr(wr.coll().RemoveId(stored.ID))
+}
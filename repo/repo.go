@@ -8,6 +8,7 @@ package repo
 
 import (
 	"errors"
+	"time"
 
 	"github.com/praelatus/praelatus/models"
 	"github.com/praelatus/praelatus/models/permission"
@@ -22,6 +23,8 @@ var (
 	ErrNotFound                     = errors.New("not found")
 	ErrInvalidTicketType            = errors.New("invalid ticket type for project")
 	ErrInvalidFieldsForTicket       = errors.New("invalid fields for ticket of that type for project")
+	ErrInvalidTransition            = errors.New("invalid transition for ticket")
+	ErrConflict                     = errors.New("the ticket was changed while updating it, try again")
 )
 
 // TicketRepo handles storing, retrieving, updating, and creating tickets.
@@ -33,8 +36,12 @@ type TicketRepo interface {
 	Delete(u *models.User, uid string) error
 
 	AddComment(u *models.User, uid string, comment models.Comment) (models.Ticket, error)
+	Transition(u *models.User, uid string, name string) (models.Ticket, models.Transition, error)
 	NextTicketKey(u *models.User, projectKey string) (string, error)
 	LabelSearch(u *models.User, query string) ([]string, error)
+	// History returns the changes made to the ticket's fields, oldest
+	// first
+	History(u *models.User, uid string) ([]models.HistoryEntry, error)
 }
 
 // FieldSchemeRepo handles storing, retrieving, updating, and creating field schemes.
@@ -65,6 +72,14 @@ type UserRepo interface {
 	Update(u *models.User, uid string, updated models.User) error
 	Create(u *models.User, user models.User) (models.User, error)
 	Delete(u *models.User, uid string) error
+
+	GetByEmail(u *models.User, email string) (models.User, error)
+	GetByChatAccount(u *models.User, account models.ChatAccount) (models.User, error)
+	SetRoles(u *models.User, uid string, roles []models.UserRole) error
+	SetTwoFactor(u *models.User, uid string, tf models.TwoFactor) error
+	SetChatAccount(u *models.User, uid string, account models.ChatAccount) error
+	SetPassword(u *models.User, uid string, password string) error
+	Activate(u *models.User, uid string) error
 }
 
 // WorkflowRepo handles storing, retrieving, updating, and creating workflows.
@@ -87,6 +102,56 @@ type NotificationRepo interface {
 	ActivityForUser(u *models.User, user models.User, onlyUnread bool, last int) ([]models.Notification, error)
 }
 
+// TokenRepo handles storing, retrieving and revoking personal API tokens.
+type TokenRepo interface {
+	Get(u *models.User, uid string) (models.APIToken, error)
+	ForUser(u *models.User, user models.User) ([]models.APIToken, error)
+	Create(u *models.User, token models.APIToken) (models.APIToken, error)
+	Delete(u *models.User, uid string) error
+}
+
+// OutboxRepo stores ticket events until they have been handled, only
+// administrators can use it
+type OutboxRepo interface {
+	Get(u *models.User, uid string) (models.OutboxEvent, error)
+	Create(u *models.User, e models.OutboxEvent) (models.OutboxEvent, error)
+	Update(u *models.User, uid string, e models.OutboxEvent) error
+
+	// Due returns up to limit pending events whose next attempt is before
+	// the given time, oldest first
+	Due(u *models.User, before time.Time, limit int) ([]models.OutboxEvent, error)
+	// Requeue will make every failed event pending again
+	Requeue(u *models.User) error
+	// Prune will delete the events which were done before the given time
+	Prune(u *models.User, before time.Time) error
+}
+
+// DeliveryRepo stores the requests sent for webhooks, only administrators can
+// use it
+type DeliveryRepo interface {
+	Get(u *models.User, uid string) (models.HookDelivery, error)
+	Create(u *models.User, d models.HookDelivery) (models.HookDelivery, error)
+
+	// ForWorkflow returns up to limit deliveries for the workflow's hooks,
+	// newest first
+	ForWorkflow(u *models.User, workflowID string, limit int) ([]models.HookDelivery, error)
+	// ForWebhook returns up to limit deliveries for the webhook, newest
+	// first
+	ForWebhook(u *models.User, webhookID string, limit int) ([]models.HookDelivery, error)
+	// Prune will delete the deliveries made before the given time
+	Prune(u *models.User, before time.Time) error
+}
+
+// WebhookRepo stores the webhooks of projects, they can be managed by users
+// who can administer the project
+type WebhookRepo interface {
+	Get(u *models.User, uid string) (models.Webhook, error)
+	ForProject(u *models.User, projectKey string) ([]models.Webhook, error)
+	Create(u *models.User, w models.Webhook) (models.Webhook, error)
+	Update(u *models.User, uid string, w models.Webhook) error
+	Delete(u *models.User, uid string) error
+}
+
 // Repo is a container interface for combining all the other repos.
 type Repo interface {
 	Tickets() TicketRepo
@@ -95,6 +160,10 @@ type Repo interface {
 	Fields() FieldSchemeRepo
 	Workflows() WorkflowRepo
 	Notifications() NotificationRepo
+	Tokens() TokenRepo
+	Outbox() OutboxRepo
+	Deliveries() DeliveryRepo
+	Webhooks() WebhookRepo
 
 	Clean() error
 	Test() error
@@ -102,7 +171,8 @@ type Repo interface {
 }
 
 // Cache is used for storing temporary resources. Usually backed by Mongo, Bolt
-// or Redis
+// or Redis. TakeSession removes the session at key and returns it, or
+// ErrNotFound if it was already gone, so only one caller can take a session.
 type Cache interface {
 	Get(key string) (interface{}, error)
 	Set(key string, value interface{}) error
@@ -110,12 +180,17 @@ type Cache interface {
 	GetSession(key string) (models.Session, error)
 	SetSession(key string, user models.Session) error
 	RemoveSession(key string) error
+	TakeSession(key string) (models.Session, error)
 }
 
 // GlobalRepo is used to store a global repo instance that can be accessed from
 // anywhere in the application
 var GlobalRepo Repo
 
+// GlobalCache is used to store a global cache instance that can be accessed
+// from anywhere in the application, it is nil when no cache is configured
+var GlobalCache Cache
+
 // Tickets is an alias to the method of the same name on the global Repo
 func Tickets() TicketRepo { return GlobalRepo.Tickets() }
 
@@ -134,6 +209,18 @@ func Workflows() WorkflowRepo { return GlobalRepo.Workflows() }
 // Notifications is an alias to the method of the same name on the global Repo
 func Notifications() NotificationRepo { return GlobalRepo.Notifications() }
 
+// Tokens is an alias to the method of the same name on the global Repo
+func Tokens() TokenRepo { return GlobalRepo.Tokens() }
+
+// Outbox is an alias to the method of the same name on the global Repo
+func Outbox() OutboxRepo { return GlobalRepo.Outbox() }
+
+// Deliveries is an alias to the method of the same name on the global Repo
+func Deliveries() DeliveryRepo { return GlobalRepo.Deliveries() }
+
+// Webhooks is an alias to the method of the same name on the global Repo
+func Webhooks() WebhookRepo { return GlobalRepo.Webhooks() }
+
 // Clean is an alias to the method of the same name on the global Repo
 func Clean() error { return GlobalRepo.Clean() }
 
@@ -0,0 +1,215 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package sql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/praelatus/praelatus/ql/ast"
+	"github.com/praelatus/praelatus/ql/token"
+)
+
+// pqlColumns maps the default PQL fields to columns of the tickets table
+// which is aliased as t
+var pqlColumns = map[string]string{
+	"createddate":    "t.created_date",
+	"updateddate":    "t.updated_date",
+	"key":            "t.key",
+	"summary":        "t.summary",
+	"description":    "t.description",
+	"status":         "t.status_name",
+	"statuscategory": "t.status_type",
+	"reporter":       "t.reporter",
+	"assignee":       "t.assignee",
+	"type":           "t.type",
+	"project":        "t.project",
+}
+
+var sqlOperators = map[string]string{
+	"=":  "=",
+	"!=": "<>",
+	">":  ">",
+	"<":  "<",
+	">=": ">=",
+	"<=": "<=",
+	"~":  "LIKE",
+}
+
+func eval(exp ast.InfixExpression) (string, []interface{}, error) {
+	switch exp.Operator {
+	case "AND", "OR":
+		left, ok := exp.Left.(ast.InfixExpression)
+		if !ok {
+			return "", nil, ast.ErrInvalidExpression
+		}
+
+		right, ok := exp.Right.(ast.InfixExpression)
+		if !ok {
+			return "", nil, ast.ErrInvalidExpression
+		}
+
+		lq, largs, err := eval(left)
+		if err != nil {
+			return "", nil, err
+		}
+
+		rq, rargs, err := eval(right)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return "(" + lq + " " + exp.Operator + " " + rq + ")", append(largs, rargs...), nil
+	}
+
+	op, ok := sqlOperators[exp.Operator]
+	if !ok {
+		return "", nil, ast.ErrInvalidExpression
+	}
+
+	val, ok := exp.Right.(ast.Literal)
+	if !ok {
+		return "", nil, ast.ErrInvalidExpression
+	}
+
+	fn, ok := exp.Left.(ast.FieldLiteral)
+	if !ok {
+		return "", nil, ast.ErrInvalidExpression
+	}
+
+	where, args := makeFieldSearchClause(fn, op, val)
+	return where, args, nil
+}
+
+// evalAST returns the WHERE clause for the query, it returns
+// ast.ErrInvalidExpression rather than matching everything for queries which
+// can't be searched with
+func evalAST(a ast.AST) (string, []interface{}, error) {
+	if a.Query.Expression == nil {
+		return "1 = 1", nil, nil
+	}
+
+	infix, ok := a.Query.Expression.(ast.InfixExpression)
+	if !ok {
+		return "", nil, ast.ErrInvalidExpression
+	}
+
+	return eval(infix)
+}
+
+func makeFieldSearchClause(fn ast.FieldLiteral, op string, val ast.Literal) (string, []interface{}) {
+	value := val.GetValue()
+	placeholder := " ?"
+
+	if op == "LIKE" {
+		value = likePattern(fmt.Sprint(value))
+		placeholder = ` ? ESCAPE '\'`
+	}
+
+	field := strings.ToLower(fn.Value)
+
+	if column, ok := pqlColumns[field]; ok {
+		if op == "LIKE" {
+			column = "LOWER(" + column + ")"
+		}
+
+		return column + " " + op + placeholder, []interface{}{value}
+	}
+
+	if field == "labels" {
+		column := "tl.label"
+		if op == "LIKE" {
+			column = "LOWER(tl.label)"
+		}
+
+		// Tickets which have no labels should match a != query so check
+		// that no label is equal instead.
+		if op == "<>" {
+			return `NOT EXISTS (SELECT 1 FROM ticket_labels tl
+				WHERE tl.ticket_key = t.key AND tl.label = ?)`, []interface{}{value}
+		}
+
+		return `EXISTS (SELECT 1 FROM ticket_labels tl
+			WHERE tl.ticket_key = t.key AND ` + column + " " + op + placeholder + ")",
+			[]interface{}{value}
+	}
+
+	column := "tf.string_value"
+	switch val.(type) {
+	case ast.IntegerLiteral:
+		column = "tf.number_value"
+	case ast.DateLiteral:
+		column = "tf.date_value"
+	}
+
+	if op == "LIKE" {
+		column = "LOWER(tf.string_value)"
+	}
+
+	return `EXISTS (SELECT 1 FROM ticket_fields tf
+		WHERE tf.ticket_key = t.key AND tf.name = ? AND ` + column + " " + op + placeholder + ")",
+		[]interface{}{fn.Value, value}
+}
+
+// orderColumns will return the columns to order by for the comma separated
+// fields of an ORDER_BY, fields prefixed with - are sorted in descending
+// order. Fields which aren't columns are skipped.
+func orderColumns(fields string) []string {
+	var columns []string
+
+	for _, field := range strings.Split(fields, ",") {
+		direction := ""
+		if strings.HasPrefix(field, "-") {
+			field = strings.TrimPrefix(field, "-")
+			direction = " DESC"
+		}
+
+		column, ok := pqlColumns[strings.ToLower(field)]
+		if !ok {
+			continue
+		}
+
+		columns = append(columns, column+direction)
+	}
+
+	return columns
+}
+
+// evalModifiers will return the ORDER BY and LIMIT clauses for the modifiers
+// of the given query
+func evalModifiers(a ast.AST) string {
+	var orderBy, limit string
+
+	for _, mod := range a.Modifiers {
+		switch mod.Token.Type {
+		case token.ORDER:
+			fn, ok := mod.Value.(ast.FieldLiteral)
+			if !ok {
+				continue
+			}
+
+			columns := orderColumns(fn.Value)
+			if len(columns) == 0 {
+				continue
+			}
+
+			orderBy = " ORDER BY " + strings.Join(columns, ", ")
+		case token.LIMIT:
+			il, ok := mod.Value.(ast.IntegerLiteral)
+			if !ok {
+				continue
+			}
+
+			limit = " LIMIT " + strconv.FormatInt(il.Value, 10)
+		}
+	}
+
+	if orderBy == "" {
+		orderBy = " ORDER BY t.created_date, t.key"
+	}
+
+	return orderBy + limit
+}
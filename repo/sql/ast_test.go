@@ -0,0 +1,117 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package sql
+
+import (
+	"testing"
+
+	"github.com/praelatus/praelatus/ql/ast"
+	"github.com/praelatus/praelatus/ql/lexer"
+	"github.com/praelatus/praelatus/ql/parser"
+)
+
+func TestSimpleEval(t *testing.T) {
+	query := "summary = \"test\""
+	l := lexer.New(query)
+	p := parser.New(l)
+	a := p.Parse()
+
+	where, args, err := evalAST(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if where != "t.summary = ?" {
+		t.Errorf("Expected: t.summary = ? Got: %s", where)
+	}
+
+	if len(args) != 1 || args[0] != "test" {
+		t.Errorf("Expected: [test] Got: %v", args)
+	}
+}
+
+func TestComplexEval(t *testing.T) {
+	query := "summary = \"test this parser\" OR (project = \"TEST\" AND (key = \"TEST-1\"))"
+	l := lexer.New(query)
+	p := parser.New(l)
+	a := p.Parse()
+
+	where, args, err := evalAST(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "(t.summary = ? OR (t.project = ? AND t.key = ?))"
+
+	if where != expected {
+		t.Errorf("Expected: %s Got: %s", expected, where)
+	}
+
+	if len(args) != 3 {
+		t.Errorf("Expected 3 arguments Got: %v", args)
+	}
+}
+
+func TestCustomFieldEval(t *testing.T) {
+	query := "storyPoints > 5"
+	l := lexer.New(query)
+	p := parser.New(l)
+	a := p.Parse()
+
+	where, args, err := evalAST(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(where)
+
+	if len(args) != 2 || args[0] != "storyPoints" || args[1] != int64(5) {
+		t.Errorf("Expected: [storyPoints 5] Got: %v", args)
+	}
+}
+
+func TestBareFieldEval(t *testing.T) {
+	a := parser.New(lexer.New("summary")).Parse()
+
+	if _, _, err := evalAST(a); err != ast.ErrInvalidExpression {
+		t.Errorf("Expected %s Got %v", ast.ErrInvalidExpression, err)
+	}
+
+	key := parser.New(lexer.New("key = \"TEST-1\"")).Parse()
+	a.Query.Expression = ast.InfixExpression{
+		Left:     key.Query.Expression,
+		Operator: "AND",
+		Right:    a.Query.Expression,
+	}
+
+	if _, _, err := evalAST(a); err != ast.ErrInvalidExpression {
+		t.Errorf("Expected %s Got %v", ast.ErrInvalidExpression, err)
+	}
+}
+
+func TestModifiers(t *testing.T) {
+	tests := map[string]string{
+		"summary = \"test\" ORDER_BY assignee LIMIT 5": " ORDER BY t.assignee LIMIT 5",
+		"summary = \"test\" ORDER_BY -assignee":        " ORDER BY t.assignee DESC",
+		"summary = \"test\" ORDER_BY -status,key":      " ORDER BY t.status_name DESC, t.key",
+		"summary = \"test\" ORDER_BY -unknown LIMIT 5": " ORDER BY t.created_date, t.key LIMIT 5",
+	}
+
+	for query, expected := range tests {
+		a := parser.New(lexer.New(query)).Parse()
+
+		mods := evalModifiers(a)
+		if mods != expected {
+			t.Errorf("Expected: %s Got: %s", expected, mods)
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	q := postgres.rebind("SELECT * FROM tickets WHERE key = ? AND project = ?")
+	expected := "SELECT * FROM tickets WHERE key = $1 AND project = $2"
+
+	if q != expected {
+		t.Errorf("Expected: %s Got: %s", expected, q)
+	}
+}
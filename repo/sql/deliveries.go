@@ -0,0 +1,116 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package sql
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type deliveryRepo struct {
+	conn conn
+}
+
+// The request and response of a delivery are only ever read whole so the
+// delivery is stored as JSON
+func scanDelivery(row scanner) (models.HookDelivery, error) {
+	var d models.HookDelivery
+	var payload string
+
+	err := row.Scan(&payload)
+	if err != nil {
+		return d, err
+	}
+
+	err = json.Unmarshal([]byte(payload), &d)
+	return d, err
+}
+
+func (dr deliveryRepo) Get(u *models.User, uid string) (models.HookDelivery, error) {
+	if u == nil || !u.IsAdmin {
+		return models.HookDelivery{}, repo.ErrAdminRequired
+	}
+
+	d, err := scanDelivery(dr.conn.QueryRow(
+		"SELECT payload FROM hook_deliveries WHERE id = ?", uid))
+	return d, sqlErr(err)
+}
+
+func (dr deliveryRepo) Create(u *models.User, d models.HookDelivery) (models.HookDelivery, error) {
+	if u == nil || !u.IsAdmin {
+		return d, repo.ErrAdminRequired
+	}
+
+	if d.ID == "" {
+		d.ID = bson.NewObjectId()
+	}
+
+	payload, err := json.Marshal(d)
+	if err != nil {
+		return d, err
+	}
+
+	_, err = dr.conn.Exec(`INSERT INTO hook_deliveries
+		(id, workflow, webhook, ticket_key, success, payload, created_date)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, d.ID.Hex(), d.Workflow.Hex(),
+		d.Webhook.Hex(), d.Ticket, d.Success, string(payload), d.CreatedDate.UTC())
+	return d, sqlErr(err)
+}
+
+// newest returns up to limit of the deliveries whose column has the value,
+// newest first
+func (dr deliveryRepo) newest(column, value string, limit int) ([]models.HookDelivery, error) {
+	rows, err := dr.conn.Query(`SELECT payload FROM hook_deliveries
+		WHERE `+column+` = ? ORDER BY created_date DESC, id DESC LIMIT ?`,
+		value, limit)
+	if err != nil {
+		return nil, sqlErr(err)
+	}
+
+	defer rows.Close()
+
+	ds := []models.HookDelivery{}
+
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, sqlErr(err)
+		}
+
+		ds = append(ds, d)
+	}
+
+	return ds, sqlErr(rows.Err())
+}
+
+func (dr deliveryRepo) ForWorkflow(u *models.User, workflowID string, limit int) ([]models.HookDelivery, error) {
+	if u == nil || !u.IsAdmin {
+		return nil, repo.ErrAdminRequired
+	}
+
+	return dr.newest("workflow", workflowID, limit)
+}
+
+func (dr deliveryRepo) ForWebhook(u *models.User, webhookID string, limit int) ([]models.HookDelivery, error) {
+	if u == nil || !u.IsAdmin {
+		return nil, repo.ErrAdminRequired
+	}
+
+	return dr.newest("webhook", webhookID, limit)
+}
+
+func (dr deliveryRepo) Prune(u *models.User, before time.Time) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	_, err := dr.conn.Exec("DELETE FROM hook_deliveries WHERE created_date < ?",
+		before.UTC())
+	return sqlErr(err)
+}
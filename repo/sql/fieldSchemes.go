@@ -0,0 +1,134 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package sql
+
+import (
+	"encoding/json"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type fieldSchemeRepo struct {
+	conn conn
+}
+
+func scanFieldScheme(row scanner) (models.FieldScheme, error) {
+	var fs models.FieldScheme
+	var id, fields string
+
+	err := row.Scan(&id, &fs.Name, &fields)
+	if err != nil {
+		return fs, err
+	}
+
+	fs.ID = objectID(id)
+	err = json.Unmarshal([]byte(fields), &fs.Fields)
+	return fs, err
+}
+
+func getFieldScheme(q querier, uid string) (models.FieldScheme, error) {
+	return scanFieldScheme(q.QueryRow(
+		"SELECT id, name, fields FROM field_schemes WHERE id = ?", uid))
+}
+
+func (fs fieldSchemeRepo) Get(u *models.User, uid string) (models.FieldScheme, error) {
+	if u == nil {
+		return models.FieldScheme{}, repo.ErrLoginRequired
+	}
+
+	f, err := getFieldScheme(fs.conn, uid)
+	return f, sqlErr(err)
+}
+
+func (fs fieldSchemeRepo) Update(u *models.User, uid string, updated models.FieldScheme) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	// FIXME: Handle what to do with tickets and projects associated with this
+	// field scheme
+
+	fields, err := json.Marshal(updated.Fields)
+	if err != nil {
+		return err
+	}
+
+	res, err := fs.conn.Exec("UPDATE field_schemes SET name = ?, fields = ? WHERE id = ?",
+		updated.Name, string(fields), uid)
+	if err != nil {
+		return sqlErr(err)
+	}
+
+	return rowsAffected(res)
+}
+
+func (fs fieldSchemeRepo) Create(u *models.User, fieldScheme models.FieldScheme) (models.FieldScheme, error) {
+	if u == nil || !u.IsAdmin {
+		return models.FieldScheme{}, repo.ErrAdminRequired
+	}
+
+	fieldScheme.ID = bson.NewObjectId()
+
+	fields, err := json.Marshal(fieldScheme.Fields)
+	if err != nil {
+		return fieldScheme, err
+	}
+
+	_, err = fs.conn.Exec("INSERT INTO field_schemes (id, name, fields) VALUES (?, ?, ?)",
+		fieldScheme.ID.Hex(), fieldScheme.Name, string(fields))
+	return fieldScheme, sqlErr(err)
+}
+
+func (fs fieldSchemeRepo) Delete(u *models.User, uid string) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	// FIXME: Handle what to do with tickets and projects associated with this
+	// workflow
+
+	res, err := fs.conn.Exec("DELETE FROM field_schemes WHERE id = ?", uid)
+	if err != nil {
+		return sqlErr(err)
+	}
+
+	return rowsAffected(res)
+}
+
+func (fs fieldSchemeRepo) Search(u *models.User, query string) ([]models.FieldScheme, error) {
+	if u == nil || !u.IsAdmin {
+		return []models.FieldScheme{}, repo.ErrAdminRequired
+	}
+
+	q := "SELECT id, name, fields FROM field_schemes"
+	args := []interface{}{}
+
+	if query != "" {
+		q += ` WHERE LOWER(name) LIKE ? ESCAPE '\'`
+		args = append(args, likePattern(query))
+	}
+
+	rows, err := fs.conn.Query(q+" ORDER BY name", args...)
+	if err != nil {
+		return nil, sqlErr(err)
+	}
+
+	defer rows.Close()
+
+	schemes := []models.FieldScheme{}
+
+	for rows.Next() {
+		f, err := scanFieldScheme(rows)
+		if err != nil {
+			return nil, sqlErr(err)
+		}
+
+		schemes = append(schemes, f)
+	}
+
+	return schemes, sqlErr(rows.Err())
+}
@@ -0,0 +1,276 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package sql
+
+import "strings"
+
+// tables holds the name of every table with data in it in the order they are
+// created
+var tables = []string{
+	"users",
+	"field_schemes",
+	"workflows",
+	"projects",
+	"project_permissions",
+	"user_roles",
+	"tickets",
+	"ticket_labels",
+	"ticket_watchers",
+	"ticket_fields",
+	"comments",
+	"notifications",
+	"api_tokens",
+	"outbox",
+	"hook_deliveries",
+	"webhooks",
+	"ticket_history",
+}
+
+// migration is a list of statements which will be run in a single transaction
+// to move the schema to the next version. The placeholder TIMESTAMP is
+// replaced with the timestamp type for the dialect.
+type migration []string
+
+// migrations holds every schema migration in the order in which they need to
+// run, a migrations version is its index plus one. Never modify a migration
+// which has been released, add a new one instead.
+var migrations = []migration{
+	{
+		`CREATE TABLE users (
+			username    TEXT PRIMARY KEY,
+			password    TEXT NOT NULL,
+			email       TEXT NOT NULL,
+			full_name   TEXT NOT NULL,
+			profile_pic TEXT NOT NULL DEFAULT '',
+			is_admin    BOOLEAN NOT NULL DEFAULT FALSE,
+			is_active   BOOLEAN NOT NULL DEFAULT FALSE,
+			settings    TEXT NOT NULL DEFAULT '{}'
+		)`,
+		`CREATE TABLE field_schemes (
+			id     TEXT PRIMARY KEY,
+			name   TEXT NOT NULL,
+			fields TEXT NOT NULL DEFAULT '{}'
+		)`,
+		`CREATE TABLE workflows (
+			id          TEXT PRIMARY KEY,
+			name        TEXT NOT NULL,
+			transitions TEXT NOT NULL DEFAULT '[]'
+		)`,
+		`CREATE TABLE projects (
+			key             TEXT PRIMARY KEY,
+			name            TEXT NOT NULL,
+			created_date    TIMESTAMP NOT NULL,
+			lead            TEXT NOT NULL DEFAULT '',
+			homepage        TEXT NOT NULL DEFAULT '',
+			repo            TEXT NOT NULL DEFAULT '',
+			ticket_types    TEXT NOT NULL DEFAULT '[]',
+			public          BOOLEAN NOT NULL DEFAULT FALSE,
+			field_scheme    TEXT NOT NULL DEFAULT '',
+			workflow_scheme TEXT NOT NULL DEFAULT '[]'
+		)`,
+		`CREATE TABLE project_permissions (
+			project_key TEXT NOT NULL REFERENCES projects (key) ON DELETE CASCADE,
+			role        TEXT NOT NULL,
+			permission  TEXT NOT NULL,
+			PRIMARY KEY (project_key, role, permission)
+		)`,
+		`CREATE TABLE user_roles (
+			username    TEXT NOT NULL REFERENCES users (username) ON DELETE CASCADE,
+			project_key TEXT NOT NULL,
+			role        TEXT NOT NULL,
+			PRIMARY KEY (username, project_key, role)
+		)`,
+		`CREATE TABLE tickets (
+			key          TEXT PRIMARY KEY,
+			project      TEXT NOT NULL REFERENCES projects (key) ON DELETE CASCADE,
+			created_date TIMESTAMP NOT NULL,
+			updated_date TIMESTAMP NOT NULL,
+			summary      TEXT NOT NULL,
+			description  TEXT NOT NULL,
+			status_name  TEXT NOT NULL DEFAULT '',
+			status_type  TEXT NOT NULL DEFAULT '',
+			reporter     TEXT NOT NULL,
+			assignee     TEXT NOT NULL DEFAULT '',
+			type         TEXT NOT NULL,
+			workflow     TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE INDEX tickets_project_idx ON tickets (project)`,
+		`CREATE TABLE ticket_labels (
+			ticket_key TEXT NOT NULL REFERENCES tickets (key) ON DELETE CASCADE,
+			label      TEXT NOT NULL,
+			PRIMARY KEY (ticket_key, label)
+		)`,
+		`CREATE INDEX ticket_labels_label_idx ON ticket_labels (label)`,
+		`CREATE TABLE ticket_watchers (
+			ticket_key TEXT NOT NULL REFERENCES tickets (key) ON DELETE CASCADE,
+			username   TEXT NOT NULL,
+			PRIMARY KEY (ticket_key, username)
+		)`,
+		`CREATE TABLE ticket_fields (
+			ticket_key   TEXT NOT NULL REFERENCES tickets (key) ON DELETE CASCADE,
+			position     INTEGER NOT NULL,
+			name         TEXT NOT NULL,
+			data_type    TEXT NOT NULL,
+			options      TEXT NOT NULL DEFAULT '[]',
+			string_value TEXT,
+			number_value DOUBLE PRECISION,
+			date_value   TIMESTAMP,
+			PRIMARY KEY (ticket_key, name)
+		)`,
+		`CREATE TABLE comments (
+			id           TEXT PRIMARY KEY,
+			ticket_key   TEXT NOT NULL REFERENCES tickets (key) ON DELETE CASCADE,
+			author       TEXT NOT NULL,
+			body         TEXT NOT NULL,
+			created_date TIMESTAMP NOT NULL,
+			updated_date TIMESTAMP NOT NULL
+		)`,
+		`CREATE INDEX comments_ticket_key_idx ON comments (ticket_key)`,
+		`CREATE TABLE notifications (
+			id              TEXT PRIMARY KEY,
+			project         TEXT NOT NULL,
+			actioning_user  TEXT NOT NULL,
+			actioned_ticket TEXT NOT NULL,
+			type            TEXT NOT NULL,
+			body            TEXT NOT NULL,
+			read            BOOLEAN NOT NULL DEFAULT FALSE,
+			watcher         TEXT NOT NULL,
+			created_date    TIMESTAMP NOT NULL
+		)`,
+	},
+	{
+		`CREATE TABLE api_tokens (
+			id           TEXT PRIMARY KEY,
+			name         TEXT NOT NULL,
+			username     TEXT NOT NULL REFERENCES users (username) ON DELETE CASCADE,
+			hash         TEXT NOT NULL,
+			scopes       TEXT NOT NULL DEFAULT '[]',
+			created_date TIMESTAMP NOT NULL,
+			expires_date TIMESTAMP
+		)`,
+		`CREATE INDEX api_tokens_username_idx ON api_tokens (username)`,
+	},
+	{
+		`ALTER TABLE users ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE users ADD COLUMN totp_secret TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE users ADD COLUMN totp_recovery_codes TEXT NOT NULL DEFAULT '[]'`,
+		`ALTER TABLE users ADD COLUMN totp_counter BIGINT NOT NULL DEFAULT 0`,
+	},
+	{
+		`ALTER TABLE users ADD COLUMN pending_verification BOOLEAN NOT NULL DEFAULT FALSE`,
+	},
+	{
+		`ALTER TABLE comments ADD COLUMN attachments TEXT NOT NULL DEFAULT '[]'`,
+	},
+	{
+		`CREATE TABLE outbox (
+			id           TEXT PRIMARY KEY,
+			type         TEXT NOT NULL,
+			project      TEXT NOT NULL,
+			ticket_key   TEXT NOT NULL,
+			status       TEXT NOT NULL,
+			attempts     INTEGER NOT NULL DEFAULT 0,
+			last_error   TEXT NOT NULL DEFAULT '',
+			handled      TEXT NOT NULL DEFAULT '[]',
+			payload      TEXT NOT NULL DEFAULT '{}',
+			next_attempt TIMESTAMP NOT NULL,
+			created_date TIMESTAMP NOT NULL,
+			updated_date TIMESTAMP NOT NULL
+		)`,
+		`CREATE INDEX outbox_status_idx ON outbox (status, next_attempt)`,
+	},
+	{
+		`CREATE TABLE hook_deliveries (
+			id           TEXT PRIMARY KEY,
+			workflow     TEXT NOT NULL,
+			ticket_key   TEXT NOT NULL,
+			success      BOOLEAN NOT NULL DEFAULT FALSE,
+			payload      TEXT NOT NULL DEFAULT '{}',
+			created_date TIMESTAMP NOT NULL
+		)`,
+		`CREATE INDEX hook_deliveries_workflow_idx ON hook_deliveries (workflow, created_date)`,
+	},
+	{
+		`CREATE TABLE webhooks (
+			id           TEXT PRIMARY KEY,
+			project      TEXT NOT NULL REFERENCES projects (key) ON DELETE CASCADE,
+			url          TEXT NOT NULL,
+			events       TEXT NOT NULL DEFAULT '[]',
+			query        TEXT NOT NULL DEFAULT '',
+			secret       TEXT NOT NULL DEFAULT '',
+			created_date TIMESTAMP NOT NULL
+		)`,
+		`ALTER TABLE hook_deliveries ADD COLUMN webhook TEXT NOT NULL DEFAULT ''`,
+		`CREATE INDEX hook_deliveries_webhook_idx ON hook_deliveries (webhook, created_date)`,
+	},
+	{
+		`ALTER TABLE webhooks ADD COLUMN format TEXT NOT NULL DEFAULT ''`,
+	},
+	{
+		`CREATE TABLE ticket_history (
+			id           TEXT PRIMARY KEY,
+			ticket_key   TEXT NOT NULL REFERENCES tickets (key) ON DELETE CASCADE,
+			field        TEXT NOT NULL,
+			from_value   TEXT NOT NULL DEFAULT 'null',
+			to_value     TEXT NOT NULL DEFAULT 'null',
+			actor        TEXT NOT NULL,
+			created_date TIMESTAMP NOT NULL
+		)`,
+		`CREATE INDEX ticket_history_ticket_key_idx ON ticket_history (ticket_key, created_date)`,
+	},
+	{
+		`ALTER TABLE users ADD COLUMN provider TEXT NOT NULL DEFAULT ''`,
+	},
+	{
+		`ALTER TABLE users ADD COLUMN chat_team_id TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE users ADD COLUMN chat_user_id TEXT NOT NULL DEFAULT ''`,
+		`CREATE INDEX users_chat_account_idx ON users (chat_team_id, chat_user_id)`,
+	},
+	{
+		`ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE`,
+	},
+}
+
+// migrate will run all migrations that have not been run against the
+// database yet, recording the latest version in schema_migrations.
+func migrate(c conn) error {
+	_, err := c.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY
+	)`)
+	if err != nil {
+		return sqlErr(err)
+	}
+
+	var version int
+
+	err = c.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").
+		Scan(&version)
+	if err != nil {
+		return sqlErr(err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		dbLog.Println("Running migration", i+1)
+
+		err = c.inTx(func(q querier) error {
+			for _, stmt := range migrations[i] {
+				stmt = strings.Replace(stmt, "TIMESTAMP", c.dialect.timestamp, -1)
+
+				if _, err := q.Exec(stmt); err != nil {
+					return err
+				}
+			}
+
+			_, err := q.Exec("INSERT INTO schema_migrations (version) VALUES (?)", i+1)
+			return err
+		})
+
+		if err != nil {
+			return sqlErr(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package sql
+
+import (
+	"strconv"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/models/permission"
+	"github.com/praelatus/praelatus/repo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const notificationColumns = `n.id, n.project, n.actioning_user,
+	n.actioned_ticket, n.type, n.body, n.read, n.watcher, n.created_date`
+
+type notificationRepo struct {
+	conn conn
+}
+
+func (nr notificationRepo) Create(u *models.User, n models.Notification) (models.Notification, error) {
+	n.ID = bson.NewObjectId()
+
+	_, err := nr.conn.Exec(`INSERT INTO notifications (id, project,
+		actioning_user, actioned_ticket, type, body, read, watcher, created_date)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, n.ID.Hex(), n.Project,
+		n.ActioningUser, n.ActionedTicket, n.Type, n.Body, n.Read, n.Watcher,
+		n.CreatedDate)
+	return n, sqlErr(err)
+}
+
+func (nr notificationRepo) MarkRead(u *models.User, uid string) error {
+	if u == nil {
+		return repo.ErrLoginRequired
+	}
+
+	res, err := nr.conn.Exec("UPDATE notifications SET read = ? WHERE id = ? AND watcher = ?",
+		true, uid, u.Username)
+	if err != nil {
+		return sqlErr(err)
+	}
+
+	return rowsAffected(res)
+}
+
+// selectNotifications will return the notifications matching the where clause
+// in projects the user can view, newest first
+func (nr notificationRepo) selectNotifications(u *models.User, where string, onlyUnread bool, last int, args ...interface{}) ([]models.Notification, error) {
+	permWhere, permArgs := userPermQuery(u, permission.ViewProject)
+
+	q := "SELECT " + notificationColumns + ` FROM notifications n
+		WHERE n.project IN (SELECT p.key FROM projects p WHERE ` + permWhere +
+		") AND " + where
+	args = append(permArgs, args...)
+
+	if onlyUnread {
+		q += " AND n.read = ?"
+		args = append(args, false)
+	}
+
+	q += " ORDER BY n.created_date DESC"
+
+	if last > 0 {
+		q += " LIMIT " + strconv.Itoa(last)
+	}
+
+	rows, err := nr.conn.Query(q, args...)
+	if err != nil {
+		return nil, sqlErr(err)
+	}
+
+	defer rows.Close()
+
+	notifications := []models.Notification{}
+
+	for rows.Next() {
+		var n models.Notification
+		var id string
+
+		err = rows.Scan(&id, &n.Project, &n.ActioningUser, &n.ActionedTicket,
+			&n.Type, &n.Body, &n.Read, &n.Watcher, &n.CreatedDate)
+		if err != nil {
+			return nil, sqlErr(err)
+		}
+
+		n.ID = objectID(id)
+		notifications = append(notifications, n)
+	}
+
+	return notifications, sqlErr(rows.Err())
+}
+
+func (nr notificationRepo) ForProject(u *models.User, project models.Project, onlyUnread bool, last int) ([]models.Notification, error) {
+	return nr.selectNotifications(u, "n.project = ?", onlyUnread, last, project.Key)
+}
+
+func (nr notificationRepo) ForUser(u *models.User, user models.User, onlyUnread bool, last int) ([]models.Notification, error) {
+	if u == nil || (!u.IsAdmin && u.Username != user.Username) {
+		return nil, repo.ErrUnauthorized
+	}
+
+	return nr.selectNotifications(u, "n.watcher = ?", onlyUnread, last, user.Username)
+}
+
+func (nr notificationRepo) ActivityForUser(u *models.User, user models.User, onlyUnread bool, last int) ([]models.Notification, error) {
+	return nr.selectNotifications(u, "n.actioning_user = ?", onlyUnread, last, user.Username)
+}
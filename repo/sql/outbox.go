@@ -0,0 +1,191 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package sql
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+)
+
+const outboxColumns = `id, type, project, ticket_key, status, attempts,
+	last_error, handled, payload, next_attempt, created_date, updated_date`
+
+type outboxRepo struct {
+	conn conn
+}
+
+// outboxPayload is the part of an outbox event which is stored as JSON
+type outboxPayload struct {
+	ActioningUser models.User          `json:"actioningUser"`
+	Ticket        models.Ticket        `json:"ticket"`
+	Comment       *models.Comment      `json:"comment,omitempty"`
+	Transition    *models.Transition   `json:"transition,omitempty"`
+	Changes       []models.FieldChange `json:"changes,omitempty"`
+}
+
+func scanOutboxEvent(row scanner) (models.OutboxEvent, error) {
+	var e models.OutboxEvent
+	var id, ticketKey, handled, payload string
+
+	err := row.Scan(&id, &e.Type, &e.Project, &ticketKey, &e.Status,
+		&e.Attempts, &e.LastError, &handled, &payload, &e.NextAttempt,
+		&e.CreatedDate, &e.UpdatedDate)
+	if err != nil {
+		return e, err
+	}
+
+	e.ID = objectID(id)
+
+	err = json.Unmarshal([]byte(handled), &e.Handled)
+	if err != nil {
+		return e, err
+	}
+
+	var p outboxPayload
+
+	err = json.Unmarshal([]byte(payload), &p)
+	if err != nil {
+		return e, err
+	}
+
+	e.ActioningUser = p.ActioningUser
+	e.Ticket = p.Ticket
+	e.Comment = p.Comment
+	e.Transition = p.Transition
+	e.Changes = p.Changes
+	return e, nil
+}
+
+// marshalOutboxEvent returns the handlers and payload of the event as JSON
+func marshalOutboxEvent(e models.OutboxEvent) (string, string, error) {
+	if e.Handled == nil {
+		e.Handled = []string{}
+	}
+
+	handled, err := json.Marshal(e.Handled)
+	if err != nil {
+		return "", "", err
+	}
+
+	payload, err := json.Marshal(outboxPayload{
+		ActioningUser: e.ActioningUser,
+		Ticket:        e.Ticket,
+		Comment:       e.Comment,
+		Transition:    e.Transition,
+		Changes:       e.Changes,
+	})
+
+	return string(handled), string(payload), err
+}
+
+// insertOutboxEvent stores the event, the ticket repo uses it to store events
+// in the same transaction as the change to the ticket. Times are stored in UTC
+// so SQLite, which stores them as text, compares them correctly.
+func insertOutboxEvent(q querier, e models.OutboxEvent) error {
+	handled, payload, err := marshalOutboxEvent(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec("INSERT INTO outbox ("+outboxColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, e.ID.Hex(), e.Type,
+		e.Project, e.Ticket.Key, e.Status, e.Attempts, e.LastError, handled,
+		payload, e.NextAttempt.UTC(), e.CreatedDate.UTC(), e.UpdatedDate.UTC())
+	return err
+}
+
+func (or outboxRepo) Get(u *models.User, uid string) (models.OutboxEvent, error) {
+	if u == nil || !u.IsAdmin {
+		return models.OutboxEvent{}, repo.ErrAdminRequired
+	}
+
+	e, err := scanOutboxEvent(or.conn.QueryRow("SELECT "+outboxColumns+
+		" FROM outbox WHERE id = ?", uid))
+	return e, sqlErr(err)
+}
+
+func (or outboxRepo) Create(u *models.User, e models.OutboxEvent) (models.OutboxEvent, error) {
+	if u == nil || !u.IsAdmin {
+		return e, repo.ErrAdminRequired
+	}
+
+	return e, sqlErr(insertOutboxEvent(or.conn, e))
+}
+
+func (or outboxRepo) Update(u *models.User, uid string, e models.OutboxEvent) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	handled, _, err := marshalOutboxEvent(e)
+	if err != nil {
+		return err
+	}
+
+	res, err := or.conn.Exec(`UPDATE outbox SET status = ?, attempts = ?,
+		last_error = ?, handled = ?, next_attempt = ?, updated_date = ?
+		WHERE id = ?`, e.Status, e.Attempts, e.LastError, handled,
+		e.NextAttempt.UTC(), e.UpdatedDate.UTC(), uid)
+	if err != nil {
+		return sqlErr(err)
+	}
+
+	return rowsAffected(res)
+}
+
+func (or outboxRepo) Due(u *models.User, before time.Time, limit int) ([]models.OutboxEvent, error) {
+	if u == nil || !u.IsAdmin {
+		return nil, repo.ErrAdminRequired
+	}
+
+	rows, err := or.conn.Query("SELECT "+outboxColumns+` FROM outbox
+		WHERE status = ? AND next_attempt <= ?
+		ORDER BY created_date, id LIMIT ?`,
+		models.OutboxPending, before.UTC(), limit)
+	if err != nil {
+		return nil, sqlErr(err)
+	}
+
+	defer rows.Close()
+
+	events := []models.OutboxEvent{}
+
+	for rows.Next() {
+		e, err := scanOutboxEvent(rows)
+		if err != nil {
+			return nil, sqlErr(err)
+		}
+
+		events = append(events, e)
+	}
+
+	return events, sqlErr(rows.Err())
+}
+
+func (or outboxRepo) Requeue(u *models.User) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	now := time.Now().UTC()
+
+	_, err := or.conn.Exec(`UPDATE outbox SET status = ?, attempts = 0,
+		next_attempt = ?, updated_date = ? WHERE status = ?`,
+		models.OutboxPending, now, now, models.OutboxFailed)
+	return sqlErr(err)
+}
+
+func (or outboxRepo) Prune(u *models.User, before time.Time) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	_, err := or.conn.Exec("DELETE FROM outbox WHERE status = ? AND updated_date < ?",
+		models.OutboxDone, before.UTC())
+	return sqlErr(err)
+}
@@ -0,0 +1,275 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package sql
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/models/permission"
+	"github.com/praelatus/praelatus/repo"
+)
+
+const projectColumns = `p.key, p.name, p.created_date, p.lead, p.homepage,
+	p.repo, p.ticket_types, p.public, p.field_scheme, p.workflow_scheme`
+
+type projectRepo struct {
+	conn conn
+}
+
+func scanProject(row scanner) (models.Project, error) {
+	var p models.Project
+	var ticketTypes, fieldScheme, workflowScheme string
+
+	err := row.Scan(&p.Key, &p.Name, &p.CreatedDate, &p.Lead, &p.Homepage,
+		&p.Repo, &ticketTypes, &p.Public, &fieldScheme, &workflowScheme)
+	if err != nil {
+		return p, err
+	}
+
+	p.FieldScheme = objectID(fieldScheme)
+
+	err = json.Unmarshal([]byte(ticketTypes), &p.TicketTypes)
+	if err != nil {
+		return p, err
+	}
+
+	err = json.Unmarshal([]byte(workflowScheme), &p.WorkflowScheme)
+	return p, err
+}
+
+// loadPermissions will fill in the role permission mappings on p
+func loadPermissions(q querier, p *models.Project) error {
+	rows, err := q.Query(`SELECT role, permission FROM project_permissions
+		WHERE project_key = ? ORDER BY role, permission`, p.Key)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	p.Permissions = []models.RolePermission{}
+
+	for rows.Next() {
+		var rp models.RolePermission
+
+		err = rows.Scan(&rp.Role, &rp.Permission)
+		if err != nil {
+			return err
+		}
+
+		p.Permissions = append(p.Permissions, rp)
+	}
+
+	return rows.Err()
+}
+
+func setPermissions(q querier, projectKey string, perms []models.RolePermission) error {
+	_, err := q.Exec("DELETE FROM project_permissions WHERE project_key = ?", projectKey)
+	if err != nil {
+		return err
+	}
+
+	for _, rp := range perms {
+		_, err = q.Exec(`INSERT INTO project_permissions (project_key, role, permission)
+			VALUES (?, ?, ?)`, projectKey, string(rp.Role), string(rp.Permission))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// selectProjects runs the given where clause against the projects table
+// aliased as p returning the fully loaded projects
+func selectProjects(q querier, where string, args ...interface{}) ([]models.Project, error) {
+	rows, err := q.Query("SELECT "+projectColumns+" FROM projects p WHERE "+
+		where+" ORDER BY p.key", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	projects := []models.Project{}
+
+	for rows.Next() {
+		p, err := scanProject(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		projects = append(projects, p)
+	}
+
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Permissions have to be loaded after the rows are closed since SQLite
+	// only has the one connection.
+	for i := range projects {
+		err = loadPermissions(q, &projects[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return projects, nil
+}
+
+// getProject will load the project with the given key without checking
+// permissions
+func getProject(q querier, key string) (models.Project, error) {
+	p, err := scanProject(q.QueryRow("SELECT "+projectColumns+
+		" FROM projects p WHERE p.key = ?", key))
+	if err != nil {
+		return p, err
+	}
+
+	return p, loadPermissions(q, &p)
+}
+
+func userPermQuery(u *models.User, perms ...permission.Permission) (string, []interface{}) {
+	if u == nil {
+		u = &models.User{}
+	}
+
+	return permQuery(u.Username, u.IsAdmin, perms...)
+}
+
+func (p projectRepo) Get(u *models.User, uid string) (models.Project, error) {
+	where, args := userPermQuery(u, permission.ViewProject)
+
+	projects, err := selectProjects(p.conn, "p.key = ? AND "+where,
+		append([]interface{}{uid}, args...)...)
+	if err != nil {
+		return models.Project{}, sqlErr(err)
+	}
+
+	if len(projects) == 0 {
+		return models.Project{}, repo.ErrNotFound
+	}
+
+	return projects[0], nil
+}
+
+func (p projectRepo) Update(u *models.User, uid string, updated models.Project) error {
+	where, args := userPermQuery(u, permission.AdminProject)
+
+	ticketTypes, err := json.Marshal(updated.TicketTypes)
+	if err != nil {
+		return err
+	}
+
+	workflowScheme, err := json.Marshal(updated.WorkflowScheme)
+	if err != nil {
+		return err
+	}
+
+	return sqlErr(p.conn.inTx(func(q querier) error {
+		res, err := q.Exec(`UPDATE projects SET name = ?, lead = ?, homepage = ?,
+			repo = ?, ticket_types = ?, public = ?, field_scheme = ?,
+			workflow_scheme = ? WHERE key = ? AND key IN (
+				SELECT p.key FROM projects p WHERE `+where+`)`,
+			append([]interface{}{updated.Name, updated.Lead, updated.Homepage,
+				updated.Repo, string(ticketTypes), updated.Public,
+				updated.FieldScheme.Hex(), string(workflowScheme), uid}, args...)...)
+		if err != nil {
+			return err
+		}
+
+		if err = rowsAffected(res); err != nil {
+			return err
+		}
+
+		return setPermissions(q, uid, updated.Permissions)
+	}))
+}
+
+func (p projectRepo) Create(u *models.User, project models.Project) (models.Project, error) {
+	if u == nil || !u.IsAdmin {
+		return models.Project{}, repo.ErrAdminRequired
+	}
+
+	project.CreatedDate = time.Now()
+
+	ticketTypes, err := json.Marshal(project.TicketTypes)
+	if err != nil {
+		return project, err
+	}
+
+	workflowScheme, err := json.Marshal(project.WorkflowScheme)
+	if err != nil {
+		return project, err
+	}
+
+	err = p.conn.inTx(func(q querier) error {
+		_, err := q.Exec(`INSERT INTO projects (key, name, created_date, lead,
+			homepage, repo, ticket_types, public, field_scheme, workflow_scheme)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, project.Key, project.Name,
+			project.CreatedDate, project.Lead, project.Homepage, project.Repo,
+			string(ticketTypes), project.Public, project.FieldScheme.Hex(),
+			string(workflowScheme))
+		if err != nil {
+			return err
+		}
+
+		return setPermissions(q, project.Key, project.Permissions)
+	})
+
+	return project, sqlErr(err)
+}
+
+func (p projectRepo) Delete(u *models.User, uid string) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	res, err := p.conn.Exec("DELETE FROM projects WHERE key = ?", uid)
+	if err != nil {
+		return sqlErr(err)
+	}
+
+	return rowsAffected(res)
+}
+
+func (p projectRepo) Search(u *models.User, query string) ([]models.Project, error) {
+	where, args := userPermQuery(u, permission.ViewProject)
+
+	if query != "" {
+		pattern := likePattern(query)
+		where += ` AND (LOWER(p.name) LIKE ? ESCAPE '\'
+			OR LOWER(p.key) LIKE ? ESCAPE '\' OR LOWER(p.lead) LIKE ? ESCAPE '\')`
+		args = append(args, pattern, pattern, pattern)
+	}
+
+	projects, err := selectProjects(p.conn, where, args...)
+	return projects, sqlErr(err)
+}
+
+func (p projectRepo) HasLead(u *models.User, lead models.User) ([]models.Project, error) {
+	where, args := userPermQuery(u, permission.ViewProject)
+
+	projects, err := selectProjects(p.conn, where+" AND p.lead = ?",
+		append(args, lead.Username)...)
+	return projects, sqlErr(err)
+}
+
+// HasPermissionTo will return all projects which the given user has the given
+// permissions to
+func (p projectRepo) HasPermissionTo(u *models.User, perms permission.Permissions) ([]models.Project, error) {
+	ps := make([]permission.Permission, len(perms))
+	for i := range perms {
+		ps[i] = permission.Permission(perms[i])
+	}
+
+	where, args := userPermQuery(u, ps...)
+
+	projects, err := selectProjects(p.conn, where, args...)
+	return projects, sqlErr(err)
+}
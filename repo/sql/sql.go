@@ -0,0 +1,415 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+// Package sql implements repo.Repo for SQL databases. PostgreSQL is supported
+// for production use and SQLite for small deployments and testing.
+package sql
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	// Register the database drivers used by this package
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/praelatus/praelatus/config"
+	"github.com/praelatus/praelatus/models/permission"
+	"github.com/praelatus/praelatus/repo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var dbLog = log.New(config.LogWriter(), "[SQL] ", log.LstdFlags)
+
+func sqlErr(e error) error {
+	if e == nil {
+		return e
+	}
+
+	dbLog.Println("ERROR:", e)
+
+	switch e {
+	case sql.ErrNoRows:
+		return repo.ErrNotFound
+	default:
+		return e
+	}
+}
+
+// dialect holds the differences between the supported SQL databases
+type dialect struct {
+	driver string
+
+	// numbered is true when the database uses $1 style placeholders
+	// instead of ?
+	numbered bool
+
+	timestamp string
+}
+
+var (
+	postgres = dialect{
+		driver:    "postgres",
+		numbered:  true,
+		timestamp: "TIMESTAMP WITH TIME ZONE",
+	}
+
+	sqlite = dialect{
+		driver:    "sqlite3",
+		timestamp: "TIMESTAMP",
+	}
+)
+
+// rebind will replace the ? placeholders in query with the placeholders used
+// by this dialect
+func (d dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+
+	for _, c := range query {
+		if c != '?' {
+			b.WriteRune(c)
+			continue
+		}
+
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+
+	return b.String()
+}
+
+// querier is implemented by both conn and tx so that helpers can be used
+// inside or outside of a transaction
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// conn wraps a database handle and rewrites queries for its dialect
+type conn struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (c conn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.db.Exec(c.dialect.rebind(query), args...)
+}
+
+func (c conn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.db.Query(c.dialect.rebind(query), args...)
+}
+
+func (c conn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.db.QueryRow(c.dialect.rebind(query), args...)
+}
+
+// inTx runs fn inside of a transaction, rolling back if fn returns an error
+func (c conn) inTx(fn func(q querier) error) error {
+	t, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx{t, c.dialect})
+	if err != nil {
+		_ = t.Rollback()
+		return err
+	}
+
+	return t.Commit()
+}
+
+// tx wraps a database transaction and rewrites queries for its dialect
+type tx struct {
+	tx      *sql.Tx
+	dialect dialect
+}
+
+func (t tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.tx.Exec(t.dialect.rebind(query), args...)
+}
+
+func (t tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return t.tx.Query(t.dialect.rebind(query), args...)
+}
+
+func (t tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return t.tx.QueryRow(t.dialect.rebind(query), args...)
+}
+
+// rowsAffected returns repo.ErrNotFound if the statement which produced res
+// did not affect any rows
+func rowsAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return sqlErr(err)
+	}
+
+	if n == 0 {
+		return repo.ErrNotFound
+	}
+
+	return nil
+}
+
+// selectStrings runs query returning the first column of every row
+func selectStrings(q querier, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	strs := []string{}
+
+	for rows.Next() {
+		var s string
+
+		err = rows.Scan(&s)
+		if err != nil {
+			return nil, err
+		}
+
+		strs = append(strs, s)
+	}
+
+	return strs, rows.Err()
+}
+
+// objectID converts an id stored in the database as hex back into a
+// bson.ObjectId, returning an empty id if it is not valid hex
+func objectID(hex string) bson.ObjectId {
+	if !bson.IsObjectIdHex(hex) {
+		return ""
+	}
+
+	return bson.ObjectIdHex(hex)
+}
+
+// placeholders returns n comma separated placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// likeEscaper escapes the characters which are special in LIKE patterns, the
+// patterns have to be used with ESCAPE '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// likePattern converts a search query, which may contain the .* wildcards
+// used by the api, into a case insensitive LIKE pattern. Any other % or _ in
+// the query is matched literally.
+func likePattern(query string) string {
+	pattern := likeEscaper.Replace(strings.ToLower(query))
+	return "%" + strings.Replace(pattern, ".*", "%", -1) + "%"
+}
+
+// permQuery returns a where clause, and its arguments, which will limit the
+// projects table aliased as p to those the user with the given username has
+// at least one of perms for
+func permQuery(username string, isAdmin bool, perms ...permission.Permission) (string, []interface{}) {
+	if isAdmin {
+		return "1 = 1", nil
+	}
+
+	args := []interface{}{}
+	clauses := []string{}
+
+	if permission.Permissions(nil).Add(perms...).Contains(permission.ViewProject) {
+		clauses = append(clauses, "p.public = ?")
+		args = append(args, true)
+	}
+
+	if username != "" && len(perms) > 0 {
+		clauses = append(clauses, `EXISTS (
+			SELECT 1 FROM user_roles ur
+			JOIN project_permissions pp
+			ON pp.project_key = ur.project_key AND pp.role = ur.role
+			WHERE ur.username = ? AND ur.project_key = p.key
+			AND pp.permission IN (`+placeholders(len(perms))+`))`)
+
+		args = append(args, username)
+		for _, perm := range perms {
+			args = append(args, string(perm))
+		}
+	}
+
+	if len(clauses) == 0 {
+		return "1 = 0", nil
+	}
+
+	return "(" + strings.Join(clauses, " OR ") + ")", args
+}
+
+// Repo contains all model specific repos.
+type Repo struct {
+	Conn          *sql.DB
+	dialect       dialect
+	tickets       ticketRepo
+	users         userRepo
+	projects      projectRepo
+	fieldSchemes  fieldSchemeRepo
+	workflows     workflowRepo
+	notifications notificationRepo
+	tokens        tokenRepo
+	outbox        outboxRepo
+	deliveries    deliveryRepo
+	webhooks      webhookRepo
+}
+
+// Fields returns the fieldSchemesRepo implementation for sql
+func (r Repo) Fields() repo.FieldSchemeRepo {
+	return r.fieldSchemes
+}
+
+// Tickets returns the ticketRepo implementation for sql
+func (r Repo) Tickets() repo.TicketRepo {
+	return r.tickets
+}
+
+// Projects returns the projectRepo implementation for sql
+func (r Repo) Projects() repo.ProjectRepo {
+	return r.projects
+}
+
+// Workflows returns the workflowRepo implementation for sql
+func (r Repo) Workflows() repo.WorkflowRepo {
+	return r.workflows
+}
+
+// Notifications returns the notificationRepo implementation for sql
+func (r Repo) Notifications() repo.NotificationRepo {
+	return r.notifications
+}
+
+// Tokens returns the tokenRepo implementation for sql
+func (r Repo) Tokens() repo.TokenRepo {
+	return r.tokens
+}
+
+// Outbox returns the outboxRepo implementation for sql
+func (r Repo) Outbox() repo.OutboxRepo {
+	return r.outbox
+}
+
+// Deliveries returns the deliveryRepo implementation for sql
+func (r Repo) Deliveries() repo.DeliveryRepo {
+	return r.deliveries
+}
+
+// Webhooks returns the webhookRepo implementation for sql
+func (r Repo) Webhooks() repo.WebhookRepo {
+	return r.webhooks
+}
+
+// Users returns the userRepo implementation for sql
+func (r Repo) Users() repo.UserRepo {
+	return r.users
+}
+
+// Clean will remove all data from the database
+func (r Repo) Clean() error {
+	c := conn{r.Conn, r.dialect}
+
+	return c.inTx(func(q querier) error {
+		// Delete in reverse order of creation so foreign keys are
+		// satisfied
+		for i := len(tables) - 1; i >= 0; i-- {
+			_, err := q.Exec("DELETE FROM " + tables[i])
+			if err != nil {
+				return sqlErr(err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Test will test connection to the database
+func (r Repo) Test() error {
+	return r.Conn.Ping()
+}
+
+// Init will run any schema migrations which have not been run yet
+func (r Repo) Init() error {
+	return migrate(conn{r.Conn, r.dialect})
+}
+
+// New will attempt to connect to the SQL database at connURL and return the
+// repo.Repo that is connected to it. URLs starting with sqlite:// open the
+// SQLite database at the path following the scheme, anything else is treated
+// as a PostgreSQL connection string. The schema is migrated to the latest
+// version before returning.
+func New(connURL string) repo.Repo {
+	d, dataSource := parseURL(connURL)
+
+	db, err := sql.Open(d.driver, dataSource)
+	if err != nil {
+		dbLog.Printf("Unable to connect: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if d == sqlite {
+		// SQLite only allows a single writer and in memory databases
+		// are per connection so only use one.
+		db.SetMaxOpenConns(1)
+	}
+
+	err = db.Ping()
+	if err != nil {
+		dbLog.Printf("Unable to reach database: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	c := conn{db, d}
+
+	r := Repo{
+		Conn:          db,
+		dialect:       d,
+		tickets:       ticketRepo{c},
+		projects:      projectRepo{c},
+		workflows:     workflowRepo{c},
+		fieldSchemes:  fieldSchemeRepo{c},
+		users:         userRepo{c},
+		notifications: notificationRepo{c},
+		tokens:        tokenRepo{c},
+		outbox:        outboxRepo{c},
+		deliveries:    deliveryRepo{c},
+		webhooks:      webhookRepo{c},
+	}
+
+	err = r.Init()
+	if err != nil {
+		dbLog.Printf("Unable to migrate database: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	return r
+}
+
+func parseURL(connURL string) (dialect, string) {
+	for _, prefix := range []string{"sqlite://", "sqlite3://"} {
+		if !strings.HasPrefix(connURL, prefix) {
+			continue
+		}
+
+		dataSource := strings.TrimPrefix(connURL, prefix)
+		if strings.Contains(dataSource, "?") {
+			return sqlite, dataSource + "&_foreign_keys=on"
+		}
+
+		return sqlite, dataSource + "?_foreign_keys=on"
+	}
+
+	return postgres, connURL
+}
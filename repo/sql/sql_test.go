@@ -0,0 +1,69 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package sql_test
+
+import (
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"github.com/praelatus/praelatus/repo/sql"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var r repo.Repo
+
+var admin = models.User{
+	Username: "testadmin",
+	IsAdmin:  true,
+}
+
+var user = models.User{
+	Username: "testuser",
+	IsAdmin:  false,
+}
+
+var fsID bson.ObjectId
+var wID bson.ObjectId
+
+func init() {
+	r = sql.New("sqlite://:memory:")
+	e := r.Clean()
+	if e != nil {
+		panic(e)
+	}
+
+	e = repo.Seed(r)
+	if e != nil {
+		panic(e)
+	}
+
+	fs, e := r.Fields().Search(&admin, "")
+	if e != nil || len(fs) == 0 {
+		panic("Should have seeded field schemes.")
+	}
+
+	fsID = fs[0].ID
+
+	ws, e := r.Workflows().Search(&admin, "")
+	if e != nil || len(ws) == 0 {
+		panic("Should have seeded workflows.")
+	}
+
+	tk, e := r.Tickets().Get(&admin, "TEST-1")
+	if e != nil {
+		panic(e)
+	}
+
+	// Use the workflow which the seeded tickets are not using so deleting
+	// it doesn't affect the ticket tests.
+	for _, w := range ws {
+		if w.ID != tk.Workflow {
+			wID = w.ID
+		}
+	}
+
+	if wID == "" {
+		panic("Should have seeded a workflow without tickets.")
+	}
+}
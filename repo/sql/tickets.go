@@ -0,0 +1,765 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package sql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/models/permission"
+	"github.com/praelatus/praelatus/ql/ast"
+	"github.com/praelatus/praelatus/repo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const ticketColumns = `t.key, t.project, t.created_date, t.updated_date,
+	t.summary, t.description, t.status_name, t.status_type, t.reporter,
+	t.assignee, t.type, t.workflow`
+
+type ticketRepo struct {
+	conn conn
+}
+
+func scanTicket(row scanner) (models.Ticket, error) {
+	var t models.Ticket
+	var statusType, workflow string
+
+	err := row.Scan(&t.Key, &t.Project, &t.CreatedDate, &t.UpdatedDate,
+		&t.Summary, &t.Description, &t.Status.Name, &statusType, &t.Reporter,
+		&t.Assignee, &t.Type, &workflow)
+
+	t.Status.Type = models.StatusType(statusType)
+	t.Workflow = objectID(workflow)
+	return t, err
+}
+
+// loadTicketRelations will fill in the labels, watchers, fields and comments
+// of the given ticket
+func loadTicketRelations(q querier, t *models.Ticket) error {
+	var err error
+
+	t.Labels, err = selectStrings(q, `SELECT label FROM ticket_labels
+		WHERE ticket_key = ? ORDER BY label`, t.Key)
+	if err != nil {
+		return err
+	}
+
+	t.Watchers, err = selectStrings(q, `SELECT username FROM ticket_watchers
+		WHERE ticket_key = ? ORDER BY username`, t.Key)
+	if err != nil {
+		return err
+	}
+
+	t.Fields, err = selectFields(q, t.Key)
+	if err != nil {
+		return err
+	}
+
+	t.Comments, err = selectComments(q, t.Key)
+	return err
+}
+
+func selectFields(q querier, ticketKey string) ([]models.Field, error) {
+	rows, err := q.Query(`SELECT name, data_type, options, string_value,
+		number_value, date_value FROM ticket_fields WHERE ticket_key = ?
+		ORDER BY position`, ticketKey)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	fields := []models.Field{}
+
+	for rows.Next() {
+		var f models.Field
+		var options string
+		var str sql.NullString
+		var num sql.NullFloat64
+		var date sql.NullTime
+
+		err = rows.Scan(&f.Name, &f.DataType, &options, &str, &num, &date)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal([]byte(options), &f.Options)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case date.Valid:
+			f.Value = date.Time
+		case num.Valid && f.DataType == models.IntField:
+			f.Value = int(num.Float64)
+		case num.Valid:
+			f.Value = num.Float64
+		case str.Valid:
+			f.Value = str.String
+		}
+
+		fields = append(fields, f)
+	}
+
+	return fields, rows.Err()
+}
+
+// storedAttachment is how attachments are stored in the comments table, it
+// includes the path which isn't part of an attachment's JSON
+type storedAttachment struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	Path        string `json:"path"`
+}
+
+// attachmentsJSON will return the attachments as a JSON array, never null
+func attachmentsJSON(attachments []models.Attachment) (string, error) {
+	stored := make([]storedAttachment, len(attachments))
+
+	for i, a := range attachments {
+		stored[i] = storedAttachment{a.ID.Hex(), a.Name, a.ContentType, a.Size, a.Path}
+	}
+
+	b, err := json.Marshal(stored)
+	return string(b), err
+}
+
+func scanAttachments(data string) ([]models.Attachment, error) {
+	var stored []storedAttachment
+
+	err := json.Unmarshal([]byte(data), &stored)
+	if err != nil || len(stored) == 0 {
+		return nil, err
+	}
+
+	attachments := make([]models.Attachment, len(stored))
+
+	for i, a := range stored {
+		attachments[i] = models.Attachment{
+			ID:          objectID(a.ID),
+			Name:        a.Name,
+			ContentType: a.ContentType,
+			Size:        a.Size,
+			Path:        a.Path,
+		}
+	}
+
+	return attachments, nil
+}
+
+func selectComments(q querier, ticketKey string) ([]models.Comment, error) {
+	rows, err := q.Query(`SELECT id, author, body, created_date, updated_date,
+		attachments FROM comments WHERE ticket_key = ? ORDER BY created_date, id`,
+		ticketKey)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	comments := []models.Comment{}
+
+	for rows.Next() {
+		var c models.Comment
+		var id, attachments string
+
+		err = rows.Scan(&id, &c.Author, &c.Body, &c.CreatedDate, &c.UpdatedDate,
+			&attachments)
+		if err != nil {
+			return nil, err
+		}
+
+		c.ID = objectID(id)
+
+		c.Attachments, err = scanAttachments(attachments)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+
+	return comments, rows.Err()
+}
+
+// insertHistory records the changes u made to the ticket, the old and new
+// values are stored as JSON
+func insertHistory(q querier, u *models.User, ticketKey string, changes []models.FieldChange) error {
+	for _, h := range models.NewHistory(u, ticketKey, changes) {
+		from, err := json.Marshal(h.From)
+		if err != nil {
+			return err
+		}
+
+		to, err := json.Marshal(h.To)
+		if err != nil {
+			return err
+		}
+
+		_, err = q.Exec(`INSERT INTO ticket_history (id, ticket_key, field,
+			from_value, to_value, actor, created_date)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`, h.ID.Hex(), h.Ticket, h.Field,
+			string(from), string(to), h.Actor, h.Date)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func selectHistory(q querier, ticketKey string) ([]models.HistoryEntry, error) {
+	rows, err := q.Query(`SELECT id, field, from_value, to_value, actor,
+		created_date FROM ticket_history WHERE ticket_key = ?
+		ORDER BY created_date, id`, ticketKey)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	entries := []models.HistoryEntry{}
+
+	for rows.Next() {
+		h := models.HistoryEntry{Ticket: ticketKey}
+		var id, from, to string
+
+		err = rows.Scan(&id, &h.Field, &from, &to, &h.Actor, &h.Date)
+		if err != nil {
+			return nil, err
+		}
+
+		h.ID = objectID(id)
+
+		err = json.Unmarshal([]byte(from), &h.From)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal([]byte(to), &h.To)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, h)
+	}
+
+	return entries, rows.Err()
+}
+
+// fieldValue splits the value of a field into the column it should be stored
+// in so that it can be queried by type
+func fieldValue(f models.Field) (str, num, date interface{}) {
+	switch v := f.Value.(type) {
+	case time.Time:
+		return nil, nil, v
+	case int:
+		return nil, float64(v), nil
+	case int64:
+		return nil, float64(v), nil
+	case float64:
+		return nil, v, nil
+	case string:
+		if f.DataType == models.DateField {
+			if d, err := time.Parse(time.RFC3339, v); err == nil {
+				return nil, nil, d
+			}
+		}
+
+		return v, nil, nil
+	default:
+		return nil, nil, nil
+	}
+}
+
+func setTicketRelations(q querier, t models.Ticket) error {
+	for _, table := range []string{"ticket_labels", "ticket_watchers", "ticket_fields"} {
+		_, err := q.Exec("DELETE FROM "+table+" WHERE ticket_key = ?", t.Key)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, label := range uniq(t.Labels) {
+		_, err := q.Exec("INSERT INTO ticket_labels (ticket_key, label) VALUES (?, ?)",
+			t.Key, label)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, watcher := range uniq(t.Watchers) {
+		_, err := q.Exec("INSERT INTO ticket_watchers (ticket_key, username) VALUES (?, ?)",
+			t.Key, watcher)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, f := range t.Fields {
+		options, err := json.Marshal(f.Options)
+		if err != nil {
+			return err
+		}
+
+		str, num, date := fieldValue(f)
+
+		_, err = q.Exec(`INSERT INTO ticket_fields (ticket_key, position, name,
+			data_type, options, string_value, number_value, date_value)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, t.Key, i, f.Name,
+			string(f.DataType), string(options), str, num, date)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func uniq(strs []string) []string {
+	seen := make(map[string]bool)
+	u := []string{}
+
+	for _, s := range strs {
+		if !seen[s] {
+			seen[s] = true
+			u = append(u, s)
+		}
+	}
+
+	return u
+}
+
+// selectTickets runs the given where clause against the tickets table aliased
+// as t returning the fully loaded tickets
+func selectTickets(q querier, where string, suffix string, args ...interface{}) ([]models.Ticket, error) {
+	rows, err := q.Query("SELECT "+ticketColumns+" FROM tickets t WHERE "+
+		where+suffix, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	tickets := []models.Ticket{}
+
+	for rows.Next() {
+		t, err := scanTicket(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		tickets = append(tickets, t)
+	}
+
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range tickets {
+		err = loadTicketRelations(q, &tickets[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tickets, nil
+}
+
+func getTicket(q querier, key string) (models.Ticket, error) {
+	t, err := scanTicket(q.QueryRow("SELECT "+ticketColumns+
+		" FROM tickets t WHERE t.key = ?", key))
+	if err != nil {
+		return t, err
+	}
+
+	return t, loadTicketRelations(q, &t)
+}
+
+// hasPermission will check that the user has the given permission on the
+// project with projectKey returning the project if so
+func hasPermission(q querier, u *models.User, perm permission.Permission, projectKey string) (models.Project, error) {
+	if u == nil {
+		return models.Project{}, repo.ErrLoginRequired
+	}
+
+	p, err := getProject(q, projectKey)
+	if err != nil {
+		return p, err
+	}
+
+	dbUser, err := getUser(q, u.Username)
+	if err != nil {
+		return p, err
+	}
+
+	dbUser.Scopes = u.Scopes
+
+	if len(models.HasPermission(perm, dbUser, p)) == 0 {
+		return p, repo.ErrUnauthorized
+	}
+
+	return p, nil
+}
+
+// validateTicket checks the ticket type and fields against the project and
+// its field scheme
+func validateTicket(q querier, p models.Project, ticket models.Ticket) error {
+	if !p.HasTicketType(ticket.Type) {
+		return repo.ErrInvalidTicketType
+	}
+
+	fs, err := getFieldScheme(q, p.FieldScheme.Hex())
+	if err != nil {
+		return err
+	}
+
+	if err := fs.ValidateTicket(ticket); err != nil {
+		return repo.ErrInvalidFieldsForTicket
+	}
+
+	return nil
+}
+
+func (t ticketRepo) Get(u *models.User, uid string) (models.Ticket, error) {
+	if u == nil {
+		u = &models.User{}
+	}
+
+	ticket, err := getTicket(t.conn, uid)
+	if err != nil {
+		return ticket, sqlErr(err)
+	}
+
+	p, err := getProject(t.conn, ticket.Project)
+	if err != nil {
+		return ticket, sqlErr(err)
+	}
+
+	if len(models.HasPermission(permission.ViewProject, *u, p)) == 0 {
+		return ticket, repo.ErrUnauthorized
+	}
+
+	return ticket, nil
+}
+
+func (t ticketRepo) Update(u *models.User, uid string, updated models.Ticket) error {
+	return sqlErr(t.conn.inTx(func(q querier) error {
+		ticket, err := getTicket(q, uid)
+		if err != nil {
+			return err
+		}
+
+		p, err := hasPermission(q, u, permission.EditTicket, ticket.Project)
+		if err != nil {
+			return err
+		}
+
+		// Status can only be changed by transitions and the project
+		// and workflow can not be changed by an update
+		updated.Key = ticket.Key
+		updated.Project = ticket.Project
+		updated.Workflow = ticket.Workflow
+		updated.Status = ticket.Status
+		updated.CreatedDate = ticket.CreatedDate
+		updated.UpdatedDate = time.Now()
+
+		err = validateTicket(q, p, updated)
+		if err != nil {
+			return err
+		}
+
+		changes := ticket.Changes(updated)
+		if len(changes) == 0 {
+			return nil
+		}
+
+		_, err = q.Exec(`UPDATE tickets SET updated_date = ?, summary = ?,
+			description = ?, reporter = ?, assignee = ?, type = ?
+			WHERE key = ?`, updated.UpdatedDate, updated.Summary,
+			updated.Description, updated.Reporter, updated.Assignee,
+			updated.Type, uid)
+		if err != nil {
+			return err
+		}
+
+		err = setTicketRelations(q, updated)
+		if err != nil {
+			return err
+		}
+
+		err = insertHistory(q, u, uid, changes)
+		if err != nil {
+			return err
+		}
+
+		e := models.NewOutboxEvent(models.UpdatedEvent, u, updated)
+		e.Changes = changes
+		return insertOutboxEvent(q, e)
+	}))
+}
+
+func (t ticketRepo) Transition(u *models.User, uid string, name string) (models.Ticket, models.Transition, error) {
+	var ticket models.Ticket
+	var transition models.Transition
+
+	err := t.conn.inTx(func(q querier) error {
+		var err error
+
+		ticket, err = getTicket(q, uid)
+		if err != nil {
+			return err
+		}
+
+		_, err = hasPermission(q, u, permission.TransitionTicket, ticket.Project)
+		if err != nil {
+			return err
+		}
+
+		wkf, err := getWorkflow(q, ticket.Workflow.Hex())
+		if err != nil {
+			return err
+		}
+
+		var ok bool
+
+		transition, ok = ticket.Transition(wkf, name)
+		if !ok {
+			return repo.ErrInvalidTransition
+		}
+
+		change := models.FieldChange{Field: "status",
+			From: ticket.Status.Name, To: transition.ToStatus.Name}
+
+		ticket.Status = transition.ToStatus
+		ticket.UpdatedDate = time.Now()
+
+		_, err = q.Exec(`UPDATE tickets SET status_name = ?, status_type = ?,
+			updated_date = ? WHERE key = ?`, ticket.Status.Name,
+			string(ticket.Status.Type), ticket.UpdatedDate, uid)
+		if err != nil {
+			return err
+		}
+
+		err = insertHistory(q, u, uid, []models.FieldChange{change})
+		if err != nil {
+			return err
+		}
+
+		e := models.NewOutboxEvent(models.TransitionEvent, u, ticket)
+		e.Transition = &transition
+		return insertOutboxEvent(q, e)
+	})
+
+	return ticket, transition, sqlErr(err)
+}
+
+func (t ticketRepo) AddComment(u *models.User, uid string, comment models.Comment) (models.Ticket, error) {
+	var ticket models.Ticket
+
+	if u == nil {
+		return ticket, repo.ErrLoginRequired
+	}
+
+	comment.CreatedDate = time.Now()
+	comment.UpdatedDate = time.Now()
+	comment.ID = bson.NewObjectId()
+
+	attachments, err := attachmentsJSON(comment.Attachments)
+	if err != nil {
+		return ticket, err
+	}
+
+	err = t.conn.inTx(func(q querier) error {
+		current, err := getTicket(q, uid)
+		if err != nil {
+			return err
+		}
+
+		_, err = hasPermission(q, u, permission.CommentTicket, current.Project)
+		if err != nil {
+			return err
+		}
+
+		_, err = q.Exec(`INSERT INTO comments (id, ticket_key, author, body,
+			created_date, updated_date, attachments) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			comment.ID.Hex(), uid, comment.Author, comment.Body,
+			comment.CreatedDate, comment.UpdatedDate, attachments)
+		if err != nil {
+			return err
+		}
+
+		err = insertHistory(q, u, uid, []models.FieldChange{
+			{Field: models.HistoryComment, To: comment.ID.Hex()}})
+		if err != nil {
+			return err
+		}
+
+		ticket, err = getTicket(q, uid)
+		if err != nil {
+			return err
+		}
+
+		e := models.NewOutboxEvent(models.CommentEvent, u, ticket)
+		e.Comment = &comment
+		return insertOutboxEvent(q, e)
+	})
+
+	return ticket, sqlErr(err)
+}
+
+func (t ticketRepo) Create(u *models.User, ticket models.Ticket) (models.Ticket, error) {
+	err := t.conn.inTx(func(q querier) error {
+		p, err := hasPermission(q, u, permission.CreateTicket, ticket.Project)
+		if err != nil {
+			return err
+		}
+
+		err = validateTicket(q, p, ticket)
+		if err != nil {
+			return err
+		}
+
+		ticket.Workflow = p.GetWorkflow(ticket.Type)
+
+		wkf, err := getWorkflow(q, ticket.Workflow.Hex())
+		if err != nil {
+			return err
+		}
+
+		ticket.Key, err = nextTicketKey(q, ticket.Project)
+		if err != nil {
+			return err
+		}
+
+		ticket.CreatedDate = time.Now()
+		ticket.UpdatedDate = time.Now()
+		ticket.Comments = []models.Comment{}
+		ticket.Status = wkf.CreateTransition().ToStatus
+		ticket.Watchers = []string{u.Username}
+
+		_, err = q.Exec(`INSERT INTO tickets (key, project, created_date,
+			updated_date, summary, description, status_name, status_type,
+			reporter, assignee, type, workflow)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, ticket.Key,
+			ticket.Project, ticket.CreatedDate, ticket.UpdatedDate,
+			ticket.Summary, ticket.Description, ticket.Status.Name,
+			string(ticket.Status.Type), ticket.Reporter, ticket.Assignee,
+			ticket.Type, ticket.Workflow.Hex())
+		if err != nil {
+			return err
+		}
+
+		err = setTicketRelations(q, ticket)
+		if err != nil {
+			return err
+		}
+
+		err = insertHistory(q, u, ticket.Key,
+			[]models.FieldChange{{Field: models.HistoryCreated}})
+		if err != nil {
+			return err
+		}
+
+		return insertOutboxEvent(q, models.NewOutboxEvent(models.CreatedEvent, u, ticket))
+	})
+
+	if err != nil {
+		return models.Ticket{}, sqlErr(err)
+	}
+
+	return ticket, nil
+}
+
+func (t ticketRepo) Delete(u *models.User, uid string) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrUnauthorized
+	}
+
+	return sqlErr(t.conn.inTx(func(q querier) error {
+		ticket, err := getTicket(q, uid)
+		if err != nil {
+			return err
+		}
+
+		p, err := getProject(q, ticket.Project)
+		if err != nil {
+			return err
+		}
+
+		if len(models.HasPermission(permission.RemoveTicket, *u, p)) == 0 {
+			return repo.ErrUnauthorized
+		}
+
+		_, err = q.Exec("DELETE FROM tickets WHERE key = ?", uid)
+		return err
+	}))
+}
+
+func (t ticketRepo) History(u *models.User, uid string) ([]models.HistoryEntry, error) {
+	_, err := t.Get(u, uid)
+	if err != nil {
+		return []models.HistoryEntry{}, err
+	}
+
+	entries, err := selectHistory(t.conn, uid)
+	return entries, sqlErr(err)
+}
+
+func (t ticketRepo) Search(u *models.User, query ast.AST) ([]models.Ticket, error) {
+	permWhere, args := userPermQuery(u, permission.ViewProject)
+
+	queryWhere, queryArgs, err := evalAST(query)
+	if err != nil {
+		return nil, err
+	}
+
+	tickets, err := selectTickets(t.conn,
+		"t.project IN (SELECT p.key FROM projects p WHERE "+permWhere+
+			") AND "+queryWhere, evalModifiers(query),
+		append(args, queryArgs...)...)
+	return tickets, sqlErr(err)
+}
+
+// nextTicketKey finds the highest ticket number in the project and returns
+// the key that comes after it
+func nextTicketKey(q querier, projectKey string) (string, error) {
+	keys, err := selectStrings(q, "SELECT key FROM tickets WHERE project = ?", projectKey)
+	if err != nil {
+		return "", err
+	}
+
+	highest := 0
+
+	for _, key := range keys {
+		n, err := strconv.Atoi(strings.TrimPrefix(key, projectKey+"-"))
+		if err == nil && n > highest {
+			highest = n
+		}
+	}
+
+	return projectKey + "-" + strconv.Itoa(highest+1), nil
+}
+
+func (t ticketRepo) NextTicketKey(u *models.User, projectKey string) (string, error) {
+	key, err := nextTicketKey(t.conn, projectKey)
+	return key, sqlErr(err)
+}
+
+func (t ticketRepo) LabelSearch(u *models.User, query string) ([]string, error) {
+	if u == nil {
+		return []string{}, repo.ErrUnauthorized
+	}
+
+	labels, err := selectStrings(t.conn, `SELECT DISTINCT label FROM ticket_labels
+		WHERE LOWER(label) LIKE ? ESCAPE '\' ORDER BY label`, likePattern(query))
+	return labels, sqlErr(err)
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package sql
+
+import (
+	"encoding/json"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const tokenColumns = `id, name, username, hash, scopes, created_date,
+	expires_date`
+
+type tokenRepo struct {
+	conn conn
+}
+
+func scanToken(row scanner) (models.APIToken, error) {
+	var token models.APIToken
+	var id, scopes string
+
+	err := row.Scan(&id, &token.Name, &token.Username, &token.Hash, &scopes,
+		&token.CreatedDate, &token.ExpiresDate)
+	if err != nil {
+		return token, err
+	}
+
+	token.ID = objectID(id)
+	err = json.Unmarshal([]byte(scopes), &token.Scopes)
+	return token, err
+}
+
+// Get does not check permissions since it is used to authenticate the request
+func (tr tokenRepo) Get(u *models.User, uid string) (models.APIToken, error) {
+	token, err := scanToken(tr.conn.QueryRow("SELECT "+tokenColumns+
+		" FROM api_tokens WHERE id = ?", uid))
+	return token, sqlErr(err)
+}
+
+func (tr tokenRepo) ForUser(u *models.User, user models.User) ([]models.APIToken, error) {
+	if u == nil || (!u.IsAdmin && u.Username != user.Username) {
+		return nil, repo.ErrUnauthorized
+	}
+
+	rows, err := tr.conn.Query("SELECT "+tokenColumns+
+		" FROM api_tokens WHERE username = ? ORDER BY created_date, id",
+		user.Username)
+	if err != nil {
+		return nil, sqlErr(err)
+	}
+
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, sqlErr(err)
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, sqlErr(rows.Err())
+}
+
+func (tr tokenRepo) Create(u *models.User, token models.APIToken) (models.APIToken, error) {
+	if u == nil || (!u.IsAdmin && u.Username != token.Username) {
+		return token, repo.ErrUnauthorized
+	}
+
+	if token.ID == "" {
+		token.ID = bson.NewObjectId()
+	}
+
+	if token.Scopes == nil {
+		token.Scopes = []string{}
+	}
+
+	scopes, err := json.Marshal(token.Scopes)
+	if err != nil {
+		return token, err
+	}
+
+	_, err = tr.conn.Exec("INSERT INTO api_tokens ("+tokenColumns+
+		") VALUES (?, ?, ?, ?, ?, ?, ?)", token.ID.Hex(), token.Name,
+		token.Username, token.Hash, string(scopes), token.CreatedDate,
+		token.ExpiresDate)
+	return token, sqlErr(err)
+}
+
+func (tr tokenRepo) Delete(u *models.User, uid string) error {
+	if u == nil {
+		return repo.ErrUnauthorized
+	}
+
+	token, err := tr.Get(u, uid)
+	if err != nil {
+		return err
+	}
+
+	if !u.IsAdmin && u.Username != token.Username {
+		return repo.ErrUnauthorized
+	}
+
+	res, err := tr.conn.Exec("DELETE FROM api_tokens WHERE id = ?", uid)
+	if err != nil {
+		return sqlErr(err)
+	}
+
+	return rowsAffected(res)
+}
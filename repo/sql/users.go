@@ -0,0 +1,349 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package sql
+
+import (
+	"encoding/json"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+)
+
+const userColumns = `username, password, email, full_name, profile_pic,
+	is_admin, is_active, settings, totp_enabled, totp_secret,
+	totp_recovery_codes, totp_counter, pending_verification, provider,
+	chat_team_id, chat_user_id, email_verified`
+
+type userRepo struct {
+	conn conn
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row scanner) (models.User, error) {
+	var user models.User
+	var settings, recoveryCodes string
+
+	err := row.Scan(&user.Username, &user.Password, &user.Email,
+		&user.FullName, &user.ProfilePic, &user.IsAdmin, &user.IsActive,
+		&settings, &user.TwoFactor.Enabled, &user.TwoFactor.Secret,
+		&recoveryCodes, &user.TwoFactor.LastCounter,
+		&user.PendingVerification, &user.Provider, &user.ChatAccount.TeamID,
+		&user.ChatAccount.UserID, &user.EmailVerified)
+	if err != nil {
+		return user, err
+	}
+
+	err = json.Unmarshal([]byte(recoveryCodes), &user.TwoFactor.RecoveryCodes)
+	if err != nil {
+		return user, err
+	}
+
+	err = json.Unmarshal([]byte(settings), &user.Settings)
+	return user, err
+}
+
+// getUser will load the user with the given username including their roles
+func getUser(q querier, username string) (models.User, error) {
+	user, err := scanUser(q.QueryRow("SELECT "+userColumns+
+		" FROM users WHERE username = ?", username))
+	if err != nil {
+		return user, err
+	}
+
+	rows, err := q.Query(`SELECT project_key, role FROM user_roles
+		WHERE username = ? ORDER BY project_key, role`, username)
+	if err != nil {
+		return user, err
+	}
+
+	defer rows.Close()
+
+	user.Roles = []models.UserRole{}
+
+	for rows.Next() {
+		var role models.UserRole
+
+		err = rows.Scan(&role.Project, &role.Role)
+		if err != nil {
+			return user, err
+		}
+
+		user.Roles = append(user.Roles, role)
+	}
+
+	return user, rows.Err()
+}
+
+func setRoles(q querier, username string, roles []models.UserRole) error {
+	_, err := q.Exec("DELETE FROM user_roles WHERE username = ?", username)
+	if err != nil {
+		return err
+	}
+
+	for _, role := range roles {
+		_, err = q.Exec(`INSERT INTO user_roles (username, project_key, role)
+			VALUES (?, ?, ?)`, username, role.Project, string(role.Role))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ur userRepo) Get(u *models.User, uid string) (models.User, error) {
+	user, err := getUser(ur.conn, uid)
+	return user, sqlErr(err)
+}
+
+func (ur userRepo) Update(u *models.User, uid string, updated models.User) error {
+	if u == nil || (!u.IsAdmin && u.Username != uid) {
+		return repo.ErrUnauthorized
+	}
+
+	settings, err := json.Marshal(updated.Settings)
+	if err != nil {
+		return err
+	}
+
+	// Anyone but an administrator has to verify a new email before they
+	// can log in again, and a new email is never verified
+	res, err := ur.conn.Exec(`UPDATE users SET email = ?, profile_pic = ?,
+		full_name = ?, settings = ?,
+		pending_verification = (pending_verification OR (? AND email <> ?)),
+		email_verified = (email_verified AND email = ?)
+		WHERE username = ?`,
+		updated.Email, updated.ProfilePic, updated.FullName, string(settings),
+		!u.IsAdmin, updated.Email, updated.Email, uid)
+	if err != nil {
+		return sqlErr(err)
+	}
+
+	return rowsAffected(res)
+}
+
+func (ur userRepo) Create(u *models.User, user models.User) (models.User, error) {
+	if u == nil || !u.IsAdmin {
+		user.IsAdmin = false
+	}
+
+	settings, err := json.Marshal(user.Settings)
+	if err != nil {
+		return user, err
+	}
+
+	recoveryCodes, err := recoveryCodesJSON(user.TwoFactor)
+	if err != nil {
+		return user, err
+	}
+
+	err = ur.conn.inTx(func(q querier) error {
+		_, err := q.Exec("INSERT INTO users ("+userColumns+
+			") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", user.Username,
+			user.Password, user.Email, user.FullName, user.ProfilePic,
+			user.IsAdmin, user.IsActive, string(settings),
+			user.TwoFactor.Enabled, user.TwoFactor.Secret, recoveryCodes,
+			user.TwoFactor.LastCounter, user.PendingVerification, user.Provider,
+			user.ChatAccount.TeamID, user.ChatAccount.UserID, user.EmailVerified)
+		if err != nil {
+			return err
+		}
+
+		return setRoles(q, user.Username, user.Roles)
+	})
+
+	return user, sqlErr(err)
+}
+
+func (ur userRepo) Delete(u *models.User, uid string) error {
+	if u == nil || (!u.IsAdmin && u.Username != uid) {
+		return repo.ErrUnauthorized
+	}
+
+	res, err := ur.conn.Exec("DELETE FROM users WHERE username = ?", uid)
+	if err != nil {
+		return sqlErr(err)
+	}
+
+	return rowsAffected(res)
+}
+
+// GetByEmail will return the user with the given email ignoring case
+func (ur userRepo) GetByEmail(u *models.User, email string) (models.User, error) {
+	var username string
+
+	err := ur.conn.QueryRow(`SELECT username FROM users
+		WHERE LOWER(email) = LOWER(?) ORDER BY username LIMIT 1`, email).
+		Scan(&username)
+	if err != nil {
+		return models.User{}, sqlErr(err)
+	}
+
+	user, err := getUser(ur.conn, username)
+	return user, sqlErr(err)
+}
+
+// GetByChatAccount will return the user who linked the chat account
+func (ur userRepo) GetByChatAccount(u *models.User, account models.ChatAccount) (models.User, error) {
+	var username string
+
+	if !account.Linked() {
+		return models.User{}, repo.ErrNotFound
+	}
+
+	err := ur.conn.QueryRow(`SELECT username FROM users
+		WHERE chat_team_id = ? AND chat_user_id = ?`,
+		account.TeamID, account.UserID).Scan(&username)
+	if err != nil {
+		return models.User{}, sqlErr(err)
+	}
+
+	user, err := getUser(ur.conn, username)
+	return user, sqlErr(err)
+}
+
+// SetChatAccount will link the user to the chat account, unlinking it from
+// any other user. Only the user themselves or an admin can link an account.
+func (ur userRepo) SetChatAccount(u *models.User, uid string, account models.ChatAccount) error {
+	if u == nil || (!u.IsAdmin && u.Username != uid) {
+		return repo.ErrUnauthorized
+	}
+
+	return sqlErr(ur.conn.inTx(func(q querier) error {
+		if account.Linked() {
+			_, err := q.Exec(`UPDATE users SET chat_team_id = '', chat_user_id = ''
+				WHERE chat_team_id = ? AND chat_user_id = ? AND username <> ?`,
+				account.TeamID, account.UserID, uid)
+			if err != nil {
+				return err
+			}
+		}
+
+		res, err := q.Exec(`UPDATE users SET chat_team_id = ?, chat_user_id = ?
+			WHERE username = ?`, account.TeamID, account.UserID, uid)
+		if err != nil {
+			return err
+		}
+
+		return rowsAffected(res)
+	}))
+}
+
+// SetRoles will replace the roles of the user, only administrators can change
+// roles
+func (ur userRepo) SetRoles(u *models.User, uid string, roles []models.UserRole) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrUnauthorized
+	}
+
+	return sqlErr(ur.conn.inTx(func(q querier) error {
+		var username string
+
+		err := q.QueryRow("SELECT username FROM users WHERE username = ?", uid).
+			Scan(&username)
+		if err != nil {
+			return err
+		}
+
+		return setRoles(q, uid, roles)
+	}))
+}
+
+// recoveryCodesJSON will return the recovery codes as a JSON array, never null
+func recoveryCodesJSON(tf models.TwoFactor) (string, error) {
+	codes := tf.RecoveryCodes
+	if codes == nil {
+		codes = []string{}
+	}
+
+	b, err := json.Marshal(codes)
+	return string(b), err
+}
+
+// SetTwoFactor will replace the two-factor authentication settings of the
+// user, only the user themselves or an admin can change them
+func (ur userRepo) SetTwoFactor(u *models.User, uid string, tf models.TwoFactor) error {
+	if u == nil || (!u.IsAdmin && u.Username != uid) {
+		return repo.ErrUnauthorized
+	}
+
+	recoveryCodes, err := recoveryCodesJSON(tf)
+	if err != nil {
+		return err
+	}
+
+	res, err := ur.conn.Exec(`UPDATE users SET totp_enabled = ?, totp_secret = ?,
+		totp_recovery_codes = ?, totp_counter = ? WHERE username = ?`,
+		tf.Enabled, tf.Secret, recoveryCodes, tf.LastCounter, uid)
+	if err != nil {
+		return sqlErr(err)
+	}
+
+	return rowsAffected(res)
+}
+
+// SetPassword will replace the password hash of the user, only the user
+// themselves or an admin can change it
+func (ur userRepo) SetPassword(u *models.User, uid string, password string) error {
+	if u == nil || (!u.IsAdmin && u.Username != uid) {
+		return repo.ErrUnauthorized
+	}
+
+	res, err := ur.conn.Exec("UPDATE users SET password = ? WHERE username = ?",
+		password, uid)
+	if err != nil {
+		return sqlErr(err)
+	}
+
+	return rowsAffected(res)
+}
+
+// Activate will mark the user as active once they have verified their email,
+// only the user themselves or an admin can activate them
+func (ur userRepo) Activate(u *models.User, uid string) error {
+	if u == nil || (!u.IsAdmin && u.Username != uid) {
+		return repo.ErrUnauthorized
+	}
+
+	res, err := ur.conn.Exec(`UPDATE users SET is_active = TRUE,
+		pending_verification = FALSE, email_verified = TRUE
+		WHERE username = ?`, uid)
+	if err != nil {
+		return sqlErr(err)
+	}
+
+	return rowsAffected(res)
+}
+
+func (ur userRepo) Search(u *models.User, query string) ([]models.User, error) {
+	q := "SELECT username FROM users"
+	args := []interface{}{}
+
+	if query != "" {
+		q += ` WHERE LOWER(username) LIKE ? ESCAPE '\'
+			OR LOWER(email) LIKE ? ESCAPE '\' OR LOWER(full_name) LIKE ? ESCAPE '\'`
+		pattern := likePattern(query)
+		args = append(args, pattern, pattern, pattern)
+	}
+
+	usernames, err := selectStrings(ur.conn, q+" ORDER BY username", args...)
+	if err != nil {
+		return nil, sqlErr(err)
+	}
+
+	users := make([]models.User, len(usernames))
+	for i, username := range usernames {
+		users[i], err = getUser(ur.conn, username)
+		if err != nil {
+			return nil, sqlErr(err)
+		}
+	}
+
+	return users, nil
+}
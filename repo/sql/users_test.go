@@ -0,0 +1,281 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package sql_test
+
+import (
+	"testing"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+)
+
+func TestUserGet(t *testing.T) {
+	user, err := r.Users().Get(&admin, "testuser")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if user.Email == "" {
+		t.Error("Expected an email got: ", user)
+	}
+}
+
+func TestUserSearch(t *testing.T) {
+	us, e := r.Users().Search(&admin, "")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if us == nil || len(us) == 0 {
+		t.Error("Expected to get users instead got none.")
+	}
+
+	// % and _ are matched literally, only .* is a wildcard
+	for query, expected := range map[string]int{"test.*user": 1, "test_user": 0, "%": 0} {
+		us, e = r.Users().Search(&admin, query)
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		if len(us) != expected {
+			t.Errorf("Expected %d users for %q Got %v", expected, query, us)
+		}
+	}
+}
+
+func TestUserUpdate(t *testing.T) {
+	u, e := r.Users().Get(&admin, "testuser")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	u.Email = "saved@test.com"
+
+	e = r.Users().Update(&admin, u.Username, u)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	u2, e := r.Users().Get(&admin, "testuser")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if u2.Email != "saved@test.com" || u2.PendingVerification {
+		t.Errorf("Expected: saved@test.com Got: %s\n", u.Email)
+	}
+
+	// Users changing their own email have to verify it again
+	u2.Email = "changed@test.com"
+
+	e = r.Users().Update(&u2, u2.Username, u2)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	u3, e := r.Users().Get(&admin, "testuser")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if !u3.PendingVerification {
+		t.Error("Expected the new email to need verifying")
+	}
+
+	e = r.Users().Activate(&admin, "testuser")
+	if e != nil {
+		t.Error(e)
+	}
+}
+
+func TestUserGetByEmail(t *testing.T) {
+	u, e := r.Users().GetByEmail(&admin, "TEST@example.com")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if u.Username != "testadmin" {
+		t.Errorf("Expected: testadmin Got: %s\n", u.Username)
+	}
+
+	if _, e = r.Users().GetByEmail(&admin, "nobody@example.com"); e != repo.ErrNotFound {
+		t.Errorf("Expected %s Got: %v", repo.ErrNotFound, e)
+	}
+}
+
+func TestUserSetTwoFactor(t *testing.T) {
+	tf := models.TwoFactor{
+		Enabled:       true,
+		Secret:        "JBSWY3DPEHPK3PXP",
+		RecoveryCodes: []string{"hash"},
+		LastCounter:   42,
+	}
+
+	e := r.Users().SetTwoFactor(&admin, "testadmin", tf)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	defer r.Users().SetTwoFactor(&admin, "testadmin", models.TwoFactor{})
+
+	u, e := r.Users().Get(&admin, "testadmin")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if !u.TwoFactor.Enabled || u.TwoFactor.Secret != tf.Secret ||
+		len(u.TwoFactor.RecoveryCodes) != 1 || u.TwoFactor.LastCounter != 42 {
+		t.Errorf("Expected: %v Got: %v\n", tf, u.TwoFactor)
+	}
+
+	other := models.User{Username: "someone"}
+	if e = r.Users().SetTwoFactor(&other, "testadmin", tf); e != repo.ErrUnauthorized {
+		t.Errorf("Expected %s Got: %v", repo.ErrUnauthorized, e)
+	}
+}
+
+func TestUserSetChatAccount(t *testing.T) {
+	account := models.ChatAccount{TeamID: "T1", UserID: "U1"}
+
+	e := r.Users().SetChatAccount(&admin, "testadmin", account)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	defer r.Users().SetChatAccount(&admin, "testuser", models.ChatAccount{})
+
+	u, e := r.Users().GetByChatAccount(&admin, account)
+	if e != nil || u.Username != "testadmin" {
+		t.Errorf("Expected: testadmin Got: %s %v\n", u.Username, e)
+	}
+
+	// Linking the account to another user unlinks it from the first
+	e = r.Users().SetChatAccount(&admin, "testuser", account)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	u, e = r.Users().GetByChatAccount(&admin, account)
+	if e != nil || u.Username != "testuser" {
+		t.Errorf("Expected: testuser Got: %s %v\n", u.Username, e)
+	}
+
+	u, e = r.Users().Get(&admin, "testadmin")
+	if e != nil || u.ChatAccount.Linked() {
+		t.Errorf("Expected testadmin to be unlinked Got: %v %v\n", u.ChatAccount, e)
+	}
+
+	if _, e = r.Users().GetByChatAccount(&admin, models.ChatAccount{}); e != repo.ErrNotFound {
+		t.Errorf("Expected %s Got: %v", repo.ErrNotFound, e)
+	}
+
+	other := models.User{Username: "someone"}
+	if e = r.Users().SetChatAccount(&other, "testadmin", account); e != repo.ErrUnauthorized {
+		t.Errorf("Expected %s Got: %v", repo.ErrUnauthorized, e)
+	}
+}
+
+func TestUserSetPassword(t *testing.T) {
+	u, e := models.NewUser("pwuser", "oldpass", "Password User", "pw@example.com", false)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	u.PendingVerification = true
+
+	_, e = r.Users().Create(&admin, *u)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	defer r.Users().Delete(&admin, "pwuser")
+
+	changed, _ := models.NewUser("pwuser", "newpass", "", "", false)
+
+	e = r.Users().SetPassword(u, "pwuser", changed.Password)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	e = r.Users().Activate(u, "pwuser")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	stored, e := r.Users().Get(&admin, "pwuser")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if !stored.CheckPw([]byte("newpass")) {
+		t.Error("Expected the password to be changed")
+	}
+
+	if !stored.IsActive || stored.PendingVerification {
+		t.Errorf("Expected the user to be active Got: %v %v", stored.IsActive, stored.PendingVerification)
+	}
+
+	other := models.User{Username: "someone"}
+	if e = r.Users().SetPassword(&other, "pwuser", "x"); e != repo.ErrUnauthorized {
+		t.Errorf("Expected %s Got: %v", repo.ErrUnauthorized, e)
+	}
+
+	if e = r.Users().Activate(&other, "pwuser"); e != repo.ErrUnauthorized {
+		t.Errorf("Expected %s Got: %v", repo.ErrUnauthorized, e)
+	}
+}
+
+func TestUserSetRoles(t *testing.T) {
+	roles := []models.UserRole{{Project: "TEST", Role: "Administrator"}}
+
+	e := r.Users().SetRoles(&models.User{Username: "testuser"}, "testuser", roles)
+	if e != repo.ErrUnauthorized {
+		t.Errorf("Expected %s Got: %v", repo.ErrUnauthorized, e)
+	}
+
+	e = r.Users().SetRoles(&admin, "testuser", roles)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	u, e := r.Users().Get(&admin, "testuser")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if len(u.Roles) != 1 || u.Roles[0] != roles[0] {
+		t.Errorf("Expected: %v Got: %v\n", roles, u.Roles)
+	}
+}
+
+func TestUserDelete(t *testing.T) {
+	e := r.Users().Delete(&admin, "testuser")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if _, e = r.Users().Get(&admin, "testuser"); e == nil {
+		t.Errorf("Expected an error getting user but got none.")
+	}
+}
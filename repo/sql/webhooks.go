@@ -0,0 +1,149 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package sql
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/models/permission"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const webhookColumns = "id, project, url, events, query, format, secret, created_date"
+
+type webhookRepo struct {
+	conn conn
+}
+
+func scanWebhook(row scanner) (models.Webhook, error) {
+	var w models.Webhook
+	var id, events string
+
+	err := row.Scan(&id, &w.Project, &w.URL, &events, &w.Query, &w.Format,
+		&w.Secret, &w.CreatedDate)
+	if err != nil {
+		return w, err
+	}
+
+	w.ID = objectID(id)
+	err = json.Unmarshal([]byte(events), &w.Events)
+	return w, err
+}
+
+// canAdminProject returns an error if the user can't administer the project.
+// Administrators aren't looked up since the system user isn't stored.
+func canAdminProject(q querier, u *models.User, projectKey string) error {
+	if u != nil && u.IsAdmin && len(u.Scopes) == 0 {
+		_, err := getProject(q, projectKey)
+		return err
+	}
+
+	_, err := hasPermission(q, u, permission.AdminProject, projectKey)
+	return err
+}
+
+// getWebhook returns the webhook if the user can administer its project
+func getWebhook(q querier, u *models.User, uid string) (models.Webhook, error) {
+	w, err := scanWebhook(q.QueryRow("SELECT "+webhookColumns+
+		" FROM webhooks WHERE id = ?", uid))
+	if err != nil {
+		return w, err
+	}
+
+	return w, canAdminProject(q, u, w.Project)
+}
+
+func (wr webhookRepo) Get(u *models.User, uid string) (models.Webhook, error) {
+	w, err := getWebhook(wr.conn, u, uid)
+	return w, sqlErr(err)
+}
+
+func (wr webhookRepo) ForProject(u *models.User, projectKey string) ([]models.Webhook, error) {
+	err := canAdminProject(wr.conn, u, projectKey)
+	if err != nil {
+		return nil, sqlErr(err)
+	}
+
+	rows, err := wr.conn.Query("SELECT "+webhookColumns+
+		" FROM webhooks WHERE project = ? ORDER BY created_date, id", projectKey)
+	if err != nil {
+		return nil, sqlErr(err)
+	}
+
+	defer rows.Close()
+
+	ws := []models.Webhook{}
+
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, sqlErr(err)
+		}
+
+		ws = append(ws, w)
+	}
+
+	return ws, sqlErr(rows.Err())
+}
+
+func (wr webhookRepo) Create(u *models.User, w models.Webhook) (models.Webhook, error) {
+	w.ID = bson.NewObjectId()
+	w.CreatedDate = time.Now()
+
+	events, err := json.Marshal(w.Events)
+	if err != nil {
+		return w, err
+	}
+
+	return w, sqlErr(wr.conn.inTx(func(q querier) error {
+		err := canAdminProject(q, u, w.Project)
+		if err != nil {
+			return err
+		}
+
+		_, err = q.Exec("INSERT INTO webhooks ("+webhookColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, w.ID.Hex(), w.Project, w.URL,
+			string(events), w.Query, w.Format, w.Secret, w.CreatedDate)
+		return err
+	}))
+}
+
+func (wr webhookRepo) Update(u *models.User, uid string, w models.Webhook) error {
+	events, err := json.Marshal(w.Events)
+	if err != nil {
+		return err
+	}
+
+	return sqlErr(wr.conn.inTx(func(q querier) error {
+		stored, err := getWebhook(q, u, uid)
+		if err != nil {
+			return err
+		}
+
+		// Secrets are never sent to clients so they don't send them back
+		if w.Secret == "" {
+			w.Secret = stored.Secret
+		}
+
+		_, err = q.Exec(`UPDATE webhooks SET url = ?, events = ?, query = ?,
+			format = ?, secret = ? WHERE id = ?`, w.URL, string(events), w.Query,
+			w.Format, w.Secret, uid)
+		return err
+	}))
+}
+
+func (wr webhookRepo) Delete(u *models.User, uid string) error {
+	return sqlErr(wr.conn.inTx(func(q querier) error {
+		_, err := getWebhook(q, u, uid)
+		if err != nil {
+			return err
+		}
+
+		_, err = q.Exec("DELETE FROM webhooks WHERE id = ?", uid)
+		return err
+	}))
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 Mathew Robinson <chasinglogic@gmail.com>. All rights reserved.
+// Use of this source code is governed by the AGPLv3 license that can be found in
+// the LICENSE file.
+
+package sql
+
+import (
+	"encoding/json"
+
+	"github.com/praelatus/praelatus/models"
+	"github.com/praelatus/praelatus/repo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type workflowRepo struct {
+	conn conn
+}
+
+func scanWorkflow(row scanner) (models.Workflow, error) {
+	var w models.Workflow
+	var id, transitions string
+
+	err := row.Scan(&id, &w.Name, &transitions)
+	if err != nil {
+		return w, err
+	}
+
+	w.ID = objectID(id)
+	err = json.Unmarshal([]byte(transitions), &w.Transitions)
+	return w, err
+}
+
+func getWorkflow(q querier, uid string) (models.Workflow, error) {
+	return scanWorkflow(q.QueryRow(
+		"SELECT id, name, transitions FROM workflows WHERE id = ?", uid))
+}
+
+func (w workflowRepo) Get(u *models.User, uid string) (models.Workflow, error) {
+	if u == nil {
+		return models.Workflow{}, repo.ErrLoginRequired
+	}
+
+	wkf, err := getWorkflow(w.conn, uid)
+	return wkf, sqlErr(err)
+}
+
+func (w workflowRepo) Update(u *models.User, uid string, updated models.Workflow) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	// FIXME: Handle what to do with tickets and projects associated with this
+	// workflow
+
+	transitions, err := json.Marshal(updated.Transitions)
+	if err != nil {
+		return err
+	}
+
+	res, err := w.conn.Exec("UPDATE workflows SET name = ?, transitions = ? WHERE id = ?",
+		updated.Name, string(transitions), uid)
+	if err != nil {
+		return sqlErr(err)
+	}
+
+	return rowsAffected(res)
+}
+
+func (w workflowRepo) Create(u *models.User, workflow models.Workflow) (models.Workflow, error) {
+	if u == nil || !u.IsAdmin {
+		return models.Workflow{}, repo.ErrAdminRequired
+	}
+
+	workflow.ID = bson.NewObjectId()
+
+	transitions, err := json.Marshal(workflow.Transitions)
+	if err != nil {
+		return workflow, err
+	}
+
+	_, err = w.conn.Exec("INSERT INTO workflows (id, name, transitions) VALUES (?, ?, ?)",
+		workflow.ID.Hex(), workflow.Name, string(transitions))
+	return workflow, sqlErr(err)
+}
+
+func (w workflowRepo) Delete(u *models.User, uid string) error {
+	if u == nil || !u.IsAdmin {
+		return repo.ErrAdminRequired
+	}
+
+	// FIXME: Handle what to do with tickets and projects associated with this
+	// workflow
+
+	res, err := w.conn.Exec("DELETE FROM workflows WHERE id = ?", uid)
+	if err != nil {
+		return sqlErr(err)
+	}
+
+	return rowsAffected(res)
+}
+
+func (w workflowRepo) Search(u *models.User, query string) ([]models.Workflow, error) {
+	if u == nil || !u.IsAdmin {
+		return []models.Workflow{}, repo.ErrAdminRequired
+	}
+
+	q := "SELECT id, name, transitions FROM workflows"
+	args := []interface{}{}
+
+	if query != "" {
+		q += ` WHERE LOWER(name) LIKE ? ESCAPE '\'`
+		args = append(args, likePattern(query))
+	}
+
+	rows, err := w.conn.Query(q+" ORDER BY name", args...)
+	if err != nil {
+		return nil, sqlErr(err)
+	}
+
+	defer rows.Close()
+
+	ws := []models.Workflow{}
+
+	for rows.Next() {
+		wkf, err := scanWorkflow(rows)
+		if err != nil {
+			return nil, sqlErr(err)
+		}
+
+		ws = append(ws, wkf)
+	}
+
+	return ws, sqlErr(rows.Err())
+}